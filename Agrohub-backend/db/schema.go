@@ -48,6 +48,7 @@ func CreateTable() error {
 		img TEXT,
 		AadharFrontImg TEXT,
 		AadharBackImg TEXT,
+		referral_code VARCHAR(12) UNIQUE,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		last_login_at TIMESTAMP
@@ -71,7 +72,8 @@ func CreateTable() error {
     	address TEXT NOT NULL,
     	city VARCHAR(100) NOT NULL,
     	state VARCHAR(100) NOT NULL,
-    	pin_code VARCHAR(10) NOT NULL
+    	pin_code VARCHAR(10) NOT NULL,
+    	is_verified_by_admin BOOLEAN DEFAULT FALSE
 	);`
 
 	createAdminsTable := `
@@ -108,10 +110,28 @@ func CreateTable() error {
     farmers_phone_number VARCHAR(15) NOT NULL,
     is_available BOOLEAN DEFAULT TRUE,
     is_verified_by_admin BOOLEAN DEFAULT FALSE,
+    min_order_qty_kg INT NOT NULL DEFAULT 1,
+    max_order_qty_kg INT,
+    order_step_kg INT NOT NULL DEFAULT 1,
+    available_from DATE,
+    expiry_notified_at TIMESTAMP,
+    expired_at TIMESTAMP,
+    fpo_id INT,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );`
 
+	// Volume based rates, a tier with no max_qty_kg is open ended
+	createProductPriceTiersTable := `
+	CREATE TABLE IF NOT EXISTS product_price_tiers (
+	id SERIAL PRIMARY KEY,
+	product_id INT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+	min_qty_kg INT NOT NULL,
+	max_qty_kg INT,
+	rate_per_kg DECIMAL(10, 2) NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
 	createOrdersTable := `
 	CREATE TABLE IF NOT EXISTS orders (
 	id SERIAL PRIMARY KEY,
@@ -126,10 +146,659 @@ func CreateTable() error {
 	delivery_address TEXT NOT NULL,
 	delivery_city VARCHAR(100) NOT NULL,
 	delivery_address_zip VARCHAR(10) NOT NULL,
+	delivery_landmark VARCHAR(150),
+	delivery_state VARCHAR(100),
+	address_id INT,
+	delivery_fee DECIMAL(10, 2) NOT NULL DEFAULT 0,
+	discount DECIMAL(10, 2) NOT NULL DEFAULT 0,
+	distance_km DECIMAL(8, 1),
+	delivered_at TIMESTAMP,
+	payout_id INT,
+	organization_id INT,
+	reviewed_by INT,
+	review_note TEXT,
+	reviewed_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
+	createProductPriceHistoryTable := `
+	CREATE TABLE IF NOT EXISTS product_price_history (
+	id SERIAL PRIMARY KEY,
+	product_id INT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+	old_rate_per_kg DECIMAL(10, 2),
+	new_rate_per_kg DECIMAL(10, 2) NOT NULL,
+	changed_by INT REFERENCES users(id),
+	changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
+	// Mandi prices as published (Rs per quintal), one row per commodity, market and day
+	createMarketPricesTable := `
+	CREATE TABLE IF NOT EXISTS market_prices (
+	id SERIAL PRIMARY KEY,
+	commodity VARCHAR(100) NOT NULL,
+	variety VARCHAR(100) NOT NULL DEFAULT '',
+	state VARCHAR(100) NOT NULL,
+	district VARCHAR(100) NOT NULL DEFAULT '',
+	market VARCHAR(150) NOT NULL,
+	arrival_date DATE NOT NULL,
+	min_price DECIMAL(10, 2) NOT NULL,
+	max_price DECIMAL(10, 2) NOT NULL,
+	modal_price DECIMAL(10, 2) NOT NULL,
+	imported_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (commodity, variety, market, arrival_date)
+);`
+
+	createNotificationsTable := `
+	CREATE TABLE IF NOT EXISTS notifications (
+	id SERIAL PRIMARY KEY,
+	user_id INT NOT NULL REFERENCES users(id),
+	kind VARCHAR(50) NOT NULL,
+	title VARCHAR(255) NOT NULL,
+	body TEXT NOT NULL,
+	read_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
+	// Projected harvest a farmer takes pre-orders against, actual_qty_kg is set once harvested
+	createHarvestsTable := `
+	CREATE TABLE IF NOT EXISTS harvests (
+	id SERIAL PRIMARY KEY,
+	product_id INT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+	projected_qty_kg INT NOT NULL,
+	projected_date DATE NOT NULL,
+	deposit_percent DECIMAL(5, 2) NOT NULL DEFAULT 0,
+	actual_qty_kg INT,
+	status VARCHAR(20) NOT NULL DEFAULT 'open',
+	recorded_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
+	createPreOrdersTable := `
+	CREATE TABLE IF NOT EXISTS pre_orders (
+	id SERIAL PRIMARY KEY,
+	harvest_id INT NOT NULL REFERENCES harvests(id) ON DELETE CASCADE,
+	product_id INT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+	buyer_id INT NOT NULL REFERENCES users(id),
+	quantity_in_kg INT NOT NULL,
+	allocated_kg INT NOT NULL DEFAULT 0,
+	rate_per_kg DECIMAL(10, 2) NOT NULL,
+	total_price DECIMAL(10, 2) NOT NULL,
+	deposit_amount DECIMAL(10, 2) NOT NULL DEFAULT 0,
+	status VARCHAR(20) NOT NULL DEFAULT 'reserved', -- awaiting_deposit until a deposit is captured
+	order_id INT REFERENCES orders(id),
+	buyers_phone_number VARCHAR(15) NOT NULL,
+	mode_of_delivery VARCHAR(100),
+	delivery_address TEXT NOT NULL,
+	delivery_city VARCHAR(100) NOT NULL,
+	delivery_address_zip VARCHAR(10) NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
+	// Buyer demand farmers can quote on, matched to farmers by delivery_state
+	createRFQsTable := `
+	CREATE TABLE IF NOT EXISTS rfqs (
+	id SERIAL PRIMARY KEY,
+	buyer_id INT NOT NULL REFERENCES users(id),
+	category VARCHAR(100) NOT NULL,
+	title VARCHAR(255) NOT NULL,
+	quantity_kg INT NOT NULL,
+	frequency VARCHAR(20) NOT NULL DEFAULT 'once',
+	target_price_per_kg DECIMAL(10, 2),
+	notes TEXT,
+	buyers_phone_number VARCHAR(15) NOT NULL,
+	delivery_address TEXT NOT NULL,
+	delivery_city VARCHAR(100) NOT NULL,
+	delivery_state VARCHAR(100) NOT NULL,
+	delivery_address_zip VARCHAR(10) NOT NULL,
+	deadline DATE NOT NULL,
+	status VARCHAR(20) NOT NULL DEFAULT 'open',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
+	createRFQQuotesTable := `
+	CREATE TABLE IF NOT EXISTS rfq_quotes (
+	id SERIAL PRIMARY KEY,
+	rfq_id INT NOT NULL REFERENCES rfqs(id) ON DELETE CASCADE,
+	farmer_id INT NOT NULL REFERENCES users(id),
+	product_id INT NOT NULL REFERENCES products(id),
+	rate_per_kg DECIMAL(10, 2) NOT NULL,
+	quantity_kg INT NOT NULL,
+	delivery_date DATE,
+	notes TEXT,
+	status VARCHAR(20) NOT NULL DEFAULT 'submitted',
+	order_id INT REFERENCES orders(id),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (rfq_id, farmer_id)
+);`
+
+	// A negotiation on a product, rate_per_kg and quantity_in_kg hold the latest proposed terms
+	createOffersTable := `
+	CREATE TABLE IF NOT EXISTS offers (
+	id SERIAL PRIMARY KEY,
+	product_id INT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+	buyer_id INT NOT NULL REFERENCES users(id),
+	farmer_id INT NOT NULL REFERENCES users(id),
+	rate_per_kg DECIMAL(10, 2) NOT NULL,
+	quantity_in_kg INT NOT NULL,
+	awaiting VARCHAR(10) NOT NULL DEFAULT 'farmer',
+	status VARCHAR(20) NOT NULL DEFAULT 'open',
+	expires_at TIMESTAMP NOT NULL,
+	order_id INT REFERENCES orders(id),
+	buyers_phone_number VARCHAR(15) NOT NULL,
+	mode_of_delivery VARCHAR(100),
+	delivery_address TEXT NOT NULL,
+	delivery_city VARCHAR(100) NOT NULL,
+	delivery_address_zip VARCHAR(10) NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
+	// Every step of a negotiation is kept, offers only ever point at the latest terms
+	createOfferEventsTable := `
+	CREATE TABLE IF NOT EXISTS offer_events (
+	id SERIAL PRIMARY KEY,
+	offer_id INT NOT NULL REFERENCES offers(id) ON DELETE CASCADE,
+	actor_id INT REFERENCES users(id),
+	action VARCHAR(20) NOT NULL,
+	rate_per_kg DECIMAL(10, 2),
+	quantity_in_kg INT,
+	message TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
+	// A lot of a product sold to the highest bidder, the lot is taken off the product's stock while the auction runs
+	createAuctionsTable := `
+	CREATE TABLE IF NOT EXISTS auctions (
+	id SERIAL PRIMARY KEY,
+	product_id INT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+	farmer_id INT NOT NULL REFERENCES users(id),
+	quantity_in_kg INT NOT NULL,
+	starting_price_per_kg DECIMAL(10, 2) NOT NULL,
+	reserve_price_per_kg DECIMAL(10, 2),
+	min_increment_per_kg DECIMAL(10, 2) NOT NULL,
+	starts_at TIMESTAMP NOT NULL,
+	ends_at TIMESTAMP NOT NULL,
+	extension_minutes INT NOT NULL DEFAULT 5,
+	status VARCHAR(20) NOT NULL DEFAULT 'open',
+	highest_bid_id INT,
+	order_id INT REFERENCES orders(id),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
+	createAuctionBidsTable := `
+	CREATE TABLE IF NOT EXISTS auction_bids (
+	id SERIAL PRIMARY KEY,
+	auction_id INT NOT NULL REFERENCES auctions(id) ON DELETE CASCADE,
+	buyer_id INT NOT NULL REFERENCES users(id),
+	amount_per_kg DECIMAL(10, 2) NOT NULL,
+	buyers_phone_number VARCHAR(15) NOT NULL,
+	mode_of_delivery VARCHAR(100),
+	delivery_address TEXT NOT NULL,
+	delivery_city VARCHAR(100) NOT NULL,
+	delivery_address_zip VARCHAR(10) NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
+	// One row per attempt to pay for an order or a pre-order's deposit, amount is in rupees and provider amounts in paise.
+	// A deposit has no order_id until its pre-order is filled.
+	createPaymentsTable := `
+	CREATE TABLE IF NOT EXISTS payments (
+	id SERIAL PRIMARY KEY,
+	order_id INT REFERENCES orders(id),
+	pre_order_id INT REFERENCES pre_orders(id),
+	provider VARCHAR(50) NOT NULL,
+	method VARCHAR(20) NOT NULL,
+	intent_id VARCHAR(100) UNIQUE,
+	provider_payment_id VARCHAR(100),
+	amount DECIMAL(10, 2) NOT NULL,
+	refunded_amount DECIMAL(10, 2) NOT NULL DEFAULT 0,
+	currency VARCHAR(3) NOT NULL DEFAULT 'INR',
+	status VARCHAR(20) NOT NULL,
+	captured_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
+	createPaymentRefundsTable := `
+	CREATE TABLE IF NOT EXISTS payment_refunds (
+	id SERIAL PRIMARY KEY,
+	payment_id INT NOT NULL REFERENCES payments(id),
+	provider_refund_id VARCHAR(100), -- set once the gateway has refunded it
+	amount DECIMAL(10, 2) NOT NULL,
+	reason TEXT,
+	status VARCHAR(20) NOT NULL DEFAULT 'processed', -- pending until the gateway confirms it
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
+	// Double-entry ledger, the debits and credits of every transaction add up to the same total
+	createLedgerTransactionsTable := `
+	CREATE TABLE IF NOT EXISTS ledger_transactions (
+	id SERIAL PRIMARY KEY,
+	kind VARCHAR(30) NOT NULL,
+	order_id INT REFERENCES orders(id),
+	payout_id INT,
+	description TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
+	createLedgerEntriesTable := `
+	CREATE TABLE IF NOT EXISTS ledger_entries (
+	id SERIAL PRIMARY KEY,
+	transaction_id INT NOT NULL REFERENCES ledger_transactions(id),
+	account VARCHAR(50) NOT NULL,
+	user_id INT REFERENCES users(id),
+	order_id INT REFERENCES orders(id),
+	debit DECIMAL(12, 2) NOT NULL DEFAULT 0,
+	credit DECIMAL(12, 2) NOT NULL DEFAULT 0,
+	payout_id INT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
+	createPayoutBatchesTable := `
+	CREATE TABLE IF NOT EXISTS payout_batches (
+	id SERIAL PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
+	createPayoutsTable := `
+	CREATE TABLE IF NOT EXISTS payouts (
+	id SERIAL PRIMARY KEY,
+	batch_id INT NOT NULL REFERENCES payout_batches(id),
+	farmer_id INT NOT NULL REFERENCES users(id),
+	amount DECIMAL(12, 2) NOT NULL,
+	status VARCHAR(20) NOT NULL DEFAULT 'pending',
+	reference VARCHAR(100),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	paid_at TIMESTAMP
+);`
+
+	// Invoice numbers run in sequence within each financial year, e.g. AGH/2024-25/000123
+	createInvoiceSequencesTable := `
+	CREATE TABLE IF NOT EXISTS invoice_sequences (
+	financial_year VARCHAR(7) PRIMARY KEY,
+	last_seq INT NOT NULL
+);`
+
+	createInvoicesTable := `
+	CREATE TABLE IF NOT EXISTS invoices (
+	id SERIAL PRIMARY KEY,
+	order_id INT NOT NULL UNIQUE REFERENCES orders(id),
+	invoice_number VARCHAR(30) NOT NULL UNIQUE,
+	financial_year VARCHAR(7) NOT NULL,
+	seq INT NOT NULL,
+	description TEXT NOT NULL,
+	hsn_code VARCHAR(8) NOT NULL,
+	tax_rate DECIMAL(5, 2) NOT NULL,
+	taxable_value DECIMAL(12, 2) NOT NULL,
+	tax_amount DECIMAL(12, 2) NOT NULL,
+	total DECIMAL(12, 2) NOT NULL,
+	issued_at TIMESTAMP NOT NULL
+);`
+
+	// Delivery windows the farmer proposes for an order, the buyer confirms one
+	createDeliverySlotsTable := `
+	CREATE TABLE IF NOT EXISTS delivery_slots (
+	id SERIAL PRIMARY KEY,
+	order_id INT NOT NULL REFERENCES orders(id),
+	starts_at TIMESTAMP NOT NULL,
+	ends_at TIMESTAMP NOT NULL,
+	status VARCHAR(20) NOT NULL DEFAULT 'proposed',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
+	createShipmentsTable := `
+	CREATE TABLE IF NOT EXISTS shipments (
+	id SERIAL PRIMARY KEY,
+	order_id INT NOT NULL UNIQUE REFERENCES orders(id),
+	slot_id INT NOT NULL REFERENCES delivery_slots(id),
+	status VARCHAR(20) NOT NULL DEFAULT 'scheduled',
+	carrier VARCHAR(100) NOT NULL DEFAULT '',
+	vehicle_number VARCHAR(20) NOT NULL DEFAULT '',
+	driver_phone VARCHAR(15) NOT NULL DEFAULT '',
+	delivery_otp VARCHAR(6) NOT NULL,
+	otp_attempts INT NOT NULL DEFAULT 0,
+	proof_photo_url TEXT,
+	dispatched_at TIMESTAMP,
+	delivered_at TIMESTAMP,
 	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );`
 
+	createShipmentEventsTable := `
+	CREATE TABLE IF NOT EXISTS shipment_events (
+	id SERIAL PRIMARY KEY,
+	shipment_id INT NOT NULL REFERENCES shipments(id) ON DELETE CASCADE,
+	status VARCHAR(50) NOT NULL,
+	location VARCHAR(150),
+	note TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
+	// Address book, orders keep a copy of the address they were placed with so editing it doesn't change them
+	createUserAddressesTable := `
+	CREATE TABLE IF NOT EXISTS user_addresses (
+	id SERIAL PRIMARY KEY,
+	user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	label VARCHAR(50) NOT NULL,
+	contact_name VARCHAR(100),
+	phone VARCHAR(15),
+	address TEXT NOT NULL,
+	landmark VARCHAR(150),
+	city VARCHAR(100) NOT NULL,
+	state VARCHAR(100) NOT NULL,
+	pin_code VARCHAR(6) NOT NULL CHECK (pin_code ~ '^[1-9][0-9]{5}$'),
+	is_default BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
+	// One review per delivered order, hidden reviews are kept for the record but left out of ratings
+	createReviewsTable := `
+	CREATE TABLE IF NOT EXISTS reviews (
+	id SERIAL PRIMARY KEY,
+	order_id INT NOT NULL UNIQUE REFERENCES orders(id) ON DELETE CASCADE,
+	product_id INT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+	farmer_id INT NOT NULL REFERENCES users(id),
+	buyer_id INT NOT NULL REFERENCES users(id),
+	rating SMALLINT NOT NULL CHECK (rating BETWEEN 1 AND 5),
+	body TEXT,
+	photo_urls TEXT[] NOT NULL DEFAULT '{}',
+	reply TEXT,
+	replied_at TIMESTAMP,
+	status VARCHAR(20) NOT NULL DEFAULT 'published',
+	hidden_reason TEXT,
+	moderated_by INT,
+	moderated_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
+	createReviewReportsTable := `
+	CREATE TABLE IF NOT EXISTS review_reports (
+	id SERIAL PRIMARY KEY,
+	review_id INT NOT NULL REFERENCES reviews(id) ON DELETE CASCADE,
+	reporter_id INT NOT NULL REFERENCES users(id),
+	reason TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (review_id, reporter_id)
+);`
+
+	// Buyer–farmer threads, one per product inquiry and one per order. Admins can only read them once a dispute
+	// is raised on the order.
+	createConversationsTable := `
+	CREATE TABLE IF NOT EXISTS conversations (
+	id SERIAL PRIMARY KEY,
+	product_id INT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+	order_id INT UNIQUE REFERENCES orders(id) ON DELETE CASCADE,
+	buyer_id INT NOT NULL REFERENCES users(id),
+	farmer_id INT NOT NULL REFERENCES users(id),
+	opened_to_admins_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+	CREATE UNIQUE INDEX IF NOT EXISTS conversations_product_inquiry_idx ON conversations(product_id, buyer_id) WHERE order_id IS NULL;`
+
+	createMessagesTable := `
+	CREATE TABLE IF NOT EXISTS messages (
+	id SERIAL PRIMARY KEY,
+	conversation_id INT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+	sender_id INT NOT NULL REFERENCES users(id),
+	body TEXT,
+	image_url TEXT,
+	read_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	CHECK (body IS NOT NULL OR image_url IS NOT NULL)
+);
+	CREATE INDEX IF NOT EXISTS messages_conversation_idx ON messages(conversation_id, id);`
+
+	// One dispute per delivered order, an order isn't paid out while its dispute is open or responded
+	createDisputesTable := `
+	CREATE TABLE IF NOT EXISTS disputes (
+	id SERIAL PRIMARY KEY,
+	order_id INT NOT NULL UNIQUE REFERENCES orders(id) ON DELETE CASCADE,
+	buyer_id INT NOT NULL REFERENCES users(id),
+	farmer_id INT NOT NULL REFERENCES users(id),
+	reason VARCHAR(20) NOT NULL,
+	description TEXT NOT NULL,
+	photo_urls TEXT[] NOT NULL DEFAULT '{}',
+	response TEXT,
+	response_photo_urls TEXT[] NOT NULL DEFAULT '{}',
+	responded_at TIMESTAMP,
+	status VARCHAR(20) NOT NULL DEFAULT 'open',
+	resolution VARCHAR(20),
+	refund_amount DECIMAL(10, 2),
+	resolution_note TEXT,
+	resolved_by INT,
+	resolved_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
+	// Standing orders, the scheduler places one order per delivery through the normal order flow
+	createSubscriptionsTable := `
+	CREATE TABLE IF NOT EXISTS subscriptions (
+	id SERIAL PRIMARY KEY,
+	buyer_id INT NOT NULL REFERENCES users(id),
+	farmer_id INT NOT NULL REFERENCES users(id),
+	product_id INT REFERENCES products(id) ON DELETE SET NULL,
+	category VARCHAR(50),
+	quantity_in_kg INT NOT NULL CHECK (quantity_in_kg > 0),
+	frequency VARCHAR(20) NOT NULL,
+	delivery_day SMALLINT NOT NULL,
+	rate_per_kg DECIMAL(10, 2) NOT NULL,
+	price_tolerance_pct DECIMAL(5, 2) NOT NULL DEFAULT 10,
+	address_id INT REFERENCES user_addresses(id) ON DELETE SET NULL,
+	mode_of_delivery VARCHAR(50),
+	buyers_phone_number VARCHAR(15) NOT NULL,
+	status VARCHAR(20) NOT NULL DEFAULT 'active',
+	next_delivery_date DATE NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	-- a subscription to a product that is deleted is cancelled first, so only cancelled ones can lose both
+	CONSTRAINT subscriptions_check CHECK (product_id IS NOT NULL OR category IS NOT NULL OR status = 'cancelled')
+);
+	CREATE INDEX IF NOT EXISTS subscriptions_due_idx ON subscriptions(next_delivery_date) WHERE status = 'active';`
+
+	createSubscriptionRunsTable := `
+	CREATE TABLE IF NOT EXISTS subscription_runs (
+	id SERIAL PRIMARY KEY,
+	subscription_id INT NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+	delivery_date DATE NOT NULL,
+	status VARCHAR(20) NOT NULL,
+	order_id INT REFERENCES orders(id) ON DELETE SET NULL,
+	reason TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (subscription_id, delivery_date)
+);`
+
+	// Businesses buying through several staff accounts. Orders above approval_limit wait for an approver.
+	createOrganizationsTable := `
+	CREATE TABLE IF NOT EXISTS organizations (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(150) NOT NULL,
+	gstin VARCHAR(15) UNIQUE,
+	approval_limit DECIMAL(10, 2),
+	created_by INT NOT NULL REFERENCES users(id),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
+	createOrganizationMembersTable := `
+	CREATE TABLE IF NOT EXISTS organization_members (
+	organization_id INT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+	user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	role VARCHAR(20) NOT NULL,
+	added_by INT REFERENCES users(id),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (organization_id, user_id)
+);
+	CREATE INDEX IF NOT EXISTS organization_members_user_idx ON organization_members(user_id);`
+
+	// Same shape as user_addresses, user_id is the member who saved the address
+	createOrganizationAddressesTable := `
+	CREATE TABLE IF NOT EXISTS organization_addresses (
+	id SERIAL PRIMARY KEY,
+	organization_id INT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+	user_id INT NOT NULL REFERENCES users(id),
+	label VARCHAR(50) NOT NULL,
+	contact_name VARCHAR(100),
+	phone VARCHAR(15),
+	address TEXT NOT NULL,
+	landmark VARCHAR(150),
+	city VARCHAR(100) NOT NULL,
+	state VARCHAR(100) NOT NULL,
+	pin_code VARCHAR(6) NOT NULL CHECK (pin_code ~ '^[1-9][0-9]{5}$'),
+	is_default BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
+	// Farmer producer organizations, cooperatives that sell their members' produce as one lot
+	createFPOsTable := `
+	CREATE TABLE IF NOT EXISTS fpos (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(150) NOT NULL,
+	registration_number VARCHAR(50) UNIQUE,
+	district VARCHAR(100),
+	state VARCHAR(100),
+	created_by INT NOT NULL REFERENCES users(id),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
+	createFPOMembersTable := `
+	CREATE TABLE IF NOT EXISTS fpo_members (
+	fpo_id INT NOT NULL REFERENCES fpos(id) ON DELETE CASCADE,
+	farmer_id INT NOT NULL REFERENCES users(id),
+	role VARCHAR(20) NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (fpo_id, farmer_id)
+);
+	CREATE INDEX IF NOT EXISTS fpo_members_farmer_idx ON fpo_members(farmer_id);`
+
+	// Stock each member put into a lot, a lot's sales are split between its members in proportion to it
+	createFPOLotContributionsTable := `
+	CREATE TABLE IF NOT EXISTS fpo_lot_contributions (
+	product_id INT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+	farmer_id INT NOT NULL REFERENCES users(id),
+	quantity_in_kg INT NOT NULL CHECK (quantity_in_kg > 0),
+	remaining_kg DECIMAL(10, 2), -- not delivered yet, each delivered order's shares are taken out of it
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (product_id, farmer_id)
+);`
+
+	// How a delivered order was split between the farmers it was sold for, written with its ledger entries
+	createOrderSharesTable := `
+	CREATE TABLE IF NOT EXISTS order_shares (
+	order_id INT NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+	farmer_id INT NOT NULL REFERENCES users(id),
+	quantity_in_kg DECIMAL(10, 2) NOT NULL,
+	gross DECIMAL(12, 2) NOT NULL,
+	commission DECIMAL(12, 2) NOT NULL,
+	PRIMARY KEY (order_id, farmer_id)
+);
+	CREATE INDEX IF NOT EXISTS order_shares_farmer_idx ON order_shares(farmer_id);`
+
+	// Products a buyer saved for later
+	createWishlistItemsTable := `
+	CREATE TABLE IF NOT EXISTS wishlist_items (
+	buyer_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	product_id INT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (buyer_id, product_id)
+);`
+
+	// A buyer's standing request to hear about a product or a category, evaluated whenever a product changes
+	createProductAlertsTable := `
+	CREATE TABLE IF NOT EXISTS product_alerts (
+	id SERIAL PRIMARY KEY,
+	buyer_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	product_id INT REFERENCES products(id) ON DELETE CASCADE,
+	category VARCHAR(50),
+	condition VARCHAR(20) NOT NULL,
+	target_price DECIMAL(10, 2),
+	pin_prefix VARCHAR(3),
+	last_notified_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+	CREATE INDEX IF NOT EXISTS product_alerts_product_idx ON product_alerts(product_id);
+	CREATE INDEX IF NOT EXISTS product_alerts_buyer_idx ON product_alerts(buyer_id);`
+
+	// Products an alert fired for. back_in_stock and price_below rows are removed once the product stops matching,
+	// so the alert fires again the next time it does.
+	createProductAlertMatchesTable := `
+	CREATE TABLE IF NOT EXISTS product_alert_matches (
+	alert_id INT NOT NULL REFERENCES product_alerts(id) ON DELETE CASCADE,
+	product_id INT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (alert_id, product_id)
+);`
+
+	// Discount codes, funded by the platform or by the farmer whose produce they are limited to
+	createCouponsTable := `
+	CREATE TABLE IF NOT EXISTS coupons (
+	id SERIAL PRIMARY KEY,
+	code VARCHAR(30) NOT NULL UNIQUE,
+	description TEXT,
+	discount_type VARCHAR(20) NOT NULL,
+	value DECIMAL(10, 2) NOT NULL CHECK (value > 0),
+	max_discount DECIMAL(10, 2),
+	funded_by VARCHAR(20) NOT NULL,
+	farmer_id INT REFERENCES users(id),
+	buyer_id INT REFERENCES users(id),
+	category VARCHAR(50),
+	min_order_value DECIMAL(10, 2) NOT NULL DEFAULT 0,
+	starts_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	ends_at TIMESTAMP,
+	usage_limit INT,
+	per_user_limit INT NOT NULL DEFAULT 1,
+	is_active BOOLEAN NOT NULL DEFAULT TRUE,
+	created_by INT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	CHECK (funded_by = 'platform' OR farmer_id IS NOT NULL)
+);`
+
+	// Discount lines of orders, for accounting and counting each coupon's uses
+	createOrderDiscountsTable := `
+	CREATE TABLE IF NOT EXISTS order_discounts (
+	id SERIAL PRIMARY KEY,
+	order_id INT NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+	coupon_id INT NOT NULL REFERENCES coupons(id),
+	buyer_id INT NOT NULL REFERENCES users(id),
+	code VARCHAR(30) NOT NULL,
+	funded_by VARCHAR(20) NOT NULL,
+	amount DECIMAL(10, 2) NOT NULL CHECK (amount >= 0),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+	CREATE INDEX IF NOT EXISTS order_discounts_order_idx ON order_discounts(order_id);
+	CREATE INDEX IF NOT EXISTS order_discounts_coupon_idx ON order_discounts(coupon_id, buyer_id);`
+
+	// A user brought in with another user's referral code. The referrer is rewarded once the referred user qualifies,
+	// referred_aadhar is kept so an Aadhaar number is only ever referred once.
+	createReferralsTable := `
+	CREATE TABLE IF NOT EXISTS referrals (
+	id SERIAL PRIMARY KEY,
+	referrer_id INT NOT NULL REFERENCES users(id),
+	referred_id INT NOT NULL UNIQUE REFERENCES users(id),
+	code VARCHAR(12) NOT NULL,
+	referred_aadhar VARCHAR(12) NOT NULL,
+	status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, rewarded or rejected
+	reject_reason TEXT,
+	reward_amount DECIMAL(10, 2),
+	reward_coupon_id INT REFERENCES coupons(id),
+	rewarded_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+	CREATE INDEX IF NOT EXISTS referrals_referrer_idx ON referrals(referrer_id);
+	CREATE INDEX IF NOT EXISTS referrals_aadhar_idx ON referrals(referred_aadhar);`
+
 	// indexes := `
 	// CREATE INDEX idx_farmer_id ON products(farmer_id);
 	// CREATE INDEX idx_buyer_id ON orders(buyer_id);
@@ -143,7 +812,22 @@ func CreateTable() error {
 		// }
 	}
 
-	tables := []string{createUsersTable, createFarmersTable, createBuyersTable, createAdminsTable, createAuthTable, createProductsTable, createOrdersTable}
+	tables := []string{createUsersTable, createFarmersTable, createBuyersTable, createAdminsTable, createAuthTable, createProductsTable, createOrdersTable,
+		createProductPriceTiersTable, createProductPriceHistoryTable, createMarketPricesTable,
+		createNotificationsTable, createHarvestsTable, createPreOrdersTable,
+		createRFQsTable, createRFQQuotesTable, createOffersTable, createOfferEventsTable,
+		createAuctionsTable, createAuctionBidsTable, createPaymentsTable, createPaymentRefundsTable,
+		createLedgerTransactionsTable, createLedgerEntriesTable, createPayoutBatchesTable, createPayoutsTable,
+		createInvoiceSequencesTable, createInvoicesTable,
+		createDeliverySlotsTable, createShipmentsTable, createShipmentEventsTable,
+		createUserAddressesTable, createReviewsTable, createReviewReportsTable,
+		createConversationsTable, createMessagesTable, createDisputesTable,
+		createSubscriptionsTable, createSubscriptionRunsTable,
+		createOrganizationsTable, createOrganizationMembersTable, createOrganizationAddressesTable,
+		createFPOsTable, createFPOMembersTable, createFPOLotContributionsTable, createOrderSharesTable,
+		createWishlistItemsTable, createProductAlertsTable, createProductAlertMatchesTable,
+		createCouponsTable, createOrderDiscountsTable, createReferralsTable,
+	}
 	for i := 0; i < len(tables); i++ {
 		_, err := db.Exec(tables[i])
 		if err != nil {
@@ -151,6 +835,54 @@ func CreateTable() error {
 		}
 	}
 
+	// Columns added after the first release, CREATE TABLE IF NOT EXISTS won't add them to existing tables
+	alterations := []string{
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS min_order_qty_kg INT NOT NULL DEFAULT 1;`,
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS max_order_qty_kg INT;`,
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS order_step_kg INT NOT NULL DEFAULT 1;`,
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS available_from DATE;`,
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS expiry_notified_at TIMESTAMP;`,
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS expired_at TIMESTAMP;`,
+		`ALTER TABLE buyers ADD COLUMN IF NOT EXISTS is_verified_by_admin BOOLEAN DEFAULT FALSE;`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMP;`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS delivery_fee DECIMAL(10, 2) NOT NULL DEFAULT 0;`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS distance_km DECIMAL(8, 1);`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS delivery_landmark VARCHAR(150);`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS delivery_state VARCHAR(100);`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS address_id INT;`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS payout_id INT;`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS organization_id INT;`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS reviewed_by INT;`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS review_note TEXT;`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS reviewed_at TIMESTAMP;`,
+		`ALTER TYPE order_status ADD VALUE IF NOT EXISTS 'pending_approval';`,
+		`ALTER TYPE order_status ADD VALUE IF NOT EXISTS 'rejected';`,
+		`ALTER TYPE order_status ADD VALUE IF NOT EXISTS 'processing';`,
+		`ALTER TYPE order_status ADD VALUE IF NOT EXISTS 'refunded';`,
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS fpo_id INT;`,
+		`ALTER TABLE ledger_entries ADD COLUMN IF NOT EXISTS payout_id INT;`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS discount DECIMAL(10, 2) NOT NULL DEFAULT 0;`,
+		`ALTER TABLE coupons ADD COLUMN IF NOT EXISTS buyer_id INT;`,
+		`ALTER TABLE payment_refunds ALTER COLUMN provider_refund_id DROP NOT NULL;`,
+		`ALTER TABLE payment_refunds ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'processed';`,
+		`ALTER TABLE subscriptions DROP CONSTRAINT IF EXISTS subscriptions_check;`,
+		`ALTER TABLE subscriptions ADD CONSTRAINT subscriptions_check CHECK (product_id IS NOT NULL OR category IS NOT NULL OR status = 'cancelled');`,
+		`ALTER TABLE fpo_lot_contributions ADD COLUMN IF NOT EXISTS remaining_kg DECIMAL(10, 2);`,
+		`UPDATE fpo_lot_contributions c SET remaining_kg = GREATEST(c.quantity_in_kg - COALESCE((
+			SELECT SUM(s.quantity_in_kg) FROM order_shares s JOIN orders o ON o.id = s.order_id
+			WHERE o.product_id = c.product_id AND s.farmer_id = c.farmer_id), 0), 0)
+		WHERE remaining_kg IS NULL;`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS referral_code VARCHAR(12) UNIQUE;`,
+		`ALTER TABLE payments ALTER COLUMN order_id DROP NOT NULL;`,
+		`ALTER TABLE payments ADD COLUMN IF NOT EXISTS pre_order_id INT REFERENCES pre_orders(id);`,
+	}
+	for i := 0; i < len(alterations); i++ {
+		_, err := db.Exec(alterations[i])
+		if err != nil {
+			return fmt.Errorf("error altering table %v: %v", alterations[i], err)
+		}
+	}
+
 	// _, err = db.Exec(indexes)
 	// if err != nil {
 	// 	return fmt.Errorf("failed to created indexes:%v", err)
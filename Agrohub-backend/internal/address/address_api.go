@@ -0,0 +1,146 @@
+package address
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ritu84/agrohub/types"
+	"github.com/labstack/echo/v4"
+)
+
+// Validate trims an address and checks it has everything a delivery needs
+func Validate(a *types.Address) error {
+	a.Label = strings.TrimSpace(a.Label)
+	a.Address = strings.TrimSpace(a.Address)
+	a.City = strings.TrimSpace(a.City)
+	a.State = strings.TrimSpace(a.State)
+	if a.Label == "" {
+		a.Label = "home"
+	}
+	if a.Address == "" || a.City == "" || a.State == "" {
+		return errors.New("address, city and state are required")
+	}
+	if !a.PinCode.Valid() {
+		return fmt.Errorf("pin_code %q must be 6 digits", a.PinCode)
+	}
+	return nil
+}
+
+// CreateAddress adds an address to the logged in user's book
+func CreateAddress(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var a types.Address
+		if err := c.Bind(&a); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if err := Validate(&a); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, err.Error())
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+		a.UserID = userID
+
+		if err := CreateAddressInStore(db, &a); err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error creating address: %v", err))
+		}
+
+		return c.JSON(http.StatusCreated, a)
+	}
+}
+
+// GetAddresses lists the logged in user's addresses
+func GetAddresses(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		addresses, err := GetAddressesFromStore(db, userID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching addresses: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, addresses)
+	}
+}
+
+// UpdateAddress replaces one of the logged in user's addresses
+func UpdateAddress(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		addressID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing address id:%v", err))
+		}
+
+		var a types.Address
+		if err := c.Bind(&a); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if err := Validate(&a); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, err.Error())
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+		a.ID = addressID
+		a.UserID = userID
+
+		if err := UpdateAddressInStore(db, &a); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error updating address: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, a)
+	}
+}
+
+// SetDefaultAddress makes one of the logged in user's addresses their default
+func SetDefaultAddress(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		addressID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing address id:%v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		if err := SetDefaultAddressInStore(db, userID, addressID); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error setting default address: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "default address updated successfully!"})
+	}
+}
+
+// DeleteAddress removes one of the logged in user's addresses
+func DeleteAddress(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		addressID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing address id:%v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		if err := DeleteAddressFromStore(db, userID, addressID); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error deleting address: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "address deleted successfully!"})
+	}
+}
@@ -0,0 +1,209 @@
+package address
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ritu84/agrohub/types"
+)
+
+const addressColumns = `
+	id, user_id, label, COALESCE(contact_name, ''), COALESCE(phone, ''), address, COALESCE(landmark, ''),
+	city, state, pin_code, is_default, created_at, updated_at`
+
+func scanAddress(row interface{ Scan(...interface{}) error }) (types.Address, error) {
+	var a types.Address
+	err := row.Scan(&a.ID, &a.UserID, &a.Label, &a.ContactName, &a.Phone, &a.Address, &a.Landmark,
+		&a.City, &a.State, &a.PinCode, &a.IsDefault, &a.CreatedAt, &a.UpdatedAt)
+	return a, err
+}
+
+// clearDefaultTx unsets the user's default address, so the one being saved can take its place
+func clearDefaultTx(tx *sql.Tx, userID int) error {
+	if _, err := tx.Exec(`UPDATE user_addresses SET is_default = false, updated_at = NOW() WHERE user_id = $1 AND is_default`, userID); err != nil {
+		return fmt.Errorf("error clearing default address: %v", err)
+	}
+	return nil
+}
+
+// CreateAddressInStore adds an address to the user's book. The first address becomes the default.
+func CreateAddressInStore(db *sql.DB, a *types.Address) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the user so two first addresses can't both become the default
+	if _, err := tx.Exec(`SELECT id FROM users WHERE id = $1 FOR UPDATE`, a.UserID); err != nil {
+		return fmt.Errorf("error locking user: %v", err)
+	}
+
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM user_addresses WHERE user_id = $1`, a.UserID).Scan(&count); err != nil {
+		return fmt.Errorf("error counting addresses: %v", err)
+	}
+	if count == 0 {
+		a.IsDefault = true
+	}
+	if a.IsDefault {
+		if err := clearDefaultTx(tx, a.UserID); err != nil {
+			return err
+		}
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO user_addresses (user_id, label, contact_name, phone, address, landmark, city, state, pin_code, is_default)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, NULLIF($6, ''), $7, $8, $9, $10)
+		RETURNING id, created_at, updated_at`,
+		a.UserID, a.Label, a.ContactName, a.Phone, a.Address, a.Landmark, a.City, a.State, a.PinCode, a.IsDefault).
+		Scan(&a.ID, &a.CreatedAt, &a.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error inserting address: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// GetAddressesFromStore lists a user's addresses, the default first
+func GetAddressesFromStore(db *sql.DB, userID int) ([]types.Address, error) {
+	rows, err := db.Query(`SELECT`+addressColumns+` FROM user_addresses WHERE user_id = $1 ORDER BY is_default DESC, created_at, id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying addresses: %v", err)
+	}
+	defer rows.Close()
+
+	addresses := []types.Address{}
+	for rows.Next() {
+		a, err := scanAddress(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan address: %v", err)
+		}
+		addresses = append(addresses, a)
+	}
+	return addresses, rows.Err()
+}
+
+// GetUserAddressFromStore returns one of the user's addresses. addressID 0 asks for their default address.
+func GetUserAddressFromStore(db *sql.DB, userID, addressID int) (types.Address, error) {
+	a, err := scanAddress(db.QueryRow(`SELECT`+addressColumns+`
+		FROM user_addresses
+		WHERE user_id = $1 AND (id = $2 OR ($2 = 0 AND is_default))`, userID, addressID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			if addressID == 0 {
+				return a, fmt.Errorf("no default address saved")
+			}
+			return a, fmt.Errorf("no address found with ID %d", addressID)
+		}
+		return a, fmt.Errorf("error querying address: %v", err)
+	}
+	return a, nil
+}
+
+// UpdateAddressInStore replaces one of the user's addresses. Orders already placed keep the old copy.
+func UpdateAddressInStore(db *sql.DB, a *types.Address) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var wasDefault bool
+	err = tx.QueryRow(`SELECT is_default FROM user_addresses WHERE id = $1 AND user_id = $2 FOR UPDATE`, a.ID, a.UserID).Scan(&wasDefault)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("no address found with ID %d", a.ID)
+		}
+		return fmt.Errorf("error querying address: %v", err)
+	}
+	// The default can only move to another address, not be unset
+	if wasDefault {
+		a.IsDefault = true
+	} else if a.IsDefault {
+		if err := clearDefaultTx(tx, a.UserID); err != nil {
+			return err
+		}
+	}
+
+	err = tx.QueryRow(`
+		UPDATE user_addresses
+		SET label = $1, contact_name = NULLIF($2, ''), phone = NULLIF($3, ''), address = $4, landmark = NULLIF($5, ''),
+			city = $6, state = $7, pin_code = $8, is_default = $9, updated_at = NOW()
+		WHERE id = $10
+		RETURNING created_at, updated_at`,
+		a.Label, a.ContactName, a.Phone, a.Address, a.Landmark, a.City, a.State, a.PinCode, a.IsDefault, a.ID).
+		Scan(&a.CreatedAt, &a.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error updating address: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// SetDefaultAddressInStore makes one of the user's addresses their default
+func SetDefaultAddressInStore(db *sql.DB, userID, addressID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := clearDefaultTx(tx, userID); err != nil {
+		return err
+	}
+	result, err := tx.Exec(`UPDATE user_addresses SET is_default = true, updated_at = NOW() WHERE id = $1 AND user_id = $2`, addressID, userID)
+	if err != nil {
+		return fmt.Errorf("error setting default address: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("no address found with ID %d", addressID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// DeleteAddressFromStore removes one of the user's addresses. When it was the default, the oldest remaining one takes over.
+func DeleteAddressFromStore(db *sql.DB, userID, addressID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var wasDefault bool
+	err = tx.QueryRow(`DELETE FROM user_addresses WHERE id = $1 AND user_id = $2 RETURNING is_default`, addressID, userID).Scan(&wasDefault)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("no address found with ID %d", addressID)
+		}
+		return fmt.Errorf("error deleting address: %v", err)
+	}
+
+	if wasDefault {
+		if _, err := tx.Exec(`
+			UPDATE user_addresses SET is_default = true, updated_at = NOW()
+			WHERE id = (SELECT id FROM user_addresses WHERE user_id = $1 ORDER BY created_at, id LIMIT 1)`, userID); err != nil {
+			return fmt.Errorf("error moving default address: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
@@ -4,6 +4,9 @@ import (
 	"database/sql"
 	"fmt"
 
+	"github.com/ritu84/agrohub/internal/events"
+	"github.com/ritu84/agrohub/internal/referral"
+	"github.com/ritu84/agrohub/internal/wishlist"
 	"github.com/ritu84/agrohub/types"
 	"github.com/labstack/echo/v4"
 )
@@ -68,6 +71,13 @@ func ApproveUserStore(db *sql.DB, userID int) error {
     WHERE user_id = $1;
     `
 
+    // Buyers are verified the same way, verified buyers can bid in auctions
+    buyersQuery := `
+    UPDATE buyers
+    SET is_verified_by_admin = true
+    WHERE user_id = $1;
+    `
+
     updateUsersQuery := `
     UPDATE users
     SET updated_at = NOW()
@@ -80,12 +90,20 @@ func ApproveUserStore(db *sql.DB, userID int) error {
         return fmt.Errorf("error updating is_verified field in userstore: %v", err)
     }
 
+    _, err = db.Exec(buyersQuery, userID)
+    if err != nil {
+        return fmt.Errorf("error updating is_verified field in userstore: %v", err)
+    }
+
     // Execute the users update query
     _, err = db.Exec(updateUsersQuery, userID)
     if err != nil {
         return fmt.Errorf("error updating updated_at field in userstore: %v", err)
     }
 
+    // A farmer's KYC approval rewards whoever referred them
+    referral.Qualify(db, userID)
+
     return nil
 }
 
@@ -93,15 +111,28 @@ func ApproveProductInStore(db *sql.DB, v types.ApproveProduct) error {
     q := `
     UPDATE products
     SET is_verified_by_admin = $1, updated_at = NOW()
-    WHERE product_id = $2;
+    WHERE id = $2
+    RETURNING id, farmer_id, name;
     `
 
     // Execute the products update query
-    _, err := db.Exec(q, v.IsVerified, v.ProductID)
+    var e types.ProductModerationEvent
+    var farmerID int
+    err := db.QueryRow(q, v.IsVerified, v.ProductID).Scan(&e.ProductID, &farmerID, &e.Name)
     if err != nil {
+        if err == sql.ErrNoRows {
+            return fmt.Errorf("no product found with ID %s", v.ProductID)
+        }
         return fmt.Errorf("error updating is_approved field in products: %v", err)
     }
 
+    // Let the farmer's ManageProducts screen know the outcome
+    e.IsVerified = v.IsVerified
+    events.Publish(farmerID, events.TypeProductModerated, e)
+
+    // a verified product can now match buyers' alerts
+    wishlist.ProductChanged(db, e.ProductID)
+
     return nil
 }
 
@@ -0,0 +1,200 @@
+package auction
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ritu84/agrohub/internal/geo"
+	"github.com/ritu84/agrohub/internal/notification"
+	"github.com/ritu84/agrohub/internal/pricing"
+	"github.com/ritu84/agrohub/internal/product"
+	"github.com/ritu84/agrohub/types"
+	"github.com/labstack/echo/v4"
+)
+
+// CreateAuction puts a lot of one of the farmer's products up for auction
+func CreateAuction(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ProductID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing product id :%v", err))
+		}
+
+		var a types.Auction
+		if err := c.Bind(&a); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		a.ProductID = ProductID
+
+		if a.StartsAt.IsZero() {
+			a.StartsAt = time.Now()
+		}
+		if a.ExtensionMinutes == 0 {
+			a.ExtensionMinutes = 5
+		}
+		if a.QuantityInKg <= 0 {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "quantity must be greater than 0")
+		}
+		if a.StartingPricePerKg <= 0 || a.MinIncrementPerKg <= 0 {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "starting_price_per_kg and min_increment_per_kg must be greater than 0")
+		}
+		if a.ReservePricePerKg != nil && *a.ReservePricePerKg < a.StartingPricePerKg {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "reserve_price_per_kg must not be below the starting price")
+		}
+		if !a.EndsAt.After(a.StartsAt) || !a.EndsAt.After(time.Now()) {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "ends_at must be in the future and after starts_at")
+		}
+		if a.ExtensionMinutes < 0 {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "extension_minutes must not be negative")
+		}
+
+		p, err := product.GetProductFromStore(db, ProductID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, fmt.Sprintf("unable to fetch the product from store :%v", err))
+		}
+		if !product.ListedBy(c, p) {
+			return echo.NewHTTPError(http.StatusForbidden, "only the farmer who listed the product can auction it")
+		}
+		if p.Quantity < a.QuantityInKg {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "insufficient quantity available")
+		}
+		a.FarmerID = p.FarmerID
+		a.ProductName = p.Name
+
+		if err := CreateAuctionInStore(db, &a); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error creating auction: %v", err))
+		}
+
+		return c.JSON(http.StatusCreated, a)
+	}
+}
+
+// GetOpenAuctions lists running and upcoming auctions
+func GetOpenAuctions(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		res, err := GetOpenAuctionsFromStore(db)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching auctions: %v", err))
+		}
+
+		userID, _ := c.Get("user_id").(int)
+		for i := range res {
+			hideReserve(&res[i], userID)
+		}
+
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+func GetAuction(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		auctionID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing auction id: %v", err))
+		}
+
+		a, err := GetAuctionFromStore(db, auctionID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+
+		userID, _ := c.Get("user_id").(int)
+		hideReserve(&a, userID)
+
+		return c.JSON(http.StatusOK, a)
+	}
+}
+
+// PlaceBid bids on an auction for the logged in buyer, only buyers verified by admin can bid
+func PlaceBid(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		auctionID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing auction id: %v", err))
+		}
+
+		var b types.Bid
+		if err := c.Bind(&b); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+		b.BuyerID = userID
+		b.AuctionID = auctionID
+
+		if b.AmountPerKg <= 0 {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "amount_per_kg must be greater than 0")
+		}
+		if b.DeliveryAddress == "" || b.DeliveryCity == "" {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "delivery address and city are required")
+		}
+		if !b.DeliveryAddressZIP.Valid() {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "delivery_address_zip must be a 6 digit pin code")
+		}
+
+		verified, err := IsVerifiedBuyerInStore(db, userID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, err.Error())
+		}
+		if !verified {
+			return echo.NewHTTPError(http.StatusForbidden, "only buyers verified by admin can bid")
+		}
+
+		// The farm has to deliver there for the bid to become an order
+		a, err := GetAuctionFromStore(db, auctionID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+		quote := types.Quote{QuantityInKg: a.QuantityInKg, Subtotal: pricing.Round(float64(a.QuantityInKg) * b.AmountPerKg)}
+		if err := geo.ApplyDelivery(db, a.FarmerID, string(b.DeliveryAddressZIP), &quote); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, err.Error())
+		}
+
+		a, outbid, err := PlaceBidInStore(db, &b)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error placing bid: %v", err))
+		}
+
+		if outbid != 0 {
+			notification.Notify(db, outbid, notification.KindAuction, "You have been outbid",
+				fmt.Sprintf("Someone bid Rs %.2f/kg on %s. The auction ends at %s.", b.AmountPerKg, a.ProductName, a.EndsAt.Format("02 Jan 2006 15:04")))
+		}
+
+		hideReserve(&a, userID)
+		return c.JSON(http.StatusCreated, a)
+	}
+}
+
+func CancelAuction(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		auctionID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing auction id: %v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		if err := CancelAuctionInStore(db, auctionID, userID); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error cancelling auction: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "auction cancelled successfully!"})
+	}
+}
+
+// hideReserve keeps the reserve price from everyone but the farmer running the auction, bidders only see reserve_met
+func hideReserve(a *types.Auction, userID int) {
+	if a.FarmerID != userID {
+		a.ReservePricePerKg = nil
+	}
+}
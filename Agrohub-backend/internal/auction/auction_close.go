@@ -0,0 +1,54 @@
+package auction
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ritu84/agrohub/internal/notification"
+	"github.com/ritu84/agrohub/internal/scheduler"
+)
+
+// Jobs are the background jobs for auctions, they run every AUCTION_JOBS_INTERVAL (default 1m)
+func Jobs() []scheduler.Job {
+	return []scheduler.Job{
+		{Name: "close-auctions", Interval: scheduler.DurationFromEnv("AUCTION_JOBS_INTERVAL", time.Minute), Run: CloseEndedAuctions},
+	}
+}
+
+// CloseEndedAuctions settles every auction past its end time and lets the farmer and bidders know how it went
+func CloseEndedAuctions(db *sql.DB) error {
+	ids, err := GetEndedAuctionIDsFromStore(db)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		a, winner, losers, err := CloseAuctionInStore(db, id)
+		if err != nil {
+			// one bad auction shouldn't hold up the rest
+			log.Printf("auction %d: %v", id, err)
+			continue
+		}
+
+		switch a.Status {
+		case StatusSold:
+			notification.Notify(db, winner.BuyerID, notification.KindAuction, "You won the auction",
+				fmt.Sprintf("Your bid of Rs %.2f/kg won %d kg of %s, it is now order #%d.", winner.AmountPerKg, a.QuantityInKg, a.ProductName, *a.OrderID))
+			notification.Notify(db, a.FarmerID, notification.KindAuction, "Your auction sold",
+				fmt.Sprintf("%d kg of %s sold at Rs %.2f/kg as order #%d.", a.QuantityInKg, a.ProductName, winner.AmountPerKg, *a.OrderID))
+		case StatusUnsold:
+			notification.Notify(db, a.FarmerID, notification.KindAuction, "Your auction ended unsold",
+				fmt.Sprintf("No bid on %s met your reserve, the %d kg lot is back in your stock.", a.ProductName, a.QuantityInKg))
+		default:
+			continue
+		}
+
+		for _, buyerID := range losers {
+			notification.Notify(db, buyerID, notification.KindAuction, "Auction ended",
+				fmt.Sprintf("The auction for %d kg of %s ended and your bid did not win.", a.QuantityInKg, a.ProductName))
+		}
+	}
+	return nil
+}
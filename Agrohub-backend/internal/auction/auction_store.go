@@ -0,0 +1,355 @@
+package auction
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ritu84/agrohub/internal/events"
+	order "github.com/ritu84/agrohub/internal/orders"
+	"github.com/ritu84/agrohub/internal/pricing"
+	"github.com/ritu84/agrohub/types"
+)
+
+const (
+	StatusOpen      = "open"
+	StatusSold      = "sold"
+	StatusUnsold    = "unsold"
+	StatusCancelled = "cancelled"
+)
+
+const auctionColumns = `
+	a.id, a.product_id, p.name, a.farmer_id, a.quantity_in_kg, a.starting_price_per_kg, a.reserve_price_per_kg,
+	COALESCE(hb.amount_per_kg >= COALESCE(a.reserve_price_per_kg, 0), false), a.min_increment_per_kg,
+	a.starts_at, a.ends_at, a.extension_minutes, a.status, hb.amount_per_kg, a.highest_bid_id,
+	(SELECT COUNT(*) FROM auction_bids b WHERE b.auction_id = a.id), a.order_id, a.created_at, a.updated_at`
+
+const auctionFrom = `
+	FROM auctions a
+	JOIN products p ON p.id = a.product_id
+	LEFT JOIN auction_bids hb ON hb.id = a.highest_bid_id`
+
+func scanAuction(row interface{ Scan(...interface{}) error }, extra ...interface{}) (types.Auction, error) {
+	var a types.Auction
+	dest := []interface{}{&a.ID, &a.ProductID, &a.ProductName, &a.FarmerID, &a.QuantityInKg, &a.StartingPricePerKg, &a.ReservePricePerKg,
+		&a.ReserveMet, &a.MinIncrementPerKg,
+		&a.StartsAt, &a.EndsAt, &a.ExtensionMinutes, &a.Status, &a.HighestBidPerKg, &a.HighestBidID,
+		&a.BidCount, &a.OrderID, &a.CreatedAt, &a.UpdatedAt}
+	err := row.Scan(append(dest, extra...)...)
+	return a, err
+}
+
+// CreateAuctionInStore takes the lot off the product's stock so it can't be sold twice while the auction runs
+func CreateAuctionInStore(db *sql.DB, a *types.Auction) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := order.DeductStockTx(tx, a.ProductID, a.QuantityInKg); err != nil {
+		return err
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO auctions (product_id, farmer_id, quantity_in_kg, starting_price_per_kg, reserve_price_per_kg, min_increment_per_kg,
+			starts_at, ends_at, extension_minutes, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, status, created_at, updated_at`,
+		a.ProductID, a.FarmerID, a.QuantityInKg, a.StartingPricePerKg, a.ReservePricePerKg, a.MinIncrementPerKg,
+		a.StartsAt, a.EndsAt, a.ExtensionMinutes, StatusOpen).
+		Scan(&a.ID, &a.Status, &a.CreatedAt, &a.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error inserting auction: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// GetAuctionFromStore returns the auction with its bids, highest first
+func GetAuctionFromStore(db *sql.DB, auctionID int) (types.Auction, error) {
+	a, err := scanAuction(db.QueryRow(`SELECT`+auctionColumns+auctionFrom+` WHERE a.id = $1`, auctionID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return a, fmt.Errorf("no auction found with ID %d", auctionID)
+		}
+		return a, fmt.Errorf("error querying auction: %v", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT id, auction_id, buyer_id, amount_per_kg, created_at
+		FROM auction_bids WHERE auction_id = $1 ORDER BY amount_per_kg DESC, created_at`, auctionID)
+	if err != nil {
+		return a, fmt.Errorf("error querying bids: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b types.Bid
+		if err := rows.Scan(&b.ID, &b.AuctionID, &b.BuyerID, &b.AmountPerKg, &b.CreatedAt); err != nil {
+			return a, fmt.Errorf("failed to scan bid: %v", err)
+		}
+		a.Bids = append(a.Bids, b)
+	}
+	return a, rows.Err()
+}
+
+// GetOpenAuctionsFromStore lists running and upcoming auctions, ending soonest first
+func GetOpenAuctionsFromStore(db *sql.DB) ([]types.Auction, error) {
+	rows, err := db.Query(`SELECT`+auctionColumns+auctionFrom+`
+		WHERE a.status = $1 AND a.ends_at > NOW()
+		ORDER BY a.ends_at`, StatusOpen)
+	if err != nil {
+		return nil, fmt.Errorf("error querying auctions: %v", err)
+	}
+	defer rows.Close()
+
+	auctions := []types.Auction{}
+	for rows.Next() {
+		a, err := scanAuction(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan auction: %v", err)
+		}
+		auctions = append(auctions, a)
+	}
+	return auctions, rows.Err()
+}
+
+func IsVerifiedBuyerInStore(db *sql.DB, userID int) (bool, error) {
+	var verified bool
+	err := db.QueryRow(`SELECT COALESCE(is_verified_by_admin, false) FROM buyers WHERE user_id = $1`, userID).Scan(&verified)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error finding buyer: %v", err)
+	}
+	return verified, nil
+}
+
+// PlaceBidInStore places a bid while holding the auction row, so concurrent bids are checked against each other
+// one at a time. A bid within extension_minutes of the end pushes the end out. It returns the buyer who was outbid, 0 if none.
+func PlaceBidInStore(db *sql.DB, b *types.Bid) (types.Auction, int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return types.Auction{}, 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var notStarted, ended bool
+	a, err := scanAuction(tx.QueryRow(`SELECT`+auctionColumns+`, NOW() < a.starts_at, NOW() >= a.ends_at`+auctionFrom+`
+		WHERE a.id = $1 FOR UPDATE OF a`, b.AuctionID), &notStarted, &ended)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return a, 0, fmt.Errorf("no auction found with ID %d", b.AuctionID)
+		}
+		return a, 0, fmt.Errorf("error querying auction: %v", err)
+	}
+	if a.Status != StatusOpen || ended {
+		return a, 0, fmt.Errorf("auction has ended")
+	}
+	if notStarted {
+		return a, 0, fmt.Errorf("auction starts at %s", a.StartsAt.Format("02 Jan 2006 15:04"))
+	}
+	if a.FarmerID == b.BuyerID {
+		return a, 0, fmt.Errorf("you can't bid on your own auction")
+	}
+
+	minBid := a.StartingPricePerKg
+	if a.HighestBidPerKg != nil {
+		minBid = pricing.Round(*a.HighestBidPerKg + a.MinIncrementPerKg)
+	}
+	if b.AmountPerKg < minBid {
+		return a, 0, fmt.Errorf("bid must be at least Rs %.2f/kg", minBid)
+	}
+
+	outbid := 0
+	if a.HighestBidID != nil {
+		if err := tx.QueryRow(`SELECT buyer_id FROM auction_bids WHERE id = $1`, *a.HighestBidID).Scan(&outbid); err != nil {
+			return a, 0, fmt.Errorf("error querying highest bid: %v", err)
+		}
+		if outbid == b.BuyerID {
+			outbid = 0
+		}
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO auction_bids (auction_id, buyer_id, amount_per_kg, buyers_phone_number, mode_of_delivery, delivery_address, delivery_city, delivery_address_zip)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at`,
+		b.AuctionID, b.BuyerID, b.AmountPerKg, b.BuyersPhoneNumber, b.ModeOfDelivery, b.DeliveryAddress, b.DeliveryCity, b.DeliveryAddressZIP).
+		Scan(&b.ID, &b.CreatedAt)
+	if err != nil {
+		return a, 0, fmt.Errorf("error inserting bid: %v", err)
+	}
+
+	err = tx.QueryRow(`
+		UPDATE auctions
+		SET highest_bid_id = $1, ends_at = GREATEST(ends_at, NOW() + extension_minutes * INTERVAL '1 minute'), updated_at = NOW()
+		WHERE id = $2
+		RETURNING ends_at, updated_at`, b.ID, a.ID).Scan(&a.EndsAt, &a.UpdatedAt)
+	if err != nil {
+		return a, 0, fmt.Errorf("error updating auction: %v", err)
+	}
+	a.HighestBidID = &b.ID
+	a.HighestBidPerKg = &b.AmountPerKg
+	a.ReserveMet = a.ReservePricePerKg == nil || b.AmountPerKg >= *a.ReservePricePerKg
+	a.BidCount++
+
+	if err := tx.Commit(); err != nil {
+		return a, 0, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return a, outbid, nil
+}
+
+// CancelAuctionInStore lets the farmer pull an auction nobody has bid on yet, the lot goes back to the product's stock
+func CancelAuctionInStore(db *sql.DB, auctionID, farmerID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	a, err := scanAuction(tx.QueryRow(`SELECT`+auctionColumns+auctionFrom+` WHERE a.id = $1 FOR UPDATE OF a`, auctionID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("no auction found with ID %d", auctionID)
+		}
+		return fmt.Errorf("error querying auction: %v", err)
+	}
+	if a.FarmerID != farmerID {
+		return fmt.Errorf("auction %d is not yours", auctionID)
+	}
+	if a.Status != StatusOpen {
+		return fmt.Errorf("auction is already %s", a.Status)
+	}
+	if a.BidCount > 0 {
+		return fmt.Errorf("auction already has bids")
+	}
+
+	if _, err := tx.Exec(`UPDATE auctions SET status = $1, updated_at = NOW() WHERE id = $2`, StatusCancelled, auctionID); err != nil {
+		return fmt.Errorf("error cancelling auction: %v", err)
+	}
+	if err := order.RestoreStockTx(tx, a.ProductID, a.QuantityInKg); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// GetEndedAuctionIDsFromStore lists open auctions past their end time, waiting to be closed
+func GetEndedAuctionIDsFromStore(db *sql.DB) ([]int, error) {
+	rows, err := db.Query(`SELECT id FROM auctions WHERE status = $1 AND ends_at <= NOW() ORDER BY ends_at`, StatusOpen)
+	if err != nil {
+		return nil, fmt.Errorf("error querying ended auctions: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan auction: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// CloseAuctionInStore settles an ended auction. If the highest bid meets the reserve it becomes an order at the bid
+// rate with delivery charged on top, otherwise the lot goes back to the product's stock. It returns the winning bid, if any, and the buyers who lost.
+// An auction that was extended by a late bid is left open and returned unchanged.
+func CloseAuctionInStore(db *sql.DB, auctionID int) (types.Auction, *types.Bid, []int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return types.Auction{}, nil, nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var ended bool
+	a, err := scanAuction(tx.QueryRow(`SELECT`+auctionColumns+`, NOW() >= a.ends_at`+auctionFrom+`
+		WHERE a.id = $1 FOR UPDATE OF a`, auctionID), &ended)
+	if err != nil {
+		return a, nil, nil, fmt.Errorf("error querying auction: %v", err)
+	}
+	if a.Status != StatusOpen || !ended {
+		return a, nil, nil, nil
+	}
+
+	var winner *types.Bid
+	if a.HighestBidID != nil && a.ReserveMet {
+		var b types.Bid
+		err := tx.QueryRow(`
+			SELECT id, auction_id, buyer_id, amount_per_kg, buyers_phone_number, COALESCE(mode_of_delivery, ''),
+				delivery_address, delivery_city, delivery_address_zip, created_at
+			FROM auction_bids WHERE id = $1`, *a.HighestBidID).
+			Scan(&b.ID, &b.AuctionID, &b.BuyerID, &b.AmountPerKg, &b.BuyersPhoneNumber, &b.ModeOfDelivery,
+				&b.DeliveryAddress, &b.DeliveryCity, &b.DeliveryAddressZIP, &b.CreatedAt)
+		if err != nil {
+			return a, nil, nil, fmt.Errorf("error querying winning bid: %v", err)
+		}
+		winner = &b
+	}
+
+	if winner != nil {
+		// The lot was taken off the stock when the auction was created, so only the order is inserted here
+		o := types.Order{
+			BuyerID:            winner.BuyerID,
+			ProductID:          a.ProductID,
+			QuantityInKg:       a.QuantityInKg,
+			ModeOfDelivery:     winner.ModeOfDelivery,
+			DeliveryAddress:    winner.DeliveryAddress,
+			DeliveryCity:       winner.DeliveryCity,
+			DeliveryAddressZIP: winner.DeliveryAddressZIP,
+			BuyersPhoneNumber:  winner.BuyersPhoneNumber,
+		}
+		if err := order.InsertAgreedOrderTx(db, tx, a.FarmerID, winner.AmountPerKg, &o); err != nil {
+			return a, nil, nil, err
+		}
+		a.Status = StatusSold
+		a.OrderID = &o.ID
+	} else {
+		if err := order.RestoreStockTx(tx, a.ProductID, a.QuantityInKg); err != nil {
+			return a, nil, nil, err
+		}
+		a.Status = StatusUnsold
+	}
+
+	err = tx.QueryRow(`UPDATE auctions SET status = $1, order_id = $2, updated_at = NOW() WHERE id = $3 RETURNING updated_at`,
+		a.Status, a.OrderID, auctionID).Scan(&a.UpdatedAt)
+	if err != nil {
+		return a, nil, nil, fmt.Errorf("error closing auction: %v", err)
+	}
+
+	winnerID := 0
+	if winner != nil {
+		winnerID = winner.BuyerID
+	}
+	rows, err := tx.Query(`SELECT DISTINCT buyer_id FROM auction_bids WHERE auction_id = $1 AND buyer_id <> $2`, auctionID, winnerID)
+	if err != nil {
+		return a, nil, nil, fmt.Errorf("error querying bidders: %v", err)
+	}
+	var losers []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return a, nil, nil, fmt.Errorf("failed to scan bidder: %v", err)
+		}
+		losers = append(losers, id)
+	}
+	rows.Close()
+
+	if err := tx.Commit(); err != nil {
+		return a, nil, nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+	if a.OrderID != nil {
+		order.PublishOrderEvent(db, *a.OrderID, events.TypeOrderCreated)
+	}
+	return a, winner, losers, nil
+}
@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/ritu84/agrohub/internal/referral"
 	users "github.com/ritu84/agrohub/internal/user"
 	"github.com/ritu84/agrohub/types"
 	"github.com/labstack/echo/v4"
@@ -14,7 +15,7 @@ import (
 
 // Save the user data in the temporary store at client side
 // Add resend OTP FUNCTIONALITY --> From frontend ->> HIT THis Api after 2 min
-func HandleSignUp() echo.HandlerFunc {
+func HandleSignUp(db *sql.DB) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		var u types.User
 		if err := c.Bind(&u); err != nil {
@@ -25,6 +26,17 @@ func HandleSignUp() echo.HandlerFunc {
 			return echo.NewHTTPError(echo.ErrBadRequest.Code, "Invalid Aadhar number. Please check your aadhar number and try again")
 		}
 
+		// Check the referral code before sending the OTP, so a mistyped code can be fixed. The fraud checks wait until
+		// the user is verified, and any failure gets the same error.
+		if u.ReferralCode != "" {
+			if _, err := referral.ReferrerFromStore(db, u.ReferralCode); err != nil {
+				if err != referral.ErrCodeUnusable {
+					c.Logger().Errorf("signup: %v", err)
+				}
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, referral.ErrCodeUnusable.Error())
+			}
+		}
+
 		u.CreatedAt = time.Now()
 		u.UpdatedAt = time.Now()
 		u.LastLoginAt = time.Now()
@@ -48,6 +60,19 @@ func HandleCompleteSignup(db *sql.DB) echo.HandlerFunc {
 			return echo.NewHTTPError(http.StatusBadRequest, "Invalid request data")
 		}
 
+		// Check the referral code again before the OTP is used up, it may have been changed since signup
+		referrerID := 0
+		if req.User.ReferralCode != "" {
+			id, err := referral.ReferrerFromStore(db, req.User.ReferralCode)
+			if err != nil {
+				if err != referral.ErrCodeUnusable {
+					c.Logger().Errorf("signup: %v", err)
+				}
+				return echo.NewHTTPError(http.StatusBadRequest, referral.ErrCodeUnusable.Error())
+			}
+			referrerID = id
+		}
+
 		// Verify the OTP
 		if err := VerifyOTP(req.User.Email, req.VerificationCode); err != nil {
 			return echo.NewHTTPError(http.StatusUnauthorized, fmt.Sprintf("Verification failed: %v", err))
@@ -73,6 +98,12 @@ func HandleCompleteSignup(db *sql.DB) echo.HandlerFunc {
 			return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Error updating auth verification: %v", err))
 		}
 
+		// The referrer is rewarded once the new user is approved or has an order delivered, unless the fraud checks
+		// reject the referral
+		if referrerID != 0 {
+			referral.Attribute(db, referrerID, userID, req.User.ReferralCode, req.User.AadharNumber)
+		}
+
 		// Get user type
 		userType := "buyer"
 		if req.User.IsFarmer {
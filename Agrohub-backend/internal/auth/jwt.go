@@ -31,6 +31,9 @@ func ExtractUserID(next echo.HandlerFunc) echo.HandlerFunc {
 		
 		// Store user ID in context
 		c.Set("user_id", userID)
+		if userType, ok := claims["user_type"].(string); ok {
+			c.Set("user_type", userType)
+		}
 		
 		return next(c)
 	}
@@ -0,0 +1,310 @@
+package conversation
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ritu84/agrohub/internal/notification"
+	order "github.com/ritu84/agrohub/internal/orders"
+	"github.com/ritu84/agrohub/types"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	maxMessageLength    = 2000
+	defaultMessagesPage = 50
+	maxMessagesPage     = 200
+)
+
+// participant loads the conversation in the route and checks the logged in user is its buyer or farmer
+func participant(c echo.Context, db *sql.DB) (types.Conversation, int, error) {
+	conversationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return types.Conversation{}, 0, echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing conversation id:%v", err))
+	}
+
+	userID, ok := c.Get("user_id").(int)
+	if !ok {
+		return types.Conversation{}, 0, errors.New("user_id not found or invalid type")
+	}
+
+	conv, err := GetConversationFromStore(db, conversationID)
+	if err != nil {
+		return conv, 0, echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+	}
+	if userID != conv.BuyerID && userID != conv.FarmerID {
+		return conv, 0, echo.NewHTTPError(http.StatusForbidden, "you are not part of this conversation")
+	}
+	return conv, userID, nil
+}
+
+// otherParty is the participant of a conversation who isn't userID
+func otherParty(conv types.Conversation, userID int) int {
+	if userID == conv.BuyerID {
+		return conv.FarmerID
+	}
+	return conv.BuyerID
+}
+
+// StartProductConversation opens the logged in buyer's inquiry thread with the farmer of a product
+func StartProductConversation(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		productID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing product id :%v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		conv, err := StartProductConversationInStore(db, productID, userID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error starting conversation: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, conv)
+	}
+}
+
+// StartOrderConversation opens the thread between the buyer and farmer of an order
+func StartOrderConversation(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orderID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing order id:%v", err))
+		}
+
+		buyerID, farmerID, err := order.GetOrderPartiesFromStore(db, orderID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+		if userID != buyerID && userID != farmerID {
+			return echo.NewHTTPError(http.StatusForbidden, "only the buyer and farmer of an order can message about it")
+		}
+
+		conv, err := StartOrderConversationInStore(db, orderID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error starting conversation: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, conv)
+	}
+}
+
+// GetConversations lists the logged in user's conversations with their unread counts
+func GetConversations(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		res, err := GetConversationsFromStore(db, userID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching conversations: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+// GetUnreadCount returns how many messages the logged in user hasn't read, for the badge on the inbox
+func GetUnreadCount(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		n, err := GetUnreadCountFromStore(db, userID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error counting unread messages: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, map[string]int{"unread": n})
+	}
+}
+
+// readPage parses ?after=, ?before= and ?limit= for a page of messages
+func readPage(c echo.Context) (after, before, limit int, err error) {
+	limit = defaultMessagesPage
+	for name, dest := range map[string]*int{"after": &after, "before": &before, "limit": &limit} {
+		v := c.QueryParam(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, 0, fmt.Errorf("%s must be a positive number", name)
+		}
+		*dest = n
+	}
+	if limit == 0 || limit > maxMessagesPage {
+		return 0, 0, 0, fmt.Errorf("limit must be between 1 and %d", maxMessagesPage)
+	}
+	return after, before, limit, nil
+}
+
+// GetMessages returns a page of a conversation's messages, ?after=<last message id> polls for new ones
+func GetMessages(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		conv, _, err := participant(c, db)
+		if err != nil {
+			return err
+		}
+
+		after, before, limit, err := readPage(c)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, err.Error())
+		}
+
+		res, err := GetMessagesFromStore(db, conv.ID, after, before, limit)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching messages: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+// SendMessage posts a text or image message and pushes it to the other party, who gets a notification if they're offline
+func SendMessage(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		conv, userID, err := participant(c, db)
+		if err != nil {
+			return err
+		}
+
+		var req types.SendMessage
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		req.Body = strings.TrimSpace(req.Body)
+		req.ImageURL = strings.TrimSpace(req.ImageURL)
+		if req.Body == "" && req.ImageURL == "" {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "a message needs a body or an image_url")
+		}
+		if len(req.Body) > maxMessageLength {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("a message can be at most %d characters", maxMessageLength))
+		}
+
+		m, err := SendMessageInStore(db, conv.ID, userID, req)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error sending message: %v", err))
+		}
+
+		to := otherParty(conv, userID)
+		if !chatHub.publish(to, types.ChatEvent{Type: "message", ConversationID: conv.ID, Message: &m}) {
+			preview := []rune(m.Body)
+			if len(preview) == 0 {
+				preview = []rune("Sent a photo")
+			} else if len(preview) > 80 {
+				preview = append(preview[:80], []rune("...")...)
+			}
+			notification.Notify(db, to, notification.KindMessage, fmt.Sprintf("New message about %s", conv.ProductName), string(preview))
+		}
+		chatHub.publish(userID, types.ChatEvent{Type: "message", ConversationID: conv.ID, Message: &m}) // the sender's other devices
+
+		return c.JSON(http.StatusCreated, m)
+	}
+}
+
+// MarkConversationRead marks the other party's messages read and sends them the read receipt
+func MarkConversationRead(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		conv, userID, err := participant(c, db)
+		if err != nil {
+			return err
+		}
+
+		upTo, readAt, err := MarkConversationReadInStore(db, conv.ID, userID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error marking conversation read: %v", err))
+		}
+		if upTo > 0 {
+			chatHub.publish(otherParty(conv, userID), types.ChatEvent{Type: "read", ConversationID: conv.ID, UpTo: upTo, ReadAt: &readAt})
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "conversation marked as read!"})
+	}
+}
+
+// Stream upgrades to a WebSocket that receives the logged in user's chat events as JSON. Nothing is read from it
+// except to notice it closing, messages are sent with SendMessage.
+func Stream() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		websocket.Server{
+			// The JWT authenticates the socket, the app doesn't send an Origin
+			Handshake: func(*websocket.Config, *http.Request) error { return nil },
+			Handler: func(ws *websocket.Conn) {
+				cl := &client{conn: ws, send: make(chan types.ChatEvent, sendBuffer)}
+				chatHub.register(userID, cl)
+				defer chatHub.unregister(userID, cl)
+				go cl.writePump()
+
+				var discard string
+				for websocket.Message.Receive(ws, &discard) == nil {
+				}
+			},
+		}.ServeHTTP(c.Response(), c.Request())
+		return nil
+	}
+}
+
+// GetAdminConversations lists the conversations admins can read because of a dispute
+func GetAdminConversations(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		res, err := GetAdminConversationsFromStore(db)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching conversations: %v", err))
+		}
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+// GetAdminMessages lets an admin read a conversation once it has been opened to admins
+func GetAdminMessages(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		conversationID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing conversation id:%v", err))
+		}
+
+		conv, err := GetConversationFromStore(db, conversationID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+		if conv.OpenedToAdminAt == nil {
+			return echo.NewHTTPError(http.StatusForbidden, "conversations are only visible to admins once a dispute is raised on the order")
+		}
+
+		after, before, limit, err := readPage(c)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, err.Error())
+		}
+
+		res, err := GetMessagesFromStore(db, conv.ID, after, before, limit)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching messages: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, res)
+	}
+}
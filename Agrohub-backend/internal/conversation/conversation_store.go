@@ -0,0 +1,260 @@
+package conversation
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ritu84/agrohub/types"
+)
+
+const conversationColumns = `
+	c.id, c.product_id, p.name, c.order_id, c.buyer_id, b.first_name || ' ' || b.last_name,
+	c.farmer_id, f.first_name || ' ' || f.last_name, c.opened_to_admins_at, c.created_at, c.updated_at`
+
+const conversationFrom = `
+	FROM conversations c
+	JOIN products p ON p.id = c.product_id
+	JOIN users b ON b.id = c.buyer_id
+	JOIN users f ON f.id = c.farmer_id`
+
+const messageColumns = `m.id, m.conversation_id, m.sender_id, COALESCE(m.body, ''), COALESCE(m.image_url, ''), m.read_at, m.created_at`
+
+// conversationFields are the scan destinations of conversationColumns
+func conversationFields(c *types.Conversation) []interface{} {
+	return []interface{}{&c.ID, &c.ProductID, &c.ProductName, &c.OrderID, &c.BuyerID, &c.BuyerName,
+		&c.FarmerID, &c.FarmerName, &c.OpenedToAdminAt, &c.CreatedAt, &c.UpdatedAt}
+}
+
+func scanConversation(row interface{ Scan(...interface{}) error }) (types.Conversation, error) {
+	var c types.Conversation
+	err := row.Scan(conversationFields(&c)...)
+	return c, err
+}
+
+func scanMessage(row interface{ Scan(...interface{}) error }) (types.Message, error) {
+	var m types.Message
+	err := row.Scan(&m.ID, &m.ConversationID, &m.SenderID, &m.Body, &m.ImageURL, &m.ReadAt, &m.CreatedAt)
+	return m, err
+}
+
+// StartProductConversationInStore opens the buyer's inquiry thread about a product, or returns the one they already have
+func StartProductConversationInStore(db *sql.DB, productID, buyerID int) (types.Conversation, error) {
+	var farmerID int
+	if err := db.QueryRow(`SELECT farmer_id FROM products WHERE id = $1`, productID).Scan(&farmerID); err != nil {
+		if err == sql.ErrNoRows {
+			return types.Conversation{}, fmt.Errorf("no product found with ID %d", productID)
+		}
+		return types.Conversation{}, fmt.Errorf("error querying product: %v", err)
+	}
+	if farmerID == buyerID {
+		return types.Conversation{}, fmt.Errorf("you can't start a conversation about your own product")
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO conversations (product_id, buyer_id, farmer_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (product_id, buyer_id) WHERE order_id IS NULL DO NOTHING`, productID, buyerID, farmerID)
+	if err != nil {
+		return types.Conversation{}, fmt.Errorf("error inserting conversation: %v", err)
+	}
+
+	c, err := scanConversation(db.QueryRow(`SELECT`+conversationColumns+conversationFrom+`
+		WHERE c.product_id = $1 AND c.buyer_id = $2 AND c.order_id IS NULL`, productID, buyerID))
+	if err != nil {
+		return c, fmt.Errorf("error querying conversation: %v", err)
+	}
+	return c, nil
+}
+
+// StartOrderConversationInStore opens the thread about an order, or returns the existing one
+func StartOrderConversationInStore(db *sql.DB, orderID int) (types.Conversation, error) {
+	_, err := db.Exec(`
+		INSERT INTO conversations (product_id, order_id, buyer_id, farmer_id)
+		SELECT o.product_id, o.id, o.buyer_id, p.farmer_id
+		FROM orders o JOIN products p ON p.id = o.product_id
+		WHERE o.id = $1
+		ON CONFLICT (order_id) DO NOTHING`, orderID)
+	if err != nil {
+		return types.Conversation{}, fmt.Errorf("error inserting conversation: %v", err)
+	}
+
+	c, err := scanConversation(db.QueryRow(`SELECT`+conversationColumns+conversationFrom+` WHERE c.order_id = $1`, orderID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c, fmt.Errorf("no order found with ID %d", orderID)
+		}
+		return c, fmt.Errorf("error querying conversation: %v", err)
+	}
+	return c, nil
+}
+
+// GetConversationFromStore returns a conversation, the caller checks who may see it
+func GetConversationFromStore(db *sql.DB, conversationID int) (types.Conversation, error) {
+	c, err := scanConversation(db.QueryRow(`SELECT`+conversationColumns+conversationFrom+` WHERE c.id = $1`, conversationID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c, fmt.Errorf("no conversation found with ID %d", conversationID)
+		}
+		return c, fmt.Errorf("error querying conversation: %v", err)
+	}
+	return c, nil
+}
+
+// GetConversationsFromStore lists the user's conversations with their last message and unread count, latest activity first
+func GetConversationsFromStore(db *sql.DB, userID int) ([]types.Conversation, error) {
+	rows, err := db.Query(`SELECT`+conversationColumns+`,
+		(SELECT COUNT(*) FROM messages m WHERE m.conversation_id = c.id AND m.sender_id <> $1 AND m.read_at IS NULL),
+		lm.id, lm.sender_id, lm.body, lm.image_url, lm.read_at, lm.created_at`+conversationFrom+`
+		LEFT JOIN LATERAL (
+			SELECT * FROM messages m WHERE m.conversation_id = c.id ORDER BY m.id DESC LIMIT 1
+		) lm ON true
+		WHERE c.buyer_id = $1 OR c.farmer_id = $1
+		ORDER BY COALESCE(lm.created_at, c.created_at) DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying conversations: %v", err)
+	}
+	defer rows.Close()
+
+	conversations := []types.Conversation{}
+	for rows.Next() {
+		var c types.Conversation
+		var last types.Message
+		var lastID, lastSender sql.NullInt64
+		var lastBody, lastImage sql.NullString
+		var lastCreatedAt sql.NullTime
+		dest := append(conversationFields(&c), &c.Unread, &lastID, &lastSender, &lastBody, &lastImage, &last.ReadAt, &lastCreatedAt)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to scan conversation: %v", err)
+		}
+		if lastID.Valid {
+			last.ID, last.ConversationID, last.SenderID = int(lastID.Int64), c.ID, int(lastSender.Int64)
+			last.Body, last.ImageURL, last.CreatedAt = lastBody.String, lastImage.String, lastCreatedAt.Time
+			c.LastMessage = &last
+		}
+		conversations = append(conversations, c)
+	}
+	return conversations, rows.Err()
+}
+
+// GetMessagesFromStore returns up to limit messages of a conversation, oldest first. With afterID it returns the ones
+// after it, which is how clients without a WebSocket poll for new messages. With beforeID it pages back through
+// history, otherwise it returns the latest ones.
+func GetMessagesFromStore(db *sql.DB, conversationID, afterID, beforeID, limit int) ([]types.Message, error) {
+	var q string
+	var args []interface{}
+	if afterID > 0 {
+		q = `SELECT ` + messageColumns + ` FROM messages m WHERE m.conversation_id = $1 AND m.id > $2 ORDER BY m.id LIMIT $3`
+		args = []interface{}{conversationID, afterID, limit}
+	} else {
+		q = `SELECT * FROM (
+			SELECT ` + messageColumns + ` FROM messages m
+			WHERE m.conversation_id = $1 AND ($2 = 0 OR m.id < $2)
+			ORDER BY m.id DESC LIMIT $3
+		) latest ORDER BY id`
+		args = []interface{}{conversationID, beforeID, limit}
+	}
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying messages: %v", err)
+	}
+	defer rows.Close()
+
+	messages := []types.Message{}
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message: %v", err)
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// SendMessageInStore adds a message to a conversation
+func SendMessageInStore(db *sql.DB, conversationID, senderID int, msg types.SendMessage) (types.Message, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return types.Message{}, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	m, err := scanMessage(tx.QueryRow(`
+		INSERT INTO messages AS m (conversation_id, sender_id, body, image_url)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''))
+		RETURNING `+messageColumns, conversationID, senderID, msg.Body, msg.ImageURL))
+	if err != nil {
+		return m, fmt.Errorf("error inserting message: %v", err)
+	}
+
+	if _, err := tx.Exec(`UPDATE conversations SET updated_at = NOW() WHERE id = $1`, conversationID); err != nil {
+		return m, fmt.Errorf("error updating conversation: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return m, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return m, nil
+}
+
+// MarkConversationReadInStore marks the other party's messages read, upTo is the last one it marked or 0 if there were none
+func MarkConversationReadInStore(db *sql.DB, conversationID, readerID int) (upTo int, readAt time.Time, err error) {
+	err = db.QueryRow(`
+		WITH marked AS (
+			UPDATE messages SET read_at = NOW()
+			WHERE conversation_id = $1 AND sender_id <> $2 AND read_at IS NULL
+			RETURNING id, read_at
+		)
+		SELECT COALESCE(MAX(id), 0), COALESCE(MAX(read_at), NOW()) FROM marked`, conversationID, readerID).Scan(&upTo, &readAt)
+	if err != nil {
+		return 0, readAt, fmt.Errorf("error marking messages read: %v", err)
+	}
+	return upTo, readAt, nil
+}
+
+// GetUnreadCountFromStore counts the messages the user hasn't read across all their conversations
+func GetUnreadCountFromStore(db *sql.DB, userID int) (int, error) {
+	var n int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE (c.buyer_id = $1 OR c.farmer_id = $1) AND m.sender_id <> $1 AND m.read_at IS NULL`, userID).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("error counting unread messages: %v", err)
+	}
+	return n, nil
+}
+
+// OpenToAdminsInStore lets admins read the conversation about an order, it is called when a dispute is raised on it
+func OpenToAdminsInStore(db *sql.DB, orderID int) error {
+	c, err := StartOrderConversationInStore(db, orderID)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(`UPDATE conversations SET opened_to_admins_at = COALESCE(opened_to_admins_at, NOW()) WHERE id = $1`, c.ID); err != nil {
+		return fmt.Errorf("error opening conversation to admins: %v", err)
+	}
+	return nil
+}
+
+// GetAdminConversationsFromStore lists the conversations opened to admins, latest first
+func GetAdminConversationsFromStore(db *sql.DB) ([]types.Conversation, error) {
+	rows, err := db.Query(`SELECT` + conversationColumns + conversationFrom + `
+		WHERE c.opened_to_admins_at IS NOT NULL
+		ORDER BY c.opened_to_admins_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying conversations: %v", err)
+	}
+	defer rows.Close()
+
+	conversations := []types.Conversation{}
+	for rows.Next() {
+		c, err := scanConversation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan conversation: %v", err)
+		}
+		conversations = append(conversations, c)
+	}
+	return conversations, rows.Err()
+}
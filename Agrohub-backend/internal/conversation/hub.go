@@ -0,0 +1,79 @@
+package conversation
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ritu84/agrohub/types"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	sendBuffer   = 32
+	writeTimeout = 10 * time.Second
+)
+
+// client is one open WebSocket, events are written from its own goroutine so a slow phone can't hold up the sender
+type client struct {
+	conn *websocket.Conn
+	send chan types.ChatEvent
+}
+
+// hub keeps the open WebSockets of each user in this process
+type hub struct {
+	mu      sync.Mutex
+	clients map[int]map[*client]struct{}
+}
+
+var chatHub = &hub{clients: map[int]map[*client]struct{}{}}
+
+func (h *hub) register(userID int, c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clients[userID] == nil {
+		h.clients[userID] = map[*client]struct{}{}
+	}
+	h.clients[userID][c] = struct{}{}
+}
+
+func (h *hub) unregister(userID int, c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[userID][c]; !ok {
+		return
+	}
+	delete(h.clients[userID], c)
+	if len(h.clients[userID]) == 0 {
+		delete(h.clients, userID)
+	}
+	close(c.send)
+}
+
+// publish hands an event to every open WebSocket of the user, it reports whether the user had any. A client whose
+// buffer is full misses the event and catches up by polling.
+func (h *hub) publish(userID int, e types.ChatEvent) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients[userID] {
+		select {
+		case c.send <- e:
+		default:
+			log.Printf("conversation: dropped %s event for user %d, client too slow", e.Type, userID)
+		}
+	}
+	return len(h.clients[userID]) > 0
+}
+
+// writePump sends a client its events until it is unregistered
+func (c *client) writePump() {
+	for e := range c.send {
+		c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err := websocket.JSON.Send(c.conn, e); err != nil {
+			c.conn.Close()
+			for range c.send {
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package dispute
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ritu84/agrohub/internal/notification"
+	"github.com/ritu84/agrohub/internal/payment"
+	"github.com/ritu84/agrohub/types"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	maxDisputePhotos = 5
+	maxDisputeLength = 2000
+)
+
+// OpenDispute lets the buyer of a delivered order raise a dispute within windowDays of delivery
+func OpenDispute(db *sql.DB, windowDays int) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orderID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing order id:%v", err))
+		}
+
+		var req types.OpenDispute
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if !Reasons[req.Reason] {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "reason must be quality, short_weight, damaged, wrong_item or other")
+		}
+		req.Description = strings.TrimSpace(req.Description)
+		if req.Description == "" || len(req.Description) > maxDisputeLength {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("description must be between 1 and %d characters", maxDisputeLength))
+		}
+		if len(req.PhotoURLs) == 0 || len(req.PhotoURLs) > maxDisputePhotos {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("a dispute needs between 1 and %d photos", maxDisputePhotos))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		d, err := OpenDisputeInStore(db, orderID, userID, windowDays, req)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error opening dispute: %v", err))
+		}
+
+		notification.Notify(db, d.FarmerID, notification.KindDispute, "A buyer raised a dispute",
+			fmt.Sprintf("The buyer of order #%d (%s) raised a dispute. Respond so an admin can resolve it, the order won't be paid out until then.", d.OrderID, d.ProductName))
+
+		return c.JSON(http.StatusCreated, d)
+	}
+}
+
+// GetOrderDispute returns the dispute raised on an order, to its buyer and farmer
+func GetOrderDispute(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orderID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing order id:%v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		d, err := GetOrderDisputeFromStore(db, orderID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+		if userID != d.BuyerID && userID != d.FarmerID {
+			return echo.NewHTTPError(http.StatusForbidden, "only the buyer and farmer of an order can see its dispute")
+		}
+
+		return c.JSON(http.StatusOK, d)
+	}
+}
+
+// GetMyDisputes lists the disputes the logged in user raised or has to answer
+func GetMyDisputes(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		disputes, err := GetMyDisputesFromStore(db, userID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching disputes: %v", err))
+		}
+		return c.JSON(http.StatusOK, disputes)
+	}
+}
+
+// RespondToDispute lets the farmer give their side with optional photos
+func RespondToDispute(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		disputeID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing dispute id:%v", err))
+		}
+
+		var req types.RespondDispute
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		req.Response = strings.TrimSpace(req.Response)
+		if req.Response == "" || len(req.Response) > maxDisputeLength {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("response must be between 1 and %d characters", maxDisputeLength))
+		}
+		if len(req.PhotoURLs) > maxDisputePhotos {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("a response can have at most %d photos", maxDisputePhotos))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		d, err := RespondToDisputeInStore(db, disputeID, userID, req)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error responding to dispute: %v", err))
+		}
+
+		notification.Notify(db, d.BuyerID, notification.KindDispute, "The farmer responded to your dispute",
+			fmt.Sprintf("The farmer responded to your dispute on order #%d. An admin will resolve it.", d.OrderID))
+
+		return c.JSON(http.StatusOK, d)
+	}
+}
+
+// GetDisputes lists disputes for admins, ?status= open, responded or resolved. Without it, the unresolved ones.
+func GetDisputes(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		status := c.QueryParam("status")
+		if status != "" && status != StatusOpen && status != StatusResponded && status != StatusResolved {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "status must be open, responded or resolved")
+		}
+
+		disputes, err := GetDisputesFromStore(db, status)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching disputes: %v", err))
+		}
+		return c.JSON(http.StatusOK, disputes)
+	}
+}
+
+// GetDispute returns one dispute for admins
+func GetDispute(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		disputeID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing dispute id:%v", err))
+		}
+
+		d, err := GetDisputeFromStore(db, disputeID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+		return c.JSON(http.StatusOK, d)
+	}
+}
+
+// ResolveDispute lets an admin refund the buyer in full or in part, or reject the dispute
+func ResolveDispute(db *sql.DB, provider payment.PaymentProvider) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		disputeID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing dispute id:%v", err))
+		}
+
+		var req types.ResolveDispute
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		switch req.Resolution {
+		case ResolutionFullRefund, ResolutionRejected:
+		case ResolutionPartialRefund:
+			if req.Amount <= 0 {
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, "a partial refund needs an amount")
+			}
+		default:
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "resolution must be full_refund, partial_refund or rejected")
+		}
+		req.Note = strings.TrimSpace(req.Note)
+		if req.Resolution == ResolutionRejected && req.Note == "" {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "a note is required to reject a dispute")
+		}
+
+		adminID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		d, err := ResolveDisputeInStore(db, provider, disputeID, adminID, req)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error resolving dispute: %v", err))
+		}
+
+		outcome := "was rejected"
+		if d.Resolution != ResolutionRejected {
+			outcome = fmt.Sprintf("was resolved with a refund of Rs %.2f", d.RefundAmount)
+		}
+		for _, to := range []int{d.BuyerID, d.FarmerID} {
+			notification.Notify(db, to, notification.KindDispute, "Dispute resolved",
+				fmt.Sprintf("The dispute on order #%d %s.", d.OrderID, outcome))
+		}
+
+		return c.JSON(http.StatusOK, d)
+	}
+}
@@ -0,0 +1,294 @@
+package dispute
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/lib/pq"
+	"github.com/ritu84/agrohub/internal/conversation"
+	"github.com/ritu84/agrohub/internal/events"
+	order "github.com/ritu84/agrohub/internal/orders"
+	"github.com/ritu84/agrohub/internal/payment"
+	"github.com/ritu84/agrohub/types"
+)
+
+const (
+	StatusOpen      = "open"      // waiting for the farmer
+	StatusResponded = "responded" // waiting for an admin
+	StatusResolved  = "resolved"
+
+	ResolutionFullRefund    = "full_refund"
+	ResolutionPartialRefund = "partial_refund"
+	ResolutionRejected      = "rejected"
+)
+
+// Reasons a buyer can give for a dispute
+var Reasons = map[string]bool{"quality": true, "short_weight": true, "damaged": true, "wrong_item": true, "other": true}
+
+const disputeColumns = `
+	d.id, d.order_id, o.product_id, p.name, d.buyer_id, d.farmer_id, d.reason, d.description, d.photo_urls,
+	COALESCE(d.response, ''), d.response_photo_urls, d.responded_at, d.status, COALESCE(d.resolution, ''),
+	COALESCE(d.refund_amount, 0), COALESCE(d.resolution_note, ''), d.resolved_at, d.created_at, d.updated_at`
+
+const disputeFrom = `
+	FROM disputes d
+	JOIN orders o ON o.id = d.order_id
+	JOIN products p ON p.id = o.product_id`
+
+func scanDispute(row interface{ Scan(...interface{}) error }) (types.Dispute, error) {
+	var d types.Dispute
+	err := row.Scan(&d.ID, &d.OrderID, &d.ProductID, &d.ProductName, &d.BuyerID, &d.FarmerID, &d.Reason, &d.Description,
+		pq.Array(&d.PhotoURLs), &d.Response, pq.Array(&d.ResponsePhotoURLs), &d.RespondedAt, &d.Status, &d.Resolution,
+		&d.RefundAmount, &d.ResolutionNote, &d.ResolvedAt, &d.CreatedAt, &d.UpdatedAt)
+	return d, err
+}
+
+// publish tells both parties a dispute changed
+func publish(d types.Dispute) {
+	e := types.DisputeEvent{DisputeID: d.ID, OrderID: d.OrderID, Status: d.Status, Resolution: d.Resolution}
+	events.Publish(d.BuyerID, events.TypeDisputeUpdated, e)
+	events.Publish(d.FarmerID, events.TypeDisputeUpdated, e)
+}
+
+// OpenDisputeInStore raises the buyer's dispute on an order delivered less than windowDays ago, an order can only be
+// disputed once. The order's conversation is opened to admins so they can read what was said.
+func OpenDisputeInStore(db *sql.DB, orderID, buyerID, windowDays int, req types.OpenDispute) (types.Dispute, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return types.Dispute{}, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var status string
+	var orderBuyerID, farmerID int
+	var inWindow bool
+	err = tx.QueryRow(`
+		SELECT o.status, o.buyer_id, p.farmer_id, o.delivered_at > NOW() - $2 * INTERVAL '1 day'
+		FROM orders o JOIN products p ON p.id = o.product_id
+		WHERE o.id = $1
+		FOR UPDATE OF o`, orderID, windowDays).Scan(&status, &orderBuyerID, &farmerID, &inWindow)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return types.Dispute{}, fmt.Errorf("no order found with ID %d", orderID)
+		}
+		return types.Dispute{}, fmt.Errorf("error querying order: %v", err)
+	}
+	if orderBuyerID != buyerID {
+		return types.Dispute{}, fmt.Errorf("only the buyer of an order can dispute it")
+	}
+	if status != "delivered" {
+		return types.Dispute{}, fmt.Errorf("an order can only be disputed once it is delivered")
+	}
+	if !inWindow {
+		return types.Dispute{}, fmt.Errorf("orders can only be disputed within %d days of delivery", windowDays)
+	}
+
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM disputes WHERE order_id = $1)`, orderID).Scan(&exists); err != nil {
+		return types.Dispute{}, fmt.Errorf("error querying dispute: %v", err)
+	}
+	if exists {
+		return types.Dispute{}, fmt.Errorf("order %d has already been disputed", orderID)
+	}
+
+	var disputeID int
+	err = tx.QueryRow(`
+		INSERT INTO disputes (order_id, buyer_id, farmer_id, reason, description, photo_urls, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id`, orderID, buyerID, farmerID, req.Reason, req.Description, pq.Array(req.PhotoURLs), StatusOpen).Scan(&disputeID)
+	if err != nil {
+		return types.Dispute{}, fmt.Errorf("error inserting dispute: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return types.Dispute{}, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	if err := conversation.OpenToAdminsInStore(db, orderID); err != nil {
+		return types.Dispute{}, err
+	}
+
+	d, err := GetDisputeFromStore(db, disputeID)
+	if err != nil {
+		return d, err
+	}
+	publish(d)
+	return d, nil
+}
+
+// GetDisputeFromStore returns a dispute, the caller checks who may see it
+func GetDisputeFromStore(db *sql.DB, disputeID int) (types.Dispute, error) {
+	d, err := scanDispute(db.QueryRow(`SELECT`+disputeColumns+disputeFrom+` WHERE d.id = $1`, disputeID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return d, fmt.Errorf("no dispute found with ID %d", disputeID)
+		}
+		return d, fmt.Errorf("error querying dispute: %v", err)
+	}
+	return d, nil
+}
+
+// GetOrderDisputeFromStore returns the dispute raised on an order
+func GetOrderDisputeFromStore(db *sql.DB, orderID int) (types.Dispute, error) {
+	d, err := scanDispute(db.QueryRow(`SELECT`+disputeColumns+disputeFrom+` WHERE d.order_id = $1`, orderID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return d, fmt.Errorf("order %d has no dispute", orderID)
+		}
+		return d, fmt.Errorf("error querying dispute: %v", err)
+	}
+	return d, nil
+}
+
+func queryDisputes(db *sql.DB, query string, args ...interface{}) ([]types.Dispute, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying disputes: %v", err)
+	}
+	defer rows.Close()
+
+	disputes := []types.Dispute{}
+	for rows.Next() {
+		d, err := scanDispute(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan dispute: %v", err)
+		}
+		disputes = append(disputes, d)
+	}
+	return disputes, rows.Err()
+}
+
+// GetMyDisputesFromStore lists the disputes the user raised or has to answer, latest first
+func GetMyDisputesFromStore(db *sql.DB, userID int) ([]types.Dispute, error) {
+	return queryDisputes(db, `SELECT`+disputeColumns+disputeFrom+`
+		WHERE d.buyer_id = $1 OR d.farmer_id = $1
+		ORDER BY d.created_at DESC`, userID)
+}
+
+// GetDisputesFromStore lists disputes for admins, oldest first so the longest waiting are handled first. An empty
+// status lists the ones still to be resolved.
+func GetDisputesFromStore(db *sql.DB, status string) ([]types.Dispute, error) {
+	if status == "" {
+		return queryDisputes(db, `SELECT`+disputeColumns+disputeFrom+`
+			WHERE d.status <> $1
+			ORDER BY d.created_at`, StatusResolved)
+	}
+	return queryDisputes(db, `SELECT`+disputeColumns+disputeFrom+`
+		WHERE d.status = $1
+		ORDER BY d.created_at`, status)
+}
+
+// RespondToDisputeInStore records the farmer's side of a dispute, responding again replaces it until it is resolved
+func RespondToDisputeInStore(db *sql.DB, disputeID, farmerID int, req types.RespondDispute) (types.Dispute, error) {
+	var owner int
+	var status string
+	err := db.QueryRow(`SELECT farmer_id, status FROM disputes WHERE id = $1`, disputeID).Scan(&owner, &status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return types.Dispute{}, fmt.Errorf("no dispute found with ID %d", disputeID)
+		}
+		return types.Dispute{}, fmt.Errorf("error querying dispute: %v", err)
+	}
+	if owner != farmerID {
+		return types.Dispute{}, fmt.Errorf("only the farmer of the order can respond to this dispute")
+	}
+
+	res, err := db.Exec(`
+		UPDATE disputes SET response = $1, response_photo_urls = $2, responded_at = NOW(), status = $3, updated_at = NOW()
+		WHERE id = $4 AND status <> $5`,
+		req.Response, pq.Array(req.PhotoURLs), StatusResponded, disputeID, StatusResolved)
+	if err != nil {
+		return types.Dispute{}, fmt.Errorf("error updating dispute: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return types.Dispute{}, fmt.Errorf("dispute %d has already been resolved", disputeID)
+	}
+
+	d, err := GetDisputeFromStore(db, disputeID)
+	if err != nil {
+		return d, err
+	}
+	publish(d)
+	return d, nil
+}
+
+// ResolveDisputeInStore closes a dispute with an admin's decision. A refund is taken from the farmer's earnings and
+// goes back to the buyer through the payment provider, or outside the platform when the order was paid cash on
+// delivery. The refund is recorded in the same transaction as the decision so a dispute is never refunded twice, a
+// refund the provider doesn't confirm straight away is retried by the retry-refunds job.
+func ResolveDisputeInStore(db *sql.DB, provider payment.PaymentProvider, disputeID, adminID int, req types.ResolveDispute) (types.Dispute, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return types.Dispute{}, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var orderID int
+	var status string
+	err = tx.QueryRow(`SELECT order_id, status FROM disputes WHERE id = $1 FOR UPDATE`, disputeID).Scan(&orderID, &status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return types.Dispute{}, fmt.Errorf("no dispute found with ID %d", disputeID)
+		}
+		return types.Dispute{}, fmt.Errorf("error querying dispute: %v", err)
+	}
+	if status == StatusResolved {
+		return types.Dispute{}, fmt.Errorf("dispute %d has already been resolved", disputeID)
+	}
+
+	var refundIDs []int
+	var refunded float64
+	if req.Resolution != ResolutionRejected {
+		amount := req.Amount
+		if req.Resolution == ResolutionFullRefund {
+			amount = 0 // whatever is left
+		}
+		reason := fmt.Sprintf("dispute #%d", disputeID)
+
+		var method string
+		err := tx.QueryRow(`SELECT method FROM payments WHERE order_id = $1 ORDER BY id DESC LIMIT 1`, orderID).Scan(&method)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return types.Dispute{}, fmt.Errorf("order %d has no payment to refund", orderID)
+			}
+			return types.Dispute{}, fmt.Errorf("error querying payment: %v", err)
+		}
+		if method == payment.MethodCOD {
+			refunded, err = payment.RefundOffPlatformTx(tx, orderID, amount, reason)
+		} else {
+			refundIDs, refunded, err = payment.RequestRefundTx(tx, orderID, amount, reason)
+		}
+		if err != nil {
+			return types.Dispute{}, err
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE disputes SET status = $1, resolution = $2, refund_amount = $3, resolution_note = NULLIF($4, ''),
+			resolved_by = $5, resolved_at = NOW(), updated_at = NOW()
+		WHERE id = $6`, StatusResolved, req.Resolution, refunded, req.Note, adminID, disputeID)
+	if err != nil {
+		return types.Dispute{}, fmt.Errorf("error updating dispute: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return types.Dispute{}, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	for _, refundID := range refundIDs {
+		if _, err := payment.ProcessRefundInStore(db, provider, refundID); err != nil {
+			log.Printf("dispute: refund %d of dispute %d is pending and will be retried: %v", refundID, disputeID, err)
+		}
+	}
+	if len(refundIDs) == 0 && refunded > 0 {
+		order.PublishOrderEvent(db, orderID, events.TypeOrderStatus)
+	}
+
+	d, err := GetDisputeFromStore(db, disputeID)
+	if err != nil {
+		return d, err
+	}
+	publish(d)
+	return d, nil
+}
@@ -0,0 +1,147 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	heartbeatEvery = 25 * time.Second
+	writeTimeout   = 10 * time.Second
+)
+
+// lastEventID is where the client wants to resume from. Browsers send Last-Event-ID when an EventSource reconnects,
+// ?last_event_id= covers the first connect and WebSockets.
+func lastEventID(c echo.Context) int64 {
+	v := c.Request().Header.Get("Last-Event-ID")
+	if v == "" {
+		v = c.QueryParam("last_event_id")
+	}
+	id, _ := strconv.ParseInt(v, 10, 64)
+	return id
+}
+
+// Stream sends the logged in user's events, over a WebSocket when the request asks to upgrade and as Server-Sent
+// Events otherwise. A resync event with no ID means events were missed and the app should refetch.
+func Stream() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		s, replay, resync, err := DefaultHub.Subscribe(userID, lastEventID(c))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusTooManyRequests, err.Error())
+		}
+		defer DefaultHub.Unsubscribe(s)
+
+		if strings.EqualFold(c.Request().Header.Get("Upgrade"), "websocket") {
+			streamWebSocket(c, s, replay, resync)
+			return nil
+		}
+		return streamSSE(c, s, replay, resync)
+	}
+}
+
+func streamSSE(c echo.Context, s *Subscriber, replay []Event, resync bool) error {
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // stop nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+
+	write := func(e Event) error {
+		data, err := json.Marshal(e.Data)
+		if err != nil {
+			return err
+		}
+		if e.ID > 0 {
+			fmt.Fprintf(w, "id: %d\n", e.ID)
+		}
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		w.Flush()
+		return err
+	}
+
+	if resync {
+		if err := write(Event{Type: "resync", Data: struct{}{}}); err != nil {
+			return nil
+		}
+	}
+	for _, e := range replay {
+		if err := write(e); err != nil {
+			return nil
+		}
+	}
+
+	heartbeat := time.NewTicker(heartbeatEvery)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e, ok := <-s.C:
+			if !ok {
+				return nil
+			}
+			if err := write(e); err != nil {
+				return nil
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return nil
+			}
+			w.Flush()
+		case <-c.Request().Context().Done():
+			return nil
+		}
+	}
+}
+
+func streamWebSocket(c echo.Context, s *Subscriber, replay []Event, resync bool) {
+	websocket.Server{
+		// The JWT authenticates the socket, the app doesn't send an Origin
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(ws *websocket.Conn) {
+			closed := make(chan struct{})
+			go func() {
+				var discard string
+				for websocket.Message.Receive(ws, &discard) == nil {
+				}
+				close(closed)
+			}()
+
+			send := func(e Event) bool {
+				ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+				return websocket.JSON.Send(ws, e) == nil
+			}
+
+			if resync && !send(Event{Type: "resync", Data: struct{}{}}) {
+				return
+			}
+			for _, e := range replay {
+				if !send(e) {
+					return
+				}
+			}
+			for {
+				select {
+				case e, ok := <-s.C:
+					if !ok || !send(e) {
+						return
+					}
+				case <-closed:
+					return
+				}
+			}
+		},
+	}.ServeHTTP(c.Response(), c.Request())
+}
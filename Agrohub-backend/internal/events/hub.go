@@ -0,0 +1,183 @@
+package events
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ritu84/agrohub/internal/scheduler"
+)
+
+// Types of events, the app uses them to decide which screen to refresh
+const (
+	TypeOrderCreated     = "order.created"     // a new order, to the farmer and the buyer
+	TypeOrderStatus      = "order.status"      // an order changed status, to the farmer and the buyer
+	TypeProductModerated = "product.moderated" // an admin approved or rejected a listing, to its farmer
+	TypeReviewModerated  = "review.moderated"  // an admin hid or restored a review, to its buyer
+	TypeDisputeUpdated   = "dispute.updated"   // a dispute was opened, answered or resolved, to the buyer and the farmer
+)
+
+const (
+	historySize       = 100       // events kept per user to resume from
+	historyAge        = time.Hour // and for how long
+	sweepEvery        = 1000      // publishes between sweeps of users with nothing left to resume
+	subscriberBuffer  = 64
+	defaultPerUser    = 3
+	defaultMaxStreams = 1000
+)
+
+var (
+	ErrTooManyForUser = errors.New("too many open event streams for this user")
+	ErrTooManyStreams = errors.New("too many open event streams")
+)
+
+// Event is something that happened to one user. IDs increase across all users, a client resumes from the last ID it saw.
+type Event struct {
+	ID   int64       `json:"id"`
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+	at   time.Time
+}
+
+// Subscriber is one open stream. Its channel is closed when it is unsubscribed or when it falls too far behind, the
+// client then reconnects with its last event ID and catches up from the history.
+type Subscriber struct {
+	userID int
+	C      chan Event
+}
+
+type userState struct {
+	history   []Event
+	trimmedTo int64 // highest ID dropped from history, resuming from before it would miss events
+	subs      map[*Subscriber]struct{}
+}
+
+// Hub is an in-process pub/sub of per-user events. It only reaches streams open on this instance.
+type Hub struct {
+	mu         sync.Mutex
+	bootID     int64 // IDs start here, anything lower was issued before a restart
+	nextID     int64
+	sweptTo    int64 // users swept since may have missed anything up to here
+	users      map[int]*userState
+	streams    int
+	perUser    int
+	maxStreams int
+}
+
+// NewHub starts IDs from the clock so they keep increasing across restarts
+func NewHub(perUser, maxStreams int) *Hub {
+	boot := time.Now().UnixMilli() * 1000
+	return &Hub{bootID: boot, nextID: boot, users: map[int]*userState{}, perUser: perUser, maxStreams: maxStreams}
+}
+
+// DefaultHub is the hub the stores publish into. EVENTS_MAX_PER_USER and EVENTS_MAX_CONNECTIONS set its limits.
+var DefaultHub = NewHub(scheduler.IntFromEnv("EVENTS_MAX_PER_USER", defaultPerUser), scheduler.IntFromEnv("EVENTS_MAX_CONNECTIONS", defaultMaxStreams))
+
+// Publish sends an event to a user through DefaultHub
+func Publish(userID int, typ string, data interface{}) {
+	DefaultHub.Publish(userID, typ, data)
+}
+
+func (h *Hub) user(userID int) *userState {
+	u := h.users[userID]
+	if u == nil {
+		u = &userState{trimmedTo: h.sweptTo, subs: map[*Subscriber]struct{}{}}
+		h.users[userID] = u
+	}
+	return u
+}
+
+// sweep forgets users with no open streams and no history left
+func (h *Hub) sweep() {
+	for id, u := range h.users {
+		h.trim(u)
+		if len(u.subs) == 0 && len(u.history) == 0 {
+			delete(h.users, id)
+		}
+	}
+	h.sweptTo = h.nextID
+}
+
+// Publish records an event for a user and hands it to their open streams
+func (h *Hub) Publish(userID int, typ string, data interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.nextID++
+	e := Event{ID: h.nextID, Type: typ, Data: data, at: time.Now()}
+	u := h.user(userID)
+	u.history = append(u.history, e)
+	h.trim(u)
+
+	for s := range u.subs {
+		select {
+		case s.C <- e:
+		default:
+			h.drop(u, s)
+		}
+	}
+
+	if (h.nextID-h.bootID)%sweepEvery == 0 {
+		h.sweep()
+	}
+}
+
+// trim drops events past the size or age of the history
+func (h *Hub) trim(u *userState) {
+	cut := 0
+	for cut < len(u.history) && (len(u.history)-cut > historySize || time.Since(u.history[cut].at) > historyAge) {
+		u.trimmedTo = u.history[cut].ID
+		cut++
+	}
+	u.history = u.history[cut:]
+}
+
+func (h *Hub) drop(u *userState, s *Subscriber) {
+	if _, ok := u.subs[s]; !ok {
+		return
+	}
+	delete(u.subs, s)
+	close(s.C)
+	h.streams--
+}
+
+// Subscribe opens a stream for a user. With a lastEventID it returns the events since then to send first, resync is
+// true when some of them are gone (too old, or from before a restart) and the client should refetch instead.
+func (h *Hub) Subscribe(userID int, lastEventID int64) (s *Subscriber, replay []Event, resync bool, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	u := h.user(userID)
+	if len(u.subs) >= h.perUser {
+		return nil, nil, false, ErrTooManyForUser
+	}
+	if h.streams >= h.maxStreams {
+		return nil, nil, false, ErrTooManyStreams
+	}
+
+	if lastEventID > 0 {
+		h.trim(u)
+		resync = lastEventID < h.bootID || lastEventID > h.nextID || lastEventID < u.trimmedTo
+		if !resync {
+			for _, e := range u.history {
+				if e.ID > lastEventID {
+					replay = append(replay, e)
+				}
+			}
+		}
+	}
+
+	s = &Subscriber{userID: userID, C: make(chan Event, subscriberBuffer)}
+	u.subs[s] = struct{}{}
+	h.streams++
+	return s, replay, resync, nil
+}
+
+// Unsubscribe closes a stream, it is safe to call after the hub has dropped it
+func (h *Hub) Unsubscribe(s *Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if u := h.users[s.userID]; u != nil {
+		h.drop(u, s)
+	}
+}
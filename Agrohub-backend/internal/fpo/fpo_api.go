@@ -0,0 +1,331 @@
+package fpo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ritu84/agrohub/internal/notification"
+	"github.com/ritu84/agrohub/types"
+	"github.com/labstack/echo/v4"
+)
+
+// validate trims an FPO's details and checks them
+func validate(f *types.FPO) error {
+	f.Name = strings.TrimSpace(f.Name)
+	f.RegistrationNumber = strings.ToUpper(strings.TrimSpace(f.RegistrationNumber))
+	f.District = strings.TrimSpace(f.District)
+	f.State = strings.TrimSpace(f.State)
+	if f.Name == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+// member parses the FPO in the URL and checks the logged in farmer belongs to it, as a manager when managerOnly is set.
+// It returns the FPO and the farmer.
+func member(c echo.Context, db *sql.DB, managerOnly bool) (int, int, error) {
+	fpoID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, 0, echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing FPO id:%v", err))
+	}
+
+	userID, ok := c.Get("user_id").(int)
+	if !ok {
+		return 0, 0, errors.New("user_id not found or invalid type")
+	}
+
+	role, err := GetMemberRoleFromStore(db, fpoID, userID)
+	if err != nil {
+		return 0, 0, echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	if managerOnly && role != RoleManager {
+		return 0, 0, echo.NewHTTPError(http.StatusForbidden, "only the FPO's managers can do this")
+	}
+	return fpoID, userID, nil
+}
+
+// CreateFPO registers an FPO managed by the logged in farmer
+func CreateFPO(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var f types.FPO
+		if err := c.Bind(&f); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if err := validate(&f); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, err.Error())
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		if err := CreateFPOInStore(db, &f, userID); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error creating FPO: %v", err))
+		}
+
+		return c.JSON(http.StatusCreated, f)
+	}
+}
+
+// GetMyFPOs lists the FPOs the logged in farmer belongs to, with their role in each
+func GetMyFPOs(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		fpos, err := GetMyFPOsFromStore(db, userID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching FPOs: %v", err))
+		}
+		return c.JSON(http.StatusOK, fpos)
+	}
+}
+
+// GetFPO returns an FPO to its members
+func GetFPO(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		fpoID, userID, err := member(c, db, false)
+		if err != nil {
+			return err
+		}
+
+		f, err := GetFPOFromStore(db, fpoID, userID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+		return c.JSON(http.StatusOK, f)
+	}
+}
+
+// UpdateFPO lets a manager change the FPO's details
+func UpdateFPO(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		fpoID, userID, err := member(c, db, true)
+		if err != nil {
+			return err
+		}
+
+		var f types.FPO
+		if err := c.Bind(&f); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if err := validate(&f); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, err.Error())
+		}
+		f.ID = fpoID
+
+		if err := UpdateFPOInStore(db, &f); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error updating FPO: %v", err))
+		}
+
+		f, err = GetFPOFromStore(db, fpoID, userID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, err.Error())
+		}
+		return c.JSON(http.StatusOK, f)
+	}
+}
+
+// GetMembers lists an FPO's members to its members
+func GetMembers(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		fpoID, _, err := member(c, db, false)
+		if err != nil {
+			return err
+		}
+
+		members, err := GetMembersFromStore(db, fpoID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching members: %v", err))
+		}
+		return c.JSON(http.StatusOK, members)
+	}
+}
+
+// AddMember lets a manager add a registered farmer to the FPO
+func AddMember(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		fpoID, userID, err := member(c, db, true)
+		if err != nil {
+			return err
+		}
+
+		var req types.AddFPOMember
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if req.Role == "" {
+			req.Role = RoleMember
+		}
+		if !Roles[req.Role] {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "role must be manager or member")
+		}
+
+		m, err := AddMemberInStore(db, fpoID, req)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error adding member: %v", err))
+		}
+
+		if f, err := GetFPOFromStore(db, fpoID, userID); err == nil {
+			notification.Notify(db, m.FarmerID, notification.KindFPO, "You were added to an FPO",
+				fmt.Sprintf("You are now a %s of %s. Stock you contribute to its lots is paid to you when they are delivered.", m.Role, f.Name))
+		}
+
+		return c.JSON(http.StatusCreated, m)
+	}
+}
+
+// RemoveMember lets a manager remove a member, or any member leave
+func RemoveMember(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		fpoID, userID, err := member(c, db, false)
+		if err != nil {
+			return err
+		}
+
+		farmerID, err := strconv.Atoi(c.Param("farmerId"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing farmer id:%v", err))
+		}
+		if farmerID != userID {
+			if _, _, err := member(c, db, true); err != nil {
+				return err
+			}
+		}
+
+		if err := RemoveMemberInStore(db, fpoID, farmerID); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error removing member: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "member removed successfully!"})
+	}
+}
+
+// CreateLot lets a manager list a lot for the FPO made of its members' contributions. The manager is the lot's
+// farmer: they fulfil its orders like any other listing.
+func CreateLot(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		fpoID, userID, err := member(c, db, true)
+		if err != nil {
+			return err
+		}
+
+		var lot types.CreateLot
+		if err := c.Bind(&lot); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if len(lot.Contributions) == 0 {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "a lot needs at least one contribution")
+		}
+		seen := map[int]bool{}
+		for _, con := range lot.Contributions {
+			if con.QuantityInKg <= 0 {
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, "every contribution needs a quantity_in_kg greater than 0")
+			}
+			if seen[con.FarmerID] {
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("farmer %d contributes more than once", con.FarmerID))
+			}
+			seen[con.FarmerID] = true
+		}
+		lot.FarmerID = userID
+
+		if err := CreateLotInStore(db, fpoID, &lot); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error creating lot: %v", err))
+		}
+
+		return c.JSON(http.StatusCreated, lot)
+	}
+}
+
+// GetLots lists the FPO's lots to its members
+func GetLots(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		fpoID, _, err := member(c, db, false)
+		if err != nil {
+			return err
+		}
+
+		lots, err := GetLotsFromStore(db, fpoID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching lots: %v", err))
+		}
+		return c.JSON(http.StatusOK, lots)
+	}
+}
+
+// AddContribution lets a manager add a member's stock to one of the FPO's lots
+func AddContribution(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		fpoID, _, err := member(c, db, true)
+		if err != nil {
+			return err
+		}
+
+		productID, err := strconv.Atoi(c.Param("productId"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing product id:%v", err))
+		}
+
+		var con types.LotContribution
+		if err := c.Bind(&con); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if con.QuantityInKg <= 0 {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "quantity_in_kg must be greater than 0")
+		}
+
+		if err := AddContributionInStore(db, fpoID, productID, con); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error adding contribution: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "contribution added successfully!"})
+	}
+}
+
+// GetReport splits the FPO's delivered lot sales by member. ?from= and ?to= are dates (2006-01-02), both included,
+// and default to the current month.
+func GetReport(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		fpoID, _, err := member(c, db, false)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		to := from.AddDate(0, 1, -1)
+		if v := c.QueryParam("from"); v != "" {
+			d, err := time.Parse("2006-01-02", v)
+			if err != nil {
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing from: %v", err))
+			}
+			from = d
+		}
+		if v := c.QueryParam("to"); v != "" {
+			d, err := time.Parse("2006-01-02", v)
+			if err != nil {
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing to: %v", err))
+			}
+			to = d
+		}
+		if to.Before(from) {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "to must not be before from")
+		}
+
+		r, err := GetReportFromStore(db, fpoID, from, to.AddDate(0, 0, 1))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching FPO report: %v", err))
+		}
+		r.To = to
+
+		return c.JSON(http.StatusOK, r)
+	}
+}
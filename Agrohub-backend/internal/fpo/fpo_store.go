@@ -0,0 +1,429 @@
+package fpo
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ritu84/agrohub/internal/ledger"
+	order "github.com/ritu84/agrohub/internal/orders"
+	"github.com/ritu84/agrohub/internal/pricing"
+	"github.com/ritu84/agrohub/internal/product"
+	"github.com/ritu84/agrohub/internal/wishlist"
+	"github.com/ritu84/agrohub/types"
+)
+
+// Member roles, managers run the FPO and list its lots
+const (
+	RoleManager = "manager"
+	RoleMember  = "member"
+)
+
+var Roles = map[string]bool{RoleManager: true, RoleMember: true}
+
+const fpoColumns = `
+	f.id, f.name, COALESCE(f.registration_number, ''), COALESCE(f.district, ''), COALESCE(f.state, ''), m.role,
+	(SELECT COUNT(*) FROM fpo_members c WHERE c.fpo_id = f.id), f.created_at, f.updated_at`
+
+const fpoFrom = `
+	FROM fpos f
+	JOIN fpo_members m ON m.fpo_id = f.id`
+
+func scanFPO(row interface{ Scan(...interface{}) error }) (types.FPO, error) {
+	var f types.FPO
+	err := row.Scan(&f.ID, &f.Name, &f.RegistrationNumber, &f.District, &f.State, &f.Role, &f.MemberCount, &f.CreatedAt, &f.UpdatedAt)
+	return f, err
+}
+
+// GetMemberRoleFromStore returns the farmer's role in the FPO
+func GetMemberRoleFromStore(db *sql.DB, fpoID, farmerID int) (string, error) {
+	var role string
+	err := db.QueryRow(`SELECT role FROM fpo_members WHERE fpo_id = $1 AND farmer_id = $2`, fpoID, farmerID).Scan(&role)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("you are not a member of FPO %d", fpoID)
+		}
+		return "", fmt.Errorf("error querying FPO member: %v", err)
+	}
+	return role, nil
+}
+
+// CreateFPOInStore registers an FPO with farmerID as its first manager
+func CreateFPOInStore(db *sql.DB, f *types.FPO, farmerID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+		INSERT INTO fpos (name, registration_number, district, state, created_by)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''), $5)
+		RETURNING id, created_at, updated_at`, f.Name, f.RegistrationNumber, f.District, f.State, farmerID).
+		Scan(&f.ID, &f.CreatedAt, &f.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error inserting FPO: %v", err)
+	}
+
+	if _, err := tx.Exec(`INSERT INTO fpo_members (fpo_id, farmer_id, role) VALUES ($1, $2, $3)`, f.ID, farmerID, RoleManager); err != nil {
+		return fmt.Errorf("error inserting FPO member: %v", err)
+	}
+	f.Role, f.MemberCount = RoleManager, 1
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// GetFPOFromStore returns an FPO with the farmer's role in it, the farmer must be a member
+func GetFPOFromStore(db *sql.DB, fpoID, farmerID int) (types.FPO, error) {
+	f, err := scanFPO(db.QueryRow(`SELECT `+fpoColumns+fpoFrom+` WHERE f.id = $1 AND m.farmer_id = $2`, fpoID, farmerID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return f, fmt.Errorf("no FPO found with ID %d", fpoID)
+		}
+		return f, fmt.Errorf("error querying FPO: %v", err)
+	}
+	return f, nil
+}
+
+// GetMyFPOsFromStore lists the FPOs the farmer belongs to
+func GetMyFPOsFromStore(db *sql.DB, farmerID int) ([]types.FPO, error) {
+	rows, err := db.Query(`SELECT `+fpoColumns+fpoFrom+` WHERE m.farmer_id = $1 ORDER BY f.name, f.id`, farmerID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying FPOs: %v", err)
+	}
+	defer rows.Close()
+
+	fpos := []types.FPO{}
+	for rows.Next() {
+		f, err := scanFPO(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning FPO: %v", err)
+		}
+		fpos = append(fpos, f)
+	}
+	return fpos, rows.Err()
+}
+
+// UpdateFPOInStore saves an FPO's name, registration number and location
+func UpdateFPOInStore(db *sql.DB, f *types.FPO) error {
+	result, err := db.Exec(`
+		UPDATE fpos SET name = $1, registration_number = NULLIF($2, ''), district = NULLIF($3, ''), state = NULLIF($4, ''), updated_at = NOW()
+		WHERE id = $5`, f.Name, f.RegistrationNumber, f.District, f.State, f.ID)
+	if err != nil {
+		return fmt.Errorf("error updating FPO: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("no FPO found with ID %d", f.ID)
+	}
+	return nil
+}
+
+// GetMembersFromStore lists an FPO's members, managers first
+func GetMembersFromStore(db *sql.DB, fpoID int) ([]types.FPOMember, error) {
+	rows, err := db.Query(`
+		SELECT m.farmer_id, u.first_name, u.last_name, m.role, m.created_at
+		FROM fpo_members m JOIN users u ON u.id = m.farmer_id
+		WHERE m.fpo_id = $1
+		ORDER BY m.role <> 'manager', u.first_name, u.last_name`, fpoID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying FPO members: %v", err)
+	}
+	defer rows.Close()
+
+	members := []types.FPOMember{}
+	for rows.Next() {
+		var m types.FPOMember
+		if err := rows.Scan(&m.FarmerID, &m.FirstName, &m.LastName, &m.Role, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning FPO member: %v", err)
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// AddMemberInStore adds a registered farmer to the FPO
+func AddMemberInStore(db *sql.DB, fpoID int, req types.AddFPOMember) (types.FPOMember, error) {
+	m := types.FPOMember{FarmerID: req.FarmerID, Role: req.Role}
+	err := db.QueryRow(`SELECT first_name, last_name FROM users WHERE id = $1 AND user_type = 'farmer'`, req.FarmerID).
+		Scan(&m.FirstName, &m.LastName)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return m, fmt.Errorf("no farmer found with ID %d", req.FarmerID)
+		}
+		return m, fmt.Errorf("error querying farmer: %v", err)
+	}
+
+	err = db.QueryRow(`
+		INSERT INTO fpo_members (fpo_id, farmer_id, role) VALUES ($1, $2, $3)
+		ON CONFLICT (fpo_id, farmer_id) DO NOTHING
+		RETURNING created_at`, fpoID, m.FarmerID, m.Role).Scan(&m.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return m, fmt.Errorf("farmer %d is already a member", m.FarmerID)
+		}
+		return m, fmt.Errorf("error inserting FPO member: %v", err)
+	}
+	return m, nil
+}
+
+// RemoveMemberInStore takes a farmer out of the FPO, the last manager can't leave. Their contributions to lots
+// already listed stay, they are still paid their share of those lots.
+func RemoveMemberInStore(db *sql.DB, fpoID, farmerID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT id FROM fpos WHERE id = $1 FOR UPDATE`, fpoID); err != nil {
+		return fmt.Errorf("error locking FPO: %v", err)
+	}
+	var otherManagers int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM fpo_members WHERE fpo_id = $1 AND role = 'manager' AND farmer_id <> $2`,
+		fpoID, farmerID).Scan(&otherManagers); err != nil {
+		return fmt.Errorf("error counting managers: %v", err)
+	}
+	if otherManagers == 0 {
+		return fmt.Errorf("an FPO needs at least one other manager first")
+	}
+
+	result, err := tx.Exec(`DELETE FROM fpo_members WHERE fpo_id = $1 AND farmer_id = $2`, fpoID, farmerID)
+	if err != nil {
+		return fmt.Errorf("error deleting FPO member: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("farmer %d is not a member", farmerID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// checkMembersTx checks every contributing farmer is a member of the FPO
+func checkMembersTx(tx *sql.Tx, fpoID int, contributions []types.LotContribution) error {
+	for _, c := range contributions {
+		var ok bool
+		if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM fpo_members WHERE fpo_id = $1 AND farmer_id = $2)`, fpoID, c.FarmerID).Scan(&ok); err != nil {
+			return fmt.Errorf("error querying FPO member: %v", err)
+		}
+		if !ok {
+			return fmt.Errorf("farmer %d is not a member of the FPO", c.FarmerID)
+		}
+	}
+	return nil
+}
+
+// CreateLotInStore lists a lot for the FPO from its members' contributions, the lot's stock is their total
+func CreateLotInStore(db *sql.DB, fpoID int, lot *types.CreateLot) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkMembersTx(tx, fpoID, lot.Contributions); err != nil {
+		return err
+	}
+
+	lot.FPOID = &fpoID
+	lot.Quantity = 0
+	for _, c := range lot.Contributions {
+		lot.Quantity += c.QuantityInKg
+	}
+	if err := product.InsertProductTx(tx, &lot.Product); err != nil {
+		return err
+	}
+
+	for i := range lot.Contributions {
+		c := &lot.Contributions[i]
+		err := tx.QueryRow(`
+			INSERT INTO fpo_lot_contributions (product_id, farmer_id, quantity_in_kg, remaining_kg) VALUES ($1, $2, $3, $3)
+			RETURNING created_at, updated_at`, lot.ID, c.FarmerID, c.QuantityInKg).Scan(&c.CreatedAt, &c.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("error inserting contribution: %v", err)
+		}
+		c.RemainingKg = float64(c.QuantityInKg)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// AddContributionInStore adds a member's stock to one of the FPO's lots. Orders already delivered keep the split
+// they were paid out with, later ones are split by what each member has left in the lot, this included.
+func AddContributionInStore(db *sql.DB, fpoID, productID int, c types.LotContribution) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var lotFPO sql.NullInt64
+	if err := tx.QueryRow(`SELECT fpo_id FROM products WHERE id = $1 FOR UPDATE`, productID).Scan(&lotFPO); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("no product found with ID %d", productID)
+		}
+		return fmt.Errorf("error querying product: %v", err)
+	}
+	if !lotFPO.Valid || int(lotFPO.Int64) != fpoID {
+		return fmt.Errorf("product %d is not a lot of this FPO", productID)
+	}
+	if err := checkMembersTx(tx, fpoID, []types.LotContribution{c}); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO fpo_lot_contributions (product_id, farmer_id, quantity_in_kg, remaining_kg) VALUES ($1, $2, $3, $3)
+		ON CONFLICT (product_id, farmer_id)
+		DO UPDATE SET quantity_in_kg = fpo_lot_contributions.quantity_in_kg + EXCLUDED.quantity_in_kg,
+			remaining_kg = COALESCE(fpo_lot_contributions.remaining_kg, 0) + EXCLUDED.remaining_kg, updated_at = NOW()`,
+		productID, c.FarmerID, c.QuantityInKg)
+	if err != nil {
+		return fmt.Errorf("error inserting contribution: %v", err)
+	}
+
+	if err := order.RestoreStockTx(tx, productID, c.QuantityInKg); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	// a sold out lot is back in stock
+	wishlist.ProductChanged(db, productID)
+	return nil
+}
+
+// GetLotsFromStore lists the FPO's lots, newest first, with their contributions and how much has been delivered
+func GetLotsFromStore(db *sql.DB, fpoID int) ([]types.Lot, error) {
+	rows, err := db.Query(`
+		SELECT p.id, COALESCE((SELECT SUM(s.quantity_in_kg) FROM order_shares s JOIN orders o ON o.id = s.order_id WHERE o.product_id = p.id), 0)
+		FROM products p
+		WHERE p.fpo_id = $1
+		ORDER BY p.created_at DESC, p.id DESC`, fpoID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying lots: %v", err)
+	}
+	var ids []int
+	sold := map[int]float64{}
+	for rows.Next() {
+		var id int
+		var kg float64
+		if err := rows.Scan(&id, &kg); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning lot: %v", err)
+		}
+		ids = append(ids, id)
+		sold[id] = kg
+	}
+	rows.Close()
+
+	lots := []types.Lot{}
+	for _, id := range ids {
+		p, err := product.GetProductFromStore(db, id)
+		if err != nil {
+			return nil, err
+		}
+		lot := types.Lot{Product: p, SoldKg: sold[id]}
+		lot.Contributions, err = GetContributionsFromStore(db, id)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range lot.Contributions {
+			lot.ContributedKg += c.QuantityInKg
+		}
+		lots = append(lots, lot)
+	}
+	return lots, nil
+}
+
+// GetContributionsFromStore lists what each member put into a lot and has left in it, largest first
+func GetContributionsFromStore(db *sql.DB, productID int) ([]types.LotContribution, error) {
+	rows, err := db.Query(`
+		SELECT c.farmer_id, u.first_name, u.last_name, c.quantity_in_kg, COALESCE(c.remaining_kg, 0), c.created_at, c.updated_at
+		FROM fpo_lot_contributions c JOIN users u ON u.id = c.farmer_id
+		WHERE c.product_id = $1
+		ORDER BY c.quantity_in_kg DESC, c.farmer_id`, productID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying contributions: %v", err)
+	}
+	defer rows.Close()
+
+	contributions := []types.LotContribution{}
+	for rows.Next() {
+		var c types.LotContribution
+		if err := rows.Scan(&c.FarmerID, &c.FirstName, &c.LastName, &c.QuantityInKg, &c.RemainingKg, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning contribution: %v", err)
+		}
+		contributions = append(contributions, c)
+	}
+	return contributions, rows.Err()
+}
+
+// GetReportFromStore splits the FPO's lot orders delivered between from and to by member, from the shares recorded
+// in the ledger on delivery less what refunds took back
+func GetReportFromStore(db *sql.DB, fpoID int, from, to time.Time) (types.FPOReport, error) {
+	r := types.FPOReport{FPOID: fpoID, From: from, To: to, Members: []types.FPOReportLine{}}
+
+	rows, err := db.Query(`
+		SELECT s.farmer_id, u.first_name, u.last_name, SUM(s.quantity_in_kg), SUM(s.gross),
+			SUM(s.commission - COALESCE((
+				SELECT SUM(e.debit) FROM ledger_entries e JOIN ledger_transactions t ON t.id = e.transaction_id
+				WHERE t.kind = $4 AND e.order_id = s.order_id AND e.user_id = s.farmer_id AND e.account = $6), 0)),
+			SUM(s.gross - s.commission - COALESCE((
+				SELECT SUM(e.debit) FROM ledger_entries e JOIN ledger_transactions t ON t.id = e.transaction_id
+				WHERE t.kind = $4 AND e.order_id = s.order_id AND e.user_id = s.farmer_id AND e.account = $5), 0))
+		FROM order_shares s
+		JOIN orders o ON o.id = s.order_id
+		JOIN products p ON p.id = o.product_id
+		JOIN users u ON u.id = s.farmer_id
+		WHERE p.fpo_id = $1 AND o.delivered_at >= $2 AND o.delivered_at < $3
+		GROUP BY s.farmer_id, u.first_name, u.last_name
+		ORDER BY SUM(s.gross) DESC, s.farmer_id`, fpoID, from, to, ledger.KindRefund, ledger.AccountFarmerPayable, ledger.AccountCommission)
+	if err != nil {
+		return r, fmt.Errorf("error querying FPO report: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var l types.FPOReportLine
+		if err := rows.Scan(&l.FarmerID, &l.FirstName, &l.LastName, &l.SoldKg, &l.Gross, &l.Commission, &l.Net); err != nil {
+			return r, fmt.Errorf("failed to scan FPO report: %v", err)
+		}
+		r.Members = append(r.Members, l)
+		r.Gross += l.Gross
+		r.Commission += l.Commission
+		r.Net += l.Net
+	}
+	if err := rows.Err(); err != nil {
+		return r, fmt.Errorf("error querying FPO report: %v", err)
+	}
+	r.Gross = pricing.Round(r.Gross)
+	r.Commission = pricing.Round(r.Commission)
+	r.Net = pricing.Round(r.Net)
+
+	err = db.QueryRow(`
+		SELECT COUNT(*) FROM orders o JOIN products p ON p.id = o.product_id
+		WHERE p.fpo_id = $1 AND o.delivered_at >= $2 AND o.delivered_at < $3
+			AND EXISTS (SELECT 1 FROM order_shares s WHERE s.order_id = o.id)`, fpoID, from, to).Scan(&r.Orders)
+	if err != nil {
+		return r, fmt.Errorf("error counting FPO orders: %v", err)
+	}
+	return r, nil
+}
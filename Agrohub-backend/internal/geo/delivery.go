@@ -0,0 +1,91 @@
+package geo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/ritu84/agrohub/internal/pricing"
+	"github.com/ritu84/agrohub/internal/scheduler"
+	"github.com/ritu84/agrohub/types"
+)
+
+// FeeRules price delivering an order from the farm to the buyer
+type FeeRules struct {
+	BaseFee     float64 // charged on every order
+	PerKm       float64
+	PerKg       float64
+	FreeAbove   float64 // orders with a subtotal at least this much are delivered free, 0 turns it off
+	MaxRadiusKm float64 // farther buyers can't order, 0 turns it off
+	FallbackKm  float64 // distance charged when a pin code can't be located, 0 refuses the delivery instead
+}
+
+// FeeRulesFromEnv reads DELIVERY_BASE_FEE (default 50), DELIVERY_FEE_PER_KM (default 2), DELIVERY_FEE_PER_KG (default 1),
+// DELIVERY_FREE_ABOVE (default 0, off), DELIVERY_MAX_RADIUS_KM (default 1000) and DELIVERY_FALLBACK_KM (default 0, off)
+func FeeRulesFromEnv() FeeRules {
+	return FeeRules{
+		BaseFee:     scheduler.FloatFromEnv("DELIVERY_BASE_FEE", 50),
+		PerKm:       scheduler.FloatFromEnv("DELIVERY_FEE_PER_KM", 2),
+		PerKg:       scheduler.FloatFromEnv("DELIVERY_FEE_PER_KG", 1),
+		FreeAbove:   scheduler.FloatFromEnv("DELIVERY_FREE_ABOVE", 0),
+		MaxRadiusKm: scheduler.FloatFromEnv("DELIVERY_MAX_RADIUS_KM", 1000),
+		FallbackKm:  scheduler.FloatFromEnv("DELIVERY_FALLBACK_KM", 0),
+	}
+}
+
+// ErrUnknownDistance is returned for a delivery between pin codes that couldn't be located when no fallback
+// distance is set
+var ErrUnknownDistance = errors.New("the distance to deliver couldn't be worked out")
+
+// Fee prices a delivery of qty kg. distanceKm is nil when either end couldn't be located, the fee is charged on
+// FallbackKm then, or the delivery is refused when there is none.
+func (r FeeRules) Fee(distanceKm *float64, qty int, subtotal float64) (float64, error) {
+	if distanceKm == nil {
+		if r.FallbackKm <= 0 {
+			return 0, ErrUnknownDistance
+		}
+		distanceKm = &r.FallbackKm
+	}
+	if r.MaxRadiusKm > 0 && *distanceKm > r.MaxRadiusKm {
+		return 0, fmt.Errorf("the farm is %.0f km away, it only delivers within %.0f km", *distanceKm, r.MaxRadiusKm)
+	}
+	if r.FreeAbove > 0 && subtotal >= r.FreeAbove {
+		return 0, nil
+	}
+
+	fee := r.BaseFee + r.PerKg*float64(qty) + r.PerKm**distanceKm
+	return pricing.Round(fee), nil
+}
+
+// ApplyDelivery adds the fee for delivering q from the farmer's pin code to buyerPin. The delivery fee is its own line
+// on the quote and is included in its total. A pin code that can't be located fails it unless a fallback distance
+// is set.
+func ApplyDelivery(db *sql.DB, farmerID int, buyerPin string, q *types.Quote) error {
+	farmerPin, err := GetFarmerPinCodeFromStore(db, farmerID)
+	if err != nil {
+		return err
+	}
+
+	q.DistanceKm = nil
+	from, ok1 := Locate(farmerPin)
+	to, ok2 := Locate(buyerPin)
+	if ok1 && ok2 {
+		d := math.Round(DistanceKm(from, to)*10) / 10
+		q.DistanceKm = &d
+	}
+
+	fee, err := FeeRulesFromEnv().Fee(q.DistanceKm, q.QuantityInKg, q.Subtotal)
+	if err == ErrUnknownDistance {
+		if !ok2 {
+			return fmt.Errorf("pin code %s couldn't be located, we can't deliver there yet", buyerPin)
+		}
+		return fmt.Errorf("the farm's pin code %s couldn't be located, it can't deliver yet", farmerPin)
+	}
+	if err != nil {
+		return err
+	}
+	q.DeliveryFee = fee
+	q.TotalPrice = pricing.Round(q.Subtotal + fee)
+	return nil
+}
@@ -0,0 +1,210 @@
+package geo
+
+import (
+	_ "embed"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// pincodes.csv is a small bundled dataset with the head post office of major towns, too few to run on without a
+// fallback distance (see CheckCoverage). Point PINCODE_DATASET at the full All India Pincode Directory (data.gov.in)
+// for exact locations, pin codes missing from the bundled file are located by the sorting district their first three
+// digits stand for.
+//
+//go:embed pincodes.csv
+var bundledPincodes []byte
+
+const earthRadiusKm = 6371.0
+
+// Point is a location in decimal degrees
+type Point struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// Dataset locates Indian pin codes
+type Dataset struct {
+	pins      map[string]Point
+	districts map[string]Point // centre of the pin codes sharing the first three digits
+}
+
+var (
+	loadOnce sync.Once
+	dataset  *Dataset
+	external bool // dataset came from PINCODE_DATASET rather than the bundled file
+)
+
+// column names as they show up in pincode exports, lower cased
+var pincodeHeaders = map[string]string{
+	"pincode":   "pincode",
+	"pin_code":  "pincode",
+	"pin code":  "pincode",
+	"latitude":  "lat",
+	"lat":       "lat",
+	"longitude": "lng",
+	"long":      "lng",
+	"lng":       "lng",
+}
+
+// ParseDataset reads a pincode CSV by header name. A pin code with several post offices is placed at their average,
+// rows without usable coordinates ("NA" in the data.gov.in export) are skipped.
+func ParseDataset(r io.Reader) (*Dataset, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading pincode header: %v", err)
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		if name, ok := pincodeHeaders[strings.ToLower(strings.TrimSpace(h))]; ok {
+			cols[name] = i
+		}
+	}
+	for _, name := range []string{"pincode", "lat", "lng"} {
+		if _, ok := cols[name]; !ok {
+			return nil, fmt.Errorf("pincode dataset has no %s column", name)
+		}
+	}
+
+	type sum struct {
+		lat, lng float64
+		n        int
+	}
+	pins := map[string]*sum{}
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading pincode dataset: %v", err)
+		}
+		if len(rec) <= cols["pincode"] || len(rec) <= cols["lat"] || len(rec) <= cols["lng"] {
+			continue
+		}
+		pin, ok := normalise(rec[cols["pincode"]])
+		if !ok {
+			continue
+		}
+		lat, err1 := strconv.ParseFloat(strings.TrimSpace(rec[cols["lat"]]), 64)
+		lng, err2 := strconv.ParseFloat(strings.TrimSpace(rec[cols["lng"]]), 64)
+		if err1 != nil || err2 != nil || lat < 6 || lat > 38 || lng < 68 || lng > 98 {
+			continue
+		}
+		s := pins[pin]
+		if s == nil {
+			s = &sum{}
+			pins[pin] = s
+		}
+		s.lat += lat
+		s.lng += lng
+		s.n++
+	}
+
+	d := &Dataset{pins: map[string]Point{}, districts: map[string]Point{}}
+	districts := map[string]*sum{}
+	for pin, s := range pins {
+		p := Point{Lat: s.lat / float64(s.n), Lng: s.lng / float64(s.n)}
+		d.pins[pin] = p
+
+		ds := districts[pin[:3]]
+		if ds == nil {
+			ds = &sum{}
+			districts[pin[:3]] = ds
+		}
+		ds.lat += p.Lat
+		ds.lng += p.Lng
+		ds.n++
+	}
+	for prefix, s := range districts {
+		d.districts[prefix] = Point{Lat: s.lat / float64(s.n), Lng: s.lng / float64(s.n)}
+	}
+	return d, nil
+}
+
+// Locate returns where a pin code is, ok is false when neither it nor its sorting district is in the dataset
+func (d *Dataset) Locate(pin string) (Point, bool) {
+	pin, ok := normalise(pin)
+	if !ok {
+		return Point{}, false
+	}
+	if p, ok := d.pins[pin]; ok {
+		return p, true
+	}
+	p, ok := d.districts[pin[:3]]
+	return p, ok
+}
+
+// Locate finds a pin code in the dataset configured with PINCODE_DATASET, or the bundled one
+func Locate(pin string) (Point, bool) {
+	loadOnce.Do(load)
+	return dataset.Locate(pin)
+}
+
+// CheckCoverage fails when only the bundled pin codes could be loaded and DELIVERY_FALLBACK_KM is not set, as orders to
+// most of India would be refused then
+func CheckCoverage() error {
+	loadOnce.Do(load)
+	if external {
+		return nil
+	}
+	if FeeRulesFromEnv().FallbackKm <= 0 {
+		return fmt.Errorf("only the %d bundled pin codes can be located, set PINCODE_DATASET to the All India Pincode Directory CSV or DELIVERY_FALLBACK_KM", len(dataset.pins))
+	}
+	log.Printf("geo: WARNING only the %d bundled pin codes can be located, other deliveries are charged on DELIVERY_FALLBACK_KM", len(dataset.pins))
+	return nil
+}
+
+func load() {
+	if path := os.Getenv("PINCODE_DATASET"); path != "" {
+		f, err := os.Open(path)
+		if err == nil {
+			defer f.Close()
+			if dataset, err = ParseDataset(f); err == nil {
+				log.Printf("geo: loaded %d pin codes from %s", len(dataset.pins), path)
+				external = true
+				return
+			}
+		}
+		log.Printf("geo: unable to load PINCODE_DATASET=%q, using the bundled pin codes: %v", path, err)
+	}
+
+	var err error
+	if dataset, err = ParseDataset(bytes.NewReader(bundledPincodes)); err != nil {
+		log.Printf("geo: unable to load the bundled pin codes: %v", err)
+		dataset = &Dataset{pins: map[string]Point{}, districts: map[string]Point{}}
+	}
+}
+
+// DistanceKm is the great circle distance between two points
+func DistanceKm(a, b Point) float64 {
+	rad := math.Pi / 180
+	dLat := (b.Lat - a.Lat) * rad
+	dLng := (b.Lng - a.Lng) * rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(a.Lat*rad)*math.Cos(b.Lat*rad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
+
+// normalise checks a pin code is six digits, the first one never 0
+func normalise(pin string) (string, bool) {
+	pin = strings.ReplaceAll(strings.TrimSpace(pin), " ", "")
+	if len(pin) != 6 || pin[0] == '0' {
+		return "", false
+	}
+	for _, r := range pin {
+		if r < '0' || r > '9' {
+			return "", false
+		}
+	}
+	return pin, true
+}
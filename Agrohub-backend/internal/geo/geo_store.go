@@ -0,0 +1,19 @@
+package geo
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// GetFarmerPinCodeFromStore returns the pin code a farmer registered their farm with
+func GetFarmerPinCodeFromStore(db *sql.DB, farmerID int) (string, error) {
+	var pin string
+	err := db.QueryRow(`SELECT pin_code FROM farmers WHERE user_id = $1`, farmerID).Scan(&pin)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("no farmer found with ID %d", farmerID)
+		}
+		return "", fmt.Errorf("error querying farmer: %v", err)
+	}
+	return pin, nil
+}
@@ -0,0 +1,104 @@
+package geo
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestDistanceKm(t *testing.T) {
+	delhi := Point{Lat: 28.6328, Lng: 77.2197}
+	mumbai := Point{Lat: 18.9388, Lng: 72.8354}
+	bengaluru := Point{Lat: 12.9716, Lng: 77.5946}
+
+	tests := []struct {
+		name string
+		a, b Point
+		want float64 // km, within 1%
+	}{
+		{"same point", delhi, delhi, 0},
+		{"Delhi to Mumbai", delhi, mumbai, 1166},
+		{"Mumbai to Bengaluru", mumbai, bengaluru, 836},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DistanceKm(tt.a, tt.b)
+			if math.Abs(got-tt.want) > tt.want/100+0.001 {
+				t.Errorf("DistanceKm() = %.1f, want about %.0f", got, tt.want)
+			}
+			if back := DistanceKm(tt.b, tt.a); math.Abs(back-got) > 1e-9 {
+				t.Errorf("DistanceKm() is %.3f one way and %.3f the other", got, back)
+			}
+		})
+	}
+}
+
+func TestDatasetLocate(t *testing.T) {
+	d, err := ParseDataset(strings.NewReader(`Pincode,OfficeName,Latitude,Longitude
+110001,A,28.60,77.20
+110001,B,28.62,77.22
+110020,C,28.54,77.24
+560001,D,NA,NA
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		pin    string
+		want   Point
+		wantOK bool
+	}{
+		{"average of its post offices", "110001", Point{Lat: 28.61, Lng: 77.21}, true},
+		{"spaces are ignored", " 110 020", Point{Lat: 28.54, Lng: 77.24}, true},
+		{"sorting district", "110099", Point{Lat: 28.575, Lng: 77.225}, true},
+		{"no coordinates", "560001", Point{}, false},
+		{"not six digits", "11001", Point{}, false},
+		{"starts with 0", "010001", Point{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := d.Locate(tt.pin)
+			if ok != tt.wantOK {
+				t.Fatalf("Locate(%q) ok = %v, want %v", tt.pin, ok, tt.wantOK)
+			}
+			if math.Abs(got.Lat-tt.want.Lat) > 1e-9 || math.Abs(got.Lng-tt.want.Lng) > 1e-9 {
+				t.Errorf("Locate(%q) = %+v, want %+v", tt.pin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFeeRulesFee(t *testing.T) {
+	rules := FeeRules{BaseFee: 50, PerKm: 2, PerKg: 1, FreeAbove: 10000, MaxRadiusKm: 500}
+	km := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name     string
+		rules    FeeRules
+		distance *float64
+		qty      int
+		subtotal float64
+		want     float64
+		wantErr  bool
+	}{
+		{"base, weight and distance", rules, km(100), 20, 2000, 270, false},
+		{"free above the threshold", rules, km(100), 20, 10000, 0, false},
+		{"beyond the radius", rules, km(501), 20, 2000, 0, true},
+		{"unknown distance without a fallback", rules, nil, 20, 2000, 0, true},
+		{"unknown distance with a fallback", FeeRules{BaseFee: 50, PerKm: 2, FallbackKm: 25}, nil, 20, 2000, 100, false},
+		{"no radius limit", FeeRules{PerKm: 1}, km(2000), 1, 0, 2000, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.rules.Fee(tt.distance, tt.qty, tt.subtotal)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Fee() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Fee() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package invoice
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	order "github.com/ritu84/agrohub/internal/orders"
+	"github.com/labstack/echo/v4"
+)
+
+// GetInvoice serves the GST invoice of a delivered order as a PDF, to its buyer, its farmer and admins
+func GetInvoice(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orderID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing order id:%v", err))
+		}
+
+		buyerID, farmerID, err := order.GetOrderPartiesFromStore(db, orderID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+		// admin ids are from their own table and can match a user's, so admins are told apart by the token's user type
+		isAdmin := c.Get("user_type") == "admin"
+		userID, ok := c.Get("user_id").(int)
+		if !isAdmin && (!ok || (userID != buyerID && userID != farmerID)) {
+			return echo.NewHTTPError(http.StatusForbidden, "only the buyer and farmer of an order can see its invoice")
+		}
+
+		inv, err := GetOrCreateInvoiceInStore(db, orderID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error generating invoice: %v", err))
+		}
+
+		filename := strings.ReplaceAll(inv.Number, "/", "-") + ".pdf"
+		c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("inline; filename=%q", filename))
+		return c.Blob(http.StatusOK, "application/pdf", Render(inv))
+	}
+}
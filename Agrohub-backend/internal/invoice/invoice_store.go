@@ -0,0 +1,136 @@
+package invoice
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ritu84/agrohub/internal/pricing"
+	"github.com/ritu84/agrohub/types"
+)
+
+// GetOrCreateInvoiceInStore returns the invoice of a delivered order. The first time it is asked for, it takes the next
+// number in the financial year's sequence and fixes the tax on it, so later changes to tax rules don't alter it.
+func GetOrCreateInvoiceInStore(db *sql.DB, orderID int) (types.Invoice, error) {
+	var inv types.Invoice
+
+	tx, err := db.Begin()
+	if err != nil {
+		return inv, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var status, category string
+	var deliveryFee, discount float64
+	var deliveredAt *time.Time
+	var orgName, orgGSTIN string
+	err = tx.QueryRow(`
+		SELECT o.id, o.status, o.quantity_in_kg, o.total_price, o.delivery_fee, o.discount, o.delivered_at,
+			o.delivery_address, o.delivery_city, o.delivery_address_zip, COALESCE(o.delivery_state, ''), o.buyers_phone_number,
+			p.name, p.type,
+			f.first_name || ' ' || f.last_name, f.phone_number,
+			COALESCE(fa.address, ''), COALESCE(fa.city, ''), COALESCE(fa.state, ''), COALESCE(fa.pin_code, ''),
+			b.first_name || ' ' || b.last_name, b.phone_number,
+			COALESCE(ba.address, ''), COALESCE(ba.city, ''), COALESCE(ba.state, ''), COALESCE(ba.pin_code, ''),
+			COALESCE(org.name, ''), COALESCE(org.gstin, '')
+		FROM orders o
+		JOIN products p ON p.id = o.product_id
+		JOIN users f ON f.id = p.farmer_id
+		LEFT JOIN farmers fa ON fa.user_id = p.farmer_id
+		JOIN users b ON b.id = o.buyer_id
+		LEFT JOIN buyers ba ON ba.user_id = o.buyer_id
+		LEFT JOIN organizations org ON org.id = o.organization_id
+		WHERE o.id = $1
+		FOR UPDATE OF o`, orderID).
+		Scan(&inv.OrderID, &status, &inv.QuantityInKg, &inv.Total, &deliveryFee, &discount, &deliveredAt,
+			&inv.ShipTo.Address, &inv.ShipTo.City, &inv.ShipTo.PinCode, &inv.ShipTo.State, &inv.ShipTo.Phone,
+			&inv.Description, &category,
+			&inv.Seller.Name, &inv.Seller.Phone,
+			&inv.Seller.Address, &inv.Seller.City, &inv.Seller.State, &inv.Seller.PinCode,
+			&inv.Buyer.Name, &inv.Buyer.Phone,
+			&inv.Buyer.Address, &inv.Buyer.City, &inv.Buyer.State, &inv.Buyer.PinCode,
+			&orgName, &orgGSTIN)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return inv, fmt.Errorf("no order found with ID %d", orderID)
+		}
+		return inv, fmt.Errorf("error querying order: %v", err)
+	}
+	if status != "delivered" {
+		return inv, fmt.Errorf("an invoice is only issued once the order is delivered")
+	}
+	inv.ShipTo.Name = inv.Buyer.Name
+	if inv.ShipTo.State == "" {
+		inv.ShipTo.State = inv.Buyer.State
+	}
+	// Orders placed for an organization are billed to it at its delivery address, with its GSTIN for input tax credit
+	if orgName != "" {
+		inv.Buyer = types.InvoiceParty{Name: orgName, Address: inv.ShipTo.Address, City: inv.ShipTo.City,
+			State: inv.ShipTo.State, PinCode: inv.ShipTo.PinCode, GSTIN: orgGSTIN}
+	}
+
+	var taxAmount float64
+	err = tx.QueryRow(`
+		SELECT id, invoice_number, financial_year, issued_at, description, hsn_code, tax_rate, taxable_value, tax_amount, total
+		FROM invoices WHERE order_id = $1`, orderID).
+		Scan(&inv.ID, &inv.Number, &inv.FinancialYear, &inv.IssuedAt, &inv.Description, &inv.HSNCode, &inv.TaxRate, &inv.TaxableValue, &taxAmount, &inv.Total)
+	if err != nil && err != sql.ErrNoRows {
+		return inv, fmt.Errorf("error querying invoice: %v", err)
+	}
+
+	// The place of supply is where the goods are delivered, IGST applies when it differs from the seller's state
+	sameState := inv.ShipTo.State == "" || strings.EqualFold(inv.Seller.State, inv.ShipTo.State)
+
+	if err == sql.ErrNoRows {
+		rule := RuleFor(category)
+		inv.HSNCode = rule.HSNCode
+		inv.TaxRate = rule.RatePercent
+		inv.Description = fmt.Sprintf("%s (%s)", inv.Description, rule.Description)
+		inv.IssuedAt = time.Now()
+		if deliveredAt != nil {
+			inv.IssuedAt = *deliveredAt
+		}
+		inv.FinancialYear = FinancialYear(inv.IssuedAt)
+		inv.TaxableValue, inv.TaxLines = SplitTax(inv.Total, inv.TaxRate, sameState)
+		taxAmount = pricing.Round(inv.Total - inv.TaxableValue)
+
+		var seq int
+		err := tx.QueryRow(`
+			INSERT INTO invoice_sequences (financial_year, last_seq) VALUES ($1, 1)
+			ON CONFLICT (financial_year) DO UPDATE SET last_seq = invoice_sequences.last_seq + 1
+			RETURNING last_seq`, inv.FinancialYear).Scan(&seq)
+		if err != nil {
+			return inv, fmt.Errorf("error allocating invoice number: %v", err)
+		}
+		inv.Number = fmt.Sprintf("AGH/%s/%06d", inv.FinancialYear, seq)
+
+		err = tx.QueryRow(`
+			INSERT INTO invoices (order_id, invoice_number, financial_year, seq, description, hsn_code, tax_rate, taxable_value, tax_amount, total, issued_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			RETURNING id`,
+			orderID, inv.Number, inv.FinancialYear, seq, inv.Description, inv.HSNCode, inv.TaxRate, inv.TaxableValue, taxAmount, inv.Total, inv.IssuedAt).
+			Scan(&inv.ID)
+		if err != nil {
+			return inv, fmt.Errorf("error inserting invoice: %v", err)
+		}
+	} else {
+		_, inv.TaxLines = SplitTax(inv.Total, inv.TaxRate, sameState)
+	}
+	// Delivery is part of the same supply and taxed at the produce's rate, it gets its own line
+	if deliveryFee > 0 {
+		inv.DeliveryValue = pricing.Round(deliveryFee / (1 + inv.TaxRate/100))
+	}
+	// Coupons are a discount on the invoice, the rate is the one before it
+	if discount > 0 {
+		inv.DiscountValue = pricing.Round(discount / (1 + inv.TaxRate/100))
+	}
+	if inv.QuantityInKg > 0 {
+		inv.RatePerKg = pricing.Round((inv.TaxableValue - inv.DeliveryValue + inv.DiscountValue) / float64(inv.QuantityInKg))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return inv, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return inv, nil
+}
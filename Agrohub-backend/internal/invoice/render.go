@@ -0,0 +1,117 @@
+package invoice
+
+import (
+	"fmt"
+
+	"github.com/ritu84/agrohub/types"
+)
+
+// Render lays the invoice out on a single A4 page
+func Render(inv types.Invoice) []byte {
+	var p pdf
+	const left, right = 40.0, 555.0
+
+	p.text(left, 60, 18, true, "TAX INVOICE")
+	p.textRight(right, 52, 10, false, "Invoice No: "+inv.Number)
+	p.textRight(right, 66, 10, false, "Date: "+inv.IssuedAt.Format("02 Jan 2006"))
+	p.textRight(right, 80, 10, false, fmt.Sprintf("Order #%d", inv.OrderID))
+	p.line(left, 92, right, 92)
+
+	party(&p, left, 112, "Sold by", inv.Seller)
+	party(&p, 230, 112, "Billed to", inv.Buyer)
+	party(&p, 420, 112, "Ship to", inv.ShipTo)
+
+	// Line items
+	y := 220.0
+	p.line(left, y, right, y)
+	cols := []struct {
+		x     float64
+		title string
+		right bool
+	}{
+		{left, "Description", false}, {250, "HSN", false}, {370, "Qty (kg)", true},
+		{445, "Rate/kg", true}, {right, "Taxable value", true},
+	}
+	for _, c := range cols {
+		if c.right {
+			p.textRight(c.x, y+15, 10, true, c.title)
+		} else {
+			p.text(c.x, y+15, 10, true, c.title)
+		}
+	}
+	p.line(left, y+22, right, y+22)
+
+	y += 40
+	p.text(left, y, 10, false, inv.Description)
+	p.text(250, y, 10, false, inv.HSNCode)
+	p.textRight(370, y, 10, false, fmt.Sprintf("%d", inv.QuantityInKg))
+	p.textRight(445, y, 10, false, money(inv.RatePerKg))
+	p.textRight(right, y, 10, false, money(inv.TaxableValue-inv.DeliveryValue+inv.DiscountValue))
+	if inv.DiscountValue > 0 {
+		y += 16
+		p.text(left, y, 10, false, "Coupon discount")
+		p.textRight(right, y, 10, false, "-"+money(inv.DiscountValue))
+	}
+	if inv.DeliveryValue > 0 {
+		y += 16
+		p.text(left, y, 10, false, "Delivery charges")
+		p.textRight(right, y, 10, false, money(inv.DeliveryValue))
+	}
+	p.line(left, y+10, right, y+10)
+
+	// Totals
+	y += 30
+	totalRow(&p, y, "Taxable value", money(inv.TaxableValue), false)
+	for _, t := range inv.TaxLines {
+		y += 16
+		totalRow(&p, y, fmt.Sprintf("%s @ %g%%", t.Name, t.RatePercent), money(t.Amount), false)
+	}
+	y += 8
+	p.line(340, y, right, y)
+	y += 16
+	totalRow(&p, y, "Total", "Rs. "+money(inv.Total), true)
+
+	p.text(left, 780, 8, false, "Prices are inclusive of GST. Issued through Agrohub on behalf of the seller.")
+	p.text(left, 792, 8, false, "This is a computer generated invoice and needs no signature.")
+
+	return p.bytes()
+}
+
+func party(p *pdf, x, y float64, title string, pt types.InvoiceParty) {
+	p.text(x, y, 10, true, title)
+	lines := []string{pt.Name, pt.Address, pt.City, pt.State}
+	if pt.PinCode != "" {
+		lines = append(lines, "PIN "+pt.PinCode)
+	}
+	if pt.Phone != "" {
+		lines = append(lines, "Ph. "+pt.Phone)
+	}
+	if pt.GSTIN != "" {
+		lines = append(lines, "GSTIN "+pt.GSTIN)
+	}
+	for _, l := range lines {
+		if l == "" {
+			continue
+		}
+		y += 13
+		p.text(x, y, 9, false, clip(l, 34))
+	}
+}
+
+func totalRow(p *pdf, y float64, label, amount string, bold bool) {
+	p.text(340, y, 10, bold, label)
+	p.textRight(555, y, 10, bold, amount)
+}
+
+func money(v float64) string {
+	return fmt.Sprintf("%.2f", v)
+}
+
+// clip keeps a line within its column, addresses can be long
+func clip(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-3]) + "..."
+}
@@ -0,0 +1,58 @@
+package invoice
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ritu84/agrohub/internal/pricing"
+	"github.com/ritu84/agrohub/types"
+)
+
+// TaxRule is the HSN code and GST rate of a product category
+type TaxRule struct {
+	HSNCode     string
+	Description string
+	RatePercent float64
+}
+
+// Fresh mushrooms are nil rated. Keeda jadi (cordyceps) is sold dried as a medicinal herb.
+var taxRules = map[string]TaxRule{
+	"mushroom": {HSNCode: "07095900", Description: "Fresh mushrooms", RatePercent: 0},
+	"jari":     {HSNCode: "12119099", Description: "Keeda jadi (cordyceps), dried", RatePercent: 5},
+}
+
+// defaultRule covers categories without a rule of their own, as fresh produce
+var defaultRule = TaxRule{HSNCode: "0709", Description: "Fresh vegetables", RatePercent: 0}
+
+func RuleFor(category string) TaxRule {
+	if r, ok := taxRules[strings.ToLower(strings.TrimSpace(category))]; ok {
+		return r
+	}
+	return defaultRule
+}
+
+// SplitTax works the tax out of a tax inclusive total. Within a state it is split evenly between CGST and SGST,
+// across states it is charged as IGST.
+func SplitTax(total, ratePercent float64, sameState bool) (float64, []types.TaxLine) {
+	taxable := pricing.Round(total / (1 + ratePercent/100))
+	tax := pricing.Round(total - taxable)
+
+	if !sameState {
+		return taxable, []types.TaxLine{{Name: "IGST", RatePercent: ratePercent, Amount: tax}}
+	}
+	cgst := pricing.Round(tax / 2)
+	return taxable, []types.TaxLine{
+		{Name: "CGST", RatePercent: ratePercent / 2, Amount: cgst},
+		{Name: "SGST", RatePercent: ratePercent / 2, Amount: pricing.Round(tax - cgst)},
+	}
+}
+
+// FinancialYear is the Indian financial year t falls in, April to March, e.g. "2024-25"
+func FinancialYear(t time.Time) string {
+	start := t.Year()
+	if t.Month() < time.April {
+		start--
+	}
+	return fmt.Sprintf("%d-%02d", start, (start+1)%100)
+}
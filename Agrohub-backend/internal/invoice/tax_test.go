@@ -0,0 +1,98 @@
+package invoice
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ritu84/agrohub/types"
+)
+
+func TestSplitTax(t *testing.T) {
+	tests := []struct {
+		name        string
+		total       float64
+		rate        float64
+		sameState   bool
+		wantTaxable float64
+		wantLines   []types.TaxLine
+	}{
+		{"within the state", 105, 5, true, 100, []types.TaxLine{
+			{Name: "CGST", RatePercent: 2.5, Amount: 2.5},
+			{Name: "SGST", RatePercent: 2.5, Amount: 2.5},
+		}},
+		{"across states", 105, 5, false, 100, []types.TaxLine{
+			{Name: "IGST", RatePercent: 5, Amount: 5},
+		}},
+		{"rounded to the paisa", 100, 5, true, 95.24, []types.TaxLine{
+			{Name: "CGST", RatePercent: 2.5, Amount: 2.38},
+			{Name: "SGST", RatePercent: 2.5, Amount: 2.38},
+		}},
+		{"odd paisa split unevenly", 20, 5, true, 19.05, []types.TaxLine{
+			{Name: "CGST", RatePercent: 2.5, Amount: 0.48},
+			{Name: "SGST", RatePercent: 2.5, Amount: 0.47},
+		}},
+		{"nil rated", 480, 0, true, 480, []types.TaxLine{
+			{Name: "CGST", RatePercent: 0, Amount: 0},
+			{Name: "SGST", RatePercent: 0, Amount: 0},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taxable, lines := SplitTax(tt.total, tt.rate, tt.sameState)
+			if math.Abs(taxable-tt.wantTaxable) > 0.001 {
+				t.Errorf("taxable = %v, want %v", taxable, tt.wantTaxable)
+			}
+			if len(lines) != len(tt.wantLines) {
+				t.Fatalf("lines = %+v, want %+v", lines, tt.wantLines)
+			}
+			sum := taxable
+			for i, l := range lines {
+				w := tt.wantLines[i]
+				if l.Name != w.Name || l.RatePercent != w.RatePercent || math.Abs(l.Amount-w.Amount) > 0.001 {
+					t.Errorf("line %d = %+v, want %+v", i, l, w)
+				}
+				sum += l.Amount
+			}
+			if math.Abs(sum-tt.total) > 0.001 {
+				t.Errorf("taxable and tax add up to %v, want %v", sum, tt.total)
+			}
+		})
+	}
+}
+
+func TestRuleFor(t *testing.T) {
+	tests := []struct {
+		category string
+		wantHSN  string
+		wantRate float64
+	}{
+		{"mushroom", "07095900", 0},
+		{" Jari ", "12119099", 5},
+		{"tomato", "0709", 0},
+		{"", "0709", 0},
+	}
+	for _, tt := range tests {
+		r := RuleFor(tt.category)
+		if r.HSNCode != tt.wantHSN || r.RatePercent != tt.wantRate {
+			t.Errorf("RuleFor(%q) = %+v, want HSN %s at %v%%", tt.category, r, tt.wantHSN, tt.wantRate)
+		}
+	}
+}
+
+func TestFinancialYear(t *testing.T) {
+	tests := []struct {
+		date time.Time
+		want string
+	}{
+		{time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC), "2024-25"},
+		{time.Date(2025, time.March, 31, 23, 59, 0, 0, time.UTC), "2024-25"},
+		{time.Date(2025, time.January, 15, 0, 0, 0, 0, time.UTC), "2024-25"},
+		{time.Date(2099, time.December, 1, 0, 0, 0, 0, time.UTC), "2099-00"},
+	}
+	for _, tt := range tests {
+		if got := FinancialYear(tt.date); got != tt.want {
+			t.Errorf("FinancialYear(%v) = %q, want %q", tt.date, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package ledger
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/ritu84/agrohub/internal/pricing"
+)
+
+// Accounts of the double-entry ledger. Every transaction debits and credits them by the same total.
+const (
+	AccountGateway          = "gateway_clearing"    // money held with the payment gateway or bank
+	AccountBuyerEscrow      = "buyer_escrow"        // buyers' payments held until their order is delivered
+	AccountFarmerPayable    = "farmer_payable"      // owed to a farmer, entries carry the farmer's user_id
+	AccountCommission       = "platform_commission" // the platform's cut of delivered orders, FPO lot entries carry the member's user_id
+	AccountPayoutsInTransit = "payouts_in_transit"  // payouts generated but not yet confirmed paid by the bank
+	AccountPromotions       = "platform_promotions" // platform funded coupon discounts, paid to farmers on delivery
+	AccountBuyerRefunds     = "buyer_refunds"       // refunds on cash on delivery orders, owed to buyers until paid outside the platform
+)
+
+const (
+	KindPaymentCaptured = "payment_captured"
+	KindOrderDelivered  = "order_delivered"
+	KindRefund          = "refund"
+	KindPayout          = "payout"
+	KindPayoutPaid      = "payout_paid"
+)
+
+type Entry struct {
+	Account string
+	UserID  *int
+	Debit   float64
+	Credit  float64
+}
+
+// CommissionPercent is the platform's cut of every delivered order, PLATFORM_COMMISSION_PERCENT (default 5)
+func CommissionPercent() float64 {
+	v := os.Getenv("PLATFORM_COMMISSION_PERCENT")
+	if v == "" {
+		return 5
+	}
+	pct, err := strconv.ParseFloat(v, 64)
+	if err != nil || pct < 0 || pct > 100 {
+		log.Printf("ledger: invalid PLATFORM_COMMISSION_PERCENT=%q, using 5", v)
+		return 5
+	}
+	return pct
+}
+
+// PostTx writes a balanced transaction as part of tx, so the books change together with whatever caused them to
+func PostTx(tx *sql.Tx, kind string, orderID, payoutID *int, description string, entries ...Entry) error {
+	var debits, credits float64
+	for _, e := range entries {
+		debits += e.Debit
+		credits += e.Credit
+	}
+	if pricing.Round(debits) != pricing.Round(credits) {
+		return fmt.Errorf("unbalanced ledger transaction %s: debits %.2f, credits %.2f", kind, debits, credits)
+	}
+
+	var txnID int
+	err := tx.QueryRow(`
+		INSERT INTO ledger_transactions (kind, order_id, payout_id, description)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id`, kind, orderID, payoutID, description).Scan(&txnID)
+	if err != nil {
+		return fmt.Errorf("error inserting ledger transaction: %v", err)
+	}
+
+	for _, e := range entries {
+		_, err := tx.Exec(`
+			INSERT INTO ledger_entries (transaction_id, account, user_id, order_id, debit, credit)
+			VALUES ($1, $2, $3, $4, $5, $6)`, txnID, e.Account, e.UserID, orderID, pricing.Round(e.Debit), pricing.Round(e.Credit))
+		if err != nil {
+			return fmt.Errorf("error inserting ledger entry: %v", err)
+		}
+	}
+	return nil
+}
+
+// RecordCaptureTx books a captured payment as held for the buyer until the order is delivered
+func RecordCaptureTx(tx *sql.Tx, orderID int, amount float64) error {
+	return PostTx(tx, KindPaymentCaptured, &orderID, nil, fmt.Sprintf("payment for order #%d", orderID),
+		Entry{Account: AccountGateway, Debit: amount},
+		Entry{Account: AccountBuyerEscrow, Credit: amount},
+	)
+}
+
+// RecordDepositTx books a captured pre-order deposit as held for the buyer. It has no order yet, once the pre-order
+// is filled the deposit counts towards the order's payment.
+func RecordDepositTx(tx *sql.Tx, preOrderID int, amount float64) error {
+	return PostTx(tx, KindPaymentCaptured, nil, nil, fmt.Sprintf("deposit for pre-order #%d", preOrderID),
+		Entry{Account: AccountGateway, Debit: amount},
+		Entry{Account: AccountBuyerEscrow, Credit: amount},
+	)
+}
+
+// RecordDepositRefundTx books a deposit returned to the buyer of a pre-order that never became an order
+func RecordDepositRefundTx(tx *sql.Tx, preOrderID int, amount float64) error {
+	return PostTx(tx, KindRefund, nil, nil, fmt.Sprintf("deposit refund on pre-order #%d", preOrderID),
+		Entry{Account: AccountBuyerEscrow, Debit: amount},
+		Entry{Account: AccountGateway, Credit: amount},
+	)
+}
+
+// RecordDeliveryTx splits a delivered order between the farmer and the platform. A prepaid order moves the buyer's
+// money out of escrow, a pre-order's deposit and the payment of the rest together. On cash on delivery the farmer already has the money, so they owe the commission instead.
+// Platform funded discounts are paid to the farmer out of promotions, commission is taken on the undiscounted price.
+func RecordDeliveryTx(tx *sql.Tx, orderID int) error {
+	var total, paid, subsidy float64
+	var farmerID, productID, qty int
+	var prepaid bool
+	err := tx.QueryRow(`
+		SELECT o.total_price, p.farmer_id, p.id, o.quantity_in_kg,
+			COALESCE((SELECT SUM(pay.amount - pay.refunded_amount) FROM payments pay
+				WHERE pay.order_id = o.id AND pay.status IN ('captured', 'partially_refunded')), -1),
+			COALESCE((SELECT SUM(d.amount) FROM order_discounts d WHERE d.order_id = o.id AND d.funded_by = 'platform'), 0)
+		FROM orders o JOIN products p ON p.id = o.product_id
+		WHERE o.id = $1`, orderID).Scan(&total, &farmerID, &productID, &qty, &paid, &subsidy)
+	if err != nil {
+		return fmt.Errorf("error querying order for ledger: %v", err)
+	}
+	prepaid = paid >= 0
+
+	var promotions []Entry
+	if subsidy > 0 {
+		promotions = []Entry{{Account: AccountPromotions, Debit: subsidy}}
+	}
+
+	// An FPO lot is sold on behalf of the members who contributed to it, in proportion to what each has left in it.
+	// Should deliveries have used it all up the lot is split by what was contributed.
+	members, kgs, err := sharesTx(tx, `
+		SELECT c.farmer_id, CASE
+				WHEN (SELECT SUM(x.remaining_kg) FROM fpo_lot_contributions x WHERE x.product_id = c.product_id) > 0
+				THEN COALESCE(c.remaining_kg, 0) ELSE c.quantity_in_kg END
+		FROM fpo_lot_contributions c WHERE c.product_id = $1 ORDER BY c.farmer_id FOR UPDATE`, productID)
+	if err != nil {
+		return err
+	}
+	if len(members) > 0 {
+		amount := total
+		if prepaid {
+			amount = paid
+		}
+		return recordLotDeliveryTx(tx, orderID, productID, farmerID, qty, amount, subsidy, prepaid, members, kgs)
+	}
+
+	pct := CommissionPercent()
+	desc := fmt.Sprintf("order #%d delivered", orderID)
+	if prepaid {
+		commission := pricing.Round((paid + subsidy) * pct / 100)
+		return PostTx(tx, KindOrderDelivered, &orderID, nil, desc, append(promotions,
+			Entry{Account: AccountBuyerEscrow, Debit: paid},
+			Entry{Account: AccountFarmerPayable, UserID: &farmerID, Credit: paid + subsidy - commission},
+			Entry{Account: AccountCommission, Credit: commission},
+		)...)
+	}
+
+	commission := pricing.Round((total + subsidy) * pct / 100)
+	if subsidy > 0 {
+		promotions = append(promotions, Entry{Account: AccountFarmerPayable, UserID: &farmerID, Credit: subsidy})
+	}
+	return PostTx(tx, KindOrderDelivered, &orderID, nil, desc+", cash on delivery", append(promotions,
+		Entry{Account: AccountFarmerPayable, UserID: &farmerID, Debit: commission},
+		Entry{Account: AccountCommission, Credit: commission},
+	)...)
+}
+
+// recordLotDeliveryTx splits a delivered FPO lot order between its contributing members, in proportion to the kg each
+// has left in the lot, and records the split in order_shares. What each member sold is taken off what they have left.
+// Commission entries carry the member they were taken from.
+// On cash on delivery the member who listed the lot collected all of the money, so they owe the other shares too.
+// A platform funded discount is shared out with the rest of the price.
+func recordLotDeliveryTx(tx *sql.Tx, orderID, productID, listerID, qty int, amount, subsidy float64, prepaid bool, members []int, kgs []float64) error {
+	pct := CommissionPercent()
+	gross := apportion(amount+subsidy, kgs)
+	sold := apportion(float64(qty), kgs)
+
+	desc := fmt.Sprintf("order #%d delivered, FPO lot of %d members", orderID, len(members))
+	entries := []Entry{{Account: AccountBuyerEscrow, Debit: amount}}
+	if !prepaid {
+		desc += ", cash on delivery"
+		entries = []Entry{{Account: AccountFarmerPayable, UserID: &listerID, Debit: amount}}
+	}
+	if subsidy > 0 {
+		entries = append(entries, Entry{Account: AccountPromotions, Debit: subsidy})
+	}
+
+	for i := range members {
+		if kgs[i] <= 0 {
+			// sold all they put in, nothing of this order is theirs
+			continue
+		}
+		member := &members[i]
+		commission := pricing.Round(gross[i] * pct / 100)
+		entries = append(entries,
+			Entry{Account: AccountFarmerPayable, UserID: member, Credit: gross[i] - commission},
+			Entry{Account: AccountCommission, UserID: member, Credit: commission},
+		)
+		_, err := tx.Exec(`INSERT INTO order_shares (order_id, farmer_id, quantity_in_kg, gross, commission) VALUES ($1, $2, $3, $4, $5)`,
+			orderID, *member, sold[i], gross[i], commission)
+		if err != nil {
+			return fmt.Errorf("error inserting order share: %v", err)
+		}
+		_, err = tx.Exec(`UPDATE fpo_lot_contributions SET remaining_kg = GREATEST(COALESCE(remaining_kg, 0) - $1, 0), updated_at = NOW()
+			WHERE product_id = $2 AND farmer_id = $3`, sold[i], productID, *member)
+		if err != nil {
+			return fmt.Errorf("error updating contribution: %v", err)
+		}
+	}
+	return PostTx(tx, KindOrderDelivered, &orderID, nil, desc, entries...)
+}
+
+// sharesTx reads (farmer, weight) rows for splitting an amount between farmers
+func sharesTx(tx *sql.Tx, q string, id int) ([]int, []float64, error) {
+	rows, err := tx.Query(q, id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error querying shares: %v", err)
+	}
+	defer rows.Close()
+
+	var farmers []int
+	var weights []float64
+	for rows.Next() {
+		var farmerID int
+		var w float64
+		if err := rows.Scan(&farmerID, &w); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan share: %v", err)
+		}
+		farmers = append(farmers, farmerID)
+		weights = append(weights, w)
+	}
+	return farmers, weights, rows.Err()
+}
+
+// apportion splits amount in proportion to weights, rounded to the paisa. The rounding difference goes to the largest
+// share so the parts always add up to amount.
+func apportion(amount float64, weights []float64) []float64 {
+	parts := make([]float64, len(weights))
+	var total, sum float64
+	for _, w := range weights {
+		total += w
+	}
+	if total <= 0 {
+		return parts
+	}
+
+	largest := 0
+	for i, w := range weights {
+		parts[i] = pricing.Round(amount * w / total)
+		sum += parts[i]
+		if w > weights[largest] {
+			largest = i
+		}
+	}
+	parts[largest] = pricing.Round(parts[largest] + amount - sum)
+	return parts
+}
+
+// RecordRefundTx books money returned to a buyer through the gateway. Before delivery it comes out of escrow,
+// after delivery it is taken back from the farmer and the platform's commission.
+func RecordRefundTx(tx *sql.Tx, orderID int, amount float64) error {
+	entries, err := refundEntriesTx(tx, orderID, amount)
+	if err != nil {
+		return err
+	}
+	entries = append(entries, Entry{Account: AccountGateway, Credit: amount})
+	return PostTx(tx, KindRefund, &orderID, nil, fmt.Sprintf("refund on order #%d", orderID), entries...)
+}
+
+// RecordOffPlatformRefundTx books a refund on a cash on delivery order. There is no payment to send back through the
+// gateway, so the amount is taken back from the farmer and owed to the buyer until it is paid outside the platform.
+func RecordOffPlatformRefundTx(tx *sql.Tx, orderID int, amount float64) error {
+	entries, err := refundEntriesTx(tx, orderID, amount)
+	if err != nil {
+		return err
+	}
+	entries = append(entries, Entry{Account: AccountBuyerRefunds, Credit: amount})
+	return PostTx(tx, KindRefund, &orderID, nil, fmt.Sprintf("refund on order #%d, paid outside the platform", orderID), entries...)
+}
+
+// refundEntriesTx returns the debit side of a refund: escrow before delivery, the farmer's earnings after it. After
+// delivery the farmer was also paid the platform funded discount, the share of it matching the refund goes back to
+// promotions. The commission taken on delivery is given back in the same proportion, so the farmer only loses the net
+// earnings on what was refunded.
+func refundEntriesTx(tx *sql.Tx, orderID int, amount float64) ([]Entry, error) {
+	var farmerID int
+	var delivered bool
+	var total, subsidy, clawedBack, commission, reversed float64
+	err := tx.QueryRow(`
+		SELECT p.farmer_id, EXISTS (SELECT 1 FROM ledger_transactions t WHERE t.order_id = o.id AND t.kind = $2),
+			o.total_price,
+			COALESCE((SELECT SUM(d.amount) FROM order_discounts d WHERE d.order_id = o.id AND d.funded_by = 'platform'), 0),
+			COALESCE((SELECT SUM(e.credit) FROM ledger_entries e JOIN ledger_transactions t ON t.id = e.transaction_id
+				WHERE t.order_id = o.id AND t.kind = $3 AND e.account = $4), 0),
+			COALESCE((SELECT SUM(e.credit - e.debit) FROM ledger_entries e JOIN ledger_transactions t ON t.id = e.transaction_id
+				WHERE t.order_id = o.id AND t.kind = $2 AND e.account = $5), 0),
+			COALESCE((SELECT SUM(e.debit) FROM ledger_entries e JOIN ledger_transactions t ON t.id = e.transaction_id
+				WHERE t.order_id = o.id AND t.kind = $3 AND e.account = $5), 0)
+		FROM orders o JOIN products p ON p.id = o.product_id
+		WHERE o.id = $1`, orderID, KindOrderDelivered, KindRefund, AccountPromotions, AccountCommission).
+		Scan(&farmerID, &delivered, &total, &subsidy, &clawedBack, &commission, &reversed)
+	if err != nil {
+		return nil, fmt.Errorf("error querying order for ledger: %v", err)
+	}
+
+	if !delivered {
+		return []Entry{{Account: AccountBuyerEscrow, Debit: amount}}, nil
+	}
+
+	var entries []Entry
+	taken := amount
+	var returned float64
+	if total > 0 {
+		if subsidy > 0 {
+			clawback := math.Min(pricing.Round(subsidy*amount/total), pricing.Round(subsidy-clawedBack))
+			if clawback > 0 {
+				entries = append(entries, Entry{Account: AccountPromotions, Credit: clawback})
+				taken += clawback
+			}
+		}
+		returned = math.Max(math.Min(pricing.Round(commission*amount/total), pricing.Round(commission-reversed)), 0)
+	}
+
+	// An FPO lot order is taken back from its members in the same proportion it was paid to them
+	members, gross, err := sharesTx(tx, `SELECT farmer_id, gross FROM order_shares WHERE order_id = $1 ORDER BY farmer_id`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		entries = append(entries, Entry{Account: AccountFarmerPayable, UserID: &farmerID, Debit: taken - returned})
+		if returned > 0 {
+			entries = append(entries, Entry{Account: AccountCommission, Debit: returned})
+		}
+		return entries, nil
+	}
+	_, commissions, err := sharesTx(tx, `SELECT farmer_id, commission FROM order_shares WHERE order_id = $1 ORDER BY farmer_id`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	parts, commissionParts := apportion(taken, gross), apportion(returned, commissions)
+	for i := range members {
+		member := &members[i]
+		entries = append(entries, Entry{Account: AccountFarmerPayable, UserID: member, Debit: parts[i] - commissionParts[i]})
+		if commissionParts[i] > 0 {
+			entries = append(entries, Entry{Account: AccountCommission, UserID: member, Debit: commissionParts[i]})
+		}
+	}
+	return entries, nil
+}
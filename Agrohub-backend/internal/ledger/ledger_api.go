@@ -0,0 +1,94 @@
+package ledger
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ritu84/agrohub/internal/notification"
+	"github.com/ritu84/agrohub/types"
+	"github.com/labstack/echo/v4"
+)
+
+// GetEarnings is the logged in farmer's earnings statement. ?from= and ?to= are dates (2006-01-02),
+// both included, and default to the current month.
+func GetEarnings(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		now := time.Now()
+		from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		to := from.AddDate(0, 1, -1)
+		if v := c.QueryParam("from"); v != "" {
+			d, err := time.Parse("2006-01-02", v)
+			if err != nil {
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing from: %v", err))
+			}
+			from = d
+		}
+		if v := c.QueryParam("to"); v != "" {
+			d, err := time.Parse("2006-01-02", v)
+			if err != nil {
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing to: %v", err))
+			}
+			to = d
+		}
+		if to.Before(from) {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "to must not be before from")
+		}
+
+		st, err := GetEarningsStatementFromStore(db, userID, from, to.AddDate(0, 0, 1))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching earnings: %v", err))
+		}
+		st.To = to
+
+		return c.JSON(http.StatusOK, st)
+	}
+}
+
+// GetReconciliation is the admin's payout reconciliation report
+func GetReconciliation(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		r, err := GetReconciliationFromStore(db)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, err.Error())
+		}
+
+		return c.JSON(http.StatusOK, r)
+	}
+}
+
+// MarkPayoutPaid records the bank transfer reference once a payout has actually been sent
+func MarkPayoutPaid(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		payoutID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing payout id: %v", err))
+		}
+
+		var req types.MarkPayoutPaid
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if req.Reference == "" {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "reference is required")
+		}
+
+		po, err := MarkPayoutPaidInStore(db, payoutID, req.Reference)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error marking payout paid: %v", err))
+		}
+
+		notification.Notify(db, po.FarmerID, notification.KindPayout, "Payout sent",
+			fmt.Sprintf("Rs %.2f was transferred to you, reference %s.", po.Amount, po.Reference))
+
+		return c.JSON(http.StatusOK, po)
+	}
+}
@@ -0,0 +1,316 @@
+package ledger
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/ritu84/agrohub/internal/pricing"
+	"github.com/ritu84/agrohub/types"
+)
+
+const (
+	PayoutPending = "pending"
+	PayoutPaid    = "paid"
+)
+
+// GeneratePayoutBatchInStore pays farmers the earnings from orders delivered more than windowDays ago that haven't been
+// paid out yet. It goes by ledger entry rather than by order, so a refund taken back after an order was paid out is
+// settled in the next batch. Each farmer gets one payout for the net of their unpaid entries, which are linked to it. A
+// farmer whose cash on delivery commission outweighs what they are owed is left for a later batch, and so is an order
+// with an unresolved dispute or a refund the gateway hasn't confirmed yet. It returns no payouts when there was
+// nothing to pay.
+func GeneratePayoutBatchInStore(db *sql.DB, windowDays int) ([]types.Payout, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT e.id, e.user_id, e.order_id, e.credit - e.debit
+		FROM ledger_entries e
+		JOIN orders o ON o.id = e.order_id
+		WHERE e.account = $1 AND e.payout_id IS NULL
+			AND o.delivered_at <= NOW() - $2 * INTERVAL '1 day'
+			AND NOT EXISTS (SELECT 1 FROM disputes d WHERE d.order_id = o.id AND d.status <> 'resolved')
+			AND NOT EXISTS (SELECT 1 FROM payment_refunds r JOIN payments p ON p.id = r.payment_id
+				WHERE p.order_id = o.id AND r.status = 'pending')
+		FOR UPDATE OF e SKIP LOCKED`, AccountFarmerPayable, windowDays)
+	if err != nil {
+		return nil, fmt.Errorf("error querying earnings due for payout: %v", err)
+	}
+	owed := map[int]float64{}
+	entries := map[int][]int64{}
+	var orderIDs []int64
+	for rows.Next() {
+		var entryID, orderID int64
+		var farmerID int
+		var net float64
+		if err := rows.Scan(&entryID, &farmerID, &orderID, &net); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan farmer earnings: %v", err)
+		}
+		owed[farmerID] += net
+		entries[farmerID] = append(entries[farmerID], entryID)
+		orderIDs = append(orderIDs, orderID)
+	}
+	rows.Close()
+	if len(owed) == 0 {
+		return nil, nil
+	}
+
+	farmers := make([]int, 0, len(owed))
+	for farmerID, amount := range owed {
+		if pricing.Round(amount) > 0 {
+			farmers = append(farmers, farmerID)
+		}
+	}
+	if len(farmers) == 0 {
+		return nil, nil
+	}
+	sort.Ints(farmers)
+
+	var batchID int
+	if err := tx.QueryRow(`INSERT INTO payout_batches DEFAULT VALUES RETURNING id`).Scan(&batchID); err != nil {
+		return nil, fmt.Errorf("error creating payout batch: %v", err)
+	}
+
+	var payouts []types.Payout
+	for _, farmerID := range farmers {
+		po := types.Payout{BatchID: batchID, FarmerID: farmerID, Amount: pricing.Round(owed[farmerID]), Status: PayoutPending}
+		err := tx.QueryRow(`INSERT INTO payouts (batch_id, farmer_id, amount, status) VALUES ($1, $2, $3, $4) RETURNING id, created_at`,
+			po.BatchID, po.FarmerID, po.Amount, po.Status).Scan(&po.ID, &po.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error creating payout: %v", err)
+		}
+
+		_, err = tx.Exec(`UPDATE ledger_entries SET payout_id = $1 WHERE id = ANY($2)`, po.ID, pq.Array(entries[farmerID]))
+		if err != nil {
+			return nil, fmt.Errorf("error linking earnings to payout: %v", err)
+		}
+
+		fid := farmerID
+		err = PostTx(tx, KindPayout, nil, &po.ID, fmt.Sprintf("payout #%d", po.ID),
+			Entry{Account: AccountFarmerPayable, UserID: &fid, Debit: po.Amount},
+			Entry{Account: AccountPayoutsInTransit, Credit: po.Amount},
+		)
+		if err != nil {
+			return nil, err
+		}
+		payouts = append(payouts, po)
+	}
+
+	// An order is paid out once every farmer it was sold for has been, an FPO lot order can take more than one batch
+	_, err = tx.Exec(`
+		UPDATE orders o SET payout_id = (SELECT MAX(e.payout_id) FROM ledger_entries e WHERE e.order_id = o.id AND e.account = $1)
+		WHERE o.id = ANY($2)
+			AND NOT EXISTS (SELECT 1 FROM ledger_entries e WHERE e.order_id = o.id AND e.account = $1 AND e.payout_id IS NULL)`,
+		AccountFarmerPayable, pq.Array(orderIDs))
+	if err != nil {
+		return nil, fmt.Errorf("error linking orders to payout: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return payouts, nil
+}
+
+// MarkPayoutPaidInStore records the bank transfer of a payout
+func MarkPayoutPaidInStore(db *sql.DB, payoutID int, reference string) (types.Payout, error) {
+	var po types.Payout
+
+	tx, err := db.Begin()
+	if err != nil {
+		return po, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`SELECT id, batch_id, farmer_id, amount, status, created_at FROM payouts WHERE id = $1 FOR UPDATE`, payoutID).
+		Scan(&po.ID, &po.BatchID, &po.FarmerID, &po.Amount, &po.Status, &po.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return po, fmt.Errorf("no payout found with ID %d", payoutID)
+		}
+		return po, fmt.Errorf("error querying payout: %v", err)
+	}
+	if po.Status != PayoutPending {
+		return po, fmt.Errorf("payout is already %s", po.Status)
+	}
+
+	po.Status = PayoutPaid
+	po.Reference = reference
+	err = tx.QueryRow(`UPDATE payouts SET status = $1, reference = $2, paid_at = NOW() WHERE id = $3 RETURNING paid_at`,
+		po.Status, po.Reference, po.ID).Scan(&po.PaidAt)
+	if err != nil {
+		return po, fmt.Errorf("error updating payout: %v", err)
+	}
+
+	err = PostTx(tx, KindPayoutPaid, nil, &po.ID, fmt.Sprintf("payout #%d paid, ref %s", po.ID, reference),
+		Entry{Account: AccountPayoutsInTransit, Debit: po.Amount},
+		Entry{Account: AccountGateway, Credit: po.Amount},
+	)
+	if err != nil {
+		return po, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return po, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return po, nil
+}
+
+// GetEarningsStatementFromStore breaks down a farmer's orders, and their shares of FPO lot orders, delivered between from and to
+func GetEarningsStatementFromStore(db *sql.DB, farmerID int, from, to time.Time) (types.EarningsStatement, error) {
+	st := types.EarningsStatement{FarmerID: farmerID, From: from, To: to, Orders: []types.EarningsLine{}}
+
+	// On FPO lot orders the farmer's line is their share, gross is what their share sold for. Gross includes the
+	// discounts the platform funds, farmers are paid as if those weren't taken.
+	rows, err := db.Query(`
+		SELECT o.id, p.name, o.delivered_at,
+			COALESCE(s.gross, o.total_price + COALESCE((SELECT SUM(d.amount) FROM order_discounts d
+				WHERE d.order_id = o.id AND d.funded_by = 'platform'), 0)),
+			COALESCE(SUM(e.credit - e.debit) FILTER (WHERE e.account = $2 AND (e.user_id = $1 OR e.user_id IS NULL)), 0),
+			COALESCE(SUM(e.credit - e.debit) FILTER (WHERE e.account = $3 AND e.user_id = $1), 0),
+			NOT bool_or(e.account = $4),
+			COALESCE(MAX(e.payout_id) FILTER (WHERE e.account = $3 AND e.user_id = $1), o.payout_id),
+			COALESCE((SELECT po.status FROM payouts po
+				WHERE po.id = COALESCE(MAX(e.payout_id) FILTER (WHERE e.account = $3 AND e.user_id = $1), o.payout_id)), '')
+		FROM orders o
+		JOIN products p ON p.id = o.product_id
+		JOIN ledger_entries e ON e.order_id = o.id
+		LEFT JOIN order_shares s ON s.order_id = o.id AND s.farmer_id = $1
+		WHERE (p.farmer_id = $1 OR s.farmer_id IS NOT NULL) AND o.delivered_at >= $5 AND o.delivered_at < $6
+		GROUP BY o.id, p.name, s.gross
+		ORDER BY o.delivered_at DESC`,
+		farmerID, AccountCommission, AccountFarmerPayable, AccountBuyerEscrow, from, to)
+	if err != nil {
+		return st, fmt.Errorf("error querying earnings: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var l types.EarningsLine
+		if err := rows.Scan(&l.OrderID, &l.ProductName, &l.DeliveredAt, &l.Gross, &l.Commission, &l.Net, &l.CashOnDelivery,
+			&l.PayoutID, &l.PayoutStatus); err != nil {
+			return st, fmt.Errorf("failed to scan earnings: %v", err)
+		}
+		st.Orders = append(st.Orders, l)
+		st.Gross += l.Gross
+		st.Commission += l.Commission
+		st.Net += l.Net
+	}
+	if err := rows.Err(); err != nil {
+		return st, fmt.Errorf("error querying earnings: %v", err)
+	}
+	st.Gross = pricing.Round(st.Gross)
+	st.Commission = pricing.Round(st.Commission)
+	st.Net = pricing.Round(st.Net)
+
+	err = db.QueryRow(`
+		SELECT
+			COALESCE((SELECT SUM(amount) FROM payouts WHERE farmer_id = $1 AND status = $2), 0),
+			COALESCE((SELECT SUM(amount) FROM payouts WHERE farmer_id = $1 AND status = $3), 0),
+			COALESCE((SELECT SUM(credit - debit) FROM ledger_entries WHERE user_id = $1 AND account = $4), 0)`,
+		farmerID, PayoutPaid, PayoutPending, AccountFarmerPayable).Scan(&st.PaidOut, &st.InTransit, &st.UnpaidBalance)
+	if err != nil {
+		return st, fmt.Errorf("error querying payouts: %v", err)
+	}
+	return st, nil
+}
+
+// GetReconciliationFromStore checks the ledger against itself, the payouts table and delivered orders
+func GetReconciliationFromStore(db *sql.DB) (types.Reconciliation, error) {
+	r := types.Reconciliation{
+		Balances:                 []types.AccountBalance{},
+		Batches:                  []types.PayoutBatchSummary{},
+		UnbalancedTransactions:   []int{},
+		DeliveredOrdersNotBooked: []int{},
+		PayoutMismatches:         []int{},
+	}
+
+	rows, err := db.Query(`SELECT account, SUM(debit), SUM(credit) FROM ledger_entries GROUP BY account ORDER BY account`)
+	if err != nil {
+		return r, fmt.Errorf("error querying balances: %v", err)
+	}
+	for rows.Next() {
+		var b types.AccountBalance
+		if err := rows.Scan(&b.Account, &b.Debit, &b.Credit); err != nil {
+			rows.Close()
+			return r, fmt.Errorf("failed to scan balance: %v", err)
+		}
+		if b.Account == AccountPayoutsInTransit {
+			r.PayoutsInTransit = pricing.Round(b.Credit - b.Debit)
+		}
+		r.Balances = append(r.Balances, b)
+	}
+	rows.Close()
+
+	rows, err = db.Query(`
+		SELECT b.id, b.created_at, COUNT(po.id), COALESCE(SUM(po.amount), 0),
+			COALESCE(SUM(po.amount) FILTER (WHERE po.status = $1), 0),
+			COALESCE(SUM(po.amount) FILTER (WHERE po.status = $2), 0)
+		FROM payout_batches b LEFT JOIN payouts po ON po.batch_id = b.id
+		GROUP BY b.id ORDER BY b.created_at DESC`, PayoutPaid, PayoutPending)
+	if err != nil {
+		return r, fmt.Errorf("error querying payout batches: %v", err)
+	}
+	for rows.Next() {
+		var b types.PayoutBatchSummary
+		if err := rows.Scan(&b.ID, &b.CreatedAt, &b.PayoutCount, &b.Total, &b.PaidTotal, &b.PendingTotal); err != nil {
+			rows.Close()
+			return r, fmt.Errorf("failed to scan payout batch: %v", err)
+		}
+		r.PendingPayouts += b.PendingTotal
+		r.Batches = append(r.Batches, b)
+	}
+	rows.Close()
+	r.PendingPayouts = pricing.Round(r.PendingPayouts)
+
+	checks := []struct {
+		dest *[]int
+		q    string
+	}{
+		{&r.UnbalancedTransactions, `SELECT transaction_id FROM ledger_entries GROUP BY transaction_id HAVING SUM(debit) <> SUM(credit) ORDER BY 1`},
+		{&r.DeliveredOrdersNotBooked, `
+			SELECT o.id FROM orders o
+			WHERE o.delivered_at IS NOT NULL
+				AND NOT EXISTS (SELECT 1 FROM ledger_transactions t WHERE t.order_id = o.id AND t.kind = '` + KindOrderDelivered + `')
+			ORDER BY 1`},
+		{&r.PayoutMismatches, `
+			SELECT po.id FROM payouts po
+			WHERE po.amount <> COALESCE((
+				SELECT SUM(e.debit) FROM ledger_entries e JOIN ledger_transactions t ON t.id = e.transaction_id
+				WHERE t.payout_id = po.id AND t.kind = '` + KindPayout + `' AND e.account = '` + AccountFarmerPayable + `'), 0)
+			ORDER BY 1`},
+	}
+	for _, ch := range checks {
+		ids, err := queryIDs(db, ch.q)
+		if err != nil {
+			return r, fmt.Errorf("error reconciling ledger: %v", err)
+		}
+		*ch.dest = append(*ch.dest, ids...)
+	}
+	return r, nil
+}
+
+func queryIDs(db *sql.DB, q string, args ...interface{}) ([]int, error) {
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,43 @@
+package ledger
+
+import (
+	"math"
+	"testing"
+)
+
+func TestApportion(t *testing.T) {
+	tests := []struct {
+		name    string
+		amount  float64
+		weights []float64
+		want    []float64
+	}{
+		{"single share", 250, []float64{40}, []float64{250}},
+		{"even split", 100, []float64{1, 1}, []float64{50, 50}},
+		{"proportional", 1000, []float64{50, 30, 20}, []float64{500, 300, 200}},
+		{"remainder to the largest share", 100, []float64{10, 30, 10}, []float64{20, 60, 20}},
+		{"rounding goes to the first largest", 100, []float64{1, 1, 1}, []float64{33.34, 33.33, 33.33}},
+		{"remainder to a later largest share", 10, []float64{1, 2, 1, 2, 1}, []float64{1.43, 2.85, 1.43, 2.86, 1.43}},
+		{"no weight", 100, []float64{0, 0}, []float64{0, 0}},
+		{"no shares", 100, nil, []float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := apportion(tt.amount, tt.weights)
+			if len(got) != len(tt.want) {
+				t.Fatalf("apportion() = %v, want %v", got, tt.want)
+			}
+			var sum float64
+			for i := range got {
+				if math.Abs(got[i]-tt.want[i]) > 0.001 {
+					t.Errorf("apportion() = %v, want %v", got, tt.want)
+					break
+				}
+				sum += got[i]
+			}
+			if len(got) > 0 && tt.want[0] != 0 && math.Abs(sum-tt.amount) > 0.001 {
+				t.Errorf("parts add up to %v, want %v", sum, tt.amount)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package ledger
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ritu84/agrohub/internal/notification"
+	"github.com/ritu84/agrohub/internal/scheduler"
+)
+
+// PayoutConfig controls when delivered orders are paid out to farmers
+type PayoutConfig struct {
+	DisputeWindowDays int // days after delivery before an order is paid out, buyers can raise a dispute until then
+	Interval          time.Duration
+}
+
+// PayoutConfigFromEnv reads DISPUTE_WINDOW_DAYS (default 7) and PAYOUT_INTERVAL (default 24h)
+func PayoutConfigFromEnv() PayoutConfig {
+	return PayoutConfig{
+		DisputeWindowDays: scheduler.IntFromEnv("DISPUTE_WINDOW_DAYS", 7),
+		Interval:          scheduler.DurationFromEnv("PAYOUT_INTERVAL", 24*time.Hour),
+	}
+}
+
+func Jobs(cfg PayoutConfig) []scheduler.Job {
+	return []scheduler.Job{
+		{Name: "generate-payouts", Interval: cfg.Interval, Run: func(db *sql.DB) error { return GeneratePayouts(db, cfg) }},
+	}
+}
+
+// GeneratePayouts creates a payout batch and tells every farmer in it what they are being paid
+func GeneratePayouts(db *sql.DB, cfg PayoutConfig) error {
+	payouts, err := GeneratePayoutBatchInStore(db, cfg.DisputeWindowDays)
+	if err != nil {
+		return err
+	}
+
+	for _, po := range payouts {
+		notification.Notify(db, po.FarmerID, notification.KindPayout, "Payout on its way",
+			fmt.Sprintf("Rs %.2f for your delivered orders is being transferred to you (payout #%d).", po.Amount, po.ID))
+	}
+	return nil
+}
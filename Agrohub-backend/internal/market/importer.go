@@ -0,0 +1,168 @@
+package market
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ritu84/agrohub/types"
+)
+
+// ImportResult summarises one CSV import
+type ImportResult struct {
+	Imported int      `json:"imported"`
+	Skipped  int      `json:"skipped"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// column names as they show up in mandi exports (agmarknet, data.gov.in), normalised by normaliseHeader
+var headerAliases = map[string]string{
+	"commodity":     "commodity",
+	"variety":       "variety",
+	"state":         "state",
+	"state name":    "state",
+	"district":      "district",
+	"district name": "district",
+	"market":        "market",
+	"market name":   "market",
+	"date":          "arrival_date",
+	"arrival date":  "arrival_date",
+	"price date":    "arrival_date",
+	"min price":     "min_price",
+	"max price":     "max_price",
+	"modal price":   "modal_price",
+}
+
+var requiredColumns = []string{"commodity", "state", "market", "arrival_date", "min_price", "max_price", "modal_price"}
+
+var dateLayouts = []string{"02/01/2006", "2006-01-02", "02-01-2006", "02 Jan 2006", "2 Jan 2006"}
+
+// ImportCSV parses a mandi price CSV and upserts its rows into market_prices
+func ImportCSV(db *sql.DB, r io.Reader) (ImportResult, error) {
+	prices, res, err := ParseCSV(r)
+	if err != nil {
+		return res, err
+	}
+
+	if err := SaveMarketPricesInStore(db, prices); err != nil {
+		return res, err
+	}
+	res.Imported = len(prices)
+	return res, nil
+}
+
+// ParseCSV reads rows by header name so column order and extra columns don't matter.
+// Rows that can't be parsed are skipped and reported in the result.
+func ParseCSV(r io.Reader) ([]types.MarketPrice, ImportResult, error) {
+	var res ImportResult
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, res, fmt.Errorf("error reading csv header: %v", err)
+	}
+
+	cols := make(map[string]int)
+	for i, h := range header {
+		if name, ok := headerAliases[normaliseHeader(h)]; ok {
+			cols[name] = i
+		}
+	}
+	for _, name := range requiredColumns {
+		if _, ok := cols[name]; !ok {
+			return nil, res, fmt.Errorf("csv is missing required column %q", name)
+		}
+	}
+
+	var prices []types.MarketPrice
+	line := 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			res.Skipped++
+			res.Errors = append(res.Errors, fmt.Sprintf("line %d: %v", line, err))
+			continue
+		}
+
+		p, err := parseRecord(record, cols)
+		if err != nil {
+			res.Skipped++
+			res.Errors = append(res.Errors, fmt.Sprintf("line %d: %v", line, err))
+			continue
+		}
+		prices = append(prices, p)
+	}
+
+	return prices, res, nil
+}
+
+func parseRecord(record []string, cols map[string]int) (types.MarketPrice, error) {
+	field := func(name string) string {
+		i, ok := cols[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	p := types.MarketPrice{
+		Commodity: field("commodity"),
+		Variety:   field("variety"),
+		State:     field("state"),
+		District:  field("district"),
+		Market:    field("market"),
+	}
+	if p.Commodity == "" || p.State == "" || p.Market == "" {
+		return p, fmt.Errorf("commodity, state and market are required")
+	}
+
+	date, err := parseDate(field("arrival_date"))
+	if err != nil {
+		return p, err
+	}
+	p.ArrivalDate = date
+
+	for name, dst := range map[string]*float64{"min_price": &p.MinPrice, "max_price": &p.MaxPrice, "modal_price": &p.ModalPrice} {
+		v, err := strconv.ParseFloat(strings.ReplaceAll(field(name), ",", ""), 64)
+		if err != nil {
+			return p, fmt.Errorf("invalid %s %q", name, field(name))
+		}
+		*dst = v
+	}
+
+	if p.MinPrice > p.MaxPrice || p.ModalPrice < p.MinPrice || p.ModalPrice > p.MaxPrice {
+		return p, fmt.Errorf("modal price must lie between min and max price")
+	}
+	return p, nil
+}
+
+func parseDate(v string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid arrival date %q", v)
+}
+
+// normaliseHeader turns "Min_x0020_Price", "min_price" and "Min Price (Rs./Quintal)" into "min price"
+func normaliseHeader(h string) string {
+	h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+	if i := strings.Index(h, "("); i >= 0 {
+		h = h[:i]
+	}
+	h = strings.ReplaceAll(h, "_x0020_", " ")
+	h = strings.ReplaceAll(h, "_", " ")
+	return strings.Join(strings.Fields(h), " ")
+}
@@ -0,0 +1,91 @@
+package market
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ritu84/agrohub/internal/pricing"
+	"github.com/ritu84/agrohub/internal/product"
+	"github.com/ritu84/agrohub/types"
+	"github.com/labstack/echo/v4"
+)
+
+const defaultComparisonDays = 30
+
+// ImportMarketPrices loads a mandi price CSV sent as the multipart field "file"
+func ImportMarketPrices(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		fh, err := c.FormFile("file")
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error reading csv file: %v", err))
+		}
+
+		f, err := fh.Open()
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error opening csv file: %v", err))
+		}
+		defer f.Close()
+
+		res, err := ImportCSV(db, f)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error importing market prices: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+// GetPriceComparison shows the farmer's rate next to recent modal prices for the same commodity in their state
+func GetPriceComparison(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ProductID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing product id :%v", err))
+		}
+
+		days := defaultComparisonDays
+		if v := c.QueryParam("days"); v != "" {
+			if days, err = strconv.Atoi(v); err != nil || days <= 0 {
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, "days must be a positive number")
+			}
+		}
+
+		p, err := product.GetProductFromStore(db, ProductID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, fmt.Sprintf("unable to fetch the product from store :%v", err))
+		}
+		if !product.ListedBy(c, p) {
+			return echo.NewHTTPError(http.StatusForbidden, "only the farmer who listed the product can see its price comparison")
+		}
+
+		state, err := GetFarmerStateFromStore(db, p.FarmerID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, err.Error())
+		}
+
+		prices, err := GetRecentMarketPricesFromStore(db, []string{p.Type, p.Name}, state, days)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, err.Error())
+		}
+
+		res := types.PriceComparison{
+			ProductID:    p.ID,
+			ProductName:  p.Name,
+			RatePerKg:    p.RatePerKg,
+			State:        state,
+			Days:         days,
+			MarketPrices: prices,
+		}
+		if len(prices) > 0 {
+			var sum float64
+			for _, mp := range prices {
+				sum += mp.ModalPricePerKg
+			}
+			res.AverageModalPricePerKg = pricing.Round(sum / float64(len(prices)))
+		}
+
+		return c.JSON(http.StatusOK, res)
+	}
+}
@@ -0,0 +1,77 @@
+package market
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ritu84/agrohub/types"
+	"github.com/lib/pq"
+)
+
+// SaveMarketPricesInStore upserts prices, re-importing a file updates the rows instead of duplicating them
+func SaveMarketPricesInStore(db *sql.DB, prices []types.MarketPrice) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	q := `
+	INSERT INTO market_prices (commodity, variety, state, district, market, arrival_date, min_price, max_price, modal_price)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	ON CONFLICT (commodity, variety, market, arrival_date)
+	DO UPDATE SET state = EXCLUDED.state, district = EXCLUDED.district,
+		min_price = EXCLUDED.min_price, max_price = EXCLUDED.max_price, modal_price = EXCLUDED.modal_price,
+		imported_at = CURRENT_TIMESTAMP;`
+
+	for _, p := range prices {
+		_, err := tx.Exec(q, p.Commodity, p.Variety, p.State, p.District, p.Market, p.ArrivalDate, p.MinPrice, p.MaxPrice, p.ModalPrice)
+		if err != nil {
+			return fmt.Errorf("error inserting market price for %s at %s: %v", p.Commodity, p.Market, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// GetRecentMarketPricesFromStore returns prices of the last `days` days in a state whose commodity
+// contains any of the given terms, e.g. "Mushroom" matches "Mushrooms"
+func GetRecentMarketPricesFromStore(db *sql.DB, terms []string, state string, days int) ([]types.MarketPrice, error) {
+	q := `
+	SELECT id, commodity, variety, state, district, market, arrival_date, min_price, max_price, modal_price
+	FROM market_prices
+	WHERE LOWER(state) = LOWER($1)
+		AND arrival_date >= CURRENT_DATE - $2::INT
+		AND EXISTS (SELECT 1 FROM UNNEST($3::TEXT[]) t WHERE t <> '' AND commodity ILIKE '%' || t || '%')
+	ORDER BY arrival_date DESC, market;`
+
+	rows, err := db.Query(q, state, days, pq.Array(terms))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch market prices: %v", err)
+	}
+	defer rows.Close()
+
+	var prices []types.MarketPrice
+	for rows.Next() {
+		var p types.MarketPrice
+		if err := rows.Scan(&p.ID, &p.Commodity, &p.Variety, &p.State, &p.District, &p.Market, &p.ArrivalDate,
+			&p.MinPrice, &p.MaxPrice, &p.ModalPrice); err != nil {
+			return nil, fmt.Errorf("failed to scan market price: %v", err)
+		}
+		// mandi prices are per quintal
+		p.ModalPricePerKg = p.ModalPrice / 100
+		prices = append(prices, p)
+	}
+	return prices, nil
+}
+
+func GetFarmerStateFromStore(db *sql.DB, farmerID int) (string, error) {
+	var state string
+	if err := db.QueryRow("SELECT state FROM farmers WHERE user_id = $1", farmerID).Scan(&state); err != nil {
+		return "", fmt.Errorf("error finding farmer state: %v", err)
+	}
+	return state, nil
+}
@@ -0,0 +1,35 @@
+package notification
+
+import (
+	"database/sql"
+	"log"
+)
+
+// Kinds of notifications, the app uses them to pick an icon and a screen to open
+const (
+	KindListingExpiring = "listing_expiring"
+	KindListingExpired  = "listing_expired"
+	KindListingLive     = "listing_live"
+	KindPreOrderUpdate  = "pre_order_update"
+	KindRFQ             = "rfq"
+	KindOffer           = "offer"
+	KindAuction         = "auction"
+	KindPayout          = "payout"
+	KindShipment        = "shipment"
+	KindReview          = "review"
+	KindMessage         = "message"
+	KindDispute         = "dispute"
+	KindSubscription    = "subscription"
+	KindOrganization    = "organization"
+	KindFPO             = "fpo"
+	KindProductAlert    = "product_alert"
+	KindReferral        = "referral"
+)
+
+// Notify stores an in-app notification for a user. Failing to notify must never fail the action
+// that triggered it, so errors are only logged.
+func Notify(db *sql.DB, userID int, kind, title, body string) {
+	if err := CreateNotificationInStore(db, userID, kind, title, body); err != nil {
+		log.Printf("notification: failed to notify user %d (%s): %v", userID, kind, err)
+	}
+}
@@ -0,0 +1,48 @@
+package notification
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// GetNotifications lists the logged in user's notifications, ?unread=true for unread ones only
+func GetNotifications(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		res, err := GetNotificationsFromStore(db, userID, c.QueryParam("unread") == "true")
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching notifications: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+func MarkNotificationRead(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		notificationID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing notification id: %v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		if err := MarkNotificationReadInStore(db, userID, notificationID); err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, fmt.Sprintf("error updating notification: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "notification marked as read!"})
+	}
+}
@@ -0,0 +1,65 @@
+package notification
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ritu84/agrohub/types"
+)
+
+func CreateNotificationInStore(db *sql.DB, userID int, kind, title, body string) error {
+	q := `
+	INSERT INTO notifications (user_id, kind, title, body)
+	VALUES ($1, $2, $3, $4);`
+
+	if _, err := db.Exec(q, userID, kind, title, body); err != nil {
+		return fmt.Errorf("error inserting notification: %v", err)
+	}
+	return nil
+}
+
+func GetNotificationsFromStore(db *sql.DB, userID int, unreadOnly bool) ([]types.Notification, error) {
+	q := `
+	SELECT id, user_id, kind, title, body, read_at, created_at
+	FROM notifications
+	WHERE user_id = $1 AND ($2 = false OR read_at IS NULL)
+	ORDER BY created_at DESC
+	LIMIT 100;`
+
+	rows, err := db.Query(q, userID, unreadOnly)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch notifications: %v", err)
+	}
+	defer rows.Close()
+
+	var notifications []types.Notification
+	for rows.Next() {
+		var n types.Notification
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Kind, &n.Title, &n.Body, &n.ReadAt, &n.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan notification: %v", err)
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, nil
+}
+
+func MarkNotificationReadInStore(db *sql.DB, userID, notificationID int) error {
+	q := `
+	UPDATE notifications
+	SET read_at = COALESCE(read_at, NOW())
+	WHERE id = $1 AND user_id = $2;`
+
+	result, err := db.Exec(q, notificationID, userID)
+	if err != nil {
+		return fmt.Errorf("error updating notification: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("no notification found with ID %d", notificationID)
+	}
+	return nil
+}
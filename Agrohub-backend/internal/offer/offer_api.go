@@ -0,0 +1,233 @@
+package offer
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ritu84/agrohub/internal/geo"
+	"github.com/ritu84/agrohub/internal/notification"
+	"github.com/ritu84/agrohub/internal/pricing"
+	"github.com/ritu84/agrohub/internal/product"
+	"github.com/ritu84/agrohub/types"
+	"github.com/labstack/echo/v4"
+)
+
+// checkTerms makes sure proposed terms could actually be sold from the listing right now
+func checkTerms(p types.Product, rate float64, qty int) error {
+	if rate <= 0 {
+		return errors.New("rate_per_kg must be greater than 0")
+	}
+	if err := pricing.ValidateQuantity(p, qty); err != nil {
+		return err
+	}
+	if p.Quantity < qty {
+		return errors.New("insufficient quantity available")
+	}
+	return nil
+}
+
+// CreateOffer lets the logged in buyer propose their own price and quantity on a listing
+func CreateOffer(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ProductID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing product id :%v", err))
+		}
+
+		var o types.Offer
+		if err := c.Bind(&o); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+		o.BuyerID = userID
+		o.ProductID = ProductID
+
+		if o.DeliveryAddress == "" || o.DeliveryCity == "" {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "delivery address and city are required")
+		}
+		if !o.DeliveryAddressZIP.Valid() {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "delivery_address_zip must be a 6 digit pin code")
+		}
+
+		p, err := product.GetProductFromStore(db, ProductID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, fmt.Sprintf("unable to fetch the product from store :%v", err))
+		}
+		if product.ListedBy(c, p) {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "you can't make an offer on your own listing")
+		}
+		if !p.IsAvailable {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "product is not available")
+		}
+		if err := checkTerms(p, o.RatePerKg, o.QuantityInKg); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, err.Error())
+		}
+		// The farm has to deliver there for the offer to become an order
+		quote := types.Quote{QuantityInKg: o.QuantityInKg, Subtotal: pricing.Round(float64(o.QuantityInKg) * o.RatePerKg)}
+		if err := geo.ApplyDelivery(db, p.FarmerID, string(o.DeliveryAddressZIP), &quote); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, err.Error())
+		}
+		o.FarmerID = p.FarmerID
+		o.ProductName = p.Name
+
+		if err := CreateOfferInStore(db, &o, TTL()); err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error creating offer: %v", err))
+		}
+
+		notification.Notify(db, o.FarmerID, notification.KindOffer, "New offer on your listing",
+			fmt.Sprintf("A buyer offered Rs %.2f/kg for %d kg of %s. Respond before %s.",
+				o.RatePerKg, o.QuantityInKg, p.Name, o.ExpiresAt.Format("02 Jan 2006 15:04")))
+
+		return c.JSON(http.StatusCreated, o)
+	}
+}
+
+// GetMyOffers lists the offers the logged in user is negotiating, as buyer or as farmer
+func GetMyOffers(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		res, err := GetUserOffersFromStore(db, userID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching offers: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+// GetOffer returns an offer and its negotiation thread to the buyer or farmer on it
+func GetOffer(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		offerID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing offer id: %v", err))
+		}
+
+		o, err := GetOfferFromStore(db, offerID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+		if userID, ok := c.Get("user_id").(int); !ok || roleOf(o, userID) == "" {
+			return echo.NewHTTPError(http.StatusForbidden, "only the buyer and farmer on an offer can see it")
+		}
+
+		return c.JSON(http.StatusOK, o)
+	}
+}
+
+// CounterOffer answers the other side's terms with new ones
+func CounterOffer(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		offerID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing offer id: %v", err))
+		}
+
+		var req types.CounterOffer
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		current, err := GetOfferFromStore(db, offerID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+		p, err := product.GetProductFromStore(db, current.ProductID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, fmt.Sprintf("unable to fetch the product from store :%v", err))
+		}
+		if err := checkTerms(p, req.RatePerKg, req.QuantityInKg); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, err.Error())
+		}
+
+		o, err := CounterOfferInStore(db, offerID, userID, req, TTL())
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error countering offer: %v", err))
+		}
+
+		notifyOther(db, o, userID, "You have a counter offer",
+			fmt.Sprintf("The %s countered with Rs %.2f/kg for %d kg of %s.", other(o.Awaiting), o.RatePerKg, o.QuantityInKg, o.ProductName))
+
+		return c.JSON(http.StatusOK, o)
+	}
+}
+
+// AcceptOffer agrees to the current terms, the stock is taken and the order placed at the agreed rate
+func AcceptOffer(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		offerID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing offer id: %v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		o, err := AcceptOfferInStore(db, offerID, userID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error accepting offer: %v", err))
+		}
+
+		notifyOther(db, o, userID, "Your offer was accepted",
+			fmt.Sprintf("Rs %.2f/kg for %d kg of %s was accepted, it is now order #%d.", o.RatePerKg, o.QuantityInKg, o.ProductName, *o.OrderID))
+
+		return c.JSON(http.StatusOK, o)
+	}
+}
+
+// RejectOffer ends the negotiation, the buyer can also use it to withdraw their offer
+func RejectOffer(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		offerID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing offer id: %v", err))
+		}
+
+		var req types.CounterOffer
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		o, err := RejectOfferInStore(db, offerID, userID, req.Message)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error rejecting offer: %v", err))
+		}
+
+		notifyOther(db, o, userID, "Your offer was declined",
+			fmt.Sprintf("The negotiation on %s was ended by the %s.", o.ProductName, roleOf(o, userID)))
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "offer rejected successfully!"})
+	}
+}
+
+// notifyOther tells the side of the offer that didn't act
+func notifyOther(db *sql.DB, o types.Offer, actorID int, title, body string) {
+	to := o.BuyerID
+	if actorID == o.BuyerID {
+		to = o.FarmerID
+	}
+	notification.Notify(db, to, notification.KindOffer, title, body)
+}
@@ -0,0 +1,42 @@
+package offer
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ritu84/agrohub/internal/notification"
+	"github.com/ritu84/agrohub/internal/scheduler"
+	"github.com/ritu84/agrohub/types"
+)
+
+// TTL is how long the other side has to respond to a proposal or counter, OFFER_TTL (default 48h)
+func TTL() time.Duration {
+	return scheduler.DurationFromEnv("OFFER_TTL", 48*time.Hour)
+}
+
+// Jobs are the background jobs for offers, they run every OFFER_JOBS_INTERVAL (default 15m)
+func Jobs() []scheduler.Job {
+	return []scheduler.Job{
+		{Name: "expire-offers", Interval: scheduler.DurationFromEnv("OFFER_JOBS_INTERVAL", 15*time.Minute), Run: ExpireOffers},
+	}
+}
+
+// ExpireOffers closes offers nobody responded to in time and lets both sides know
+func ExpireOffers(db *sql.DB) error {
+	expired, err := ExpireOffersInStore(db)
+	if err != nil {
+		return err
+	}
+
+	for _, o := range expired {
+		notifyBoth(db, o, "Your offer expired",
+			fmt.Sprintf("The offer on %s expired because the %s did not respond in time.", o.ProductName, o.Awaiting))
+	}
+	return nil
+}
+
+func notifyBoth(db *sql.DB, o types.Offer, title, body string) {
+	notification.Notify(db, o.BuyerID, notification.KindOffer, title, body)
+	notification.Notify(db, o.FarmerID, notification.KindOffer, title, body)
+}
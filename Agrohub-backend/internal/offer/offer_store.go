@@ -0,0 +1,342 @@
+package offer
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ritu84/agrohub/internal/events"
+	order "github.com/ritu84/agrohub/internal/orders"
+	"github.com/ritu84/agrohub/types"
+)
+
+const (
+	StatusOpen     = "open"
+	StatusAccepted = "accepted"
+	StatusRejected = "rejected"
+	StatusExpired  = "expired"
+
+	Buyer  = "buyer"
+	Farmer = "farmer"
+
+	ActionProposed  = "proposed"
+	ActionCountered = "countered"
+	ActionAccepted  = "accepted"
+	ActionRejected  = "rejected"
+	ActionExpired   = "expired"
+)
+
+const offerColumns = `
+	o.id, o.product_id, p.name, o.buyer_id, o.farmer_id, o.rate_per_kg, o.quantity_in_kg, o.awaiting, o.status,
+	o.expires_at, o.order_id, COALESCE(o.mode_of_delivery, ''), o.delivery_address, o.delivery_city,
+	o.delivery_address_zip, o.buyers_phone_number, o.created_at, o.updated_at`
+
+func scanOffer(row interface{ Scan(...interface{}) error }) (types.Offer, error) {
+	var o types.Offer
+	err := row.Scan(&o.ID, &o.ProductID, &o.ProductName, &o.BuyerID, &o.FarmerID, &o.RatePerKg, &o.QuantityInKg, &o.Awaiting, &o.Status,
+		&o.ExpiresAt, &o.OrderID, &o.ModeOfDelivery, &o.DeliveryAddress, &o.DeliveryCity,
+		&o.DeliveryAddressZIP, &o.BuyersPhoneNumber, &o.CreatedAt, &o.UpdatedAt)
+	return o, err
+}
+
+// roleOf tells whether userID is the buyer or the farmer on the offer, empty if neither
+func roleOf(o types.Offer, userID int) string {
+	switch userID {
+	case o.BuyerID:
+		return Buyer
+	case o.FarmerID:
+		return Farmer
+	}
+	return ""
+}
+
+func other(role string) string {
+	if role == Buyer {
+		return Farmer
+	}
+	return Buyer
+}
+
+func insertEventTx(tx *sql.Tx, offerID int, actorID *int, action string, rate *float64, qty *int, message string) error {
+	_, err := tx.Exec(`
+		INSERT INTO offer_events (offer_id, actor_id, action, rate_per_kg, quantity_in_kg, message)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))`, offerID, actorID, action, rate, qty, message)
+	if err != nil {
+		return fmt.Errorf("error recording offer event: %v", err)
+	}
+	return nil
+}
+
+// CreateOfferInStore opens a negotiation with the buyer's proposal, the farmer has ttl to respond
+func CreateOfferInStore(db *sql.DB, o *types.Offer, ttl time.Duration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+		INSERT INTO offers (product_id, buyer_id, farmer_id, rate_per_kg, quantity_in_kg, awaiting, status, expires_at,
+			buyers_phone_number, mode_of_delivery, delivery_address, delivery_city, delivery_address_zip)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW() + $8 * INTERVAL '1 second', $9, $10, $11, $12, $13)
+		RETURNING id, awaiting, status, expires_at, created_at, updated_at`,
+		o.ProductID, o.BuyerID, o.FarmerID, o.RatePerKg, o.QuantityInKg, Farmer, StatusOpen, int(ttl.Seconds()),
+		o.BuyersPhoneNumber, o.ModeOfDelivery, o.DeliveryAddress, o.DeliveryCity, o.DeliveryAddressZIP).
+		Scan(&o.ID, &o.Awaiting, &o.Status, &o.ExpiresAt, &o.CreatedAt, &o.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error inserting offer: %v", err)
+	}
+
+	if err := insertEventTx(tx, o.ID, &o.BuyerID, ActionProposed, &o.RatePerKg, &o.QuantityInKg, o.Message); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// GetOfferFromStore returns the offer with its whole negotiation thread, oldest step first
+func GetOfferFromStore(db *sql.DB, offerID int) (types.Offer, error) {
+	o, err := scanOffer(db.QueryRow(`SELECT`+offerColumns+` FROM offers o JOIN products p ON p.id = o.product_id WHERE o.id = $1`, offerID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return o, fmt.Errorf("no offer found with ID %d", offerID)
+		}
+		return o, fmt.Errorf("error querying offer: %v", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT id, offer_id, actor_id, action, rate_per_kg, quantity_in_kg, COALESCE(message, ''), created_at
+		FROM offer_events WHERE offer_id = $1 ORDER BY created_at, id`, offerID)
+	if err != nil {
+		return o, fmt.Errorf("error querying offer events: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e types.OfferEvent
+		if err := rows.Scan(&e.ID, &e.OfferID, &e.ActorID, &e.Action, &e.RatePerKg, &e.QuantityInKg, &e.Message, &e.CreatedAt); err != nil {
+			return o, fmt.Errorf("failed to scan offer event: %v", err)
+		}
+		o.Events = append(o.Events, e)
+	}
+	return o, rows.Err()
+}
+
+// GetUserOffersFromStore lists the offers a user is part of as buyer or farmer, latest activity first
+func GetUserOffersFromStore(db *sql.DB, userID int) ([]types.Offer, error) {
+	rows, err := db.Query(`SELECT`+offerColumns+`
+		FROM offers o JOIN products p ON p.id = o.product_id
+		WHERE o.buyer_id = $1 OR o.farmer_id = $1
+		ORDER BY o.updated_at DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying offers: %v", err)
+	}
+	defer rows.Close()
+
+	offers := []types.Offer{}
+	for rows.Next() {
+		o, err := scanOffer(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan offer: %v", err)
+		}
+		offers = append(offers, o)
+	}
+	return offers, rows.Err()
+}
+
+// lockOpenOfferTx locks the offer and checks that it's still open and that it's userID's turn to respond
+func lockOpenOfferTx(tx *sql.Tx, offerID, userID int) (types.Offer, string, error) {
+	var expired bool
+	row := tx.QueryRow(`SELECT`+offerColumns+`, o.expires_at <= NOW()
+		FROM offers o JOIN products p ON p.id = o.product_id
+		WHERE o.id = $1 FOR UPDATE OF o`, offerID)
+
+	var o types.Offer
+	err := row.Scan(&o.ID, &o.ProductID, &o.ProductName, &o.BuyerID, &o.FarmerID, &o.RatePerKg, &o.QuantityInKg, &o.Awaiting, &o.Status,
+		&o.ExpiresAt, &o.OrderID, &o.ModeOfDelivery, &o.DeliveryAddress, &o.DeliveryCity,
+		&o.DeliveryAddressZIP, &o.BuyersPhoneNumber, &o.CreatedAt, &o.UpdatedAt, &expired)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return o, "", fmt.Errorf("no offer found with ID %d", offerID)
+		}
+		return o, "", fmt.Errorf("error querying offer: %v", err)
+	}
+
+	role := roleOf(o, userID)
+	if role == "" {
+		return o, "", fmt.Errorf("offer %d is not yours", offerID)
+	}
+	if o.Status != StatusOpen {
+		return o, role, fmt.Errorf("offer is already %s", o.Status)
+	}
+	if expired {
+		return o, role, fmt.Errorf("offer has expired")
+	}
+	return o, role, nil
+}
+
+// CounterOfferInStore replaces the terms with the counter, hands the turn to the other side and restarts the clock
+func CounterOfferInStore(db *sql.DB, offerID, userID int, counter types.CounterOffer, ttl time.Duration) (types.Offer, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return types.Offer{}, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	o, role, err := lockOpenOfferTx(tx, offerID, userID)
+	if err != nil {
+		return o, err
+	}
+	if o.Awaiting != role {
+		return o, fmt.Errorf("waiting for the %s to respond", o.Awaiting)
+	}
+
+	err = tx.QueryRow(`
+		UPDATE offers
+		SET rate_per_kg = $1, quantity_in_kg = $2, awaiting = $3, expires_at = NOW() + $4 * INTERVAL '1 second', updated_at = NOW()
+		WHERE id = $5
+		RETURNING rate_per_kg, quantity_in_kg, awaiting, expires_at, updated_at`,
+		counter.RatePerKg, counter.QuantityInKg, other(role), int(ttl.Seconds()), offerID).
+		Scan(&o.RatePerKg, &o.QuantityInKg, &o.Awaiting, &o.ExpiresAt, &o.UpdatedAt)
+	if err != nil {
+		return o, fmt.Errorf("error countering offer: %v", err)
+	}
+
+	if err := insertEventTx(tx, offerID, &userID, ActionCountered, &o.RatePerKg, &o.QuantityInKg, counter.Message); err != nil {
+		return o, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return o, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return o, nil
+}
+
+// RejectOfferInStore ends the negotiation, either side can walk away from an open offer
+func RejectOfferInStore(db *sql.DB, offerID, userID int, message string) (types.Offer, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return types.Offer{}, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	o, _, err := lockOpenOfferTx(tx, offerID, userID)
+	if err != nil {
+		return o, err
+	}
+
+	o.Status = StatusRejected
+	err = tx.QueryRow(`UPDATE offers SET status = $1, updated_at = NOW() WHERE id = $2 RETURNING updated_at`, o.Status, offerID).
+		Scan(&o.UpdatedAt)
+	if err != nil {
+		return o, fmt.Errorf("error rejecting offer: %v", err)
+	}
+
+	if err := insertEventTx(tx, offerID, &userID, ActionRejected, nil, nil, message); err != nil {
+		return o, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return o, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return o, nil
+}
+
+// AcceptOfferInStore takes the agreed quantity off the product's stock and places the order at the agreed rate, with
+// delivery charged on top
+func AcceptOfferInStore(db *sql.DB, offerID, userID int) (types.Offer, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return types.Offer{}, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	o, role, err := lockOpenOfferTx(tx, offerID, userID)
+	if err != nil {
+		return o, err
+	}
+	if o.Awaiting != role {
+		return o, fmt.Errorf("you can't accept your own terms, waiting for the %s to respond", o.Awaiting)
+	}
+
+	ord := types.Order{
+		BuyerID:            o.BuyerID,
+		ProductID:          o.ProductID,
+		QuantityInKg:       o.QuantityInKg,
+		ModeOfDelivery:     o.ModeOfDelivery,
+		DeliveryAddress:    o.DeliveryAddress,
+		DeliveryCity:       o.DeliveryCity,
+		DeliveryAddressZIP: o.DeliveryAddressZIP,
+		BuyersPhoneNumber:  o.BuyersPhoneNumber,
+	}
+	if err := order.InsertAgreedOrderTx(db, tx, o.FarmerID, o.RatePerKg, &ord); err != nil {
+		return o, err
+	}
+	if err := order.DeductStockTx(tx, o.ProductID, o.QuantityInKg); err != nil {
+		return o, fmt.Errorf("the farmer no longer has enough stock for this offer: %v", err)
+	}
+
+	o.Status = StatusAccepted
+	o.OrderID = &ord.ID
+	err = tx.QueryRow(`UPDATE offers SET status = $1, order_id = $2, updated_at = NOW() WHERE id = $3 RETURNING updated_at`,
+		o.Status, ord.ID, offerID).Scan(&o.UpdatedAt)
+	if err != nil {
+		return o, fmt.Errorf("error accepting offer: %v", err)
+	}
+
+	if err := insertEventTx(tx, offerID, &userID, ActionAccepted, &o.RatePerKg, &o.QuantityInKg, ""); err != nil {
+		return o, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return o, fmt.Errorf("error committing transaction: %v", err)
+	}
+	order.PublishOrderEvent(db, ord.ID, events.TypeOrderCreated)
+	return o, nil
+}
+
+// ExpireOffersInStore closes every open offer nobody responded to in time and returns them
+func ExpireOffersInStore(db *sql.DB) ([]types.Offer, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		UPDATE offers o SET status = $1, updated_at = NOW()
+		FROM products p
+		WHERE p.id = o.product_id AND o.status = $2 AND o.expires_at <= NOW()
+		RETURNING`+offerColumns, StatusExpired, StatusOpen)
+	if err != nil {
+		return nil, fmt.Errorf("error expiring offers: %v", err)
+	}
+
+	var expired []types.Offer
+	for rows.Next() {
+		o, err := scanOffer(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan offer: %v", err)
+		}
+		expired = append(expired, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error expiring offers: %v", err)
+	}
+
+	for _, o := range expired {
+		if err := insertEventTx(tx, o.ID, nil, ActionExpired, nil, nil, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return expired, nil
+}
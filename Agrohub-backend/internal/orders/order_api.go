@@ -8,6 +8,7 @@ import (
 
 	"database/sql"
 
+	"github.com/ritu84/agrohub/internal/address"
 	users "github.com/ritu84/agrohub/internal/user"
 	"github.com/ritu84/agrohub/types"
 	"github.com/labstack/echo/v4"
@@ -28,6 +29,8 @@ func CreateOrder(db *sql.DB) echo.HandlerFunc {
 		}
 
 		o.ProductID = ProductID
+		// Orders for an organization are placed through its own route, where the member's role is checked
+		o.OrganizationID = nil
 		if userID, ok := c.Get("user_id").(int); ok {
 			o.BuyerID = userID
 		} else {
@@ -39,11 +42,27 @@ func CreateOrder(db *sql.DB) echo.HandlerFunc {
             return c.JSON(http.StatusBadRequest, map[string]string{"error": "quantity must be greater than 0"})
         }
 
+		// A saved address (or the buyer's default one when nothing is typed in) is copied onto the order
+		if o.AddressID != nil || (o.DeliveryAddress == "" && o.DeliveryCity == "" && o.DeliveryAddressZIP == "") {
+			addressID := 0
+			if o.AddressID != nil {
+				addressID = *o.AddressID
+			}
+			a, err := address.GetUserAddressFromStore(db, o.BuyerID, addressID)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+			}
+			SnapshotAddress(&o, a)
+		}
+
         if o.DeliveryAddress == "" || o.DeliveryCity == "" {
             return c.JSON(http.StatusBadRequest, map[string]string{"error": "delivery address and city are required"})
         }
+		if !o.DeliveryAddressZIP.Valid() {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "delivery_address_zip must be a 6 digit pin code"})
+		}
 
-		if err := CreateOrderInStore(db, o); err != nil {
+		if err := CreateOrderInStore(db, &o); err != nil {
 			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error creating new order:%v", err))
 		}
 
@@ -51,6 +70,16 @@ func CreateOrder(db *sql.DB) echo.HandlerFunc {
 	}
 }
 
+// SnapshotAddress copies a saved address onto an order, later edits to the address don't change the order
+func SnapshotAddress(o *types.Order, a types.Address) {
+	o.AddressID = &a.ID
+	o.DeliveryAddress = a.Address
+	o.DeliveryLandmark = a.Landmark
+	o.DeliveryCity = a.City
+	o.DeliveryState = a.State
+	o.DeliveryAddressZIP = a.PinCode
+}
+
 func GetOrders(db *sql.DB) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		userID, err := strconv.Atoi(c.Param("id"))
@@ -104,8 +133,16 @@ func UpdateOrderStatus(db *sql.DB) echo.HandlerFunc {
 			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing update request:%v", err))
 		}
 
-		if err := UpdateOrderStatusInStore(db, orderID, o.Status); err != nil {
-			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error updating order status:%v", err))
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		if err := UpdateOrderStatusInStore(db, orderID, userID, o.Status); err != nil {
+			if err == ErrNotOrderParty {
+				return echo.NewHTTPError(http.StatusForbidden, err.Error())
+			}
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error updating order status:%v", err))
 		}
 
 		return c.JSON(200, map[string]string{"message": "order status updated successfully!"})
@@ -2,12 +2,29 @@ package order
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
 
+	"github.com/ritu84/agrohub/internal/events"
+	"github.com/ritu84/agrohub/internal/geo"
+	"github.com/ritu84/agrohub/internal/ledger"
+	"github.com/ritu84/agrohub/internal/pricing"
 	"github.com/ritu84/agrohub/internal/product"
+	"github.com/ritu84/agrohub/internal/promotion"
 	"github.com/ritu84/agrohub/types"
 )
 
+// Orders placed for an organization above its approval limit wait in pending_approval for one of its approvers, and
+// end up rejected if turned down. The farmer doesn't see them until they are approved.
+const (
+	StatusPendingApproval = "pending_approval"
+	StatusRejected        = "rejected"
+)
+
+// ErrNotOrderParty is returned when someone other than the order's buyer or farmer tries to change it
+var ErrNotOrderParty = errors.New("only the buyer and farmer of an order can change it")
+
 func GetOrderFromStore(db *sql.DB, orderID int) (types.OrderSummary, error) {
 	var order types.OrderSummary
 	var expectedDeliveryDate sql.NullTime
@@ -15,10 +32,11 @@ func GetOrderFromStore(db *sql.DB, orderID int) (types.OrderSummary, error) {
 
 	query := `
 		SELECT 
-			o.id, o.quantity_in_kg, o.total_price, o.status, o.mode_of_delivery, 
+			o.id, o.quantity_in_kg, o.total_price, o.delivery_fee, o.discount, o.status, o.mode_of_delivery, 
 			o.expected_delivery_date, o.created_at, o.product_id, p.name,p.img,
 			u.id, u.first_name, u.last_name, u.phone_number,
 			o.delivery_address, o.delivery_city, o.delivery_address_zip,
+			COALESCE(o.delivery_landmark, ''), COALESCE(o.delivery_state, ''),
 			o.buyers_phone_number, p.farmers_phone_number
 		FROM 
 			orders o
@@ -31,10 +49,11 @@ func GetOrderFromStore(db *sql.DB, orderID int) (types.OrderSummary, error) {
 	`
 
 	err := db.QueryRow(query, orderID).Scan(
-		&order.OrderID, &order.QuantityInKg, &order.TotalPrice, &order.Status, &order.ModeOfDelivery,
+		&order.OrderID, &order.QuantityInKg, &order.TotalPrice, &order.DeliveryFee, &order.Discount, &order.Status, &order.ModeOfDelivery,
 		&expectedDeliveryDate, &order.OrderDate, &order.ProductID, &order.ProductName,&order.ProductImg,
 		&order.UserID, &order.UserFirstName, &order.UserLastName, &order.UserPhoneNumber,
 		&order.DeliveryAddress, &order.DeliveryCity, &order.DeliveryAddressZIP,
+		&order.DeliveryLandmark, &order.DeliveryState,
 		&order.BuyersPhoneNumber, &order.FarmersPhoneNumber,
 	)
 
@@ -49,17 +68,22 @@ func GetOrderFromStore(db *sql.DB, orderID int) (types.OrderSummary, error) {
 		order.ExpectedDeliveryDate = &expectedDeliveryDate.Time
 	}
 
+	if order.Discount > 0 {
+		if order.Discounts, err = promotion.GetOrderDiscountsFromStore(db, orderID); err != nil {
+			return order, err
+		}
+	}
+
 	return order, nil
 }
 
 func isValidOrderStatus(status string) bool {
-	// Replace this with the actual allowed enum values from your database
+	// Only what the buyer and farmer decide themselves, everything else follows money or goods actually moving
 	allowedStatuses := map[string]bool{
-		"pending":   true,
-		"approved":  true,
-		"shipped":   true,  // Ensure this is the correct enum value
-		"delivered": true,
+		"approved":  true, // the farmer accepts a paid or cash on delivery order
 		"cancelled": true,
+		// "processing" and "refunded" are only set by the payment package once money has actually moved
+		// "shipped" and "delivered" are only set by the shipment package when the goods are dispatched and handed over
 	}
 
 	// Check if the provided status exists in the allowed statuses
@@ -68,9 +92,65 @@ func isValidOrderStatus(status string) bool {
 }
 
 
-func UpdateOrderStatusInStore(db *sql.DB, orderID int, status string) error {
+// UpdateOrderStatusInStore lets the farmer approve an order and the buyer or farmer cancel one that no money was
+// taken for yet. An order waiting for its organization's approval isn't the farmer's yet, only the member who placed
+// it can cancel it. A cancelled order's stock goes back on the product.
+func UpdateOrderStatusInStore(db *sql.DB, orderID, userID int, status string) error {
 	if !isValidOrderStatus(status) {
-		return fmt.Errorf("invalid status: %s", status)
+		return fmt.Errorf("status must be approved or cancelled, the others are set by payments and shipments")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var current string
+	var buyerID, farmerID int
+	var captured bool
+	err = tx.QueryRow(`
+		SELECT o.status, o.buyer_id, p.farmer_id,
+			EXISTS (SELECT 1 FROM payments pay WHERE pay.order_id = o.id AND pay.status IN ('captured', 'partially_refunded', 'refunded'))
+		FROM orders o JOIN products p ON p.id = o.product_id
+		WHERE o.id = $1
+		FOR UPDATE OF o`, orderID).Scan(&current, &buyerID, &farmerID, &captured)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("no order found with ID %d", orderID)
+		}
+		return fmt.Errorf("error querying order: %v", err)
+	}
+	awaitingOrganization := current == StatusPendingApproval || current == StatusRejected
+	if userID != buyerID && (userID != farmerID || awaitingOrganization) {
+		return ErrNotOrderParty
+	}
+	if current == StatusRejected {
+		return fmt.Errorf("order was rejected by the buyer's organization")
+	}
+
+	switch status {
+	case "approved":
+		if userID != farmerID {
+			return fmt.Errorf("only the farmer can approve an order")
+		}
+		if current != "processing" {
+			return fmt.Errorf("only processing orders can be approved, order is %s", current)
+		}
+	case "cancelled":
+		if captured {
+			return fmt.Errorf("order is paid for, it is cancelled by refunding its payment")
+		}
+		if current != "pending" && current != "processing" && current != "approved" && current != StatusPendingApproval {
+			return fmt.Errorf("order is %s and can't be cancelled", current)
+		}
+		// an unfinished checkout or cash on delivery can't be paid any more
+		if _, err := tx.Exec(`UPDATE payments SET status = 'cancelled', updated_at = NOW() WHERE order_id = $1 AND status IN ('created', 'cod')`, orderID); err != nil {
+			return fmt.Errorf("error cancelling payment: %v", err)
+		}
+		if err := ReleaseOrderTx(tx, orderID); err != nil {
+			return err
+		}
 	}
 
 	query := `
@@ -79,24 +159,46 @@ func UpdateOrderStatusInStore(db *sql.DB, orderID int, status string) error {
 		WHERE id = $2
 	`
 
-	result, err := db.Exec(query, status, orderID)
-	if err != nil {
+	if _, err := tx.Exec(query, status, orderID); err != nil {
 		return fmt.Errorf("error updating order status: %v", err)
 	}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("error getting rows affected: %v", err)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
 	}
+	PublishOrderEvent(db, orderID, events.TypeOrderStatus)
+	return nil
+}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("no order found with ID %d", orderID)
+// PublishOrderEvent streams an order's current state to its buyer and farmer. Call it once the change is committed,
+// like notifications a failure is only logged.
+func PublishOrderEvent(db *sql.DB, orderID int, typ string) {
+	var e types.OrderEvent
+	err := db.QueryRow(`
+		SELECT o.id, o.product_id, p.name, o.buyer_id, p.farmer_id, o.status, o.quantity_in_kg, o.total_price, o.updated_at
+		FROM orders o JOIN products p ON p.id = o.product_id
+		WHERE o.id = $1`, orderID).
+		Scan(&e.OrderID, &e.ProductID, &e.ProductName, &e.BuyerID, &e.FarmerID, &e.Status, &e.QuantityInKg, &e.TotalPrice, &e.UpdatedAt)
+	if err != nil {
+		log.Printf("order: failed to publish %s for order %d: %v", typ, orderID, err)
+		return
+	}
+	events.Publish(e.BuyerID, typ, e)
+	if e.Status != StatusPendingApproval && e.Status != StatusRejected {
+		events.Publish(e.FarmerID, typ, e)
 	}
+}
 
-	return nil
+// MarkDeliveredTx marks a locked order delivered and books the farmer's earnings, now that the buyer has the goods
+func MarkDeliveredTx(tx *sql.Tx, orderID int) error {
+	if _, err := tx.Exec(`UPDATE orders SET status = 'delivered', delivered_at = NOW(), updated_at = NOW() WHERE id = $1`, orderID); err != nil {
+		return fmt.Errorf("error updating order status: %v", err)
+	}
+	return ledger.RecordDeliveryTx(tx, orderID)
 }
 
-func CreateOrderInStore(db *sql.DB, order types.Order) error {
+// CreateOrderInStore prices and places an order from a product's stock, order.ID is set once it is placed
+func CreateOrderInStore(db *sql.DB, order *types.Order) error {
 	tx, err := db.Begin()
 	if err != nil {
 		return fmt.Errorf("error starting transaction: %v", err)
@@ -109,36 +211,202 @@ func CreateOrderInStore(db *sql.DB, order types.Order) error {
 		return fmt.Errorf("unable to fetch product :%v", err)
 	}
 
+	if !p.IsAvailable {
+		return fmt.Errorf("product is not available for ordering")
+	}
+
 	if p.Quantity < order.QuantityInKg {
 		return fmt.Errorf("insufficient quantity available")
 	}
 
-	// Calculate total price
-	order.TotalPrice = float64(order.QuantityInKg) * p.RatePerKg
+	// Calculate total price from the product's tiers and order limits
+	quote, err := pricing.Calculate(p, order.QuantityInKg)
+	if err != nil {
+		return err
+	}
+	// Delivery from the farm to the buyer's pin code is charged on top
+	if err := geo.ApplyDelivery(db, p.FarmerID, string(order.DeliveryAddressZIP), &quote); err != nil {
+		return err
+	}
+	// A coupon comes off the goods, the coupon stays locked until the order is placed
+	if order.CouponCode != "" {
+		if err := promotion.ApplyTx(tx, order.CouponCode, order.BuyerID, p, &quote); err != nil {
+			return err
+		}
+	}
+	order.TotalPrice = quote.TotalPrice
+	order.DeliveryFee = quote.DeliveryFee
+	order.DistanceKm = quote.DistanceKm
+	order.Discount = quote.Discount
+	order.Discounts = quote.Discounts
+
+	if err := setApprovalStatusTx(tx, order); err != nil {
+		return err
+	}
+
+	if err := InsertOrderTx(tx, order); err != nil {
+		return err
+	}
 
-	err = tx.QueryRow(`
-		INSERT INTO orders (buyer_id, product_id, quantity_in_kg, total_price, status, mode_of_delivery, expected_delivery_date, delivery_address, delivery_city, delivery_address_zip, buyers_phone_number)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
-		RETURNING id, created_at, updated_at
-	`, order.BuyerID, order.ProductID, order.QuantityInKg, order.TotalPrice, "pending", order.ModeOfDelivery, order.ExpectedDeliveryDate ,order.DeliveryAddress, order.DeliveryCity, order.DeliveryAddressZIP, order.BuyersPhoneNumber).
-		Scan(&order.ID, &order.CreatedAt, &order.UpdatedAt)
+	if err := promotion.RecordTx(tx, order.ID, order.BuyerID, order.Discounts); err != nil {
+		return err
+	}
+
+	if err := DeductStockTx(tx, order.ProductID, order.QuantityInKg); err != nil {
+		return err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	PublishOrderEvent(db, order.ID, events.TypeOrderCreated)
+
+	return nil
+
+}
+
+// InsertAgreedOrderTx places an order at a rate agreed outside the listing's tiers, on an accepted offer or a won
+// auction. The agreed rate makes up the subtotal, delivery to the buyer's pin code is charged on top and an organization
+// order above its approval limit waits for an approver, as in CreateOrderInStore. Taking the stock is left to the caller.
+func InsertAgreedOrderTx(db *sql.DB, tx *sql.Tx, farmerID int, ratePerKg float64, order *types.Order) error {
+	if !order.DeliveryAddressZIP.Valid() {
+		return fmt.Errorf("delivery_address_zip must be a 6 digit pin code")
+	}
+
+	quote := types.Quote{
+		ProductID:    order.ProductID,
+		QuantityInKg: order.QuantityInKg,
+		RatePerKg:    ratePerKg,
+		Subtotal:     pricing.Round(float64(order.QuantityInKg) * ratePerKg),
+	}
+	if err := geo.ApplyDelivery(db, farmerID, string(order.DeliveryAddressZIP), &quote); err != nil {
+		return err
+	}
+	order.TotalPrice = quote.TotalPrice
+	order.DeliveryFee = quote.DeliveryFee
+	order.DistanceKm = quote.DistanceKm
+
+	if err := setApprovalStatusTx(tx, order); err != nil {
+		return err
+	}
+	return InsertOrderTx(tx, order)
+}
+
+// setApprovalStatusTx starts an order pending, or pending_approval when it is for an organization and above its
+// approval limit
+func setApprovalStatusTx(tx *sql.Tx, order *types.Order) error {
+	order.Status = "pending"
+	if order.OrganizationID == nil {
+		return nil
+	}
+	var limit sql.NullFloat64
+	if err := tx.QueryRow(`SELECT approval_limit FROM organizations WHERE id = $1`, *order.OrganizationID).Scan(&limit); err != nil {
+		return fmt.Errorf("error querying organization: %v", err)
+	}
+	if limit.Valid && order.TotalPrice > limit.Float64 {
+		order.Status = StatusPendingApproval
+	}
+	return nil
+}
+
+// InsertOrderTx writes a pending order with an already calculated TotalPrice. Flows that sell outside
+// CreateOrderInStore (pre-orders, offers, auctions) use it so every sale ends up in the orders table.
+// Only CreateOrderInStore and InsertAgreedOrderTx may start an order in pending_approval.
+func InsertOrderTx(tx *sql.Tx, order *types.Order) error {
+	status := "pending"
+	if order.Status == StatusPendingApproval {
+		status = StatusPendingApproval
+	}
+	err := tx.QueryRow(`
+		INSERT INTO orders (buyer_id, product_id, quantity_in_kg, total_price, delivery_fee, distance_km, status, mode_of_delivery, expected_delivery_date, delivery_address, delivery_city, delivery_address_zip, delivery_landmark, delivery_state, address_id, buyers_phone_number, organization_id, discount)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NULLIF($13, ''), NULLIF($14, ''), $15, $16, $17, $18)
+		RETURNING id, status, created_at, updated_at
+	`, order.BuyerID, order.ProductID, order.QuantityInKg, order.TotalPrice, order.DeliveryFee, order.DistanceKm, status, order.ModeOfDelivery, order.ExpectedDeliveryDate ,order.DeliveryAddress, order.DeliveryCity, order.DeliveryAddressZIP, order.DeliveryLandmark, order.DeliveryState, order.AddressID, order.BuyersPhoneNumber, order.OrganizationID, order.Discount).
+		Scan(&order.ID, &order.Status, &order.CreatedAt, &order.UpdatedAt)
 	if err != nil {
 		return fmt.Errorf("error inserting order: %v", err)
 	}
+	return nil
+}
 
-	// Update product quantity
-	_, err = tx.Exec("UPDATE products SET quantity_in_kg = quantity_in_kg - $1 WHERE id = $2", order.QuantityInKg, order.ProductID)
+// DeductStockTx takes qty off a product's stock, the listing is taken down once it runs out of stock
+func DeductStockTx(tx *sql.Tx, productID, qty int) error {
+	result, err := tx.Exec(`
+		UPDATE products
+		SET quantity_in_kg = quantity_in_kg - $1, is_available = quantity_in_kg - $1 > 0, updated_at = NOW()
+		WHERE id = $2 AND quantity_in_kg >= $1`, qty, productID)
 	if err != nil {
 		return fmt.Errorf("error updating product quantity: %v", err)
 	}
 
-	// Commit the transaction
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("error committing transaction: %v", err)
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("insufficient quantity available")
+	}
+	return nil
+}
+
+// GetOrderPartiesFromStore returns the buyer and the farmer of an order
+func GetOrderPartiesFromStore(db *sql.DB, orderID int) (int, int, error) {
+	var buyerID, farmerID int
+	err := db.QueryRow(`SELECT o.buyer_id, p.farmer_id FROM orders o JOIN products p ON p.id = o.product_id WHERE o.id = $1`, orderID).
+		Scan(&buyerID, &farmerID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, 0, fmt.Errorf("no order found with ID %d", orderID)
+		}
+		return 0, 0, fmt.Errorf("error querying order: %v", err)
 	}
+	return buyerID, farmerID, nil
+}
 
+// ReleaseOrderTx gives back what a locked order that hasn't left the farm holds on to, once it is cancelled or fully
+// refunded: its stock goes back on the product, a scheduled shipment is cancelled and open delivery slots withdrawn
+func ReleaseOrderTx(tx *sql.Tx, orderID int) error {
+	var productID, qty int
+	if err := tx.QueryRow(`SELECT product_id, quantity_in_kg FROM orders WHERE id = $1`, orderID).Scan(&productID, &qty); err != nil {
+		return fmt.Errorf("error querying order: %v", err)
+	}
+	if err := RestoreStockTx(tx, productID, qty); err != nil {
+		return err
+	}
+
+	var shipmentID int
+	err := tx.QueryRow(`UPDATE shipments SET status = 'cancelled', updated_at = NOW() WHERE order_id = $1 AND status = 'scheduled' RETURNING id`,
+		orderID).Scan(&shipmentID)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("error cancelling shipment: %v", err)
+	}
+	if err == nil {
+		if _, err := tx.Exec(`INSERT INTO shipment_events (shipment_id, status, note) VALUES ($1, 'cancelled', 'order cancelled')`, shipmentID); err != nil {
+			return fmt.Errorf("error inserting shipment event: %v", err)
+		}
+	}
+	if _, err := tx.Exec(`UPDATE delivery_slots SET status = 'withdrawn' WHERE order_id = $1 AND status IN ('proposed', 'confirmed')`, orderID); err != nil {
+		return fmt.Errorf("error withdrawing delivery slots: %v", err)
+	}
 	return nil
+}
 
+// RestoreStockTx puts qty back on a product's stock. A listing taken down because it sold out goes live again unless
+// it has expired or isn't available yet, one the farmer took down themselves stays down.
+func RestoreStockTx(tx *sql.Tx, productID, qty int) error {
+	_, err := tx.Exec(`
+		UPDATE products
+		SET quantity_in_kg = quantity_in_kg + $1,
+			is_available = CASE WHEN quantity_in_kg <= 0
+				THEN expired_at IS NULL AND (available_from IS NULL OR available_from <= CURRENT_DATE)
+				ELSE is_available END,
+			updated_at = NOW()
+		WHERE id = $2`, qty, productID)
+	if err != nil {
+		return fmt.Errorf("error restoring product quantity: %v", err)
+	}
+	return nil
 }
 
 // GetOrdersBasedOnUser fetches orders based on whether the user is a buyer or a farmer.
@@ -149,7 +417,7 @@ func GetOrdersBasedOnUser(db *sql.DB, userID int, userType string) ([]types.Orde
 	if userType == "farmer" {
 		query = `
 			SELECT 
-				o.id, o.quantity_in_kg, o.total_price, o.status, o.mode_of_delivery, 
+				o.id, o.quantity_in_kg, o.total_price, o.delivery_fee, o.discount, o.status, o.mode_of_delivery, 
 				o.expected_delivery_date, o.created_at, 
 				p.id, p.name,p.img, 
 				b.first_name, b.last_name, b.phone_number, o.delivery_address, o.delivery_city, o.delivery_address_zip
@@ -160,14 +428,14 @@ func GetOrdersBasedOnUser(db *sql.DB, userID int, userType string) ([]types.Orde
 			JOIN 
 				users b ON o.buyer_id = b.id -- Join to get the buyer's details
 			WHERE 
-				p.farmer_id = $1
+				p.farmer_id = $1 AND o.status NOT IN ('pending_approval', 'rejected') -- not the farmer's until the buyer's organization approves it
 			ORDER BY 
 				o.created_at DESC
 		`
 	} else if userType == "buyer" {
 		query = `
 			SELECT 
-				o.id, o.quantity_in_kg, o.total_price, o.status, o.mode_of_delivery, 
+				o.id, o.quantity_in_kg, o.total_price, o.delivery_fee, o.discount, o.status, o.mode_of_delivery, 
 				o.expected_delivery_date, o.created_at, 
 				p.id, p.name, p.img,
 				f.first_name, f.last_name, f.phone_number,
@@ -201,7 +469,7 @@ func GetOrdersBasedOnUser(db *sql.DB, userID int, userType string) ([]types.Orde
 		if userType == "farmer" {
 			// Scan for farmer-specific data (including buyer details)
 			err := rows.Scan(
-				&o.OrderDetails.OrderID, &o.OrderDetails.QuantityInKg, &o.OrderDetails.TotalPrice, &o.OrderDetails.Status,
+				&o.OrderDetails.OrderID, &o.OrderDetails.QuantityInKg, &o.OrderDetails.TotalPrice, &o.OrderDetails.DeliveryFee, &o.OrderDetails.Discount, &o.OrderDetails.Status,
 				&o.OrderDetails.ModeOfDelivery, &expectedDeliveryDate, &o.OrderDetails.OrderDate,
 				&o.OrderDetails.ProductID, &o.OrderDetails.ProductName,&o.OrderDetails.ProductImg,
 				&o.BuyersDetails.BuyerFirstName, &o.BuyersDetails.BuyerLastName,
@@ -213,7 +481,7 @@ func GetOrdersBasedOnUser(db *sql.DB, userID int, userType string) ([]types.Orde
 		} else if userType == "buyer" {
 			// Scan for buyer-specific data (no buyer details, just the order and product info)
 			err := rows.Scan(
-				&o.OrderDetails.OrderID, &o.OrderDetails.QuantityInKg, &o.OrderDetails.TotalPrice, &o.OrderDetails.Status,
+				&o.OrderDetails.OrderID, &o.OrderDetails.QuantityInKg, &o.OrderDetails.TotalPrice, &o.OrderDetails.DeliveryFee, &o.OrderDetails.Discount, &o.OrderDetails.Status,
 				&o.OrderDetails.ModeOfDelivery, &expectedDeliveryDate, &o.OrderDetails.OrderDate,
 				&o.OrderDetails.ProductID, &o.OrderDetails.ProductName,&o.OrderDetails.ProductImg,
 				&o.SellerDetails.FarmerFirstName, &o.SellerDetails.FarmerLastName, &o.SellerDetails.FarmerPhoneNumber,
@@ -0,0 +1,440 @@
+package organization
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ritu84/agrohub/internal/address"
+	"github.com/ritu84/agrohub/internal/notification"
+	order "github.com/ritu84/agrohub/internal/orders"
+	"github.com/ritu84/agrohub/types"
+	"github.com/labstack/echo/v4"
+)
+
+// gstinPattern is the 15 character GST identification number: state code, PAN, entity number, Z and a check character
+var gstinPattern = regexp.MustCompile(`^[0-9]{2}[A-Z]{5}[0-9]{4}[A-Z][1-9A-Z]Z[0-9A-Z]$`)
+
+// validate trims an organization's details and checks them
+func validate(org *types.Organization) error {
+	org.Name = strings.TrimSpace(org.Name)
+	org.GSTIN = strings.ToUpper(strings.TrimSpace(org.GSTIN))
+	if org.Name == "" {
+		return errors.New("name is required")
+	}
+	if org.GSTIN != "" && !gstinPattern.MatchString(org.GSTIN) {
+		return fmt.Errorf("gstin %q is not a valid GSTIN", org.GSTIN)
+	}
+	if org.ApprovalLimit != nil && *org.ApprovalLimit < 0 {
+		return errors.New("approval_limit can't be negative")
+	}
+	return nil
+}
+
+// member parses the organization in the URL and checks the logged in user holds one of roles in it, any role
+// will do when none are given. It returns the organization and the user.
+func member(c echo.Context, db *sql.DB, roles ...string) (int, int, error) {
+	orgID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, 0, echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing organization id:%v", err))
+	}
+
+	userID, ok := c.Get("user_id").(int)
+	if !ok {
+		return 0, 0, errors.New("user_id not found or invalid type")
+	}
+
+	role, err := GetMemberRoleFromStore(db, orgID, userID)
+	if err != nil {
+		return 0, 0, echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	if len(roles) == 0 {
+		return orgID, userID, nil
+	}
+	for _, r := range roles {
+		if r == role {
+			return orgID, userID, nil
+		}
+	}
+	return 0, 0, echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("a %s can't do this, it needs a %s", role, strings.Join(roles, " or ")))
+}
+
+// CreateOrganization creates an organization owned by the logged in buyer
+func CreateOrganization(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var org types.Organization
+		if err := c.Bind(&org); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if err := validate(&org); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, err.Error())
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+		if c.Get("user_type") != "buyer" {
+			return echo.NewHTTPError(http.StatusForbidden, "only buyers can create an organization")
+		}
+
+		if err := CreateOrganizationInStore(db, &org, userID); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error creating organization: %v", err))
+		}
+
+		return c.JSON(http.StatusCreated, org)
+	}
+}
+
+// GetMyOrganizations lists the organizations the logged in user belongs to, with their role in each
+func GetMyOrganizations(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		organizations, err := GetMyOrganizationsFromStore(db, userID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching organizations: %v", err))
+		}
+		return c.JSON(http.StatusOK, organizations)
+	}
+}
+
+// GetOrganization returns an organization to its members
+func GetOrganization(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orgID, userID, err := member(c, db)
+		if err != nil {
+			return err
+		}
+
+		org, err := GetOrganizationFromStore(db, orgID, userID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+		return c.JSON(http.StatusOK, org)
+	}
+}
+
+// UpdateOrganization lets an owner change the organization's name, GSTIN and approval limit. Leaving approval_limit
+// out means no order needs approval.
+func UpdateOrganization(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orgID, userID, err := member(c, db, RoleOwner)
+		if err != nil {
+			return err
+		}
+
+		var org types.Organization
+		if err := c.Bind(&org); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if err := validate(&org); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, err.Error())
+		}
+		org.ID = orgID
+
+		if err := UpdateOrganizationInStore(db, &org); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error updating organization: %v", err))
+		}
+
+		org, err = GetOrganizationFromStore(db, orgID, userID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, err.Error())
+		}
+		return c.JSON(http.StatusOK, org)
+	}
+}
+
+// GetMembers lists an organization's members to its members
+func GetMembers(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orgID, _, err := member(c, db)
+		if err != nil {
+			return err
+		}
+
+		members, err := GetMembersFromStore(db, orgID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching members: %v", err))
+		}
+		return c.JSON(http.StatusOK, members)
+	}
+}
+
+// AddMember lets an owner add a registered buyer to the organization by email
+func AddMember(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orgID, userID, err := member(c, db, RoleOwner)
+		if err != nil {
+			return err
+		}
+
+		var req types.AddOrganizationMember
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		req.Email = strings.TrimSpace(req.Email)
+		if req.Email == "" {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "email is required")
+		}
+		if !Roles[req.Role] {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "role must be owner, purchaser, approver or viewer")
+		}
+
+		m, err := AddMemberInStore(db, orgID, userID, req)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error adding member: %v", err))
+		}
+
+		org, err := GetOrganizationFromStore(db, orgID, userID)
+		if err == nil {
+			notification.Notify(db, m.UserID, notification.KindOrganization, "You were added to an organization",
+				fmt.Sprintf("You can now act for %s as its %s.", org.Name, m.Role))
+		}
+
+		return c.JSON(http.StatusCreated, m)
+	}
+}
+
+// UpdateMemberRole lets an owner change a member's role
+func UpdateMemberRole(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orgID, _, err := member(c, db, RoleOwner)
+		if err != nil {
+			return err
+		}
+
+		memberID, err := strconv.Atoi(c.Param("userId"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing user id:%v", err))
+		}
+
+		var req types.AddOrganizationMember
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if !Roles[req.Role] {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "role must be owner, purchaser, approver or viewer")
+		}
+
+		if err := UpdateMemberRoleInStore(db, orgID, memberID, req.Role); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error updating member: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "member role updated successfully!"})
+	}
+}
+
+// RemoveMember lets an owner remove a member, or any member leave
+func RemoveMember(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orgID, userID, err := member(c, db)
+		if err != nil {
+			return err
+		}
+
+		memberID, err := strconv.Atoi(c.Param("userId"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing user id:%v", err))
+		}
+		if memberID != userID {
+			if _, _, err := member(c, db, RoleOwner); err != nil {
+				return err
+			}
+		}
+
+		if err := RemoveMemberInStore(db, orgID, memberID); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error removing member: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "member removed successfully!"})
+	}
+}
+
+// CreateAddress lets an owner add a delivery address to the organization's book
+func CreateAddress(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orgID, userID, err := member(c, db, RoleOwner)
+		if err != nil {
+			return err
+		}
+
+		var a types.Address
+		if err := c.Bind(&a); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if err := address.Validate(&a); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, err.Error())
+		}
+		a.UserID = userID
+
+		if err := CreateAddressInStore(db, orgID, &a); err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error creating address: %v", err))
+		}
+
+		return c.JSON(http.StatusCreated, a)
+	}
+}
+
+// GetAddresses lists the organization's addresses to its members
+func GetAddresses(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orgID, _, err := member(c, db)
+		if err != nil {
+			return err
+		}
+
+		addresses, err := GetAddressesFromStore(db, orgID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching addresses: %v", err))
+		}
+		return c.JSON(http.StatusOK, addresses)
+	}
+}
+
+// DeleteAddress lets an owner remove one of the organization's addresses, orders keep their copy of it
+func DeleteAddress(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orgID, _, err := member(c, db, RoleOwner)
+		if err != nil {
+			return err
+		}
+
+		addressID, err := strconv.Atoi(c.Param("addressId"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing address id:%v", err))
+		}
+
+		if err := DeleteAddressInStore(db, orgID, addressID); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error deleting address: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "address deleted successfully!"})
+	}
+}
+
+// CreateOrder lets an owner or purchaser order for the organization, delivered to one of its addresses (the default
+// one when address_id is left out). Orders above the approval limit wait for an approver before the farmer sees them.
+func CreateOrder(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orgID, userID, err := member(c, db, RoleOwner, RolePurchaser)
+		if err != nil {
+			return err
+		}
+
+		var o types.Order
+		if err := c.Bind(&o); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if o.ProductID == 0 {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "product_id is required")
+		}
+		if o.QuantityInKg <= 0 {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "quantity must be greater than 0")
+		}
+
+		addressID := 0
+		if o.AddressID != nil {
+			addressID = *o.AddressID
+		}
+		a, err := GetAddressFromStore(db, orgID, addressID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, err.Error())
+		}
+		order.SnapshotAddress(&o, a)
+		// address_id points into the members' own address books, the organization's address is only copied
+		o.AddressID = nil
+		o.BuyerID = userID
+		o.OrganizationID = &orgID
+
+		if err := order.CreateOrderInStore(db, &o); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error creating new order:%v", err))
+		}
+
+		if o.Status == order.StatusPendingApproval {
+			reviewers, err := GetReviewersFromStore(db, orgID)
+			if err == nil {
+				for _, id := range reviewers {
+					if id == userID {
+						continue
+					}
+					notification.Notify(db, id, notification.KindOrganization, "Order waiting for approval",
+						fmt.Sprintf("Order #%d for Rs %.2f is above your organization's approval limit and needs your approval.", o.ID, o.TotalPrice))
+				}
+			}
+		}
+
+		return c.JSON(http.StatusCreated, o)
+	}
+}
+
+// GetOrders lists the organization's orders to its members, ?status=pending_approval shows the ones waiting for approval
+func GetOrders(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orgID, _, err := member(c, db)
+		if err != nil {
+			return err
+		}
+
+		orders, err := GetOrdersFromStore(db, orgID, c.QueryParam("status"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching orders: %v", err))
+		}
+		return c.JSON(http.StatusOK, orders)
+	}
+}
+
+// reviewOrder returns the handler for an owner's or approver's decision on an order waiting for approval
+func reviewOrder(db *sql.DB, approve bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orgID, userID, err := member(c, db, RoleOwner, RoleApprover)
+		if err != nil {
+			return err
+		}
+
+		orderID, err := strconv.Atoi(c.Param("orderId"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing order id:%v", err))
+		}
+
+		var req types.ReviewOrganizationOrder
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		req.Note = strings.TrimSpace(req.Note)
+		if !approve && req.Note == "" {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "a note is required to reject an order")
+		}
+
+		placedBy, err := ReviewOrderInStore(db, orgID, orderID, userID, approve, req.Note)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error reviewing order: %v", err))
+		}
+
+		if approve {
+			notification.Notify(db, placedBy, notification.KindOrganization, "Order approved",
+				fmt.Sprintf("Order #%d was approved and sent to the farmer. Pay for it in the app to confirm it.", orderID))
+			return c.JSON(http.StatusOK, map[string]string{"message": "order approved successfully!"})
+		}
+		notification.Notify(db, placedBy, notification.KindOrganization, "Order rejected",
+			fmt.Sprintf("Order #%d was rejected: %s", orderID, req.Note))
+		return c.JSON(http.StatusOK, map[string]string{"message": "order rejected successfully!"})
+	}
+}
+
+// ApproveOrder sends an order waiting for approval on to the farmer
+func ApproveOrder(db *sql.DB) echo.HandlerFunc {
+	return reviewOrder(db, true)
+}
+
+// RejectOrder turns down an order waiting for approval and puts its stock back
+func RejectOrder(db *sql.DB) echo.HandlerFunc {
+	return reviewOrder(db, false)
+}
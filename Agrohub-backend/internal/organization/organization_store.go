@@ -0,0 +1,488 @@
+package organization
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ritu84/agrohub/internal/events"
+	order "github.com/ritu84/agrohub/internal/orders"
+	"github.com/ritu84/agrohub/types"
+)
+
+// Member roles. Owners manage the organization, purchasers place its orders, approvers sign off orders above
+// its approval limit and viewers can only look.
+const (
+	RoleOwner     = "owner"
+	RolePurchaser = "purchaser"
+	RoleApprover  = "approver"
+	RoleViewer    = "viewer"
+)
+
+var Roles = map[string]bool{RoleOwner: true, RolePurchaser: true, RoleApprover: true, RoleViewer: true}
+
+const organizationColumns = `o.id, o.name, COALESCE(o.gstin, ''), o.approval_limit, m.role, o.created_at, o.updated_at`
+
+const organizationFrom = `
+	FROM organizations o
+	JOIN organization_members m ON m.organization_id = o.id`
+
+func scanOrganization(row interface{ Scan(...interface{}) error }) (types.Organization, error) {
+	var org types.Organization
+	err := row.Scan(&org.ID, &org.Name, &org.GSTIN, &org.ApprovalLimit, &org.Role, &org.CreatedAt, &org.UpdatedAt)
+	return org, err
+}
+
+// GetMemberRoleFromStore returns the user's role in the organization
+func GetMemberRoleFromStore(db *sql.DB, orgID, userID int) (string, error) {
+	var role string
+	err := db.QueryRow(`SELECT role FROM organization_members WHERE organization_id = $1 AND user_id = $2`, orgID, userID).Scan(&role)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("you are not a member of organization %d", orgID)
+		}
+		return "", fmt.Errorf("error querying organization member: %v", err)
+	}
+	return role, nil
+}
+
+// CreateOrganizationInStore creates an organization with ownerID as its first owner
+func CreateOrganizationInStore(db *sql.DB, org *types.Organization, ownerID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+		INSERT INTO organizations (name, gstin, approval_limit, created_by)
+		VALUES ($1, NULLIF($2, ''), $3, $4)
+		RETURNING id, created_at, updated_at`, org.Name, org.GSTIN, org.ApprovalLimit, ownerID).
+		Scan(&org.ID, &org.CreatedAt, &org.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error inserting organization: %v", err)
+	}
+
+	if _, err := tx.Exec(`INSERT INTO organization_members (organization_id, user_id, role, added_by) VALUES ($1, $2, $3, $2)`,
+		org.ID, ownerID, RoleOwner); err != nil {
+		return fmt.Errorf("error inserting organization member: %v", err)
+	}
+	org.Role = RoleOwner
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// GetOrganizationFromStore returns an organization with the user's role in it, the user must be a member
+func GetOrganizationFromStore(db *sql.DB, orgID, userID int) (types.Organization, error) {
+	org, err := scanOrganization(db.QueryRow(`SELECT `+organizationColumns+organizationFrom+` WHERE o.id = $1 AND m.user_id = $2`, orgID, userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return org, fmt.Errorf("no organization found with ID %d", orgID)
+		}
+		return org, fmt.Errorf("error querying organization: %v", err)
+	}
+	return org, nil
+}
+
+// GetMyOrganizationsFromStore lists the organizations the user belongs to
+func GetMyOrganizationsFromStore(db *sql.DB, userID int) ([]types.Organization, error) {
+	rows, err := db.Query(`SELECT `+organizationColumns+organizationFrom+` WHERE m.user_id = $1 ORDER BY o.name, o.id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying organizations: %v", err)
+	}
+	defer rows.Close()
+
+	organizations := []types.Organization{}
+	for rows.Next() {
+		org, err := scanOrganization(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning organization: %v", err)
+		}
+		organizations = append(organizations, org)
+	}
+	return organizations, rows.Err()
+}
+
+// UpdateOrganizationInStore saves an organization's name, GSTIN and approval limit. Orders already waiting for
+// approval keep waiting if the limit is raised.
+func UpdateOrganizationInStore(db *sql.DB, org *types.Organization) error {
+	err := db.QueryRow(`
+		UPDATE organizations SET name = $1, gstin = NULLIF($2, ''), approval_limit = $3, updated_at = NOW()
+		WHERE id = $4
+		RETURNING created_at, updated_at`, org.Name, org.GSTIN, org.ApprovalLimit, org.ID).
+		Scan(&org.CreatedAt, &org.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("no organization found with ID %d", org.ID)
+		}
+		return fmt.Errorf("error updating organization: %v", err)
+	}
+	return nil
+}
+
+// GetMembersFromStore lists an organization's members, owners first
+func GetMembersFromStore(db *sql.DB, orgID int) ([]types.OrganizationMember, error) {
+	rows, err := db.Query(`
+		SELECT m.user_id, u.first_name, u.last_name, u.email, m.role, m.created_at
+		FROM organization_members m JOIN users u ON u.id = m.user_id
+		WHERE m.organization_id = $1
+		ORDER BY m.role <> 'owner', u.first_name, u.last_name`, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying organization members: %v", err)
+	}
+	defer rows.Close()
+
+	members := []types.OrganizationMember{}
+	for rows.Next() {
+		var m types.OrganizationMember
+		if err := rows.Scan(&m.UserID, &m.FirstName, &m.LastName, &m.Email, &m.Role, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning organization member: %v", err)
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// AddMemberInStore adds the buyer registered with email to the organization
+func AddMemberInStore(db *sql.DB, orgID, addedBy int, req types.AddOrganizationMember) (types.OrganizationMember, error) {
+	m := types.OrganizationMember{Role: req.Role}
+	err := db.QueryRow(`SELECT id, first_name, last_name, email FROM users WHERE LOWER(email) = LOWER($1) AND user_type = 'buyer'`, req.Email).
+		Scan(&m.UserID, &m.FirstName, &m.LastName, &m.Email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return m, fmt.Errorf("no buyer is registered with %s", req.Email)
+		}
+		return m, fmt.Errorf("error querying user: %v", err)
+	}
+
+	err = db.QueryRow(`
+		INSERT INTO organization_members (organization_id, user_id, role, added_by) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (organization_id, user_id) DO NOTHING
+		RETURNING created_at`, orgID, m.UserID, m.Role, addedBy).Scan(&m.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return m, fmt.Errorf("%s is already a member", m.Email)
+		}
+		return m, fmt.Errorf("error inserting organization member: %v", err)
+	}
+	return m, nil
+}
+
+// lockOwnersTx locks the organization and checks that changing userID's membership still leaves it an owner
+func lockOwnersTx(tx *sql.Tx, orgID, userID int) error {
+	if _, err := tx.Exec(`SELECT id FROM organizations WHERE id = $1 FOR UPDATE`, orgID); err != nil {
+		return fmt.Errorf("error locking organization: %v", err)
+	}
+	var others int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM organization_members WHERE organization_id = $1 AND role = 'owner' AND user_id <> $2`,
+		orgID, userID).Scan(&others); err != nil {
+		return fmt.Errorf("error counting owners: %v", err)
+	}
+	if others == 0 {
+		return fmt.Errorf("an organization needs at least one other owner first")
+	}
+	return nil
+}
+
+// UpdateMemberRoleInStore changes a member's role, the last owner can't be demoted
+func UpdateMemberRoleInStore(db *sql.DB, orgID, userID int, role string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if role != RoleOwner {
+		if err := lockOwnersTx(tx, orgID, userID); err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.Exec(`UPDATE organization_members SET role = $1 WHERE organization_id = $2 AND user_id = $3`, role, orgID, userID)
+	if err != nil {
+		return fmt.Errorf("error updating organization member: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("user %d is not a member", userID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// RemoveMemberInStore takes a user out of the organization, the last owner can't leave. Orders they placed stay
+// with the organization.
+func RemoveMemberInStore(db *sql.DB, orgID, userID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockOwnersTx(tx, orgID, userID); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`DELETE FROM organization_members WHERE organization_id = $1 AND user_id = $2`, orgID, userID)
+	if err != nil {
+		return fmt.Errorf("error deleting organization member: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("user %d is not a member", userID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// GetReviewersFromStore returns the members who can approve the organization's orders
+func GetReviewersFromStore(db *sql.DB, orgID int) ([]int, error) {
+	rows, err := db.Query(`SELECT user_id FROM organization_members WHERE organization_id = $1 AND role IN ('owner', 'approver')`, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying organization members: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error scanning organization member: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+const addressColumns = `
+	id, user_id, label, COALESCE(contact_name, ''), COALESCE(phone, ''), address, COALESCE(landmark, ''),
+	city, state, pin_code, is_default, created_at, updated_at`
+
+func scanAddress(row interface{ Scan(...interface{}) error }) (types.Address, error) {
+	var a types.Address
+	err := row.Scan(&a.ID, &a.UserID, &a.Label, &a.ContactName, &a.Phone, &a.Address, &a.Landmark,
+		&a.City, &a.State, &a.PinCode, &a.IsDefault, &a.CreatedAt, &a.UpdatedAt)
+	return a, err
+}
+
+// CreateAddressInStore adds an address to the organization's book. The first address becomes the default.
+func CreateAddressInStore(db *sql.DB, orgID int, a *types.Address) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the organization so two first addresses can't both become the default
+	if _, err := tx.Exec(`SELECT id FROM organizations WHERE id = $1 FOR UPDATE`, orgID); err != nil {
+		return fmt.Errorf("error locking organization: %v", err)
+	}
+
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM organization_addresses WHERE organization_id = $1`, orgID).Scan(&count); err != nil {
+		return fmt.Errorf("error counting addresses: %v", err)
+	}
+	if count == 0 {
+		a.IsDefault = true
+	}
+	if a.IsDefault {
+		if _, err := tx.Exec(`UPDATE organization_addresses SET is_default = false, updated_at = NOW() WHERE organization_id = $1 AND is_default`, orgID); err != nil {
+			return fmt.Errorf("error clearing default address: %v", err)
+		}
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO organization_addresses (organization_id, user_id, label, contact_name, phone, address, landmark, city, state, pin_code, is_default)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, NULLIF($7, ''), $8, $9, $10, $11)
+		RETURNING id, created_at, updated_at`,
+		orgID, a.UserID, a.Label, a.ContactName, a.Phone, a.Address, a.Landmark, a.City, a.State, a.PinCode, a.IsDefault).
+		Scan(&a.ID, &a.CreatedAt, &a.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error inserting address: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// GetAddressesFromStore lists an organization's addresses, the default first
+func GetAddressesFromStore(db *sql.DB, orgID int) ([]types.Address, error) {
+	rows, err := db.Query(`SELECT`+addressColumns+` FROM organization_addresses WHERE organization_id = $1 ORDER BY is_default DESC, created_at, id`, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying addresses: %v", err)
+	}
+	defer rows.Close()
+
+	addresses := []types.Address{}
+	for rows.Next() {
+		a, err := scanAddress(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning address: %v", err)
+		}
+		addresses = append(addresses, a)
+	}
+	return addresses, rows.Err()
+}
+
+// GetAddressFromStore returns one of the organization's addresses, or its default one when addressID is 0
+func GetAddressFromStore(db *sql.DB, orgID, addressID int) (types.Address, error) {
+	query := `SELECT` + addressColumns + ` FROM organization_addresses WHERE organization_id = $1 AND id = $2`
+	args := []interface{}{orgID, addressID}
+	if addressID == 0 {
+		query = `SELECT` + addressColumns + ` FROM organization_addresses WHERE organization_id = $1 AND is_default`
+		args = args[:1]
+	}
+
+	a, err := scanAddress(db.QueryRow(query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			if addressID == 0 {
+				return a, fmt.Errorf("the organization has no saved address, add one first")
+			}
+			return a, fmt.Errorf("no address found with ID %d", addressID)
+		}
+		return a, fmt.Errorf("error querying address: %v", err)
+	}
+	return a, nil
+}
+
+// DeleteAddressInStore removes an address from the organization's book, the oldest remaining one becomes the
+// default if it was the default
+func DeleteAddressInStore(db *sql.DB, orgID, addressID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var wasDefault bool
+	err = tx.QueryRow(`DELETE FROM organization_addresses WHERE id = $1 AND organization_id = $2 RETURNING is_default`, addressID, orgID).Scan(&wasDefault)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("no address found with ID %d", addressID)
+		}
+		return fmt.Errorf("error deleting address: %v", err)
+	}
+
+	if wasDefault {
+		if _, err := tx.Exec(`
+			UPDATE organization_addresses SET is_default = true, updated_at = NOW()
+			WHERE id = (SELECT id FROM organization_addresses WHERE organization_id = $1 ORDER BY created_at, id LIMIT 1)`, orgID); err != nil {
+			return fmt.Errorf("error setting default address: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// GetOrdersFromStore lists the orders placed for an organization, newest first, optionally only those in status
+func GetOrdersFromStore(db *sql.DB, orgID int, status string) ([]types.OrganizationOrder, error) {
+	rows, err := db.Query(`
+		SELECT o.id, o.product_id, p.name, o.quantity_in_kg, o.total_price, o.status, o.buyer_id,
+			u.first_name || ' ' || u.last_name, o.reviewed_by, COALESCE(o.review_note, ''), o.reviewed_at, o.created_at
+		FROM orders o
+		JOIN products p ON p.id = o.product_id
+		JOIN users u ON u.id = o.buyer_id
+		WHERE o.organization_id = $1 AND ($2 = '' OR o.status::text = $2)
+		ORDER BY o.created_at DESC, o.id DESC`, orgID, status)
+	if err != nil {
+		return nil, fmt.Errorf("error querying organization orders: %v", err)
+	}
+	defer rows.Close()
+
+	orders := []types.OrganizationOrder{}
+	for rows.Next() {
+		var o types.OrganizationOrder
+		if err := rows.Scan(&o.OrderID, &o.ProductID, &o.ProductName, &o.QuantityInKg, &o.TotalPrice, &o.Status, &o.PlacedBy,
+			&o.PlacedByName, &o.ReviewedBy, &o.ReviewNote, &o.ReviewedAt, &o.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning organization order: %v", err)
+		}
+		orders = append(orders, o)
+	}
+	return orders, rows.Err()
+}
+
+// ReviewOrderInStore approves or rejects an order of the organization waiting for approval, and returns the member
+// who placed it. Approved orders go on to the farmer as pending, rejected ones put their stock back. Members can't
+// review their own orders, unless they are an owner and nobody else in the organization could.
+func ReviewOrderInStore(db *sql.DB, orgID, orderID, reviewerID int, approve bool, note string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var status string
+	var placedBy, productID, qty int
+	err = tx.QueryRow(`SELECT status, buyer_id, product_id, quantity_in_kg FROM orders WHERE id = $1 AND organization_id = $2 FOR UPDATE`,
+		orderID, orgID).Scan(&status, &placedBy, &productID, &qty)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("no order found with ID %d", orderID)
+		}
+		return 0, fmt.Errorf("error querying order: %v", err)
+	}
+	if status != order.StatusPendingApproval {
+		return 0, fmt.Errorf("order is %s, not waiting for approval", status)
+	}
+	if placedBy == reviewerID {
+		var role string
+		var others bool
+		err := tx.QueryRow(`
+			SELECT m.role, EXISTS (SELECT 1 FROM organization_members x
+				WHERE x.organization_id = m.organization_id AND x.user_id <> m.user_id AND x.role IN ($3, $4))
+			FROM organization_members m
+			WHERE m.organization_id = $1 AND m.user_id = $2`, orgID, reviewerID, RoleOwner, RoleApprover).Scan(&role, &others)
+		if err != nil {
+			return 0, fmt.Errorf("error querying reviewers: %v", err)
+		}
+		if role != RoleOwner || others {
+			return 0, fmt.Errorf("another approver has to review an order you placed")
+		}
+	}
+
+	status = "pending"
+	if !approve {
+		status = order.StatusRejected
+		if err := order.RestoreStockTx(tx, productID, qty); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE orders SET status = $1, reviewed_by = $2, review_note = NULLIF($3, ''), reviewed_at = NOW(), updated_at = NOW()
+		WHERE id = $4`, status, reviewerID, note, orderID); err != nil {
+		return 0, fmt.Errorf("error updating order status: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	// The farmer hears about an approved order as a new one
+	if approve {
+		order.PublishOrderEvent(db, orderID, events.TypeOrderCreated)
+	} else {
+		order.PublishOrderEvent(db, orderID, events.TypeOrderStatus)
+	}
+	return placedBy, nil
+}
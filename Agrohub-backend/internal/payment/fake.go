@@ -0,0 +1,116 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FakeProvider is a gateway that runs entirely in the process, for development and tests.
+// Every intent can be paid and refunded. Like Razorpay's, webhooks are signed with HMAC-SHA256 of the body and
+// checkouts with HMAC-SHA256 of "<intent_id>|<payment_id>", Checkout simulates a buyer paying.
+// Payments captured since the process started can't be refunded beyond what was captured, the fake keeps nothing
+// across restarts so older ones are taken at their word.
+type FakeProvider struct {
+	secret []byte
+	seq    int64
+
+	mu       sync.Mutex
+	refunds  map[string]string // reference -> refund id
+	captured map[string]int64  // payment id -> paise captured
+	refunded map[string]int64  // payment id -> paise refunded
+}
+
+func NewFakeProvider(secret string) *FakeProvider {
+	return &FakeProvider{secret: []byte(secret), refunds: map[string]string{}, captured: map[string]int64{}, refunded: map[string]int64{}}
+}
+
+func (f *FakeProvider) Name() string { return "fake" }
+
+func (f *FakeProvider) next(prefix string) string {
+	return fmt.Sprintf("%s_%d%d", prefix, time.Now().Unix(), atomic.AddInt64(&f.seq, 1))
+}
+
+func (f *FakeProvider) CreateIntent(amountPaise int64, currency, reference string) (Intent, error) {
+	if amountPaise <= 0 {
+		return Intent{}, errors.New("amount must be greater than 0")
+	}
+	id := f.next("fake_pi")
+	return Intent{ID: id, AmountPaise: amountPaise, Currency: currency, ClientSecret: id + "_secret_" + reference}, nil
+}
+
+// Checkout pays an intent the way the buyer would in the gateway's checkout, returning the payment id and signature
+// the app sends to capture it
+func (f *FakeProvider) Checkout(intentID string) (string, string) {
+	paymentID := f.next("fake_pay")
+	return paymentID, f.Sign([]byte(intentID + "|" + paymentID))
+}
+
+func (f *FakeProvider) VerifyCheckout(intentID, paymentID, signature string) error {
+	if !hmac.Equal([]byte(f.Sign([]byte(intentID+"|"+paymentID))), []byte(signature)) {
+		return errors.New("invalid checkout signature")
+	}
+	return nil
+}
+
+func (f *FakeProvider) Capture(intentID, paymentID string, amountPaise int64) (string, error) {
+	if !strings.HasPrefix(intentID, "fake_pi_") {
+		return "", fmt.Errorf("unknown payment intent %s", intentID)
+	}
+	if !strings.HasPrefix(paymentID, "fake_pay_") {
+		return "", fmt.Errorf("unknown payment %s", paymentID)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.captured[paymentID] = amountPaise
+	return paymentID, nil
+}
+
+func (f *FakeProvider) Refund(paymentID string, amountPaise int64, reference string) (string, error) {
+	if !strings.HasPrefix(paymentID, "fake_pay_") {
+		return "", fmt.Errorf("unknown payment %s", paymentID)
+	}
+	if amountPaise <= 0 {
+		return "", errors.New("refund amount must be greater than 0")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if id, ok := f.refunds[reference]; ok {
+		return id, nil
+	}
+	if captured, ok := f.captured[paymentID]; ok && f.refunded[paymentID]+amountPaise > captured {
+		return "", fmt.Errorf("refund of %d paise is more than the %d paise left on payment %s",
+			amountPaise, captured-f.refunded[paymentID], paymentID)
+	}
+	id := f.next("fake_rfnd")
+	f.refunds[reference] = id
+	f.refunded[paymentID] += amountPaise
+	return id, nil
+}
+
+// Sign returns the signature the fake gateway would send with body, use it to simulate webhooks locally
+func (f *FakeProvider) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, f.secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (f *FakeProvider) VerifyWebhook(body []byte, signature string) (WebhookEvent, error) {
+	var ev WebhookEvent
+	if !hmac.Equal([]byte(f.Sign(body)), []byte(signature)) {
+		return ev, errors.New("invalid webhook signature")
+	}
+	if err := json.Unmarshal(body, &ev); err != nil {
+		return ev, fmt.Errorf("invalid webhook body: %v", err)
+	}
+	return ev, nil
+}
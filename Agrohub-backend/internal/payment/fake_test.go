@@ -0,0 +1,110 @@
+package payment
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFakeProviderVerifyWebhook(t *testing.T) {
+	f := NewFakeProvider("secret")
+	body, _ := json.Marshal(WebhookEvent{Type: EventCaptured, IntentID: "fake_pi_1", PaymentID: "fake_pay_1", AmountPaise: 1000})
+
+	tests := []struct {
+		name      string
+		body      []byte
+		signature string
+		wantErr   bool
+	}{
+		{"signed by the provider", body, f.Sign(body), false},
+		{"signed with another secret", body, NewFakeProvider("other").Sign(body), true},
+		{"no signature", body, "", true},
+		{"body changed after signing", append([]byte(`{"x":1,`), body[1:]...), f.Sign(body), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev, err := f.VerifyWebhook(tt.body, tt.signature)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyWebhook() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && ev.PaymentID != "fake_pay_1" {
+				t.Errorf("VerifyWebhook() payment id = %q, want fake_pay_1", ev.PaymentID)
+			}
+		})
+	}
+}
+
+func TestFakeProviderVerifyCheckout(t *testing.T) {
+	f := NewFakeProvider("secret")
+	intent, err := f.CreateIntent(1000, "INR", "order_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paymentID, signature := f.Checkout(intent.ID)
+
+	tests := []struct {
+		name      string
+		intentID  string
+		paymentID string
+		signature string
+		wantErr   bool
+	}{
+		{"checkout of the intent", intent.ID, paymentID, signature, false},
+		{"another intent", "fake_pi_0", paymentID, signature, true},
+		{"another payment", intent.ID, "fake_pay_0", signature, true},
+		{"no signature", intent.ID, paymentID, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := f.VerifyCheckout(tt.intentID, tt.paymentID, tt.signature); (err != nil) != tt.wantErr {
+				t.Errorf("VerifyCheckout() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFakeProviderRefund(t *testing.T) {
+	f := NewFakeProvider("secret")
+	intent, _ := f.CreateIntent(1000, "INR", "order_1")
+	paymentID, _ := f.Checkout(intent.ID)
+	if _, err := f.Capture(intent.ID, paymentID, 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := f.Refund(paymentID, 600, "refund_1")
+	if err != nil {
+		t.Fatalf("Refund() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		paymentID string
+		amount    int64
+		reference string
+		wantID    string // empty for a new refund id
+		wantErr   bool
+	}{
+		{"same reference refunds once", paymentID, 600, "refund_1", first, false},
+		{"more than is left", paymentID, 500, "refund_2", "", true},
+		{"what is left", paymentID, 400, "refund_3", "", false},
+		{"nothing left", paymentID, 1, "refund_4", "", true},
+		{"zero amount", paymentID, 0, "refund_5", "", true},
+		{"unknown payment", "pay_1", 100, "refund_6", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := f.Refund(tt.paymentID, tt.amount, tt.reference)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Refund() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.wantID != "" && id != tt.wantID {
+				t.Errorf("Refund() = %q, want %q", id, tt.wantID)
+			}
+			if tt.wantID == "" && id == first {
+				t.Errorf("Refund() reused refund id %q for a new reference", id)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package payment
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	order "github.com/ritu84/agrohub/internal/orders"
+	"github.com/ritu84/agrohub/types"
+	"github.com/labstack/echo/v4"
+)
+
+// CreatePayment starts paying for the logged in buyer's order, with cash on delivery or through the provider
+func CreatePayment(db *sql.DB, provider PaymentProvider) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orderID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing order id:%v", err))
+		}
+
+		var req types.CreatePayment
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if !Methods[req.Method] {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "method must be one of upi, card, netbanking or cod")
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		p, err := CreatePaymentInStore(db, provider, orderID, userID, req.Method)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error creating payment: %v", err))
+		}
+
+		return c.JSON(http.StatusCreated, p)
+	}
+}
+
+// GetPayment returns the latest payment of an order to its buyer or farmer
+func GetPayment(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orderID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing order id:%v", err))
+		}
+
+		buyerID, farmerID, err := order.GetOrderPartiesFromStore(db, orderID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+		if userID, ok := c.Get("user_id").(int); !ok || (userID != buyerID && userID != farmerID) {
+			return echo.NewHTTPError(http.StatusForbidden, "only the buyer and farmer of an order can see its payment")
+		}
+
+		p, err := GetOrderPaymentFromStore(db, orderID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+
+		return c.JSON(http.StatusOK, p)
+	}
+}
+
+// CapturePayment is called by the app once the buyer completes the provider's checkout, with the payment id and
+// signature the checkout handed it
+func CapturePayment(db *sql.DB, provider PaymentProvider) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orderID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing order id:%v", err))
+		}
+
+		var req types.CapturePayment
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if req.PaymentID == "" || req.Signature == "" {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "payment_id and signature from the checkout are required")
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		p, err := CapturePaymentInStore(db, provider, orderID, userID, req)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error capturing payment: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, p)
+	}
+}
+
+// CreateDeposit starts paying the deposit of the logged in buyer's pre-order through the provider
+func CreateDeposit(db *sql.DB, provider PaymentProvider) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		preOrderID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing pre-order id:%v", err))
+		}
+
+		var req types.CreatePayment
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if !Methods[req.Method] || req.Method == MethodCOD {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "method must be one of upi, card or netbanking")
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		p, err := CreateDepositInStore(db, provider, preOrderID, userID, req.Method)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error creating deposit: %v", err))
+		}
+
+		return c.JSON(http.StatusCreated, p)
+	}
+}
+
+// CaptureDeposit is called by the app once the buyer completes the provider's checkout for a deposit
+func CaptureDeposit(db *sql.DB, provider PaymentProvider) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		preOrderID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing pre-order id:%v", err))
+		}
+
+		var req types.CapturePayment
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if req.PaymentID == "" || req.Signature == "" {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "payment_id and signature from the checkout are required")
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		p, err := CaptureDepositInStore(db, provider, preOrderID, userID, req)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error capturing deposit: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, p)
+	}
+}
+
+// Webhook receives payment events from the provider, signed in the X-Payment-Signature header
+func Webhook(db *sql.DB, provider PaymentProvider) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		body, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to read req body: %v", err))
+		}
+
+		ev, err := provider.VerifyWebhook(body, c.Request().Header.Get("X-Payment-Signature"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		}
+
+		if err := ApplyWebhookInStore(db, ev); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error applying webhook: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "webhook processed successfully!"})
+	}
+}
+
+// RefundPayment lets an admin refund all or part of an order's captured payment
+func RefundPayment(db *sql.DB, provider PaymentProvider) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orderID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing order id:%v", err))
+		}
+
+		var req types.RefundRequest
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if req.Amount < 0 {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "amount must not be negative")
+		}
+
+		p, err := RefundPaymentInStore(db, provider, orderID, req.Amount, req.Reason)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error refunding payment: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, p)
+	}
+}
@@ -0,0 +1,721 @@
+package payment
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ritu84/agrohub/internal/events"
+	"github.com/ritu84/agrohub/internal/ledger"
+	order "github.com/ritu84/agrohub/internal/orders"
+	"github.com/ritu84/agrohub/internal/pricing"
+	"github.com/ritu84/agrohub/internal/scheduler"
+	"github.com/ritu84/agrohub/types"
+)
+
+const (
+	StatusCreated           = "created"
+	StatusCaptured          = "captured"
+	StatusFailed            = "failed"
+	StatusCancelled         = "cancelled"
+	StatusCOD               = "cod"
+	StatusPartiallyRefunded = "partially_refunded"
+	StatusRefunded          = "refunded"
+
+	MethodCOD = "cod"
+
+	RefundPending     = "pending"
+	RefundProcessed   = "processed"
+	RefundOffPlatform = "off_platform" // a cash on delivery refund, paid to the buyer outside the gateway
+)
+
+var Methods = map[string]bool{"upi": true, "card": true, "netbanking": true, MethodCOD: true}
+
+const paymentColumns = `
+	id, COALESCE(order_id, 0), pre_order_id, provider, method, COALESCE(intent_id, ''), COALESCE(provider_payment_id, ''),
+	amount, refunded_amount, currency, status, captured_at, created_at, updated_at`
+
+func scanPayment(row interface{ Scan(...interface{}) error }) (types.Payment, error) {
+	var p types.Payment
+	err := row.Scan(&p.ID, &p.OrderID, &p.PreOrderID, &p.Provider, &p.Method, &p.IntentID, &p.ProviderPaymentID,
+		&p.Amount, &p.RefundedAmount, &p.Currency, &p.Status, &p.CapturedAt, &p.CreatedAt, &p.UpdatedAt)
+	return p, err
+}
+
+// Jobs are the background jobs for payments: refunds the gateway didn't confirm are retried every
+// REFUND_RETRY_INTERVAL (default 10m)
+func Jobs(provider PaymentProvider) []scheduler.Job {
+	return []scheduler.Job{
+		{Name: "retry-refunds", Interval: scheduler.DurationFromEnv("REFUND_RETRY_INTERVAL", 10*time.Minute),
+			Run: func(db *sql.DB) error { return RetryRefunds(db, provider) }},
+	}
+}
+
+// markOrderProcessingTx moves a pending order on once it is paid for or will be paid on delivery
+func markOrderProcessingTx(tx *sql.Tx, orderID int) error {
+	_, err := tx.Exec(`UPDATE orders SET status = 'processing', updated_at = NOW() WHERE id = $1 AND status = 'pending'`, orderID)
+	if err != nil {
+		return fmt.Errorf("error updating order status: %v", err)
+	}
+	return nil
+}
+
+// insertPaymentTx records a new payment of p.Amount, cash on delivery or through an intent with the provider that the
+// buyer completes in its checkout. reference is what the provider knows it by.
+func insertPaymentTx(tx *sql.Tx, provider PaymentProvider, p *types.Payment, reference string) error {
+	p.Provider = provider.Name()
+	p.Currency = "INR"
+	var intentID *string
+	if p.Method == MethodCOD {
+		p.Status = StatusCOD
+	} else {
+		intent, err := provider.CreateIntent(toPaise(p.Amount), p.Currency, reference)
+		if err != nil {
+			return fmt.Errorf("error creating payment intent: %v", err)
+		}
+		p.Status = StatusCreated
+		p.IntentID = intent.ID
+		p.ClientSecret = intent.ClientSecret
+		intentID = &intent.ID
+	}
+
+	err := tx.QueryRow(`
+		INSERT INTO payments (order_id, pre_order_id, provider, method, intent_id, amount, currency, status)
+		VALUES (NULLIF($1, 0), $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at, updated_at`,
+		p.OrderID, p.PreOrderID, p.Provider, p.Method, intentID, p.Amount, p.Currency, p.Status).
+		Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error inserting payment: %v", err)
+	}
+	return nil
+}
+
+// CreatePaymentInStore starts paying for a pending order. Cash on delivery moves the order to processing right away,
+// any other method creates an intent with the provider that the buyer completes in its checkout.
+// An order from a pre-order whose deposit was captured only has the rest left to pay, online like the deposit.
+// An earlier unfinished attempt is cancelled.
+func CreatePaymentInStore(db *sql.DB, provider PaymentProvider, orderID, buyerID int, method string) (types.Payment, error) {
+	var p types.Payment
+
+	tx, err := db.Begin()
+	if err != nil {
+		return p, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var orderBuyerID int
+	var status string
+	var total, paid float64
+	err = tx.QueryRow(`
+		SELECT buyer_id, total_price, status,
+			COALESCE((SELECT SUM(pay.amount - pay.refunded_amount) FROM payments pay
+				WHERE pay.order_id = o.id AND pay.status IN ($2, $3)), 0)
+		FROM orders o WHERE id = $1 FOR UPDATE OF o`, orderID, StatusCaptured, StatusPartiallyRefunded).
+		Scan(&orderBuyerID, &total, &status, &paid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return p, fmt.Errorf("no order found with ID %d", orderID)
+		}
+		return p, fmt.Errorf("error querying order: %v", err)
+	}
+	if orderBuyerID != buyerID {
+		return p, fmt.Errorf("order %d is not yours", orderID)
+	}
+	if status != "pending" {
+		return p, fmt.Errorf("order is already %s", status)
+	}
+	if paid > 0 && method == MethodCOD {
+		return p, fmt.Errorf("the deposit of this order was paid online, the rest can't be paid cash on delivery")
+	}
+
+	if _, err := tx.Exec(`UPDATE payments SET status = $1, updated_at = NOW() WHERE order_id = $2 AND status = $3`,
+		StatusCancelled, orderID, StatusCreated); err != nil {
+		return p, fmt.Errorf("error cancelling earlier payment: %v", err)
+	}
+
+	p.OrderID = orderID
+	p.Method = method
+	p.Amount = pricing.Round(total - paid)
+	if err := insertPaymentTx(tx, provider, &p, fmt.Sprintf("order_%d", orderID)); err != nil {
+		return p, err
+	}
+
+	if p.Status == StatusCOD {
+		if err := markOrderProcessingTx(tx, orderID); err != nil {
+			return p, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return p, fmt.Errorf("error committing transaction: %v", err)
+	}
+	if p.Status == StatusCOD {
+		order.PublishOrderEvent(db, orderID, events.TypeOrderStatus)
+	}
+	return p, nil
+}
+
+// GetOrderPaymentFromStore returns the latest payment of an order
+func GetOrderPaymentFromStore(db *sql.DB, orderID int) (types.Payment, error) {
+	p, err := scanPayment(db.QueryRow(`SELECT`+paymentColumns+` FROM payments WHERE order_id = $1 ORDER BY created_at DESC, id DESC LIMIT 1`, orderID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return p, fmt.Errorf("no payment found for order %d", orderID)
+		}
+		return p, fmt.Errorf("error querying payment: %v", err)
+	}
+	return p, nil
+}
+
+// refundOrderTx marks an order refunded once nothing paid for it is left with us. An order that hasn't left the farm
+// gives back its stock and its scheduled shipment, one already shipped or delivered keeps them. Reports whether the
+// order was marked refunded.
+func refundOrderTx(tx *sql.Tx, orderID int) (bool, error) {
+	var status string
+	var paid bool
+	err := tx.QueryRow(`
+		SELECT o.status, EXISTS (SELECT 1 FROM payments p WHERE p.order_id = o.id AND p.status IN ($2, $3))
+		FROM orders o WHERE o.id = $1 FOR UPDATE OF o`, orderID, StatusCaptured, StatusPartiallyRefunded).Scan(&status, &paid)
+	if err != nil {
+		return false, fmt.Errorf("error querying order: %v", err)
+	}
+	if paid || status == "refunded" {
+		return false, nil
+	}
+
+	switch status {
+	case "pending", "processing", "approved", "pending_approval":
+		if err := order.ReleaseOrderTx(tx, orderID); err != nil {
+			return false, err
+		}
+	}
+	if _, err := tx.Exec(`UPDATE orders SET status = 'refunded', updated_at = NOW() WHERE id = $1`, orderID); err != nil {
+		return false, fmt.Errorf("error updating order status: %v", err)
+	}
+	return true, nil
+}
+
+// CreateDepositInStore starts paying the deposit of a pre-order awaiting one. A deposit is always paid through the
+// provider, the pre-order is only confirmed once it is captured. An earlier unfinished attempt is cancelled.
+func CreateDepositInStore(db *sql.DB, provider PaymentProvider, preOrderID, buyerID int, method string) (types.Payment, error) {
+	var p types.Payment
+	if method == MethodCOD {
+		return p, fmt.Errorf("a deposit can't be paid cash on delivery")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return p, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var preOrderBuyerID int
+	var status string
+	err = tx.QueryRow(`SELECT buyer_id, deposit_amount, status FROM pre_orders WHERE id = $1 FOR UPDATE`, preOrderID).
+		Scan(&preOrderBuyerID, &p.Amount, &status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return p, fmt.Errorf("no pre-order found with ID %d", preOrderID)
+		}
+		return p, fmt.Errorf("error querying pre-order: %v", err)
+	}
+	if preOrderBuyerID != buyerID {
+		return p, fmt.Errorf("pre-order %d is not yours", preOrderID)
+	}
+	if status != "awaiting_deposit" {
+		return p, fmt.Errorf("pre-order is %s, it isn't awaiting a deposit", status)
+	}
+
+	if _, err := tx.Exec(`UPDATE payments SET status = $1, updated_at = NOW() WHERE pre_order_id = $2 AND status = $3`,
+		StatusCancelled, preOrderID, StatusCreated); err != nil {
+		return p, fmt.Errorf("error cancelling earlier payment: %v", err)
+	}
+
+	p.PreOrderID = &preOrderID
+	p.Method = method
+	if err := insertPaymentTx(tx, provider, &p, fmt.Sprintf("pre_order_%d", preOrderID)); err != nil {
+		return p, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return p, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return p, nil
+}
+
+// capturedTx records a captured payment and moves its order to processing. A captured deposit confirms its
+// pre-order's reservation instead, or is refunded when the pre-order was cancelled or its harvest recorded while the
+// buyer was paying.
+func capturedTx(tx *sql.Tx, p *types.Payment, providerPaymentID string) error {
+	p.Status = StatusCaptured
+	p.ProviderPaymentID = providerPaymentID
+	err := tx.QueryRow(`
+		UPDATE payments SET status = $1, provider_payment_id = $2, captured_at = NOW(), updated_at = NOW()
+		WHERE id = $3
+		RETURNING captured_at, updated_at`, p.Status, providerPaymentID, p.ID).Scan(&p.CapturedAt, &p.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error capturing payment: %v", err)
+	}
+
+	if p.OrderID == 0 && p.PreOrderID != nil {
+		if err := ledger.RecordDepositTx(tx, *p.PreOrderID, p.Amount); err != nil {
+			return err
+		}
+		res, err := tx.Exec(`UPDATE pre_orders SET status = 'reserved', updated_at = NOW() WHERE id = $1 AND status = 'awaiting_deposit'`,
+			*p.PreOrderID)
+		if err != nil {
+			return fmt.Errorf("error confirming pre-order: %v", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			// the retry-refunds job sends it back
+			_, err := tx.Exec(`INSERT INTO payment_refunds (payment_id, amount, reason, status) VALUES ($1, $2, $3, $4)`,
+				p.ID, p.Amount, "pre-order is no longer awaiting its deposit", RefundPending)
+			if err != nil {
+				return fmt.Errorf("error recording refund: %v", err)
+			}
+		}
+		return nil
+	}
+
+	if err := ledger.RecordCaptureTx(tx, p.OrderID, p.Amount); err != nil {
+		return err
+	}
+	return markOrderProcessingTx(tx, p.OrderID)
+}
+
+// captureTx collects a payment the buyer has authorised in the provider's checkout. The checkout's signature is
+// checked first, the app's word alone isn't enough to mark anything paid.
+func captureTx(tx *sql.Tx, provider PaymentProvider, p *types.Payment, checkout types.CapturePayment) error {
+	if p.Status != StatusCreated {
+		return fmt.Errorf("payment is already %s", p.Status)
+	}
+	if err := provider.VerifyCheckout(p.IntentID, checkout.PaymentID, checkout.Signature); err != nil {
+		return err
+	}
+
+	providerPaymentID, err := provider.Capture(p.IntentID, checkout.PaymentID, toPaise(p.Amount))
+	if err != nil {
+		return fmt.Errorf("error capturing payment: %v", err)
+	}
+	return capturedTx(tx, p, providerPaymentID)
+}
+
+// CapturePaymentInStore collects the buyer's authorised payment for an order once they are back from checkout
+func CapturePaymentInStore(db *sql.DB, provider PaymentProvider, orderID, buyerID int, checkout types.CapturePayment) (types.Payment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return types.Payment{}, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	p, err := scanPayment(tx.QueryRow(`
+		SELECT`+paymentColumns+` FROM payments
+		WHERE order_id = $1 AND order_id IN (SELECT id FROM orders WHERE buyer_id = $2)
+		ORDER BY created_at DESC, id DESC LIMIT 1 FOR UPDATE`, orderID, buyerID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return p, fmt.Errorf("no payment found for order %d", orderID)
+		}
+		return p, fmt.Errorf("error querying payment: %v", err)
+	}
+	if err := captureTx(tx, provider, &p, checkout); err != nil {
+		return p, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return p, fmt.Errorf("error committing transaction: %v", err)
+	}
+	order.PublishOrderEvent(db, orderID, events.TypeOrderStatus)
+	return p, nil
+}
+
+// CaptureDepositInStore collects the buyer's authorised deposit for a pre-order once they are back from checkout
+func CaptureDepositInStore(db *sql.DB, provider PaymentProvider, preOrderID, buyerID int, checkout types.CapturePayment) (types.Payment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return types.Payment{}, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	p, err := scanPayment(tx.QueryRow(`
+		SELECT`+paymentColumns+` FROM payments
+		WHERE pre_order_id = $1 AND pre_order_id IN (SELECT id FROM pre_orders WHERE buyer_id = $2)
+		ORDER BY created_at DESC, id DESC LIMIT 1 FOR UPDATE`, preOrderID, buyerID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return p, fmt.Errorf("no deposit found for pre-order %d", preOrderID)
+		}
+		return p, fmt.Errorf("error querying payment: %v", err)
+	}
+	if err := captureTx(tx, provider, &p, checkout); err != nil {
+		return p, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return p, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return p, nil
+}
+
+// ApplyDepositTx counts the captured deposit of a pre-order towards the order it was filled as, an order it covers
+// moves to processing. When a short harvest filled less than the deposit paid for, the difference is refunded: the id
+// of that pending refund is returned for ProcessRefundInStore to run after tx commits, 0 when there is none.
+func ApplyDepositTx(tx *sql.Tx, preOrderID, orderID int, total float64) (int, error) {
+	var paymentID int
+	var paid float64
+	err := tx.QueryRow(`
+		UPDATE payments SET order_id = $1, updated_at = NOW()
+		WHERE pre_order_id = $2 AND status = $3
+		RETURNING id, amount`, orderID, preOrderID, StatusCaptured).Scan(&paymentID, &paid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("error applying deposit: %v", err)
+	}
+	if paid < total {
+		return 0, nil
+	}
+
+	if err := markOrderProcessingTx(tx, orderID); err != nil {
+		return 0, err
+	}
+	excess := pricing.Round(paid - total)
+	if excess <= 0 {
+		return 0, nil
+	}
+	var refundID int
+	err = tx.QueryRow(`
+		INSERT INTO payment_refunds (payment_id, amount, reason, status) VALUES ($1, $2, $3, $4)
+		RETURNING id`, paymentID, excess, "deposit above the filled quantity", RefundPending).Scan(&refundID)
+	if err != nil {
+		return 0, fmt.Errorf("error recording refund: %v", err)
+	}
+	return refundID, nil
+}
+
+// ReleaseDepositTx gives up the deposit of a pre-order that won't become an order: an unfinished attempt is cancelled
+// and a captured deposit refunded. The id of the pending refund is returned for ProcessRefundInStore to run after tx
+// commits, 0 when nothing was paid.
+func ReleaseDepositTx(tx *sql.Tx, preOrderID int, reason string) (int, error) {
+	if _, err := tx.Exec(`UPDATE payments SET status = $1, updated_at = NOW() WHERE pre_order_id = $2 AND status = $3`,
+		StatusCancelled, preOrderID, StatusCreated); err != nil {
+		return 0, fmt.Errorf("error cancelling deposit: %v", err)
+	}
+
+	var refundID int
+	err := tx.QueryRow(`
+		INSERT INTO payment_refunds (payment_id, amount, reason, status)
+		SELECT p.id, p.amount, NULLIF($2, ''), $3 FROM payments p
+		WHERE p.pre_order_id = $1 AND p.order_id IS NULL AND p.status = $4
+		RETURNING id`, preOrderID, reason, RefundPending, StatusCaptured).Scan(&refundID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("error recording refund: %v", err)
+	}
+	return refundID, nil
+}
+
+// ApplyWebhookInStore records what the gateway reported about an intent. Gateways retry webhooks,
+// so events for payments that have already moved on are ignored.
+func ApplyWebhookInStore(db *sql.DB, ev WebhookEvent) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	p, err := scanPayment(tx.QueryRow(`SELECT`+paymentColumns+` FROM payments WHERE intent_id = $1 FOR UPDATE`, ev.IntentID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("no payment found for intent %s", ev.IntentID)
+		}
+		return fmt.Errorf("error querying payment: %v", err)
+	}
+	if p.Status != StatusCreated {
+		return nil
+	}
+
+	switch ev.Type {
+	case EventCaptured:
+		if ev.AmountPaise != toPaise(p.Amount) {
+			return fmt.Errorf("captured amount %d does not match payment amount %d", ev.AmountPaise, toPaise(p.Amount))
+		}
+		if err := capturedTx(tx, &p, ev.PaymentID); err != nil {
+			return err
+		}
+	case EventFailed:
+		if _, err := tx.Exec(`UPDATE payments SET status = $1, updated_at = NOW() WHERE id = $2`, StatusFailed, p.ID); err != nil {
+			return fmt.Errorf("error failing payment: %v", err)
+		}
+	default:
+		return nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	if p.Status == StatusCaptured && p.OrderID != 0 {
+		order.PublishOrderEvent(db, p.OrderID, events.TypeOrderStatus)
+	}
+	return nil
+}
+
+// RequestRefundTx records pending refunds of amount of an order's captured payments, or whatever is left of them when
+// amount is 0, and returns their ids and the amount. An order from a pre-order can have been paid with a deposit and
+// then the rest, the latest payment is refunded first. Nothing is sent to the gateway until ProcessRefundInStore runs
+// after tx commits.
+func RequestRefundTx(tx *sql.Tx, orderID int, amount float64, reason string) ([]int, float64, error) {
+	rows, err := tx.Query(`
+		SELECT p.id, p.amount - p.refunded_amount
+			- COALESCE((SELECT SUM(r.amount) FROM payment_refunds r WHERE r.payment_id = p.id AND r.status = $4), 0)
+		FROM payments p
+		WHERE p.order_id = $1 AND p.status IN ($2, $3)
+		ORDER BY p.id DESC
+		FOR UPDATE OF p`, orderID, StatusCaptured, StatusPartiallyRefunded, RefundPending)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error querying payment: %v", err)
+	}
+	var paymentIDs []int
+	var lefts []float64
+	var left float64
+	for rows.Next() {
+		var id int
+		var l float64
+		if err := rows.Scan(&id, &l); err != nil {
+			rows.Close()
+			return nil, 0, fmt.Errorf("error scanning payment: %v", err)
+		}
+		paymentIDs = append(paymentIDs, id)
+		lefts = append(lefts, pricing.Round(l))
+		left += l
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error querying payment: %v", err)
+	}
+	if len(paymentIDs) == 0 {
+		return nil, 0, fmt.Errorf("order %d has no captured payment to refund", orderID)
+	}
+
+	left = pricing.Round(left)
+	if amount == 0 {
+		amount = left
+	}
+	if amount <= 0 || amount > left {
+		return nil, 0, fmt.Errorf("refund must be between 0 and %.2f", left)
+	}
+
+	var refundIDs []int
+	rest := amount
+	for i, paymentID := range paymentIDs {
+		part := min(rest, lefts[i])
+		if part <= 0 {
+			continue
+		}
+		var refundID int
+		err := tx.QueryRow(`
+			INSERT INTO payment_refunds (payment_id, amount, reason, status) VALUES ($1, $2, NULLIF($3, ''), $4)
+			RETURNING id`, paymentID, part, reason, RefundPending).Scan(&refundID)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error recording refund: %v", err)
+		}
+		refundIDs = append(refundIDs, refundID)
+		rest = pricing.Round(rest - part)
+	}
+	return refundIDs, amount, nil
+}
+
+// RefundOffPlatformTx refunds amount of a cash on delivery order, or whatever is left of it when amount is 0, and
+// returns the amount. The buyer paid the farmer in cash so nothing goes through the gateway: the refund is recorded as
+// paid outside the platform and taken back from the farmer in the ledger. A fully refunded order is marked refunded,
+// see refundOrderTx.
+func RefundOffPlatformTx(tx *sql.Tx, orderID int, amount float64, reason string) (float64, error) {
+	var p types.Payment
+	err := tx.QueryRow(`
+		SELECT id, amount, refunded_amount FROM payments
+		WHERE order_id = $1 AND status = $2
+		FOR UPDATE`, orderID, StatusCOD).Scan(&p.ID, &p.Amount, &p.RefundedAmount)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("order %d wasn't paid cash on delivery", orderID)
+		}
+		return 0, fmt.Errorf("error querying payment: %v", err)
+	}
+
+	left := pricing.Round(p.Amount - p.RefundedAmount)
+	if amount == 0 {
+		amount = left
+	}
+	if amount <= 0 || amount > left {
+		return 0, fmt.Errorf("refund must be between 0 and %.2f", left)
+	}
+
+	_, err = tx.Exec(`INSERT INTO payment_refunds (payment_id, amount, reason, status) VALUES ($1, $2, NULLIF($3, ''), $4)`,
+		p.ID, amount, reason, RefundOffPlatform)
+	if err != nil {
+		return 0, fmt.Errorf("error recording refund: %v", err)
+	}
+	if err := ledger.RecordOffPlatformRefundTx(tx, orderID, amount); err != nil {
+		return 0, err
+	}
+
+	// the payment stays cod, it only counts what has been given back
+	p.RefundedAmount = pricing.Round(p.RefundedAmount + amount)
+	if _, err := tx.Exec(`UPDATE payments SET refunded_amount = $1, updated_at = NOW() WHERE id = $2`, p.RefundedAmount, p.ID); err != nil {
+		return 0, fmt.Errorf("error updating payment: %v", err)
+	}
+	if p.RefundedAmount >= p.Amount {
+		if _, err := refundOrderTx(tx, orderID); err != nil {
+			return 0, err
+		}
+	}
+	return amount, nil
+}
+
+// ProcessRefundInStore sends a pending refund to the gateway and then books it: the ledger, the payment's refunded
+// amount and, once all of its payments are fully refunded, the order (see refundOrderTx). A deposit refunded before it became part of an
+// order only touches the ledger and the payment. The refund's id is the gateway's idempotency reference,
+// so it is safe to run again for a refund whose outcome isn't known. An already processed refund is left alone.
+func ProcessRefundInStore(db *sql.DB, provider PaymentProvider, refundID int) (types.Payment, error) {
+	var providerPaymentID, status string
+	var amount float64
+	err := db.QueryRow(`
+		SELECT COALESCE(p.provider_payment_id, ''), r.amount, r.status
+		FROM payment_refunds r
+		JOIN payments p ON p.id = r.payment_id
+		WHERE r.id = $1`, refundID).Scan(&providerPaymentID, &amount, &status)
+	if err != nil {
+		return types.Payment{}, fmt.Errorf("error querying refund: %v", err)
+	}
+
+	var providerRefundID string
+	if status == RefundPending {
+		// the gateway is called outside any transaction so no row stays locked while we wait on it
+		providerRefundID, err = provider.Refund(providerPaymentID, toPaise(amount), fmt.Sprintf("refund_%d", refundID))
+		if err != nil {
+			return types.Payment{}, fmt.Errorf("error refunding payment: %v", err)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return types.Payment{}, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	p, err := scanPayment(tx.QueryRow(`
+		SELECT`+paymentColumns+` FROM payments
+		WHERE id = (SELECT payment_id FROM payment_refunds WHERE id = $1)
+		FOR UPDATE`, refundID))
+	if err != nil {
+		return p, fmt.Errorf("error querying payment: %v", err)
+	}
+
+	// a retry running at the same time may have booked it already
+	res, err := tx.Exec(`UPDATE payment_refunds SET status = $1, provider_refund_id = $2 WHERE id = $3 AND status = $4`,
+		RefundProcessed, providerRefundID, refundID, RefundPending)
+	if err != nil {
+		return p, fmt.Errorf("error updating refund: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return p, nil
+	}
+
+	if p.OrderID == 0 && p.PreOrderID != nil {
+		err = ledger.RecordDepositRefundTx(tx, *p.PreOrderID, amount)
+	} else {
+		err = ledger.RecordRefundTx(tx, p.OrderID, amount)
+	}
+	if err != nil {
+		return p, err
+	}
+
+	p.RefundedAmount = pricing.Round(p.RefundedAmount + amount)
+	p.Status = StatusPartiallyRefunded
+	if p.RefundedAmount >= p.Amount {
+		p.Status = StatusRefunded
+	}
+	err = tx.QueryRow(`UPDATE payments SET refunded_amount = $1, status = $2, updated_at = NOW() WHERE id = $3 RETURNING updated_at`,
+		p.RefundedAmount, p.Status, p.ID).Scan(&p.UpdatedAt)
+	if err != nil {
+		return p, fmt.Errorf("error updating payment: %v", err)
+	}
+
+	orderRefunded := false
+	if p.Status == StatusRefunded && p.OrderID != 0 {
+		if orderRefunded, err = refundOrderTx(tx, p.OrderID); err != nil {
+			return p, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return p, fmt.Errorf("error committing transaction: %v", err)
+	}
+	if orderRefunded {
+		order.PublishOrderEvent(db, p.OrderID, events.TypeOrderStatus)
+	}
+	return p, nil
+}
+
+// RefundPaymentInStore refunds amount of an order's captured payments, or whatever is left of them when amount is 0,
+// and returns the last payment refunded. A fully refunded order is marked refunded. A refund the gateway doesn't confirm stays pending and is retried by
+// the retry-refunds job.
+func RefundPaymentInStore(db *sql.DB, provider PaymentProvider, orderID int, amount float64, reason string) (types.Payment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return types.Payment{}, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	refundIDs, _, err := RequestRefundTx(tx, orderID, amount, reason)
+	if err != nil {
+		return types.Payment{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return types.Payment{}, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	var p types.Payment
+	for _, refundID := range refundIDs {
+		p, err = ProcessRefundInStore(db, provider, refundID)
+		if err != nil {
+			return p, fmt.Errorf("refund #%d is pending and will be retried: %v", refundID, err)
+		}
+	}
+	return p, nil
+}
+
+// RetryRefunds processes every refund still pending, oldest first
+func RetryRefunds(db *sql.DB, provider PaymentProvider) error {
+	rows, err := db.Query(`SELECT id FROM payment_refunds WHERE status = $1 ORDER BY id`, RefundPending)
+	if err != nil {
+		return fmt.Errorf("error querying pending refunds: %v", err)
+	}
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning refund: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error querying pending refunds: %v", err)
+	}
+
+	for _, id := range ids {
+		// one refund the gateway keeps failing shouldn't hold up the rest
+		if _, err := ProcessRefundInStore(db, provider, id); err != nil {
+			log.Printf("payment: refund %d: %v", id, err)
+		}
+	}
+	return nil
+}
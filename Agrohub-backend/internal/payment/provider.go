@@ -0,0 +1,70 @@
+package payment
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+)
+
+// Amounts are passed to providers in paise so no rounding happens on the gateway side
+type Intent struct {
+	ID           string `json:"id"`
+	AmountPaise  int64  `json:"amount_paise"`
+	Currency     string `json:"currency"`
+	ClientSecret string `json:"client_secret"` // handed to the app to open the gateway's checkout
+}
+
+// WebhookEvent is a verified notification from the gateway
+type WebhookEvent struct {
+	Type        string `json:"event"`
+	IntentID    string `json:"intent_id"`
+	PaymentID   string `json:"payment_id"`
+	AmountPaise int64  `json:"amount_paise"`
+}
+
+const (
+	EventCaptured = "payment.captured"
+	EventFailed   = "payment.failed"
+)
+
+// PaymentProvider is a payment gateway such as UPI or Razorpay
+type PaymentProvider interface {
+	Name() string
+	// CreateIntent registers a payment the buyer can then complete in the gateway's checkout, reference is our order
+	CreateIntent(amountPaise int64, currency, reference string) (Intent, error)
+	// VerifyCheckout checks the signature the gateway's checkout hands the app with the payment id once the buyer has
+	// paid an intent, so a capture can't be asked for without the gateway having seen a payment
+	VerifyCheckout(intentID, paymentID, signature string) error
+	// Capture collects a payment the buyer has authorised in checkout and returns the gateway's payment id
+	Capture(intentID, paymentID string, amountPaise int64) (string, error)
+	// Refund returns amountPaise of a captured payment and returns the gateway's refund id. Calls with the same
+	// reference refund only once, so a refund can be retried when we don't know whether it went through.
+	Refund(paymentID string, amountPaise int64, reference string) (string, error)
+	// VerifyWebhook checks the signature of a webhook body and parses it
+	VerifyWebhook(body []byte, signature string) (WebhookEvent, error)
+}
+
+// ProviderFromEnv picks the gateway named by PAYMENT_PROVIDER, only the local fake is available for now. It has to be
+// named explicitly: the fake captures any intent without money moving, so it must never be picked by default.
+// Its checkouts and webhooks are signed with PAYMENT_WEBHOOK_SECRET, or a development secret when that isn't set.
+func ProviderFromEnv() (PaymentProvider, error) {
+	switch name := os.Getenv("PAYMENT_PROVIDER"); name {
+	case "fake":
+		secret := os.Getenv("PAYMENT_WEBHOOK_SECRET")
+		if secret == "" {
+			log.Printf("payment: PAYMENT_WEBHOOK_SECRET is not set, signing fake provider webhooks with a development secret")
+			secret = "dev-secret"
+		}
+		log.Printf("payment: using the fake provider, no money is collected")
+		return NewFakeProvider(secret), nil
+	case "":
+		return nil, fmt.Errorf("PAYMENT_PROVIDER must be set, use PAYMENT_PROVIDER=fake for local development")
+	default:
+		return nil, fmt.Errorf("unknown PAYMENT_PROVIDER=%q", name)
+	}
+}
+
+func toPaise(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
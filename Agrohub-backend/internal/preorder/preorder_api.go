@@ -0,0 +1,242 @@
+package preorder
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ritu84/agrohub/internal/notification"
+	"github.com/ritu84/agrohub/internal/payment"
+	"github.com/ritu84/agrohub/internal/product"
+	"github.com/ritu84/agrohub/types"
+	"github.com/labstack/echo/v4"
+)
+
+// DeclareHarvest lets a farmer open a projected harvest of one of their products for pre-orders
+func DeclareHarvest(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ProductID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing product id :%v", err))
+		}
+
+		var h types.Harvest
+		if err := c.Bind(&h); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing harvest request :%v", err))
+		}
+		h.ProductID = ProductID
+
+		if h.ProjectedQtyKg <= 0 {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "projected_qty_kg must be greater than 0")
+		}
+		if h.ProjectedDate.Before(time.Now().Truncate(24 * time.Hour)) {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "projected_date must not be in the past")
+		}
+		if h.DepositPercent < 0 || h.DepositPercent > 100 {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "deposit_percent must be between 0 and 100")
+		}
+
+		p, err := product.GetProductFromStore(db, ProductID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, fmt.Sprintf("unable to fetch the product from store :%v", err))
+		}
+		if !product.ListedBy(c, p) {
+			return echo.NewHTTPError(http.StatusForbidden, "only the farmer who listed the product can declare its harvest")
+		}
+		h.FarmerID = p.FarmerID
+
+		if err := CreateHarvestInStore(db, &h); err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error declaring harvest :%v", err))
+		}
+
+		return c.JSON(http.StatusCreated, h)
+	}
+}
+
+func ListHarvests(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ProductID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing product id :%v", err))
+		}
+
+		res, err := GetProductHarvestsFromStore(db, ProductID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching harvests :%v", err))
+		}
+
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+// CreatePreOrder reserves quantity of a harvest for the logged in buyer at today's rate. When the harvest takes a
+// deposit the pre-order awaits it, the buyer pays it with POST /pre-orders/:id/deposit.
+func CreatePreOrder(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		harvestID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing harvest id :%v", err))
+		}
+
+		var po types.PreOrder
+		if err := c.Bind(&po); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+		po.BuyerID = userID
+		po.HarvestID = harvestID
+
+		if po.QuantityInKg <= 0 {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "quantity must be greater than 0")
+		}
+		if po.DeliveryAddress == "" || po.DeliveryCity == "" {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "delivery address and city are required")
+		}
+
+		h, err := GetHarvestFromStore(db, harvestID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+		if h.FarmerID == userID {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "you can't pre-order your own harvest")
+		}
+		po.ProductID = h.ProductID
+
+		p, err := product.GetProductFromStore(db, h.ProductID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, fmt.Sprintf("unable to fetch the product from store :%v", err))
+		}
+
+		if err := CreatePreOrderInStore(db, &po, p); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error creating pre-order :%v", err))
+		}
+
+		return c.JSON(http.StatusCreated, po)
+	}
+}
+
+// GetMyPreOrders lists the logged in buyer's pre-orders
+func GetMyPreOrders(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		res, err := GetBuyerPreOrdersFromStore(db, userID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching pre-orders :%v", err))
+		}
+
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+// GetHarvestPreOrders lists the pre-orders of a harvest for the farmer who declared it
+func GetHarvestPreOrders(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		harvestID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing harvest id :%v", err))
+		}
+
+		h, err := GetHarvestFromStore(db, harvestID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+		if userID, ok := c.Get("user_id").(int); !ok || userID != h.FarmerID {
+			return echo.NewHTTPError(http.StatusForbidden, "only the farmer who declared the harvest can see its pre-orders")
+		}
+
+		res, err := GetHarvestPreOrdersFromStore(db, harvestID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching pre-orders :%v", err))
+		}
+
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+func CancelPreOrder(db *sql.DB, provider payment.PaymentProvider) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		preOrderID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing pre-order id :%v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		if err := CancelPreOrderInStore(db, provider, preOrderID, userID); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error cancelling pre-order :%v", err))
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "pre-order cancelled successfully!"})
+	}
+}
+
+// RecordHarvest records the actual harvest, fills pre-orders and tells every buyer how much they got
+func RecordHarvest(db *sql.DB, provider payment.PaymentProvider) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		harvestID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing harvest id :%v", err))
+		}
+
+		var req types.RecordHarvest
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing harvest request :%v", err))
+		}
+		if req.ActualQtyKg < 0 {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "actual_qty_kg must not be negative")
+		}
+
+		h, err := GetHarvestFromStore(db, harvestID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+		if userID, ok := c.Get("user_id").(int); !ok || userID != h.FarmerID {
+			return echo.NewHTTPError(http.StatusForbidden, "only the farmer who declared the harvest can record it")
+		}
+
+		preOrders, err := RecordHarvestInStore(db, provider, harvestID, req.ActualQtyKg)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error recording harvest :%v", err))
+		}
+
+		for _, po := range preOrders {
+			notifyBuyer(db, po)
+		}
+
+		return c.JSON(http.StatusOK, preOrders)
+	}
+}
+
+func notifyBuyer(db *sql.DB, po types.PreOrder) {
+	var title, body string
+	switch po.Status {
+	case StatusConfirmed:
+		title = "Your pre-order is confirmed"
+		body = fmt.Sprintf("The harvest is in and your %d kg pre-order is confirmed as order #%d.", po.QuantityInKg, *po.OrderID)
+	case StatusPartiallyFilled:
+		title = "Your pre-order was partially filled"
+		body = fmt.Sprintf("The harvest fell short, you will receive %d kg of the %d kg you pre-ordered (order #%d).",
+			po.AllocatedKg, po.QuantityInKg, *po.OrderID)
+	default:
+		title = "Your pre-order could not be filled"
+		body = fmt.Sprintf("The harvest fell short and none of your %d kg pre-order could be filled.", po.QuantityInKg)
+		if po.DepositAmount > 0 {
+			body += fmt.Sprintf(" Your deposit of Rs %.2f is being refunded.", po.DepositAmount)
+		}
+	}
+	notification.Notify(db, po.BuyerID, notification.KindPreOrderUpdate, title, body)
+}
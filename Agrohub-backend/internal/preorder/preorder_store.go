@@ -0,0 +1,466 @@
+package preorder
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ritu84/agrohub/internal/events"
+	order "github.com/ritu84/agrohub/internal/orders"
+	"github.com/ritu84/agrohub/internal/payment"
+	"github.com/ritu84/agrohub/internal/pricing"
+	"github.com/ritu84/agrohub/internal/scheduler"
+	"github.com/ritu84/agrohub/types"
+)
+
+// Harvest statuses
+const (
+	HarvestOpen      = "open"
+	HarvestRecorded  = "recorded"
+	HarvestCancelled = "cancelled"
+)
+
+// Pre-order statuses. A pre-order with a deposit awaits it and is only reserved once the deposit is captured, a
+// reserved pre-order ends up confirmed, partially filled or unfilled when the harvest is recorded.
+const (
+	StatusAwaitingDeposit = "awaiting_deposit"
+	StatusReserved        = "reserved"
+	StatusConfirmed       = "confirmed"
+	StatusPartiallyFilled = "partially_filled"
+	StatusUnfilled        = "unfilled"
+	StatusCancelled       = "cancelled"
+)
+
+const harvestColumns = `
+	h.id, h.product_id, p.farmer_id, h.projected_qty_kg, h.projected_date, h.deposit_percent,
+	COALESCE((SELECT SUM(po.quantity_in_kg) FROM pre_orders po WHERE po.harvest_id = h.id AND po.status IN ('reserved', 'awaiting_deposit')), 0),
+	h.actual_qty_kg, h.status, h.recorded_at, h.created_at`
+
+const preOrderColumns = `
+	id, harvest_id, product_id, buyer_id, quantity_in_kg, allocated_kg, rate_per_kg, total_price, deposit_amount,
+	status, order_id, COALESCE(mode_of_delivery, ''), delivery_address, delivery_city, delivery_address_zip,
+	buyers_phone_number, created_at, updated_at`
+
+// Jobs are the background jobs for pre-orders: a pre-order whose deposit isn't captured within PREORDER_DEPOSIT_WINDOW
+// (default 30m) is cancelled so it stops holding back the harvest, checked every PREORDER_JOBS_INTERVAL (default 5m)
+func Jobs() []scheduler.Job {
+	window := scheduler.DurationFromEnv("PREORDER_DEPOSIT_WINDOW", 30*time.Minute)
+	return []scheduler.Job{
+		{Name: "expire-pre-order-deposits", Interval: scheduler.DurationFromEnv("PREORDER_JOBS_INTERVAL", 5*time.Minute),
+			Run: func(db *sql.DB) error { return ExpireDepositsInStore(db, window) }},
+	}
+}
+
+func scanHarvest(row interface{ Scan(...interface{}) error }) (types.Harvest, error) {
+	var h types.Harvest
+	err := row.Scan(&h.ID, &h.ProductID, &h.FarmerID, &h.ProjectedQtyKg, &h.ProjectedDate, &h.DepositPercent,
+		&h.ReservedQtyKg, &h.ActualQtyKg, &h.Status, &h.RecordedAt, &h.CreatedAt)
+	return h, err
+}
+
+func scanPreOrder(row interface{ Scan(...interface{}) error }) (types.PreOrder, error) {
+	var po types.PreOrder
+	err := row.Scan(&po.ID, &po.HarvestID, &po.ProductID, &po.BuyerID, &po.QuantityInKg, &po.AllocatedKg, &po.RatePerKg,
+		&po.TotalPrice, &po.DepositAmount, &po.Status, &po.OrderID, &po.ModeOfDelivery, &po.DeliveryAddress,
+		&po.DeliveryCity, &po.DeliveryAddressZIP, &po.BuyersPhoneNumber, &po.CreatedAt, &po.UpdatedAt)
+	return po, err
+}
+
+func CreateHarvestInStore(db *sql.DB, h *types.Harvest) error {
+	q := `
+	INSERT INTO harvests (product_id, projected_qty_kg, projected_date, deposit_percent)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, status, created_at;`
+
+	if err := db.QueryRow(q, h.ProductID, h.ProjectedQtyKg, h.ProjectedDate, h.DepositPercent).
+		Scan(&h.ID, &h.Status, &h.CreatedAt); err != nil {
+		return fmt.Errorf("error inserting harvest: %v", err)
+	}
+	return nil
+}
+
+func GetHarvestFromStore(db *sql.DB, harvestID int) (types.Harvest, error) {
+	q := `SELECT` + harvestColumns + `
+	FROM harvests h
+	JOIN products p ON h.product_id = p.id
+	WHERE h.id = $1;`
+
+	h, err := scanHarvest(db.QueryRow(q, harvestID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return h, fmt.Errorf("no harvest found with ID %d", harvestID)
+		}
+		return h, fmt.Errorf("error querying harvest: %v", err)
+	}
+	return h, nil
+}
+
+func GetProductHarvestsFromStore(db *sql.DB, productID int) ([]types.Harvest, error) {
+	q := `SELECT` + harvestColumns + `
+	FROM harvests h
+	JOIN products p ON h.product_id = p.id
+	WHERE h.product_id = $1
+	ORDER BY h.projected_date;`
+
+	rows, err := db.Query(q, productID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch harvests: %v", err)
+	}
+	defer rows.Close()
+
+	var harvests []types.Harvest
+	for rows.Next() {
+		h, err := scanHarvest(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan harvest: %v", err)
+		}
+		harvests = append(harvests, h)
+	}
+	return harvests, nil
+}
+
+// CreatePreOrderInStore reserves quantity against an open harvest. The harvest row is locked so
+// concurrent pre-orders can't reserve more than the projected quantity. A pre-order with a deposit holds its quantity
+// while it awaits the deposit.
+func CreatePreOrderInStore(db *sql.DB, po *types.PreOrder, p types.Product) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var projected int
+	var status string
+	var depositPercent float64
+	err = tx.QueryRow(`SELECT projected_qty_kg, status, deposit_percent FROM harvests WHERE id = $1 AND product_id = $2 FOR UPDATE`,
+		po.HarvestID, po.ProductID).Scan(&projected, &status, &depositPercent)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("no harvest found with ID %d", po.HarvestID)
+		}
+		return fmt.Errorf("error querying harvest: %v", err)
+	}
+	if status != HarvestOpen {
+		return fmt.Errorf("harvest is no longer taking pre-orders")
+	}
+
+	var reserved int
+	err = tx.QueryRow(`SELECT COALESCE(SUM(quantity_in_kg), 0) FROM pre_orders WHERE harvest_id = $1 AND status IN ($2, $3)`,
+		po.HarvestID, StatusReserved, StatusAwaitingDeposit).Scan(&reserved)
+	if err != nil {
+		return fmt.Errorf("error summing reservations: %v", err)
+	}
+	if reserved+po.QuantityInKg > projected {
+		return fmt.Errorf("only %d kg of this harvest is left to pre-order", projected-reserved)
+	}
+
+	quote, err := pricing.Calculate(p, po.QuantityInKg)
+	if err != nil {
+		return err
+	}
+	po.RatePerKg = quote.RatePerKg
+	po.TotalPrice = quote.TotalPrice
+	po.DepositAmount = pricing.Round(quote.TotalPrice * depositPercent / 100)
+	po.Status = StatusReserved
+	if po.DepositAmount > 0 {
+		po.Status = StatusAwaitingDeposit
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO pre_orders (harvest_id, product_id, buyer_id, quantity_in_kg, rate_per_kg, total_price, deposit_amount,
+			status, buyers_phone_number, mode_of_delivery, delivery_address, delivery_city, delivery_address_zip)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING id, created_at, updated_at`,
+		po.HarvestID, po.ProductID, po.BuyerID, po.QuantityInKg, po.RatePerKg, po.TotalPrice, po.DepositAmount,
+		po.Status, po.BuyersPhoneNumber, po.ModeOfDelivery, po.DeliveryAddress, po.DeliveryCity, po.DeliveryAddressZIP).
+		Scan(&po.ID, &po.CreatedAt, &po.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error inserting pre-order: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+func GetPreOrderFromStore(db *sql.DB, preOrderID int) (types.PreOrder, error) {
+	po, err := scanPreOrder(db.QueryRow(`SELECT`+preOrderColumns+` FROM pre_orders WHERE id = $1`, preOrderID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return po, fmt.Errorf("no pre-order found with ID %d", preOrderID)
+		}
+		return po, fmt.Errorf("error querying pre-order: %v", err)
+	}
+	return po, nil
+}
+
+func GetBuyerPreOrdersFromStore(db *sql.DB, buyerID int) ([]types.PreOrder, error) {
+	return queryPreOrders(db, `SELECT`+preOrderColumns+` FROM pre_orders WHERE buyer_id = $1 ORDER BY created_at DESC`, buyerID)
+}
+
+func GetHarvestPreOrdersFromStore(db *sql.DB, harvestID int) ([]types.PreOrder, error) {
+	return queryPreOrders(db, `SELECT`+preOrderColumns+` FROM pre_orders WHERE harvest_id = $1 ORDER BY created_at`, harvestID)
+}
+
+func queryPreOrders(db *sql.DB, q string, args ...interface{}) ([]types.PreOrder, error) {
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch pre-orders: %v", err)
+	}
+	defer rows.Close()
+
+	var preOrders []types.PreOrder
+	for rows.Next() {
+		po, err := scanPreOrder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan pre-order: %v", err)
+		}
+		preOrders = append(preOrders, po)
+	}
+	return preOrders, nil
+}
+
+// CancelPreOrderInStore lets a buyer drop a reservation while the harvest is still open, a captured deposit is
+// refunded
+func CancelPreOrderInStore(db *sql.DB, provider payment.PaymentProvider, preOrderID, buyerID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	q := `
+	UPDATE pre_orders po
+	SET status = $1, updated_at = NOW()
+	FROM harvests h
+	WHERE po.harvest_id = h.id AND po.id = $2 AND po.buyer_id = $3 AND po.status IN ($4, $5) AND h.status = $6;`
+
+	result, err := tx.Exec(q, StatusCancelled, preOrderID, buyerID, StatusReserved, StatusAwaitingDeposit, HarvestOpen)
+	if err != nil {
+		return fmt.Errorf("error cancelling pre-order: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("no open pre-order found with ID %d", preOrderID)
+	}
+
+	refundID, err := payment.ReleaseDepositTx(tx, preOrderID, "pre-order cancelled")
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	processRefunds(db, provider, []int{refundID})
+	return nil
+}
+
+// processRefunds sends deposit refunds to the gateway once they are committed, one that fails stays pending for the
+// retry-refunds job
+func processRefunds(db *sql.DB, provider payment.PaymentProvider, refundIDs []int) {
+	for _, refundID := range refundIDs {
+		if refundID == 0 {
+			continue
+		}
+		if _, err := payment.ProcessRefundInStore(db, provider, refundID); err != nil {
+			log.Printf("preorder: refund %d is pending and will be retried: %v", refundID, err)
+		}
+	}
+}
+
+// ExpireDepositsInStore cancels the pre-orders that have been awaiting their deposit for longer than window
+func ExpireDepositsInStore(db *sql.DB, window time.Duration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		UPDATE pre_orders SET status = $1, updated_at = NOW()
+		WHERE status = $2 AND created_at < $3
+		RETURNING id`, StatusCancelled, StatusAwaitingDeposit, time.Now().Add(-window))
+	if err != nil {
+		return fmt.Errorf("error expiring pre-orders: %v", err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning pre-order: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error expiring pre-orders: %v", err)
+	}
+
+	for _, id := range ids {
+		// nothing was captured, this only cancels the unfinished attempts
+		if _, err := payment.ReleaseDepositTx(tx, id, "deposit not paid in time"); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// RecordHarvestInStore adds the actual harvest to the product's stock and fills reserved pre-orders
+// first come first served. Every filled pre-order becomes an order at its reserved rate, all in one
+// transaction. A captured deposit counts towards its order, what a short harvest left unfilled is refunded. Pre-orders
+// still awaiting their deposit are cancelled. The returned pre-orders carry their final status so buyers can be told.
+func RecordHarvestInStore(db *sql.DB, provider payment.PaymentProvider, harvestID, actualQty int) ([]types.PreOrder, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var productID int
+	var status string
+	var projectedDate time.Time
+	err = tx.QueryRow(`SELECT product_id, status, projected_date FROM harvests WHERE id = $1 FOR UPDATE`, harvestID).
+		Scan(&productID, &status, &projectedDate)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no harvest found with ID %d", harvestID)
+		}
+		return nil, fmt.Errorf("error querying harvest: %v", err)
+	}
+	if status != HarvestOpen {
+		return nil, fmt.Errorf("harvest has already been %s", status)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE products
+		SET quantity_in_kg = quantity_in_kg + $1, is_available = quantity_in_kg + $1 > 0, available_from = NULL, updated_at = NOW()
+		WHERE id = $2`, actualQty, productID)
+	if err != nil {
+		return nil, fmt.Errorf("error adding harvest to stock: %v", err)
+	}
+
+	var refundIDs []int
+	unpaid, err := tx.Query(`UPDATE pre_orders SET status = $1, updated_at = NOW() WHERE harvest_id = $2 AND status = $3 RETURNING id`,
+		StatusCancelled, harvestID, StatusAwaitingDeposit)
+	if err != nil {
+		return nil, fmt.Errorf("error cancelling unpaid pre-orders: %v", err)
+	}
+	var unpaidIDs []int
+	for unpaid.Next() {
+		var id int
+		if err := unpaid.Scan(&id); err != nil {
+			unpaid.Close()
+			return nil, fmt.Errorf("failed to scan pre-order: %v", err)
+		}
+		unpaidIDs = append(unpaidIDs, id)
+	}
+	unpaid.Close()
+	for _, id := range unpaidIDs {
+		if _, err := payment.ReleaseDepositTx(tx, id, "deposit not paid before the harvest"); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := tx.Query(`SELECT`+preOrderColumns+` FROM pre_orders WHERE harvest_id = $1 AND status = $2 ORDER BY created_at, id FOR UPDATE`,
+		harvestID, StatusReserved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch pre-orders: %v", err)
+	}
+	var preOrders []types.PreOrder
+	for rows.Next() {
+		po, err := scanPreOrder(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan pre-order: %v", err)
+		}
+		preOrders = append(preOrders, po)
+	}
+	rows.Close()
+
+	remaining := actualQty
+	for i := range preOrders {
+		po := &preOrders[i]
+		po.AllocatedKg = min(po.QuantityInKg, remaining)
+		remaining -= po.AllocatedKg
+
+		switch {
+		case po.AllocatedKg == po.QuantityInKg:
+			po.Status = StatusConfirmed
+		case po.AllocatedKg > 0:
+			po.Status = StatusPartiallyFilled
+		default:
+			po.Status = StatusUnfilled
+		}
+
+		if po.AllocatedKg > 0 {
+			po.TotalPrice = pricing.Round(float64(po.AllocatedKg) * po.RatePerKg)
+			o := types.Order{
+				BuyerID:              po.BuyerID,
+				ProductID:            po.ProductID,
+				QuantityInKg:         po.AllocatedKg,
+				TotalPrice:           po.TotalPrice,
+				ModeOfDelivery:       po.ModeOfDelivery,
+				ExpectedDeliveryDate: projectedDate,
+				DeliveryAddress:      po.DeliveryAddress,
+				DeliveryCity:         po.DeliveryCity,
+				DeliveryAddressZIP:   po.DeliveryAddressZIP,
+				BuyersPhoneNumber:    po.BuyersPhoneNumber,
+			}
+			if err := order.InsertOrderTx(tx, &o); err != nil {
+				return nil, err
+			}
+			if err := order.DeductStockTx(tx, po.ProductID, po.AllocatedKg); err != nil {
+				return nil, err
+			}
+			po.OrderID = &o.ID
+
+			refundID, err := payment.ApplyDepositTx(tx, po.ID, o.ID, o.TotalPrice)
+			if err != nil {
+				return nil, err
+			}
+			refundIDs = append(refundIDs, refundID)
+		} else {
+			refundID, err := payment.ReleaseDepositTx(tx, po.ID, "pre-order unfilled")
+			if err != nil {
+				return nil, err
+			}
+			refundIDs = append(refundIDs, refundID)
+		}
+
+		_, err = tx.Exec(`
+			UPDATE pre_orders SET status = $1, allocated_kg = $2, total_price = $3, order_id = $4, updated_at = NOW()
+			WHERE id = $5`, po.Status, po.AllocatedKg, po.TotalPrice, po.OrderID, po.ID)
+		if err != nil {
+			return nil, fmt.Errorf("error updating pre-order: %v", err)
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE harvests SET status = $1, actual_qty_kg = $2, recorded_at = NOW() WHERE id = $3`,
+		HarvestRecorded, actualQty, harvestID)
+	if err != nil {
+		return nil, fmt.Errorf("error updating harvest: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+	for _, po := range preOrders {
+		if po.OrderID != nil {
+			order.PublishOrderEvent(db, *po.OrderID, events.TypeOrderCreated)
+		}
+	}
+	processRefunds(db, provider, refundIDs)
+	return preOrders, nil
+}
@@ -0,0 +1,105 @@
+package pricing
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/ritu84/agrohub/types"
+)
+
+// ValidateQuantity checks the requested quantity against the product's min, max and step
+func ValidateQuantity(p types.Product, qty int) error {
+	if qty <= 0 {
+		return fmt.Errorf("quantity must be greater than 0")
+	}
+
+	min := p.MinOrderQty
+	if min <= 0 {
+		min = 1
+	}
+	if qty < min {
+		return fmt.Errorf("minimum order quantity is %d kg", min)
+	}
+
+	if p.MaxOrderQty > 0 && qty > p.MaxOrderQty {
+		return fmt.Errorf("maximum order quantity is %d kg", p.MaxOrderQty)
+	}
+
+	if p.OrderStep > 1 && (qty-min)%p.OrderStep != 0 {
+		return fmt.Errorf("quantity must be ordered in steps of %d kg starting from %d kg", p.OrderStep, min)
+	}
+
+	return nil
+}
+
+// ValidateTiers makes sure tiers are positive, well formed and don't overlap
+func ValidateTiers(tiers []types.PriceTier) error {
+	sorted := sortTiers(tiers)
+	for i, t := range sorted {
+		if t.MinQtyKg <= 0 {
+			return fmt.Errorf("tier min_qty_kg must be greater than 0")
+		}
+		if t.RatePerKg <= 0 {
+			return fmt.Errorf("tier rate_per_kg must be greater than 0")
+		}
+		if t.MaxQtyKg != 0 && t.MaxQtyKg < t.MinQtyKg {
+			return fmt.Errorf("tier max_qty_kg %d is less than min_qty_kg %d", t.MaxQtyKg, t.MinQtyKg)
+		}
+		if i == 0 {
+			continue
+		}
+		prev := sorted[i-1]
+		if prev.MaxQtyKg == 0 || prev.MaxQtyKg >= t.MinQtyKg {
+			return fmt.Errorf("tier starting at %d kg overlaps tier starting at %d kg", t.MinQtyKg, prev.MinQtyKg)
+		}
+	}
+	return nil
+}
+
+// RateFor returns the rate that applies to qty, falling back to the product's flat rate_per_kg
+// when no tier covers it. The matching tier is returned so it can be shown to the buyer.
+func RateFor(p types.Product, qty int) (float64, *types.PriceTier) {
+	for _, t := range sortTiers(p.PriceTiers) {
+		if qty < t.MinQtyKg {
+			continue
+		}
+		if t.MaxQtyKg != 0 && qty > t.MaxQtyKg {
+			continue
+		}
+		tier := t
+		return tier.RatePerKg, &tier
+	}
+	return p.RatePerKg, nil
+}
+
+// Calculate validates qty and prices it, p.PriceTiers should already be loaded
+func Calculate(p types.Product, qty int) (types.Quote, error) {
+	if err := ValidateQuantity(p, qty); err != nil {
+		return types.Quote{}, err
+	}
+
+	rate, tier := RateFor(p, qty)
+	subtotal := Round(float64(qty) * rate)
+
+	return types.Quote{
+		ProductID:    p.ID,
+		QuantityInKg: qty,
+		RatePerKg:    rate,
+		Subtotal:     subtotal,
+		TotalPrice:   subtotal,
+		AppliedTier:  tier,
+	}, nil
+}
+
+// Round rounds an amount to paise
+func Round(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+func sortTiers(tiers []types.PriceTier) []types.PriceTier {
+	sorted := make([]types.PriceTier, len(tiers))
+	copy(sorted, tiers)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].MinQtyKg < sorted[j].MinQtyKg })
+	return sorted
+}
@@ -0,0 +1,67 @@
+package pricing
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ritu84/agrohub/types"
+)
+
+func GetPriceTiersFromStore(db *sql.DB, productID int) ([]types.PriceTier, error) {
+	q := `
+	SELECT id, product_id, min_qty_kg, COALESCE(max_qty_kg, 0), rate_per_kg
+	FROM product_price_tiers
+	WHERE product_id = $1
+	ORDER BY min_qty_kg;`
+
+	rows, err := db.Query(q, productID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch price tiers: %v", err)
+	}
+	defer rows.Close()
+
+	var tiers []types.PriceTier
+	for rows.Next() {
+		var t types.PriceTier
+		if err := rows.Scan(&t.ID, &t.ProductID, &t.MinQtyKg, &t.MaxQtyKg, &t.RatePerKg); err != nil {
+			return nil, fmt.Errorf("failed to scan price tier: %v", err)
+		}
+		tiers = append(tiers, t)
+	}
+	return tiers, nil
+}
+
+// SetProductPricingInStore replaces the tiers and order limits of a product in one transaction
+func SetProductPricingInStore(db *sql.DB, productID int, pricing types.ProductPricing) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE products
+		SET min_order_qty_kg = $1, max_order_qty_kg = NULLIF($2, 0), order_step_kg = $3, updated_at = NOW()
+		WHERE id = $4`, pricing.MinOrderQty, pricing.MaxOrderQty, pricing.OrderStep, productID)
+	if err != nil {
+		return fmt.Errorf("error updating order limits: %v", err)
+	}
+
+	if _, err = tx.Exec("DELETE FROM product_price_tiers WHERE product_id = $1", productID); err != nil {
+		return fmt.Errorf("error clearing price tiers: %v", err)
+	}
+
+	for _, t := range pricing.PriceTiers {
+		_, err = tx.Exec(`
+			INSERT INTO product_price_tiers (product_id, min_qty_kg, max_qty_kg, rate_per_kg)
+			VALUES ($1, $2, NULLIF($3, 0), $4)`, productID, t.MinQtyKg, t.MaxQtyKg, t.RatePerKg)
+		if err != nil {
+			return fmt.Errorf("error inserting price tier: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
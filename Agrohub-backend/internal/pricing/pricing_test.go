@@ -0,0 +1,88 @@
+package pricing
+
+import (
+	"testing"
+
+	"github.com/ritu84/agrohub/types"
+)
+
+func TestValidateQuantity(t *testing.T) {
+	tests := []struct {
+		name    string
+		p       types.Product
+		qty     int
+		wantErr bool
+	}{
+		{"no limits", types.Product{}, 1, false},
+		{"zero", types.Product{}, 0, true},
+		{"negative", types.Product{}, -5, true},
+		{"below minimum", types.Product{MinOrderQty: 10}, 9, true},
+		{"at minimum", types.Product{MinOrderQty: 10}, 10, false},
+		{"at maximum", types.Product{MaxOrderQty: 50}, 50, false},
+		{"above maximum", types.Product{MaxOrderQty: 50}, 51, true},
+		{"on a step from the minimum", types.Product{MinOrderQty: 10, OrderStep: 5}, 25, false},
+		{"off a step from the minimum", types.Product{MinOrderQty: 10, OrderStep: 5}, 22, true},
+		{"step of one", types.Product{MinOrderQty: 10, OrderStep: 1}, 11, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateQuantity(tt.p, tt.qty); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateQuantity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateTiers(t *testing.T) {
+	tests := []struct {
+		name    string
+		tiers   []types.PriceTier
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"open ended", []types.PriceTier{{MinQtyKg: 1, RatePerKg: 50}}, false},
+		{"out of order but adjacent", []types.PriceTier{{MinQtyKg: 100, RatePerKg: 40}, {MinQtyKg: 1, MaxQtyKg: 99, RatePerKg: 50}}, false},
+		{"zero minimum", []types.PriceTier{{MinQtyKg: 0, RatePerKg: 50}}, true},
+		{"zero rate", []types.PriceTier{{MinQtyKg: 1, RatePerKg: 0}}, true},
+		{"maximum below minimum", []types.PriceTier{{MinQtyKg: 10, MaxQtyKg: 5, RatePerKg: 50}}, true},
+		{"overlapping", []types.PriceTier{{MinQtyKg: 1, MaxQtyKg: 100, RatePerKg: 50}, {MinQtyKg: 100, RatePerKg: 40}}, true},
+		{"after an open ended tier", []types.PriceTier{{MinQtyKg: 1, RatePerKg: 50}, {MinQtyKg: 100, RatePerKg: 40}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateTiers(tt.tiers); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateTiers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRateFor(t *testing.T) {
+	p := types.Product{RatePerKg: 60, PriceTiers: []types.PriceTier{
+		{MinQtyKg: 500, RatePerKg: 45},
+		{MinQtyKg: 100, MaxQtyKg: 499, RatePerKg: 50},
+	}}
+
+	tests := []struct {
+		name     string
+		qty      int
+		wantRate float64
+		wantTier bool
+	}{
+		{"below every tier", 50, 60, false},
+		{"start of a tier", 100, 50, true},
+		{"end of a tier", 499, 50, true},
+		{"open ended tier", 2000, 45, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rate, tier := RateFor(p, tt.qty)
+			if rate != tt.wantRate {
+				t.Errorf("RateFor() rate = %v, want %v", rate, tt.wantRate)
+			}
+			if (tier != nil) != tt.wantTier {
+				t.Errorf("RateFor() tier = %v, want a tier %v", tier, tt.wantTier)
+			}
+		})
+	}
+}
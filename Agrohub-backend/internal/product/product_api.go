@@ -2,11 +2,17 @@ package product
 
 import (
 	"database/sql"
+	"errors"
+	"math"
 	"net/http"
+	"sort"
 	"strconv"
 
 	"fmt"
 
+	"github.com/ritu84/agrohub/internal/geo"
+	"github.com/ritu84/agrohub/internal/pricing"
+	"github.com/ritu84/agrohub/internal/promotion"
 	"github.com/ritu84/agrohub/types"
 	"github.com/labstack/echo/v4"
 )
@@ -34,16 +40,87 @@ func ListAllProducts(db *sql.DB) echo.HandlerFunc {
 		if err != nil {
 			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("failed to fetch products from store: %+v", err))
 		}
+		if err := AttachRatingsFromStore(db, res); err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("failed to fetch ratings from store: %v", err))
+		}
 		return c.JSON(200, res)
 	}
 }
 
+const (
+	defaultNearbyRadiusKm = 100
+	maxNearbyResults      = 100
+)
+
+// ListNearbyProducts lists live products within ?radius_km= of the buyer, nearest first. The buyer is located by
+// ?pin_code= or by ?lat= and ?lng=, ?type= narrows it down to jari or mushroom.
+func ListNearbyProducts(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var from geo.Point
+		if pin := c.QueryParam("pin_code"); pin != "" {
+			pt, ok := geo.Locate(pin)
+			if !ok {
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to locate pin code %s", pin))
+			}
+			from = pt
+		} else {
+			lat, err1 := strconv.ParseFloat(c.QueryParam("lat"), 64)
+			lng, err2 := strconv.ParseFloat(c.QueryParam("lng"), 64)
+			if err1 != nil || err2 != nil || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, "either pin_code or lat and lng are required")
+			}
+			from = geo.Point{Lat: lat, Lng: lng}
+		}
+
+		radius := float64(defaultNearbyRadiusKm)
+		if v := c.QueryParam("radius_km"); v != "" {
+			r, err := strconv.ParseFloat(v, 64)
+			if err != nil || r <= 0 {
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, "radius_km must be a positive number")
+			}
+			radius = r
+		}
+
+		products, farmPins, err := GetLiveProductsWithFarmFromStore(db, c.QueryParam("type"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("failed to fetch products from store: %v", err))
+		}
+
+		// Farms whose pin code can't be located are left out rather than shown at an unknown distance
+		nearby := []types.Product{}
+		for i, p := range products {
+			farm, ok := geo.Locate(farmPins[i])
+			if !ok {
+				continue
+			}
+			d := math.Round(geo.DistanceKm(from, farm)*10) / 10
+			if d > radius {
+				continue
+			}
+			p.DistanceKm = &d
+			nearby = append(nearby, p)
+		}
+		sort.SliceStable(nearby, func(i, j int) bool { return *nearby[i].DistanceKm < *nearby[j].DistanceKm })
+		if len(nearby) > maxNearbyResults {
+			nearby = nearby[:maxNearbyResults]
+		}
+		if err := AttachRatingsFromStore(db, nearby); err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("failed to fetch ratings from store: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, nearby)
+	}
+}
+
 func ListJariProducts(db *sql.DB) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		res, err := GetAllMushroomAndJariProductsFromStore(db,"Jari")
 		if err != nil {
 			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("failed to fetch jari products from store: %v", err))
 		}
+		if err := AttachRatingsFromStore(db, res); err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("failed to fetch ratings from store: %v", err))
+		}
 		return c.JSON(200, res)
 	}
 }
@@ -54,6 +131,9 @@ func ListMushroomProducts(db *sql.DB) echo.HandlerFunc {
 		if err != nil {
 			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("failed to fetch mushroom products from store: %v", err))
 		}
+		if err := AttachRatingsFromStore(db, res); err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("failed to fetch ratings from store: %v", err))
+		}
 		return c.JSON(200, res)
 	}
 }
@@ -86,6 +166,9 @@ func ListAllProductsOfFarmer(db *sql.DB) echo.HandlerFunc {
 		if err != nil {
 			echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("unable to fetch the products from store :%v", err))
 		}
+		if err := AttachRatingsFromStore(db, res); err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("failed to fetch ratings from store: %v", err))
+		}
 
 		return c.JSON(200, res)
 	}
@@ -106,6 +189,8 @@ func CreateProduct(db *sql.DB) echo.HandlerFunc {
 		if err := c.Bind(&p); err != nil {
 			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing create request :%v", err))
 		}
+		// FPO lots are listed through their FPO, together with what each member contributed
+		p.FPOID = nil
 		if err := CreateProductInStore(db, &p); err != nil {
 			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error creating new product:%v", err))
 		}
@@ -113,6 +198,187 @@ func CreateProduct(db *sql.DB) echo.HandlerFunc {
 	}
 }
 
+// SetProductPricing replaces the price tiers and order limits of a product, only its farmer can do this
+func SetProductPricing(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ProductID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing product id :%v", err))
+		}
+
+		var req types.ProductPricing
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing pricing request :%v", err))
+		}
+
+		if req.MinOrderQty <= 0 {
+			req.MinOrderQty = 1
+		}
+		if req.OrderStep <= 0 {
+			req.OrderStep = 1
+		}
+		if req.MaxOrderQty != 0 && req.MaxOrderQty < req.MinOrderQty {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "max_order_qty_kg must not be less than min_order_qty_kg")
+		}
+		if err := pricing.ValidateTiers(req.PriceTiers); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("invalid price tiers :%v", err))
+		}
+
+		p, err := GetProductFromStore(db, ProductID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, fmt.Sprintf("unable to fetch the product from store :%v", err))
+		}
+		if !ListedBy(c, p) {
+			return echo.NewHTTPError(http.StatusForbidden, "only the farmer who listed the product can change its pricing")
+		}
+
+		if err := pricing.SetProductPricingInStore(db, ProductID, req); err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error updating product pricing :%v", err))
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "product pricing updated successfully!"})
+	}
+}
+
+type updateRateRequest struct {
+	RatePerKg float64 `json:"rate_per_kg"`
+}
+
+// UpdateProductRate changes rate_per_kg of a product, every change is kept in its price history
+func UpdateProductRate(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ProductID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing product id :%v", err))
+		}
+
+		var req updateRateRequest
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing rate request :%v", err))
+		}
+		if req.RatePerKg <= 0 {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "rate_per_kg must be greater than 0")
+		}
+
+		p, err := GetProductFromStore(db, ProductID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, fmt.Sprintf("unable to fetch the product from store :%v", err))
+		}
+		if !ListedBy(c, p) {
+			return echo.NewHTTPError(http.StatusForbidden, "only the farmer who listed the product can change its rate")
+		}
+
+		if err := UpdateProductRateInStore(db, ProductID, req.RatePerKg, p.FarmerID); err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error updating product rate :%v", err))
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "product rate updated successfully!"})
+	}
+}
+
+// UpdateProductSchedule changes expected_delivery and available_from, which also re-lists an expired listing
+func UpdateProductSchedule(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ProductID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing product id :%v", err))
+		}
+
+		var req types.ProductSchedule
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing schedule request :%v", err))
+		}
+
+		p, err := GetProductFromStore(db, ProductID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, fmt.Sprintf("unable to fetch the product from store :%v", err))
+		}
+		if !ListedBy(c, p) {
+			return echo.NewHTTPError(http.StatusForbidden, "only the farmer who listed the product can change its schedule")
+		}
+
+		expected := req.ExpectedDelivery
+		if expected == nil {
+			expected = p.ExpectedDelivery
+		}
+		if expected != nil && req.AvailableFrom != nil && req.AvailableFrom.After(*expected) {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "available_from must not be after expected_delivery")
+		}
+
+		if err := UpdateProductScheduleInStore(db, ProductID, req); err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error updating product schedule :%v", err))
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "product schedule updated successfully!"})
+	}
+}
+
+func GetPriceHistory(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ProductID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing product id :%v", err))
+		}
+
+		res, err := GetPriceHistoryFromStore(db, ProductID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("unable to fetch price history :%v", err))
+		}
+
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+// ListedBy reports whether the logged in user is the farmer who listed p
+func ListedBy(c echo.Context, p types.Product) bool {
+	userID, ok := c.Get("user_id").(int)
+	return ok && userID == p.FarmerID
+}
+
+// GetQuote prices ?quantity_in_kg= of a product without placing an order, with delivery to ?pin_code= when given
+func GetQuote(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ProductID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing product id :%v", err))
+		}
+
+		qty, err := strconv.Atoi(c.QueryParam("quantity_in_kg"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing quantity_in_kg :%v", err))
+		}
+
+		p, err := GetProductFromStore(db, ProductID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, fmt.Sprintf("unable to fetch the product from store :%v", err))
+		}
+
+		quote, err := pricing.Calculate(p, qty)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to price order :%v", err))
+		}
+
+		if pin := c.QueryParam("pin_code"); pin != "" {
+			if err := geo.ApplyDelivery(db, p.FarmerID, pin, &quote); err != nil {
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to price delivery :%v", err))
+			}
+		}
+
+		// checkout shows the coupon's discount before the order is placed, the coupon isn't used up until then
+		if code := c.QueryParam("coupon_code"); code != "" {
+			userID, ok := c.Get("user_id").(int)
+			if !ok {
+				return errors.New("user_id not found or invalid type")
+			}
+			if err := promotion.PreviewInStore(db, code, userID, p, &quote); err != nil {
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to apply coupon :%v", err))
+			}
+		}
+
+		return c.JSON(http.StatusOK, quote)
+	}
+}
+
 // func UpdateProduct(db *sql.DB) echo.HandlerFunc {
 // 	return func(c echo.Context) error {
 // 		ProductID, err := strconv.Atoi(c.Param("id"))
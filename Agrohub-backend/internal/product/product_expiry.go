@@ -0,0 +1,128 @@
+package product
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ritu84/agrohub/internal/notification"
+	"github.com/ritu84/agrohub/internal/scheduler"
+	"github.com/ritu84/agrohub/internal/wishlist"
+)
+
+// ExpiryConfig controls when listings past their expected_delivery are taken down
+type ExpiryConfig struct {
+	GraceDays  int // days after expected_delivery a listing stays up
+	NoticeDays int // days before expiry the farmer is warned
+	Interval   time.Duration
+}
+
+// ExpiryConfigFromEnv reads LISTING_EXPIRY_GRACE_DAYS (default 7), LISTING_EXPIRY_NOTICE_DAYS (default 2)
+// and LISTING_JOBS_INTERVAL (default 1h)
+func ExpiryConfigFromEnv() ExpiryConfig {
+	return ExpiryConfig{
+		GraceDays:  scheduler.IntFromEnv("LISTING_EXPIRY_GRACE_DAYS", 7),
+		NoticeDays: scheduler.IntFromEnv("LISTING_EXPIRY_NOTICE_DAYS", 2),
+		Interval:   scheduler.DurationFromEnv("LISTING_JOBS_INTERVAL", time.Hour),
+	}
+}
+
+// ListingJobs are the background jobs that keep listing availability in line with their dates
+func ListingJobs(cfg ExpiryConfig) []scheduler.Job {
+	return []scheduler.Job{
+		{Name: "activate-scheduled-listings", Interval: cfg.Interval, Run: ActivateScheduledListings},
+		{Name: "notify-expiring-listings", Interval: cfg.Interval, Run: func(db *sql.DB) error { return NotifyExpiringListings(db, cfg) }},
+		{Name: "expire-stale-listings", Interval: cfg.Interval, Run: func(db *sql.DB) error { return ExpireStaleListings(db, cfg) }},
+	}
+}
+
+type listing struct {
+	ID       int
+	FarmerID int
+	Name     string
+}
+
+// ActivateScheduledListings puts pre-harvest listings live once their available_from date arrives
+func ActivateScheduledListings(db *sql.DB) error {
+	q := `
+	UPDATE products
+	SET is_available = quantity_in_kg > 0, available_from = NULL, updated_at = NOW()
+	WHERE available_from IS NOT NULL AND available_from <= CURRENT_DATE AND expired_at IS NULL
+	RETURNING id, farmer_id, name;`
+
+	listings, err := queryListings(db, q)
+	if err != nil {
+		return fmt.Errorf("error activating scheduled listings: %v", err)
+	}
+
+	for _, l := range listings {
+		notification.Notify(db, l.FarmerID, notification.KindListingLive,
+			"Your listing is live", fmt.Sprintf("%s is now available to buyers.", l.Name))
+		wishlist.ProductChanged(db, l.ID)
+	}
+	return nil
+}
+
+// NotifyExpiringListings warns farmers once, NoticeDays before a listing expires
+func NotifyExpiringListings(db *sql.DB, cfg ExpiryConfig) error {
+	q := `
+	UPDATE products
+	SET expiry_notified_at = NOW()
+	WHERE is_available = true AND expired_at IS NULL AND expiry_notified_at IS NULL
+		AND expected_delivery IS NOT NULL
+		AND expected_delivery + $1::INT - $2::INT <= CURRENT_DATE
+		AND expected_delivery + $1::INT >= CURRENT_DATE
+	RETURNING id, farmer_id, name;`
+
+	listings, err := queryListings(db, q, cfg.GraceDays, cfg.NoticeDays)
+	if err != nil {
+		return fmt.Errorf("error finding expiring listings: %v", err)
+	}
+
+	for _, l := range listings {
+		notification.Notify(db, l.FarmerID, notification.KindListingExpiring,
+			"Your listing expires soon",
+			fmt.Sprintf("%s will be taken down within %d days. Update its expected delivery date to keep it listed.", l.Name, cfg.NoticeDays))
+	}
+	return nil
+}
+
+// ExpireStaleListings takes down listings more than GraceDays past their expected_delivery
+func ExpireStaleListings(db *sql.DB, cfg ExpiryConfig) error {
+	q := `
+	UPDATE products
+	SET is_available = false, expired_at = NOW(), updated_at = NOW()
+	WHERE is_available = true AND expired_at IS NULL
+		AND expected_delivery IS NOT NULL
+		AND expected_delivery + $1::INT < CURRENT_DATE
+	RETURNING id, farmer_id, name;`
+
+	listings, err := queryListings(db, q, cfg.GraceDays)
+	if err != nil {
+		return fmt.Errorf("error expiring listings: %v", err)
+	}
+
+	for _, l := range listings {
+		notification.Notify(db, l.FarmerID, notification.KindListingExpired,
+			"Your listing has expired", fmt.Sprintf("%s was taken down because its expected delivery date has passed.", l.Name))
+	}
+	return nil
+}
+
+func queryListings(db *sql.DB, q string, args ...interface{}) ([]listing, error) {
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var listings []listing
+	for rows.Next() {
+		var l listing
+		if err := rows.Scan(&l.ID, &l.FarmerID, &l.Name); err != nil {
+			return nil, err
+		}
+		listings = append(listings, l)
+	}
+	return listings, rows.Err()
+}
@@ -4,20 +4,55 @@ import (
 	"database/sql"
 	"fmt"
 
+	"github.com/lib/pq"
+	"github.com/ritu84/agrohub/internal/notification"
+	"github.com/ritu84/agrohub/internal/pricing"
+	"github.com/ritu84/agrohub/internal/wishlist"
 	"github.com/ritu84/agrohub/types"
 	"github.com/labstack/echo/v4"
 )
 
+// UpdateProductAvailabilityInStore lists or takes down a product by hand. It also drops any available_from date, so the
+// scheduler doesn't put a listing the farmer took down back up.
 func UpdateProductAvailabilityInStore(db *sql.DB, ProductID int, availabilty bool) error {
 	q := `
 	UPDATE products
-	SET is_available = $1
+	SET is_available = $1, available_from = NULL, updated_at = NOW()
 	WHERE id = $2;`
 
 	_, err := db.Exec(q, availabilty, ProductID)
 	if err != nil {
 		return echo.NewHTTPError(echo.ErrInternalServerError.Code, "failed to update product availability in store :%v", err)
 	}
+
+	wishlist.ProductChanged(db, ProductID)
+	return nil
+}
+
+// UpdateProductScheduleInStore moves a listing's dates and re-lists it, a future available_from keeps it
+// hidden until the scheduler puts it live. expected_delivery is left as it is when it isn't sent.
+func UpdateProductScheduleInStore(db *sql.DB, ProductID int, s types.ProductSchedule) error {
+	q := `
+	UPDATE products
+	SET expected_delivery = COALESCE($1::DATE, expected_delivery), available_from = $2::DATE,
+		is_available = CASE WHEN $2::DATE > CURRENT_DATE THEN false ELSE quantity_in_kg > 0 END,
+		expiry_notified_at = NULL, expired_at = NULL, updated_at = NOW()
+	WHERE id = $3;`
+
+	result, err := db.Exec(q, s.ExpectedDelivery, s.AvailableFrom, ProductID)
+	if err != nil {
+		return fmt.Errorf("error updating product schedule: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("no product found with ID %d", ProductID)
+	}
+
+	wishlist.ProductChanged(db, ProductID)
 	return nil
 }
 
@@ -63,6 +98,85 @@ func GetAllProductsFromStore(db *sql.DB) ([]types.Product, error) {
 	return products, nil
 }
 
+// GetLiveProductsWithFarmFromStore lists verified products that can be ordered right now with where their farm is,
+// optionally of one type. farmPins holds the farm pin code of each product.
+func GetLiveProductsWithFarmFromStore(db *sql.DB, productType string) ([]types.Product, []string, error) {
+	rows, err := db.Query(`
+		SELECT p.id, p.farmer_id, p.name, p.type, p.img, p.quantity_in_kg,
+		p.rate_per_kg, p.jari_size, p.expected_delivery,
+		p.farmers_phone_number, p.created_at, p.updated_at,
+		p.is_available, p.is_verified_by_admin,
+		u.first_name, u.last_name, f.city, f.state, f.pin_code
+		FROM products p
+		JOIN users u ON p.farmer_id = u.id
+		JOIN farmers f ON f.user_id = p.farmer_id
+		WHERE p.is_verified_by_admin = true AND p.is_available = true AND p.quantity_in_kg > 0
+			AND ($1::text = '' OR LOWER(p.type) = LOWER($1::text))`, productType)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch rows from store: %v", err)
+	}
+	defer rows.Close()
+
+	products := []types.Product{}
+	farmPins := []string{}
+	for rows.Next() {
+		var p types.Product
+		var nullJariSize sql.NullString
+		var pin string
+		if err := rows.Scan(
+			&p.ID, &p.FarmerID, &p.Name, &p.Type, &p.Img, &p.Quantity,
+			&p.RatePerKg, &nullJariSize, &p.ExpectedDelivery,
+			&p.FarmersPhoneNumber, &p.CreatedAt, &p.UpdatedAt,
+			&p.IsAvailable, &p.IsVerifiedByAdmin,
+			&p.FarmerFirstName, &p.FarmerLastName, &p.FarmerCity, &p.FarmerState, &pin,
+		); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan rows: %v", err)
+		}
+		p.JariSize = nullJariSize.String
+		products = append(products, p)
+		farmPins = append(farmPins, pin)
+	}
+	return products, farmPins, rows.Err()
+}
+
+// GetLiveProductsOfFarmerFromStore lists a farmer's products that can be ordered right now, for their public profile
+func GetLiveProductsOfFarmerFromStore(db *sql.DB, FarmerID int) ([]types.Product, error) {
+	rows, err := db.Query(`
+		SELECT p.id, p.farmer_id, p.name, p.type, p.img, p.quantity_in_kg,
+		p.rate_per_kg, COALESCE(p.jari_size, ''), p.expected_delivery,
+		p.created_at, p.updated_at, p.is_available, p.is_verified_by_admin,
+		p.min_order_qty_kg, COALESCE(p.max_order_qty_kg, 0), p.order_step_kg,
+		u.first_name, u.last_name
+		FROM products p
+		JOIN users u ON p.farmer_id = u.id
+		WHERE p.farmer_id = $1 AND p.is_verified_by_admin = true AND p.is_available = true AND p.quantity_in_kg > 0
+		ORDER BY p.created_at DESC`, FarmerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch rows from store: %v", err)
+	}
+	defer rows.Close()
+
+	products := []types.Product{}
+	for rows.Next() {
+		var p types.Product
+		if err := rows.Scan(
+			&p.ID, &p.FarmerID, &p.Name, &p.Type, &p.Img, &p.Quantity,
+			&p.RatePerKg, &p.JariSize, &p.ExpectedDelivery,
+			&p.CreatedAt, &p.UpdatedAt, &p.IsAvailable, &p.IsVerifiedByAdmin,
+			&p.MinOrderQty, &p.MaxOrderQty, &p.OrderStep,
+			&p.FarmerFirstName, &p.FarmerLastName,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan rows: %v", err)
+		}
+		products = append(products, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch rows from store: %v", err)
+	}
+
+	return products, AttachRatingsFromStore(db, products)
+}
+
 func GetAllMushroomAndJariProductsFromStore(db *sql.DB, productType string) ([]types.Product, error) {
 	fmt.Printf("\nPRODUCT TYPE : %v\n\n\n", productType)
 	// Base query
@@ -119,9 +233,10 @@ func GetAllMushroomAndJariProductsFromStore(db *sql.DB, productType string) ([]t
 
 func GetProductFromStore(db *sql.DB, ProductID int) (types.Product, error) {
 	q := `
-	SELECT p.id, p.farmer_id, p.name, p.type, p.img, p.quantity_in_kg, 
+	SELECT p.id, p.farmer_id, p.fpo_id, p.name, p.type, p.img, p.quantity_in_kg, 
 	p.rate_per_kg, p.jari_size, p.expected_delivery, 
 	p.farmers_phone_number, p.created_at, p.updated_at, p.is_available,
+	p.min_order_qty_kg, COALESCE(p.max_order_qty_kg, 0), p.order_step_kg, p.available_from,
 	u.first_name AS farmer_first_name, u.last_name AS farmer_last_name
 	FROM 
 		products p
@@ -132,15 +247,67 @@ func GetProductFromStore(db *sql.DB, ProductID int) (types.Product, error) {
 
 	var p types.Product
 	if err := db.QueryRow(q, ProductID).Scan(
-		&p.ID, &p.FarmerID, &p.Name, &p.Type, &p.Img, &p.Quantity,
+		&p.ID, &p.FarmerID, &p.FPOID, &p.Name, &p.Type, &p.Img, &p.Quantity,
 		&p.RatePerKg, &p.JariSize, &p.ExpectedDelivery,
 		&p.FarmersPhoneNumber, &p.CreatedAt, &p.UpdatedAt, &p.IsAvailable,
+		&p.MinOrderQty, &p.MaxOrderQty, &p.OrderStep, &p.AvailableFrom,
 		&p.FarmerFirstName, &p.FarmerLastName,
 	); err != nil {
 		return types.Product{}, echo.NewHTTPError(echo.ErrInternalServerError.Code, "failed to scan rows: %v", err)
 	}
 
-	return p, nil
+	tiers, err := pricing.GetPriceTiersFromStore(db, ProductID)
+	if err != nil {
+		return types.Product{}, err
+	}
+	p.PriceTiers = tiers
+
+	ratings := []types.Product{p}
+	if err := AttachRatingsFromStore(db, ratings); err != nil {
+		return types.Product{}, err
+	}
+
+	return ratings[0], nil
+}
+
+// AttachRatingsFromStore sets the rating of each product from its published reviews
+func AttachRatingsFromStore(db *sql.DB, products []types.Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(products))
+	for i, p := range products {
+		ids[i] = int64(p.ID)
+	}
+
+	rows, err := db.Query(`
+	SELECT product_id, ROUND(AVG(rating), 1), COUNT(*)
+	FROM reviews
+	WHERE product_id = ANY($1) AND status = 'published'
+	GROUP BY product_id`, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("error querying ratings: %v", err)
+	}
+	defer rows.Close()
+
+	ratings := map[int]types.RatingSummary{}
+	for rows.Next() {
+		var id int
+		var s types.RatingSummary
+		if err := rows.Scan(&id, &s.Average, &s.Count); err != nil {
+			return fmt.Errorf("failed to scan rating: %v", err)
+		}
+		ratings[id] = s
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error querying ratings: %v", err)
+	}
+
+	for i := range products {
+		s := ratings[products[i].ID]
+		products[i].Rating = &s
+	}
+	return nil
 }
 
 func GetFarmersProductFromStore(db *sql.DB, FarmerID int) ([]types.Product, error) {
@@ -181,27 +348,159 @@ func GetFarmersProductFromStore(db *sql.DB, FarmerID int) ([]types.Product, erro
 }
 
 func CreateProductInStore(db *sql.DB, p *types.Product) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := InsertProductTx(tx, p); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	// new listings are matched against buyers' alerts, most wait for admin verification before they can match
+	wishlist.ProductChanged(db, p.ID)
+	return nil
+}
+
+// InsertProductTx lists a product as part of tx, flows that list stock together with other records (FPO lots) use it
+func InsertProductTx(tx *sql.Tx, p *types.Product) error {
 	q := `
-    INSERT INTO products (farmer_id, name, type, img, quantity_in_kg, rate_per_kg, jari_size, expected_delivery, farmers_phone_number)
-    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+    INSERT INTO products (farmer_id, fpo_id, name, type, img, quantity_in_kg, rate_per_kg, jari_size, expected_delivery, farmers_phone_number,
+        min_order_qty_kg, max_order_qty_kg, order_step_kg, available_from, is_available)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, GREATEST($11, 1), NULLIF($12, 0), GREATEST($13, 1),
+        $14::DATE, $14::DATE IS NULL OR $14::DATE <= CURRENT_DATE)
     RETURNING id, created_at, updated_at, is_available, is_verified_by_admin;`
 
-	err := db.QueryRow(q, p.FarmerID, p.Name, p.Type, p.Img, p.Quantity, p.RatePerKg, p.JariSize, p.ExpectedDelivery, p.FarmersPhoneNumber).
+	err := tx.QueryRow(q, p.FarmerID, p.FPOID, p.Name, p.Type, p.Img, p.Quantity, p.RatePerKg, p.JariSize, p.ExpectedDelivery, p.FarmersPhoneNumber,
+		p.MinOrderQty, p.MaxOrderQty, p.OrderStep, p.AvailableFrom).
 		Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt, &p.IsAvailable, &p.IsVerifiedByAdmin)
 	if err != nil {
 		return fmt.Errorf("failed to insert product in store: %v", err)
 	}
+
+	// the listing price is the first entry of the price history
+	_, err = tx.Exec(`
+		INSERT INTO product_price_history (product_id, old_rate_per_kg, new_rate_per_kg, changed_by)
+		VALUES ($1, NULL, $2, $3)`, p.ID, p.RatePerKg, p.FarmerID)
+	if err != nil {
+		return fmt.Errorf("failed to record price history: %v", err)
+	}
+	return nil
+}
+
+// UpdateProductRateInStore changes rate_per_kg and records the change in product_price_history
+func UpdateProductRateInStore(db *sql.DB, ProductID int, newRate float64, changedBy int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var oldRate float64
+	if err := tx.QueryRow("SELECT rate_per_kg FROM products WHERE id = $1 FOR UPDATE", ProductID).Scan(&oldRate); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("no product found with ID %d", ProductID)
+		}
+		return fmt.Errorf("error fetching current rate: %v", err)
+	}
+
+	if oldRate == newRate {
+		return nil
+	}
+
+	if _, err := tx.Exec("UPDATE products SET rate_per_kg = $1, updated_at = NOW() WHERE id = $2", newRate, ProductID); err != nil {
+		return fmt.Errorf("error updating rate: %v", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO product_price_history (product_id, old_rate_per_kg, new_rate_per_kg, changed_by)
+		VALUES ($1, $2, $3, $4)`, ProductID, oldRate, newRate, changedBy)
+	if err != nil {
+		return fmt.Errorf("failed to record price history: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	wishlist.ProductChanged(db, ProductID)
 	return nil
 }
 
+func GetPriceHistoryFromStore(db *sql.DB, ProductID int) ([]types.PriceChange, error) {
+	q := `
+	SELECT id, product_id, old_rate_per_kg, new_rate_per_kg, changed_by, changed_at
+	FROM product_price_history
+	WHERE product_id = $1
+	ORDER BY changed_at DESC;`
+
+	rows, err := db.Query(q, ProductID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch price history: %v", err)
+	}
+	defer rows.Close()
+
+	var history []types.PriceChange
+	for rows.Next() {
+		var h types.PriceChange
+		if err := rows.Scan(&h.ID, &h.ProductID, &h.OldRatePerKg, &h.NewRatePerKg, &h.ChangedBy, &h.ChangedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan price history: %v", err)
+		}
+		history = append(history, h)
+	}
+	return history, nil
+}
+
+// DeleteProductFromStore deletes a product. Subscriptions to it that have no category to fall back on are cancelled
+// first and their buyers told, subscriptions with a category carry on without it.
 func DeleteProductFromStore(db *sql.DB, ProductID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		UPDATE subscriptions SET status = 'cancelled', updated_at = NOW()
+		WHERE product_id = $1 AND category IS NULL AND status <> 'cancelled'
+		RETURNING id, buyer_id`, ProductID)
+	if err != nil {
+		return fmt.Errorf("error cancelling subscriptions: %v", err)
+	}
+	cancelled := map[int]int{}
+	for rows.Next() {
+		var id, buyerID int
+		if err := rows.Scan(&id, &buyerID); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning subscription: %v", err)
+		}
+		cancelled[id] = buyerID
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error cancelling subscriptions: %v", err)
+	}
+
 	q := `
 	DELETE FROM products
 	WHERE id = $1;`
 
-	_, err := db.Exec(q, ProductID)
-	if err != nil {
+	if _, err := tx.Exec(q, ProductID); err != nil {
 		return echo.NewHTTPError(echo.ErrInternalServerError.Code, "failed to delete product from store :%v", err)
 	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	for id, buyerID := range cancelled {
+		notification.Notify(db, buyerID, notification.KindSubscription, "Subscription cancelled",
+			fmt.Sprintf("Subscription #%d was cancelled because the farmer removed its product.", id))
+	}
 	return nil
 }
@@ -0,0 +1,140 @@
+package promotion
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ritu84/agrohub/types"
+	"github.com/labstack/echo/v4"
+)
+
+var codePattern = regexp.MustCompile(`^[A-Z0-9_-]{3,30}$`)
+
+// validate normalises a coupon's code and category and checks its terms
+func validate(c *types.Coupon) error {
+	c.Code = strings.ToUpper(strings.TrimSpace(c.Code))
+	c.Description = strings.TrimSpace(c.Description)
+	c.Category = strings.ToLower(strings.TrimSpace(c.Category))
+	if !codePattern.MatchString(c.Code) {
+		return errors.New("code must be 3 to 30 letters, digits, - or _")
+	}
+
+	switch c.DiscountType {
+	case TypePercentage:
+		if c.Value <= 0 || c.Value > 100 {
+			return errors.New("a percentage value must be between 0 and 100")
+		}
+		if c.MaxDiscount != nil && *c.MaxDiscount <= 0 {
+			return errors.New("max_discount must be greater than 0")
+		}
+	case TypeFlat:
+		if c.Value <= 0 {
+			return errors.New("value must be greater than 0")
+		}
+		c.MaxDiscount = nil
+	default:
+		return errors.New("discount_type must be percentage or flat")
+	}
+
+	if c.MinOrderValue < 0 {
+		return errors.New("min_order_value can't be negative")
+	}
+	if c.EndsAt != nil && (c.EndsAt.Before(time.Now()) || (!c.StartsAt.IsZero() && !c.EndsAt.After(c.StartsAt))) {
+		return errors.New("ends_at must be in the future and after starts_at")
+	}
+	if c.UsageLimit != nil && *c.UsageLimit <= 0 {
+		return errors.New("usage_limit must be greater than 0")
+	}
+	if c.PerUserLimit == 0 {
+		c.PerUserLimit = 1
+	}
+	if c.PerUserLimit < 0 {
+		return errors.New("per_user_limit must be greater than 0")
+	}
+	return nil
+}
+
+// CreateCoupon creates a coupon. Admins create platform funded coupons, optionally limited to a farmer_id. Farmers
+// create coupons they fund themselves, which are always limited to their own produce.
+func CreateCoupon(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var coupon types.Coupon
+		if err := c.Bind(&coupon); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if err := validate(&coupon); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, err.Error())
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+		switch c.Get("user_type") {
+		case "admin":
+			coupon.FundedBy = FundedByPlatform
+		case "farmer":
+			coupon.FundedBy, coupon.FarmerID = FundedByFarmer, &userID
+		default:
+			return echo.NewHTTPError(http.StatusForbidden, "only farmers and admins can create coupons")
+		}
+
+		if err := CreateCouponInStore(db, &coupon, userID); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error creating coupon: %v", err))
+		}
+
+		return c.JSON(http.StatusCreated, coupon)
+	}
+}
+
+// GetCoupons lists every coupon to admins and their own coupons to farmers, with how often each was used and the
+// discount it gave
+func GetCoupons(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+		farmerID := userID
+		if c.Get("user_type") == "admin" {
+			farmerID = 0
+		}
+
+		coupons, err := GetCouponsFromStore(db, farmerID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching coupons: %v", err))
+		}
+		return c.JSON(http.StatusOK, coupons)
+	}
+}
+
+// DeactivateCoupon ends a coupon early. Admins can end any coupon, farmers the ones they fund.
+func DeactivateCoupon(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		couponID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing coupon id:%v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+		farmerID := userID
+		if c.Get("user_type") == "admin" {
+			farmerID = 0
+		}
+
+		if err := DeactivateCouponInStore(db, couponID, farmerID); err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "coupon deactivated successfully!"})
+	}
+}
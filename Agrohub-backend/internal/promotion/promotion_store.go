@@ -0,0 +1,242 @@
+package promotion
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ritu84/agrohub/internal/pricing"
+	"github.com/ritu84/agrohub/types"
+)
+
+const (
+	TypePercentage = "percentage"
+	TypeFlat       = "flat"
+
+	FundedByPlatform = "platform"
+	FundedByFarmer   = "farmer"
+)
+
+// usesOf selects the orders a coupon was used on, cancelled and rejected orders give their use back
+const usesOf = `
+	FROM order_discounts d JOIN orders o ON o.id = d.order_id
+	WHERE o.status::text NOT IN ('cancelled', 'rejected') AND d.coupon_id = `
+
+const couponColumns = `
+	c.id, c.code, COALESCE(c.description, ''), c.discount_type, c.value, c.max_discount, c.funded_by, c.farmer_id, c.buyer_id,
+	COALESCE(c.category, ''), c.min_order_value, c.starts_at, c.ends_at, c.usage_limit, c.per_user_limit, c.is_active,
+	(SELECT COUNT(*)` + usesOf + `c.id), COALESCE((SELECT SUM(d.amount)` + usesOf + `c.id), 0),
+	c.created_at, c.updated_at`
+
+func scanCoupon(row interface{ Scan(...interface{}) error }) (types.Coupon, error) {
+	var c types.Coupon
+	err := row.Scan(&c.ID, &c.Code, &c.Description, &c.DiscountType, &c.Value, &c.MaxDiscount, &c.FundedBy, &c.FarmerID, &c.BuyerID,
+		&c.Category, &c.MinOrderValue, &c.StartsAt, &c.EndsAt, &c.UsageLimit, &c.PerUserLimit, &c.IsActive,
+		&c.Used, &c.Redeemed, &c.CreatedAt, &c.UpdatedAt)
+	return c, err
+}
+
+func CreateCouponInStore(db *sql.DB, c *types.Coupon, createdBy int) error {
+	// a coupon without starts_at starts now, by the database's clock like the validity checks
+	var startsAt *time.Time
+	if !c.StartsAt.IsZero() {
+		startsAt = &c.StartsAt
+	}
+
+	err := db.QueryRow(`
+		INSERT INTO coupons (code, description, discount_type, value, max_discount, funded_by, farmer_id, buyer_id, category,
+			min_order_value, starts_at, ends_at, usage_limit, per_user_limit, created_by)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7, $8, NULLIF($9, ''), $10, COALESCE($11::TIMESTAMP, NOW()), $12, $13, $14, $15)
+		RETURNING id, starts_at, is_active, created_at, updated_at`,
+		c.Code, c.Description, c.DiscountType, c.Value, c.MaxDiscount, c.FundedBy, c.FarmerID, c.BuyerID, c.Category,
+		c.MinOrderValue, startsAt, c.EndsAt, c.UsageLimit, c.PerUserLimit, createdBy).
+		Scan(&c.ID, &c.StartsAt, &c.IsActive, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		if strings.Contains(err.Error(), "coupons_code_key") {
+			return fmt.Errorf("coupon %s already exists", c.Code)
+		}
+		return fmt.Errorf("error inserting coupon: %v", err)
+	}
+	return nil
+}
+
+// IssueTx creates a platform funded coupon for one buyer as part of tx, e.g. a reward. Its code must be unique.
+func IssueTx(tx *sql.Tx, c *types.Coupon) error {
+	c.FundedBy, c.FarmerID = FundedByPlatform, nil
+	if c.PerUserLimit == 0 {
+		c.PerUserLimit = 1
+	}
+	err := tx.QueryRow(`
+		INSERT INTO coupons (code, description, discount_type, value, max_discount, funded_by, buyer_id, min_order_value,
+			ends_at, usage_limit, per_user_limit)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id, starts_at, is_active, created_at, updated_at`,
+		c.Code, c.Description, c.DiscountType, c.Value, c.MaxDiscount, c.FundedBy, c.BuyerID, c.MinOrderValue,
+		c.EndsAt, c.UsageLimit, c.PerUserLimit).
+		Scan(&c.ID, &c.StartsAt, &c.IsActive, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error issuing coupon: %v", err)
+	}
+	return nil
+}
+
+// GetCouponsFromStore lists coupons newest first, all of them when farmerID is 0 or the farmer's own otherwise
+func GetCouponsFromStore(db *sql.DB, farmerID int) ([]types.Coupon, error) {
+	rows, err := db.Query(`SELECT`+couponColumns+`
+		FROM coupons c
+		WHERE $1 = 0 OR (c.funded_by = $2 AND c.farmer_id = $1)
+		ORDER BY c.created_at DESC`, farmerID, FundedByFarmer)
+	if err != nil {
+		return nil, fmt.Errorf("error querying coupons: %v", err)
+	}
+	defer rows.Close()
+
+	coupons := []types.Coupon{}
+	for rows.Next() {
+		c, err := scanCoupon(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning coupon: %v", err)
+		}
+		coupons = append(coupons, c)
+	}
+	return coupons, rows.Err()
+}
+
+// DeactivateCouponInStore stops a coupon being applied to new orders, orders already placed keep their discount.
+// A farmer (farmerID not 0) can only deactivate coupons they fund.
+func DeactivateCouponInStore(db *sql.DB, couponID, farmerID int) error {
+	result, err := db.Exec(`
+		UPDATE coupons SET is_active = false, updated_at = NOW()
+		WHERE id = $1 AND ($2 = 0 OR (funded_by = $3 AND farmer_id = $2))`, couponID, farmerID, FundedByFarmer)
+	if err != nil {
+		return fmt.Errorf("error deactivating coupon: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("no coupon found with ID %d", couponID)
+	}
+	return nil
+}
+
+// ApplyTx checks a coupon against an order of p priced in q and takes its discount off q.TotalPrice. The discount is
+// on the goods, never on the delivery fee. The coupon stays locked until tx ends so two orders can't both take its
+// last use.
+func ApplyTx(tx *sql.Tx, code string, buyerID int, p types.Product, q *types.Quote) error {
+	code = strings.ToUpper(strings.TrimSpace(code))
+
+	var c types.Coupon
+	var started, ended bool
+	err := tx.QueryRow(`
+		SELECT id, code, discount_type, value, max_discount, funded_by, farmer_id, buyer_id, COALESCE(category, ''), min_order_value,
+			usage_limit, per_user_limit, is_active, starts_at <= NOW(), ends_at IS NOT NULL AND ends_at <= NOW()
+		FROM coupons WHERE code = $1
+		FOR UPDATE`, code).
+		Scan(&c.ID, &c.Code, &c.DiscountType, &c.Value, &c.MaxDiscount, &c.FundedBy, &c.FarmerID, &c.BuyerID, &c.Category, &c.MinOrderValue,
+			&c.UsageLimit, &c.PerUserLimit, &c.IsActive, &started, &ended)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("coupon %s doesn't exist", code)
+		}
+		return fmt.Errorf("error querying coupon: %v", err)
+	}
+
+	switch {
+	case !c.IsActive || ended:
+		return fmt.Errorf("coupon %s has expired", code)
+	case !started:
+		return fmt.Errorf("coupon %s isn't valid yet", code)
+	case c.BuyerID != nil && *c.BuyerID != buyerID:
+		return fmt.Errorf("coupon %s doesn't exist", code)
+	case c.FarmerID != nil && *c.FarmerID != p.FarmerID:
+		return fmt.Errorf("coupon %s isn't valid on this farmer's produce", code)
+	case c.Category != "" && !strings.EqualFold(c.Category, p.Type):
+		return fmt.Errorf("coupon %s is only valid on %s", code, c.Category)
+	case q.Subtotal < c.MinOrderValue:
+		return fmt.Errorf("coupon %s needs an order of at least Rs %.2f before delivery", code, c.MinOrderValue)
+	}
+
+	var used, usedByBuyer int
+	err = tx.QueryRow(`
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE d.buyer_id = $2)`+usesOf+`$1`, c.ID, buyerID).Scan(&used, &usedByBuyer)
+	if err != nil {
+		return fmt.Errorf("error counting coupon uses: %v", err)
+	}
+	if c.UsageLimit != nil && used >= *c.UsageLimit {
+		return fmt.Errorf("coupon %s has been fully redeemed", code)
+	}
+	if usedByBuyer >= c.PerUserLimit {
+		return fmt.Errorf("you have already used coupon %s", code)
+	}
+
+	amount := Discount(c, q.Subtotal)
+	q.Discounts = append(q.Discounts, types.OrderDiscount{CouponID: c.ID, Code: c.Code, FundedBy: c.FundedBy, Amount: amount})
+	q.Discount = pricing.Round(q.Discount + amount)
+	q.TotalPrice = pricing.Round(q.TotalPrice - amount)
+	return nil
+}
+
+// Discount is what a coupon takes off goods worth subtotal, never more than subtotal
+func Discount(c types.Coupon, subtotal float64) float64 {
+	amount := c.Value
+	if c.DiscountType == TypePercentage {
+		amount = subtotal * c.Value / 100
+		if c.MaxDiscount != nil && amount > *c.MaxDiscount {
+			amount = *c.MaxDiscount
+		}
+	}
+	if amount > subtotal {
+		amount = subtotal
+	}
+	return pricing.Round(amount)
+}
+
+// PreviewInStore applies a coupon to a quote without redeeming it, for showing the buyer the price at checkout
+func PreviewInStore(db *sql.DB, code string, buyerID int, p types.Product, q *types.Quote) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	// nothing is written, rolling back only releases the coupon
+	defer tx.Rollback()
+
+	return ApplyTx(tx, code, buyerID, p, q)
+}
+
+// RecordTx writes an order's discount lines, each one counts as a use of its coupon
+func RecordTx(tx *sql.Tx, orderID, buyerID int, discounts []types.OrderDiscount) error {
+	for _, d := range discounts {
+		_, err := tx.Exec(`
+			INSERT INTO order_discounts (order_id, coupon_id, buyer_id, code, funded_by, amount)
+			VALUES ($1, $2, $3, $4, $5, $6)`, orderID, d.CouponID, buyerID, d.Code, d.FundedBy, d.Amount)
+		if err != nil {
+			return fmt.Errorf("error recording discount: %v", err)
+		}
+	}
+	return nil
+}
+
+// GetOrderDiscountsFromStore returns an order's discount lines
+func GetOrderDiscountsFromStore(db *sql.DB, orderID int) ([]types.OrderDiscount, error) {
+	rows, err := db.Query(`
+		SELECT coupon_id, code, funded_by, amount FROM order_discounts
+		WHERE order_id = $1 ORDER BY id`, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying discounts: %v", err)
+	}
+	defer rows.Close()
+
+	var discounts []types.OrderDiscount
+	for rows.Next() {
+		var d types.OrderDiscount
+		if err := rows.Scan(&d.CouponID, &d.Code, &d.FundedBy, &d.Amount); err != nil {
+			return nil, fmt.Errorf("error scanning discount: %v", err)
+		}
+		discounts = append(discounts, d)
+	}
+	return discounts, rows.Err()
+}
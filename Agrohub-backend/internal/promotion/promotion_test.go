@@ -0,0 +1,34 @@
+package promotion
+
+import (
+	"testing"
+
+	"github.com/ritu84/agrohub/types"
+)
+
+func TestDiscount(t *testing.T) {
+	capAt := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name     string
+		c        types.Coupon
+		subtotal float64
+		want     float64
+	}{
+		{"flat", types.Coupon{DiscountType: TypeFlat, Value: 100}, 1500, 100},
+		{"flat above the subtotal", types.Coupon{DiscountType: TypeFlat, Value: 500}, 300, 300},
+		{"percentage", types.Coupon{DiscountType: TypePercentage, Value: 10}, 1500, 150},
+		{"percentage rounded to paise", types.Coupon{DiscountType: TypePercentage, Value: 7.5}, 333.33, 25},
+		{"percentage under its cap", types.Coupon{DiscountType: TypePercentage, Value: 10, MaxDiscount: capAt(200)}, 1500, 150},
+		{"percentage over its cap", types.Coupon{DiscountType: TypePercentage, Value: 10, MaxDiscount: capAt(200)}, 5000, 200},
+		{"cap doesn't apply to flat", types.Coupon{DiscountType: TypeFlat, Value: 300, MaxDiscount: capAt(200)}, 5000, 300},
+		{"nothing to discount", types.Coupon{DiscountType: TypeFlat, Value: 100}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Discount(tt.c, tt.subtotal); got != tt.want {
+				t.Errorf("Discount() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
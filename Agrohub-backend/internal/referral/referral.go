@@ -0,0 +1,228 @@
+package referral
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ritu84/agrohub/internal/notification"
+	"github.com/ritu84/agrohub/internal/promotion"
+	"github.com/ritu84/agrohub/internal/scheduler"
+	"github.com/ritu84/agrohub/types"
+)
+
+const (
+	StatusPending  = "pending"
+	StatusRewarded = "rewarded"
+	StatusRejected = "rejected"
+)
+
+// Config sets the rewards referrers get, as a coupon for their next orders
+type Config struct {
+	FarmerReward int // rupees, when a referred farmer is KYC approved
+	BuyerReward  int // rupees, when a referred buyer's first order is delivered
+	RewardDays   int // days the reward coupon can be used for
+	Interval     time.Duration
+}
+
+// ConfigFromEnv reads REFERRAL_FARMER_REWARD (default 200), REFERRAL_BUYER_REWARD (default 100),
+// REFERRAL_REWARD_DAYS (default 90) and REFERRAL_JOBS_INTERVAL (default 1h)
+func ConfigFromEnv() Config {
+	return Config{
+		FarmerReward: scheduler.IntFromEnv("REFERRAL_FARMER_REWARD", 200),
+		BuyerReward:  scheduler.IntFromEnv("REFERRAL_BUYER_REWARD", 100),
+		RewardDays:   scheduler.IntFromEnv("REFERRAL_REWARD_DAYS", 90),
+		Interval:     scheduler.DurationFromEnv("REFERRAL_JOBS_INTERVAL", time.Hour),
+	}
+}
+
+// Jobs are the background jobs for referrals. Referrals are rewarded as soon as the referred user qualifies, the
+// sweep catches the ones a failed reward left pending.
+func Jobs(cfg Config) []scheduler.Job {
+	return []scheduler.Job{
+		{Name: "reward-referrals", Interval: cfg.Interval, Run: func(db *sql.DB) error { return SweepReferrals(db, cfg) }},
+	}
+}
+
+// identity is what the fraud checks compare between a referrer and the user they referred
+type identity struct {
+	Email        string
+	PhoneNumber  string
+	AadharNumber string
+}
+
+// phoneDigits drops formatting and the country code so the same number always compares equal
+func phoneDigits(number string) string {
+	var b strings.Builder
+	for _, r := range number {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	digits := b.String()
+	if len(digits) > 10 {
+		digits = digits[len(digits)-10:]
+	}
+	return digits
+}
+
+// selfReferral reports whether the referred user is the referrer under another account
+func selfReferral(referrer, referred identity) bool {
+	return strings.EqualFold(strings.TrimSpace(referrer.Email), strings.TrimSpace(referred.Email)) ||
+		(phoneDigits(referrer.PhoneNumber) != "" && phoneDigits(referrer.PhoneNumber) == phoneDigits(referred.PhoneNumber)) ||
+		referrer.AadharNumber == referred.AadharNumber
+}
+
+// Attribute records that a new user signed up with a referrer's code. Signing up must never fail because of it, so
+// errors are only logged.
+func Attribute(db *sql.DB, referrerID, referredID int, code, aadhar string) {
+	if err := AttributeInStore(db, referrerID, referredID, code, aadhar); err != nil {
+		log.Printf("referral: failed to attribute user %d to %d: %v", referredID, referrerID, err)
+	}
+}
+
+// Qualify rewards the referrer of a user once they qualify, call it after a farmer is approved or a buyer's order is
+// delivered. Like notifications a failure is only logged, the sweep retries it.
+func Qualify(db *sql.DB, userID int) {
+	if err := RewardInStore(db, userID, ConfigFromEnv()); err != nil {
+		log.Printf("referral: failed to reward the referral of user %d: %v", userID, err)
+	}
+}
+
+// OrderDelivered rewards the referrer of the order's buyer if it was their first delivered order
+func OrderDelivered(db *sql.DB, orderID int) {
+	var buyerID int
+	if err := db.QueryRow(`SELECT buyer_id FROM orders WHERE id = $1`, orderID).Scan(&buyerID); err != nil {
+		log.Printf("referral: failed to find the buyer of order %d: %v", orderID, err)
+		return
+	}
+	Qualify(db, buyerID)
+}
+
+// RewardInStore rewards the referrer of userID with a coupon once userID qualifies: a farmer when they are KYC
+// approved, a buyer when an order of theirs is delivered. The fraud checks run again first, a referral that fails
+// them is rejected instead.
+func RewardInStore(db *sql.DB, userID int, cfg Config) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var r types.Referral
+	var referrer, referred identity
+	var qualified, duplicate bool
+	err = tx.QueryRow(`
+		SELECT r.id, r.referrer_id, r.referred_id, ru.first_name, ru.last_name, ru.user_type, r.code,
+			rr.email, rr.phone_number, rr.aadhar_number, ru.email, ru.phone_number, ru.aadhar_number,
+			CASE WHEN ru.user_type = 'farmer'
+				THEN EXISTS (SELECT 1 FROM farmers f WHERE f.user_id = ru.id AND f.is_verified_by_admin = true)
+				ELSE EXISTS (SELECT 1 FROM orders o WHERE o.buyer_id = ru.id AND o.status = 'delivered')
+			END,
+			EXISTS (SELECT 1 FROM referrals x WHERE x.referred_aadhar = r.referred_aadhar AND x.id <> r.id AND x.status = $3)
+				OR EXISTS (SELECT 1 FROM users u WHERE u.aadhar_number = r.referred_aadhar AND u.id <> ru.id)
+		FROM referrals r
+		JOIN users ru ON ru.id = r.referred_id
+		JOIN users rr ON rr.id = r.referrer_id
+		WHERE r.referred_id = $1 AND r.status = $2
+		FOR UPDATE OF r`, userID, StatusPending, StatusRewarded).
+		Scan(&r.ID, &r.ReferrerID, &r.ReferredID, &r.FirstName, &r.LastName, &r.UserType, &r.Code,
+			&referrer.Email, &referrer.PhoneNumber, &referrer.AadharNumber,
+			&referred.Email, &referred.PhoneNumber, &referred.AadharNumber, &qualified, &duplicate)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// not referred, or already rewarded or rejected
+			return nil
+		}
+		return fmt.Errorf("error querying referral: %v", err)
+	}
+	if !qualified {
+		return nil
+	}
+
+	reason := ""
+	switch {
+	case selfReferral(referrer, referred):
+		reason = "referrer and referred user share an email, phone number or Aadhaar number"
+	case duplicate:
+		reason = "the Aadhaar number was already referred"
+	}
+	if reason != "" {
+		_, err := tx.Exec(`UPDATE referrals SET status = $1, reject_reason = $2 WHERE id = $3`, StatusRejected, reason, r.ID)
+		if err != nil {
+			return fmt.Errorf("error rejecting referral: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("error committing transaction: %v", err)
+		}
+		log.Printf("referral: rejected referral %d: %s", r.ID, reason)
+		return nil
+	}
+
+	amount := cfg.BuyerReward
+	if r.UserType == "farmer" {
+		amount = cfg.FarmerReward
+	}
+	endsAt := time.Now().AddDate(0, 0, cfg.RewardDays)
+	usageLimit := 1
+	coupon := types.Coupon{
+		Code:         fmt.Sprintf("REF%06d", r.ID),
+		Description:  fmt.Sprintf("Referral reward for inviting %s %s", r.FirstName, r.LastName),
+		DiscountType: promotion.TypeFlat,
+		Value:        float64(amount),
+		BuyerID:      &r.ReferrerID,
+		EndsAt:       &endsAt,
+		UsageLimit:   &usageLimit,
+	}
+	if err := promotion.IssueTx(tx, &coupon); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE referrals SET status = $1, reward_amount = $2, reward_coupon_id = $3, rewarded_at = NOW()
+		WHERE id = $4`, StatusRewarded, coupon.Value, coupon.ID, r.ID)
+	if err != nil {
+		return fmt.Errorf("error updating referral: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	notification.Notify(db, r.ReferrerID, notification.KindReferral, "Referral reward",
+		fmt.Sprintf("%s joined Agrohub with your code. Use coupon %s for Rs %.2f off an order before %s.",
+			r.FirstName, coupon.Code, coupon.Value, endsAt.Format("02 Jan 2006")))
+	return nil
+}
+
+// SweepReferrals rewards every pending referral whose referred user qualifies
+func SweepReferrals(db *sql.DB, cfg Config) error {
+	rows, err := db.Query(`SELECT referred_id FROM referrals WHERE status = $1`, StatusPending)
+	if err != nil {
+		return fmt.Errorf("error querying pending referrals: %v", err)
+	}
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning referral: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error querying pending referrals: %v", err)
+	}
+
+	for _, id := range ids {
+		// one bad referral shouldn't hold up the rest
+		if err := RewardInStore(db, id, cfg); err != nil {
+			log.Printf("referral: user %d: %v", id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package referral
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// GetReferrals returns the logged in user's referral code, the users who signed up with it and what they earned
+func GetReferrals(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		s, err := GetSummaryFromStore(db, userID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching referrals: %v", err))
+		}
+		return c.JSON(http.StatusOK, s)
+	}
+}
+
+// GetReport is the admin referral report. ?from= and ?to= are dates (2006-01-02), both included, and default to the
+// current month.
+func GetReport(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		now := time.Now()
+		from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		to := from.AddDate(0, 1, -1)
+		if v := c.QueryParam("from"); v != "" {
+			d, err := time.Parse("2006-01-02", v)
+			if err != nil {
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing from: %v", err))
+			}
+			from = d
+		}
+		if v := c.QueryParam("to"); v != "" {
+			d, err := time.Parse("2006-01-02", v)
+			if err != nil {
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing to: %v", err))
+			}
+			to = d
+		}
+		if to.Before(from) {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "to must not be before from")
+		}
+
+		rep, err := GetReportFromStore(db, from, to.AddDate(0, 0, 1))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching referral report: %v", err))
+		}
+		rep.To = to
+
+		return c.JSON(http.StatusOK, rep)
+	}
+}
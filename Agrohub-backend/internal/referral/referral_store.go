@@ -0,0 +1,233 @@
+package referral
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ritu84/agrohub/types"
+)
+
+// codeChars leaves out 0, 1, I, L and O, which are easy to mix up when a code is read out
+const codeChars = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+
+// newCode makes a code from up to four letters of the user's name and four random characters, e.g. RAME7K2P
+func newCode(firstName string) string {
+	var prefix strings.Builder
+	for _, r := range strings.ToUpper(firstName) {
+		if r >= 'A' && r <= 'Z' && prefix.Len() < 4 {
+			prefix.WriteRune(r)
+		}
+	}
+	if prefix.Len() == 0 {
+		prefix.WriteString("AGRO")
+	}
+
+	bytes := make([]byte, 4)
+	rand.Read(bytes)
+	for _, b := range bytes {
+		prefix.WriteByte(codeChars[int(b)%len(codeChars)])
+	}
+	return prefix.String()
+}
+
+// CodeFromStore returns the user's referral code, giving them one the first time it is asked for
+func CodeFromStore(db *sql.DB, userID int) (string, error) {
+	var code sql.NullString
+	var firstName string
+	err := db.QueryRow(`SELECT referral_code, first_name FROM users WHERE id = $1`, userID).Scan(&code, &firstName)
+	if err != nil {
+		return "", fmt.Errorf("error querying user: %v", err)
+	}
+	if code.Valid {
+		return code.String, nil
+	}
+
+	// another user may already have the code, try a few before giving up
+	for i := 0; i < 5; i++ {
+		err := db.QueryRow(`
+			UPDATE users SET referral_code = COALESCE(referral_code, $2)
+			WHERE id = $1
+			RETURNING referral_code`, userID, newCode(firstName)).Scan(&code)
+		if err == nil {
+			return code.String, nil
+		}
+		if !strings.Contains(err.Error(), "users_referral_code_key") {
+			return "", fmt.Errorf("error saving referral code: %v", err)
+		}
+	}
+	return "", fmt.Errorf("error saving referral code: no unused code found")
+}
+
+// ErrCodeUnusable is the only reason a signup is given for refusing a referral code, so the signup form can't be used
+// to find out whose code it is or whether an Aadhaar number is registered
+var ErrCodeUnusable = errors.New("referral code can't be used")
+
+// ReferrerFromStore finds the owner of a referral code a new user is signing up with
+func ReferrerFromStore(db *sql.DB, code string) (int, error) {
+	var referrerID int
+	err := db.QueryRow(`SELECT id FROM users WHERE referral_code = $1`, strings.ToUpper(strings.TrimSpace(code))).Scan(&referrerID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrCodeUnusable
+		}
+		return 0, fmt.Errorf("error querying referral code: %v", err)
+	}
+	return referrerID, nil
+}
+
+// AttributeInStore records a referral once the new user has verified their email, a user is only ever referred
+// once. The fraud checks run here rather than at signup: a referral from the new user's own other account, or for an
+// Aadhaar number that is already registered or was referred before, is recorded as rejected.
+func AttributeInStore(db *sql.DB, referrerID, referredID int, code, aadhar string) error {
+	var referrer, referred identity
+	var duplicate bool
+	err := db.QueryRow(`
+		SELECT rr.email, rr.phone_number, rr.aadhar_number, ru.email, ru.phone_number, ru.aadhar_number,
+			EXISTS (SELECT 1 FROM users u WHERE u.aadhar_number = $3 AND u.id <> ru.id)
+				OR EXISTS (SELECT 1 FROM referrals x WHERE x.referred_aadhar = $3)
+		FROM users rr, users ru
+		WHERE rr.id = $1 AND ru.id = $2`, referrerID, referredID, aadhar).
+		Scan(&referrer.Email, &referrer.PhoneNumber, &referrer.AadharNumber,
+			&referred.Email, &referred.PhoneNumber, &referred.AadharNumber, &duplicate)
+	if err != nil {
+		return fmt.Errorf("error querying users: %v", err)
+	}
+
+	status, reason := StatusPending, ""
+	switch {
+	case selfReferral(referrer, referred):
+		status, reason = StatusRejected, "referrer and referred user share an email, phone number or Aadhaar number"
+	case duplicate:
+		status, reason = StatusRejected, "the Aadhaar number was already registered or referred"
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO referrals (referrer_id, referred_id, code, referred_aadhar, status, reject_reason)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
+		ON CONFLICT (referred_id) DO NOTHING`, referrerID, referredID, strings.ToUpper(strings.TrimSpace(code)), aadhar, status, reason)
+	if err != nil {
+		return fmt.Errorf("error inserting referral: %v", err)
+	}
+	return nil
+}
+
+const referralColumns = `
+	r.id, r.referrer_id, r.referred_id, u.first_name, u.last_name, u.user_type, r.code, r.status,
+	COALESCE(r.reject_reason, ''), r.reward_amount, COALESCE(c.code, ''), r.rewarded_at, r.created_at`
+
+const referralFrom = `
+	FROM referrals r
+	JOIN users u ON u.id = r.referred_id
+	LEFT JOIN coupons c ON c.id = r.reward_coupon_id`
+
+func scanReferral(row interface{ Scan(...interface{}) error }) (types.Referral, error) {
+	var r types.Referral
+	err := row.Scan(&r.ID, &r.ReferrerID, &r.ReferredID, &r.FirstName, &r.LastName, &r.UserType, &r.Code, &r.Status,
+		&r.RejectReason, &r.RewardAmount, &r.RewardCoupon, &r.RewardedAt, &r.CreatedAt)
+	return r, err
+}
+
+// GetSummaryFromStore returns the user's referral code and everyone who signed up with it, latest first
+func GetSummaryFromStore(db *sql.DB, userID int) (types.ReferralSummary, error) {
+	code, err := CodeFromStore(db, userID)
+	if err != nil {
+		return types.ReferralSummary{}, err
+	}
+	s := types.ReferralSummary{Code: code, Referrals: []types.Referral{}}
+
+	rows, err := db.Query(`SELECT`+referralColumns+referralFrom+`
+		WHERE r.referrer_id = $1
+		ORDER BY r.created_at DESC`, userID)
+	if err != nil {
+		return s, fmt.Errorf("error querying referrals: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r, err := scanReferral(rows)
+		if err != nil {
+			return s, fmt.Errorf("error scanning referral: %v", err)
+		}
+		switch r.Status {
+		case StatusPending:
+			s.Pending++
+		case StatusRewarded:
+			s.Rewarded++
+			s.Earned += *r.RewardAmount
+		case StatusRejected:
+			s.Rejected++
+		}
+		s.Referrals = append(s.Referrals, r)
+	}
+	return s, rows.Err()
+}
+
+// GetReportFromStore reports on the referrals made between from and to (not included): how many, what they cost,
+// who referred them and which were rejected as fraudulent
+func GetReportFromStore(db *sql.DB, from, to time.Time) (types.ReferralReport, error) {
+	rep := types.ReferralReport{From: from, To: to, Referrers: []types.ReferralReportLine{}, Flagged: []types.Referral{}}
+
+	err := db.QueryRow(`
+		SELECT COUNT(*),
+			COUNT(*) FILTER (WHERE u.user_type = 'farmer'),
+			COUNT(*) FILTER (WHERE u.user_type = 'buyer'),
+			COUNT(*) FILTER (WHERE r.status = $3),
+			COUNT(*) FILTER (WHERE r.status = $4),
+			COUNT(*) FILTER (WHERE r.status = $5),
+			COALESCE(SUM(r.reward_amount), 0),
+			COALESCE((SELECT SUM(d.amount) FROM referrals x
+				JOIN order_discounts d ON d.coupon_id = x.reward_coupon_id
+				JOIN orders o ON o.id = d.order_id
+				WHERE x.created_at >= $1 AND x.created_at < $2 AND o.status::text NOT IN ('cancelled', 'rejected')), 0)
+		FROM referrals r
+		JOIN users u ON u.id = r.referred_id
+		WHERE r.created_at >= $1 AND r.created_at < $2`, from, to, StatusPending, StatusRewarded, StatusRejected).
+		Scan(&rep.Referred, &rep.Farmers, &rep.Buyers, &rep.Pending, &rep.Rewarded, &rep.Rejected, &rep.Rewards, &rep.Redeemed)
+	if err != nil {
+		return rep, fmt.Errorf("error querying referral totals: %v", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT r.referrer_id, u.first_name, u.last_name, u.user_type, COUNT(*),
+			COUNT(*) FILTER (WHERE r.status = $3), COUNT(*) FILTER (WHERE r.status = $4),
+			COALESCE(SUM(r.reward_amount), 0)
+		FROM referrals r
+		JOIN users u ON u.id = r.referrer_id
+		WHERE r.created_at >= $1 AND r.created_at < $2
+		GROUP BY r.referrer_id, u.first_name, u.last_name, u.user_type
+		ORDER BY COUNT(*) DESC, r.referrer_id`, from, to, StatusRewarded, StatusRejected)
+	if err != nil {
+		return rep, fmt.Errorf("error querying referrers: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l types.ReferralReportLine
+		if err := rows.Scan(&l.ReferrerID, &l.FirstName, &l.LastName, &l.UserType, &l.Referred, &l.Rewarded, &l.Rejected, &l.Rewards); err != nil {
+			return rep, fmt.Errorf("error scanning referrer: %v", err)
+		}
+		rep.Referrers = append(rep.Referrers, l)
+	}
+	if err := rows.Err(); err != nil {
+		return rep, fmt.Errorf("error querying referrers: %v", err)
+	}
+
+	flagged, err := db.Query(`SELECT`+referralColumns+referralFrom+`
+		WHERE r.status = $3 AND r.created_at >= $1 AND r.created_at < $2
+		ORDER BY r.created_at DESC`, from, to, StatusRejected)
+	if err != nil {
+		return rep, fmt.Errorf("error querying rejected referrals: %v", err)
+	}
+	defer flagged.Close()
+	for flagged.Next() {
+		r, err := scanReferral(flagged)
+		if err != nil {
+			return rep, fmt.Errorf("error scanning referral: %v", err)
+		}
+		rep.Flagged = append(rep.Flagged, r)
+	}
+	return rep, flagged.Err()
+}
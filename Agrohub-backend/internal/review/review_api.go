@@ -0,0 +1,230 @@
+package review
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ritu84/agrohub/internal/notification"
+	"github.com/ritu84/agrohub/types"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	maxReviewPhotos = 5
+	maxReviewLength = 2000
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// page reads ?limit= and ?offset=
+func page(c echo.Context) (int, int, error) {
+	limit, offset := defaultPageSize, 0
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxPageSize {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		limit = n
+	}
+	if v := c.QueryParam("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, errors.New("offset must be 0 or more")
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
+
+// CreateReview lets the buyer of a delivered order rate it, once
+func CreateReview(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orderID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing order id:%v", err))
+		}
+
+		var r types.Review
+		if err := c.Bind(&r); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if r.Rating < 1 || r.Rating > 5 {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "rating must be between 1 and 5")
+		}
+		r.Body = strings.TrimSpace(r.Body)
+		if len(r.Body) > maxReviewLength {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("a review can be at most %d characters", maxReviewLength))
+		}
+		if len(r.PhotoURLs) > maxReviewPhotos {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("a review can have at most %d photos", maxReviewPhotos))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+		r.OrderID = orderID
+
+		if err := CreateReviewInStore(db, userID, &r); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error creating review: %v", err))
+		}
+
+		notification.Notify(db, r.FarmerID, notification.KindReview, "New review",
+			fmt.Sprintf("A buyer rated %s %d/5 on order #%d.", r.ProductName, r.Rating, orderID))
+
+		return c.JSON(http.StatusCreated, r)
+	}
+}
+
+// GetProductReviews lists the published reviews of a product with its rating
+func GetProductReviews(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ProductID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing product id :%v", err))
+		}
+		limit, offset, err := page(c)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, err.Error())
+		}
+
+		list, err := GetProductReviewsFromStore(db, ProductID, limit, offset)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching reviews: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, list)
+	}
+}
+
+// GetFarmerReviews lists the published reviews of a farmer's products with their overall rating
+func GetFarmerReviews(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		FarmerID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing farmer id :%v", err))
+		}
+		limit, offset, err := page(c)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, err.Error())
+		}
+
+		list, err := GetFarmerReviewsFromStore(db, FarmerID, limit, offset)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching reviews: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, list)
+	}
+}
+
+// ReplyToReview lets the farmer answer a review of their product publicly
+func ReplyToReview(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		reviewID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing review id:%v", err))
+		}
+
+		var req types.ReviewReply
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		req.Reply = strings.TrimSpace(req.Reply)
+		if req.Reply == "" || len(req.Reply) > maxReviewLength {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("reply must be between 1 and %d characters", maxReviewLength))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		if err := ReplyToReviewInStore(db, reviewID, userID, req.Reply); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error replying to review: %v", err))
+		}
+
+		if r, err := GetReviewFromStore(db, reviewID); err == nil {
+			notification.Notify(db, r.BuyerID, notification.KindReview, "The farmer replied to your review",
+				fmt.Sprintf("The farmer replied to your review of %s.", r.ProductName))
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "reply saved successfully!"})
+	}
+}
+
+// ReportReview flags a review as abusive for admins to look at
+func ReportReview(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		reviewID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing review id:%v", err))
+		}
+
+		var req types.ReportReview
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if strings.TrimSpace(req.Reason) == "" {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "reason is required")
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		if err := ReportReviewInStore(db, reviewID, userID, strings.TrimSpace(req.Reason)); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error reporting review: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "review reported successfully!"})
+	}
+}
+
+// GetReportedReviews lists reviews reported since they were last moderated, for admins
+func GetReportedReviews(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		reviews, err := GetReportedReviewsFromStore(db)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching reported reviews: %v", err))
+		}
+		return c.JSON(http.StatusOK, reviews)
+	}
+}
+
+// ModerateReview lets an admin hide an abusive review or publish it again
+func ModerateReview(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		reviewID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing review id:%v", err))
+		}
+
+		var req types.ModerateReview
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if req.Status != StatusHidden && req.Status != StatusPublished {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "status must be hidden or published")
+		}
+		if req.Status == StatusHidden && strings.TrimSpace(req.Reason) == "" {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "a reason is required to hide a review")
+		}
+
+		adminID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		if err := ModerateReviewInStore(db, reviewID, adminID, req.Status, strings.TrimSpace(req.Reason)); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error moderating review: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "review moderated successfully!"})
+	}
+}
@@ -0,0 +1,225 @@
+package review
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/ritu84/agrohub/internal/events"
+	"github.com/ritu84/agrohub/types"
+)
+
+const (
+	StatusPublished = "published"
+	StatusHidden    = "hidden"
+)
+
+const reviewColumns = `
+	r.id, r.order_id, r.product_id, p.name, r.farmer_id, r.buyer_id, u.first_name || ' ' || LEFT(u.last_name, 1),
+	r.rating, COALESCE(r.body, ''), r.photo_urls, COALESCE(r.reply, ''), r.replied_at, r.status, COALESCE(r.hidden_reason, ''),
+	(SELECT COUNT(*) FROM review_reports rr WHERE rr.review_id = r.id), r.created_at, r.updated_at`
+
+const reviewFrom = `
+	FROM reviews r
+	JOIN products p ON p.id = r.product_id
+	JOIN users u ON u.id = r.buyer_id`
+
+func scanReview(row interface{ Scan(...interface{}) error }) (types.Review, error) {
+	var r types.Review
+	err := row.Scan(&r.ID, &r.OrderID, &r.ProductID, &r.ProductName, &r.FarmerID, &r.BuyerID, &r.BuyerName,
+		&r.Rating, &r.Body, pq.Array(&r.PhotoURLs), &r.Reply, &r.RepliedAt, &r.Status, &r.HiddenReason,
+		&r.ReportCount, &r.CreatedAt, &r.UpdatedAt)
+	return r, err
+}
+
+func queryReviews(db *sql.DB, query string, args ...interface{}) ([]types.Review, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying reviews: %v", err)
+	}
+	defer rows.Close()
+
+	reviews := []types.Review{}
+	for rows.Next() {
+		r, err := scanReview(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan review: %v", err)
+		}
+		reviews = append(reviews, r)
+	}
+	return reviews, rows.Err()
+}
+
+// CreateReviewInStore records the buyer's review of a delivered order, an order can only be reviewed once
+func CreateReviewInStore(db *sql.DB, buyerID int, r *types.Review) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRow(`
+		SELECT o.status, o.buyer_id, o.product_id, p.farmer_id, p.name
+		FROM orders o JOIN products p ON p.id = o.product_id
+		WHERE o.id = $1
+		FOR UPDATE OF o`, r.OrderID).
+		Scan(&status, &r.BuyerID, &r.ProductID, &r.FarmerID, &r.ProductName)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("no order found with ID %d", r.OrderID)
+		}
+		return fmt.Errorf("error querying order: %v", err)
+	}
+	if r.BuyerID != buyerID {
+		return fmt.Errorf("only the buyer of an order can review it")
+	}
+	if status != "delivered" {
+		return fmt.Errorf("an order can only be reviewed once it is delivered")
+	}
+
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM reviews WHERE order_id = $1)`, r.OrderID).Scan(&exists); err != nil {
+		return fmt.Errorf("error querying review: %v", err)
+	}
+	if exists {
+		return fmt.Errorf("order %d has already been reviewed", r.OrderID)
+	}
+
+	r.Status = StatusPublished
+	err = tx.QueryRow(`
+		INSERT INTO reviews (order_id, product_id, farmer_id, buyer_id, rating, body, photo_urls, status)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8)
+		RETURNING id, created_at, updated_at`,
+		r.OrderID, r.ProductID, r.FarmerID, r.BuyerID, r.Rating, r.Body, pq.Array(r.PhotoURLs), r.Status).
+		Scan(&r.ID, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error inserting review: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// GetReviewFromStore returns a review whatever its status
+func GetReviewFromStore(db *sql.DB, reviewID int) (types.Review, error) {
+	r, err := scanReview(db.QueryRow(`SELECT`+reviewColumns+reviewFrom+` WHERE r.id = $1`, reviewID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return r, fmt.Errorf("no review found with ID %d", reviewID)
+		}
+		return r, fmt.Errorf("error querying review: %v", err)
+	}
+	return r, nil
+}
+
+// GetProductReviewsFromStore lists the published reviews of a product, latest first
+func GetProductReviewsFromStore(db *sql.DB, productID, limit, offset int) (types.ReviewList, error) {
+	var list types.ReviewList
+	rating, err := GetProductRatingFromStore(db, productID)
+	if err != nil {
+		return list, err
+	}
+	list.Rating = rating
+
+	list.Reviews, err = queryReviews(db, `SELECT`+reviewColumns+reviewFrom+`
+		WHERE r.product_id = $1 AND r.status = $2
+		ORDER BY r.created_at DESC, r.id DESC
+		LIMIT $3 OFFSET $4`, productID, StatusPublished, limit, offset)
+	return list, err
+}
+
+// GetFarmerReviewsFromStore lists the published reviews of all of a farmer's products, latest first
+func GetFarmerReviewsFromStore(db *sql.DB, farmerID, limit, offset int) (types.ReviewList, error) {
+	var list types.ReviewList
+	rating, err := GetFarmerRatingFromStore(db, farmerID)
+	if err != nil {
+		return list, err
+	}
+	list.Rating = rating
+
+	list.Reviews, err = queryReviews(db, `SELECT`+reviewColumns+reviewFrom+`
+		WHERE r.farmer_id = $1 AND r.status = $2
+		ORDER BY r.created_at DESC, r.id DESC
+		LIMIT $3 OFFSET $4`, farmerID, StatusPublished, limit, offset)
+	return list, err
+}
+
+// GetProductRatingFromStore adds up the published reviews of a product
+func GetProductRatingFromStore(db *sql.DB, productID int) (types.RatingSummary, error) {
+	return ratingFromStore(db, `product_id`, productID)
+}
+
+// GetFarmerRatingFromStore adds up the published reviews of a farmer's products
+func GetFarmerRatingFromStore(db *sql.DB, farmerID int) (types.RatingSummary, error) {
+	return ratingFromStore(db, `farmer_id`, farmerID)
+}
+
+func ratingFromStore(db *sql.DB, column string, id int) (types.RatingSummary, error) {
+	var s types.RatingSummary
+	err := db.QueryRow(`SELECT COALESCE(ROUND(AVG(rating), 1), 0), COUNT(*) FROM reviews WHERE `+column+` = $1 AND status = $2`,
+		id, StatusPublished).Scan(&s.Average, &s.Count)
+	if err != nil {
+		return s, fmt.Errorf("error querying rating: %v", err)
+	}
+	return s, nil
+}
+
+// ReplyToReviewInStore sets the farmer's public reply to a review of their product, replying again replaces it
+func ReplyToReviewInStore(db *sql.DB, reviewID, farmerID int, reply string) error {
+	result, err := db.Exec(`
+		UPDATE reviews SET reply = $1, replied_at = NOW(), updated_at = NOW()
+		WHERE id = $2 AND farmer_id = $3`, reply, reviewID, farmerID)
+	if err != nil {
+		return fmt.Errorf("error replying to review: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("no review found with ID %d on your products", reviewID)
+	}
+	return nil
+}
+
+// ReportReviewInStore flags a review for moderation, each user can report a review once
+func ReportReviewInStore(db *sql.DB, reviewID, reporterID int, reason string) error {
+	_, err := db.Exec(`
+		INSERT INTO review_reports (review_id, reporter_id, reason)
+		SELECT id, $2, $3 FROM reviews WHERE id = $1
+		ON CONFLICT (review_id, reporter_id) DO NOTHING`, reviewID, reporterID, reason)
+	if err != nil {
+		return fmt.Errorf("error reporting review: %v", err)
+	}
+	return nil
+}
+
+// GetReportedReviewsFromStore lists reviews with reports, most reported first, for admins to moderate
+func GetReportedReviewsFromStore(db *sql.DB) ([]types.Review, error) {
+	return queryReviews(db, `SELECT`+reviewColumns+reviewFrom+`
+		WHERE EXISTS (SELECT 1 FROM review_reports rr WHERE rr.review_id = r.id AND rr.created_at > COALESCE(r.moderated_at, 'epoch'))
+		ORDER BY (SELECT COUNT(*) FROM review_reports rr WHERE rr.review_id = r.id) DESC, r.created_at`)
+}
+
+// ModerateReviewInStore hides an abusive review or publishes it again. Hidden reviews don't count towards ratings.
+func ModerateReviewInStore(db *sql.DB, reviewID, adminID int, status, reason string) error {
+	e := types.ReviewModerationEvent{ReviewID: reviewID, Status: status, Reason: reason}
+	var buyerID int
+	err := db.QueryRow(`
+		UPDATE reviews SET status = $1, hidden_reason = NULLIF($2, ''), moderated_by = $3, moderated_at = NOW(), updated_at = NOW()
+		WHERE id = $4
+		RETURNING buyer_id, product_id`, status, reason, adminID, reviewID).Scan(&buyerID, &e.ProductID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("no review found with ID %d", reviewID)
+		}
+		return fmt.Errorf("error moderating review: %v", err)
+	}
+
+	events.Publish(buyerID, events.TypeReviewModerated, e)
+	return nil
+}
@@ -0,0 +1,300 @@
+package rfq
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ritu84/agrohub/internal/notification"
+	"github.com/ritu84/agrohub/internal/product"
+	"github.com/ritu84/agrohub/types"
+	"github.com/labstack/echo/v4"
+)
+
+var frequencies = map[string]bool{"once": true, "weekly": true, "fortnightly": true, "monthly": true}
+
+// CreateRFQ posts demand for the logged in buyer and tells verified farmers in the delivery state
+func CreateRFQ(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var r types.RFQ
+		if err := c.Bind(&r); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+		r.BuyerID = userID
+
+		if r.Frequency == "" {
+			r.Frequency = "once"
+		}
+		if !frequencies[r.Frequency] {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "frequency must be one of once, weekly, fortnightly or monthly")
+		}
+		if r.Category == "" || r.Title == "" {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "category and title are required")
+		}
+		if r.QuantityKg <= 0 {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "quantity must be greater than 0")
+		}
+		if r.DeliveryAddress == "" || r.DeliveryCity == "" || r.DeliveryState == "" {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "delivery address, city and state are required")
+		}
+		if r.Deadline.Before(time.Now().Truncate(24 * time.Hour)) {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "deadline must not be in the past")
+		}
+
+		if err := CreateRFQInStore(db, &r); err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error creating rfq: %v", err))
+		}
+
+		farmers, err := GetVerifiedFarmersInStateFromStore(db, r.DeliveryState)
+		if err != nil {
+			c.Logger().Errorf("rfq %d: unable to notify farmers: %v", r.ID, err)
+		}
+		for _, farmerID := range farmers {
+			notification.Notify(db, farmerID, notification.KindRFQ, "New buyer request near you",
+				fmt.Sprintf("A buyer wants %d kg of %s (%s) delivered to %s. Quote before %s.",
+					r.QuantityKg, r.Title, r.Frequency, r.DeliveryCity, r.Deadline.Format("02 Jan 2006")))
+		}
+
+		return c.JSON(http.StatusCreated, r)
+	}
+}
+
+// hideContact drops the buyer's phone number and street address from an RFQ shown to a farmer, city, state and pin
+// code are enough to quote on it
+func hideContact(r *types.RFQ) {
+	r.BuyersPhoneNumber = 0
+	r.DeliveryAddress = ""
+}
+
+// GetRFQ shows an RFQ. Its contact details are only shown to the buyer who posted it and the farmer whose quote they
+// accepted.
+func GetRFQ(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		rfqID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing rfq id: %v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		res, err := GetRFQFromStore(db, rfqID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+
+		if userID != res.BuyerID {
+			accepted, err := HasAcceptedQuoteFromStore(db, rfqID, userID)
+			if err != nil {
+				return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching rfq: %v", err))
+			}
+			if !accepted {
+				hideContact(&res)
+			}
+		}
+
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+// GetMyRFQs lists the logged in buyer's RFQs
+func GetMyRFQs(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		res, err := GetBuyerRFQsFromStore(db, userID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching rfqs: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+// GetOpenRFQs is the verified farmer's feed of open RFQs delivering to their state, ?category= filters it
+func GetOpenRFQs(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		state, verified, err := GetFarmerRegionFromStore(db, userID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+		if !verified {
+			return echo.NewHTTPError(http.StatusForbidden, "only farmers verified by admin can see buyer requests")
+		}
+
+		res, err := GetOpenRFQsForStateFromStore(db, state, c.QueryParam("category"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching rfqs: %v", err))
+		}
+		for i := range res {
+			hideContact(&res[i])
+		}
+
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+func CloseRFQ(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		rfqID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing rfq id: %v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		if err := CloseRFQInStore(db, rfqID, userID); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error closing rfq: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "rfq closed successfully!"})
+	}
+}
+
+// SubmitQuote lets a verified farmer in the RFQ's delivery state quote from one of their listings
+func SubmitQuote(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		rfqID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing rfq id: %v", err))
+		}
+
+		var qt types.RFQQuote
+		if err := c.Bind(&qt); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+		qt.RFQID = rfqID
+		qt.FarmerID = userID
+
+		if qt.RatePerKg <= 0 || qt.QuantityKg <= 0 {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "rate_per_kg and quantity_kg must be greater than 0")
+		}
+
+		r, err := GetRFQFromStore(db, rfqID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+		if r.Status != StatusOpen || r.Deadline.Before(time.Now().Truncate(24*time.Hour)) {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "rfq is no longer taking quotes")
+		}
+		if qt.QuantityKg > r.QuantityKg {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("the buyer asked for %d kg", r.QuantityKg))
+		}
+
+		state, verified, err := GetFarmerRegionFromStore(db, userID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+		if !verified {
+			return echo.NewHTTPError(http.StatusForbidden, "only farmers verified by admin can quote")
+		}
+		if !strings.EqualFold(state, r.DeliveryState) {
+			return echo.NewHTTPError(http.StatusForbidden, "this rfq is for farmers in "+r.DeliveryState)
+		}
+
+		p, err := product.GetProductFromStore(db, qt.ProductID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, fmt.Sprintf("unable to fetch the product from store :%v", err))
+		}
+		if !product.ListedBy(c, p) {
+			return echo.NewHTTPError(http.StatusForbidden, "you can only quote from your own listings")
+		}
+		if p.Quantity < qt.QuantityKg {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "insufficient quantity available")
+		}
+
+		if err := SubmitQuoteInStore(db, &qt); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error submitting quote: %v", err))
+		}
+
+		notification.Notify(db, r.BuyerID, notification.KindRFQ, "New quote on your request",
+			fmt.Sprintf("A farmer quoted Rs %.2f/kg for %d kg of %s.", qt.RatePerKg, qt.QuantityKg, r.Title))
+
+		return c.JSON(http.StatusCreated, qt)
+	}
+}
+
+// GetQuotes lists the quotes on an RFQ for the buyer who posted it, cheapest first
+func GetQuotes(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		rfqID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing rfq id: %v", err))
+		}
+
+		r, err := GetRFQFromStore(db, rfqID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+		if userID, ok := c.Get("user_id").(int); !ok || userID != r.BuyerID {
+			return echo.NewHTTPError(http.StatusForbidden, "only the buyer who posted the rfq can see its quotes")
+		}
+
+		res, err := GetRFQQuotesFromStore(db, rfqID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching quotes: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+// AcceptQuote turns a quote into an order and closes the RFQ
+func AcceptQuote(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		rfqID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing rfq id: %v", err))
+		}
+		quoteID, err := strconv.Atoi(c.Param("quoteId"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing quote id: %v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		qt, rejected, err := AcceptQuoteInStore(db, rfqID, quoteID, userID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error accepting quote: %v", err))
+		}
+
+		notification.Notify(db, qt.FarmerID, notification.KindRFQ, "Your quote was accepted",
+			fmt.Sprintf("The buyer accepted your quote, it is now order #%d.", *qt.OrderID))
+		for _, farmerID := range rejected {
+			notification.Notify(db, farmerID, notification.KindRFQ, "Your quote was not selected",
+				"The buyer went with another quote on a request you quoted on.")
+		}
+
+		return c.JSON(http.StatusOK, qt)
+	}
+}
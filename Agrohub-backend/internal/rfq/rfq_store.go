@@ -0,0 +1,304 @@
+package rfq
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ritu84/agrohub/internal/events"
+	order "github.com/ritu84/agrohub/internal/orders"
+	"github.com/ritu84/agrohub/internal/pricing"
+	"github.com/ritu84/agrohub/types"
+)
+
+// RFQ statuses
+const (
+	StatusOpen    = "open"
+	StatusAwarded = "awarded"
+	StatusClosed  = "closed"
+)
+
+// Quote statuses
+const (
+	QuoteSubmitted = "submitted"
+	QuoteAccepted  = "accepted"
+	QuoteRejected  = "rejected"
+)
+
+const rfqColumns = `
+	r.id, r.buyer_id, r.category, r.title, r.quantity_kg, r.frequency, r.target_price_per_kg, COALESCE(r.notes, ''),
+	r.buyers_phone_number, r.delivery_address, r.delivery_city, r.delivery_state, r.delivery_address_zip,
+	r.deadline, r.status, (SELECT COUNT(*) FROM rfq_quotes q WHERE q.rfq_id = r.id), r.created_at, r.updated_at`
+
+func scanRFQ(row interface{ Scan(...interface{}) error }) (types.RFQ, error) {
+	var r types.RFQ
+	err := row.Scan(&r.ID, &r.BuyerID, &r.Category, &r.Title, &r.QuantityKg, &r.Frequency, &r.TargetPricePerKg, &r.Notes,
+		&r.BuyersPhoneNumber, &r.DeliveryAddress, &r.DeliveryCity, &r.DeliveryState, &r.DeliveryAddressZIP,
+		&r.Deadline, &r.Status, &r.QuoteCount, &r.CreatedAt, &r.UpdatedAt)
+	return r, err
+}
+
+func CreateRFQInStore(db *sql.DB, r *types.RFQ) error {
+	q := `
+	INSERT INTO rfqs (buyer_id, category, title, quantity_kg, frequency, target_price_per_kg, notes, buyers_phone_number,
+		delivery_address, delivery_city, delivery_state, delivery_address_zip, deadline)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+	RETURNING id, status, created_at, updated_at;`
+
+	err := db.QueryRow(q, r.BuyerID, r.Category, r.Title, r.QuantityKg, r.Frequency, r.TargetPricePerKg, r.Notes, r.BuyersPhoneNumber,
+		r.DeliveryAddress, r.DeliveryCity, r.DeliveryState, r.DeliveryAddressZIP, r.Deadline).
+		Scan(&r.ID, &r.Status, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error inserting rfq: %v", err)
+	}
+	return nil
+}
+
+func GetRFQFromStore(db *sql.DB, rfqID int) (types.RFQ, error) {
+	r, err := scanRFQ(db.QueryRow(`SELECT`+rfqColumns+` FROM rfqs r WHERE r.id = $1`, rfqID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return r, fmt.Errorf("no rfq found with ID %d", rfqID)
+		}
+		return r, fmt.Errorf("error querying rfq: %v", err)
+	}
+	return r, nil
+}
+
+func GetBuyerRFQsFromStore(db *sql.DB, buyerID int) ([]types.RFQ, error) {
+	return queryRFQs(db, `SELECT`+rfqColumns+` FROM rfqs r WHERE r.buyer_id = $1 ORDER BY r.created_at DESC`, buyerID)
+}
+
+// GetOpenRFQsForStateFromStore is the feed farmers quote from, RFQs past their deadline are left out
+func GetOpenRFQsForStateFromStore(db *sql.DB, state, category string) ([]types.RFQ, error) {
+	q := `SELECT` + rfqColumns + `
+	FROM rfqs r
+	WHERE r.status = $1 AND r.deadline >= CURRENT_DATE
+		AND LOWER(r.delivery_state) = LOWER($2)
+		AND ($3 = '' OR LOWER(r.category) = LOWER($3))
+	ORDER BY r.deadline;`
+
+	return queryRFQs(db, q, StatusOpen, state, category)
+}
+
+func queryRFQs(db *sql.DB, q string, args ...interface{}) ([]types.RFQ, error) {
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch rfqs: %v", err)
+	}
+	defer rows.Close()
+
+	var rfqs []types.RFQ
+	for rows.Next() {
+		r, err := scanRFQ(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan rfq: %v", err)
+		}
+		rfqs = append(rfqs, r)
+	}
+	return rfqs, nil
+}
+
+func CloseRFQInStore(db *sql.DB, rfqID, buyerID int) error {
+	result, err := db.Exec(`UPDATE rfqs SET status = $1, updated_at = NOW() WHERE id = $2 AND buyer_id = $3 AND status = $4`,
+		StatusClosed, rfqID, buyerID, StatusOpen)
+	if err != nil {
+		return fmt.Errorf("error closing rfq: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("no open rfq found with ID %d", rfqID)
+	}
+	return nil
+}
+
+// GetFarmerRegionFromStore returns the state a farmer is registered in and whether an admin verified them
+func GetFarmerRegionFromStore(db *sql.DB, farmerID int) (string, bool, error) {
+	var state string
+	var verified bool
+	err := db.QueryRow(`SELECT state, COALESCE(is_verified_by_admin, false) FROM farmers WHERE user_id = $1`, farmerID).
+		Scan(&state, &verified)
+	if err != nil {
+		return "", false, fmt.Errorf("error finding farmer: %v", err)
+	}
+	return state, verified, nil
+}
+
+func GetVerifiedFarmersInStateFromStore(db *sql.DB, state string) ([]int, error) {
+	rows, err := db.Query(`SELECT user_id FROM farmers WHERE is_verified_by_admin = true AND LOWER(state) = LOWER($1)`, state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch farmers: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan farmer: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// SubmitQuoteInStore stores a farmer's quote, quoting again on the same RFQ replaces the earlier quote
+func SubmitQuoteInStore(db *sql.DB, qt *types.RFQQuote) error {
+	q := `
+	INSERT INTO rfq_quotes (rfq_id, farmer_id, product_id, rate_per_kg, quantity_kg, delivery_date, notes)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	ON CONFLICT (rfq_id, farmer_id) DO UPDATE SET
+		product_id = EXCLUDED.product_id, rate_per_kg = EXCLUDED.rate_per_kg, quantity_kg = EXCLUDED.quantity_kg,
+		delivery_date = EXCLUDED.delivery_date, notes = EXCLUDED.notes, updated_at = NOW()
+	WHERE rfq_quotes.status = 'submitted'
+	RETURNING id, status, created_at, updated_at;`
+
+	err := db.QueryRow(q, qt.RFQID, qt.FarmerID, qt.ProductID, qt.RatePerKg, qt.QuantityKg, qt.DeliveryDate, qt.Notes).
+		Scan(&qt.ID, &qt.Status, &qt.CreatedAt, &qt.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("your quote on this rfq has already been decided")
+		}
+		return fmt.Errorf("error inserting quote: %v", err)
+	}
+	return nil
+}
+
+func GetRFQQuotesFromStore(db *sql.DB, rfqID int) ([]types.RFQQuote, error) {
+	q := `
+	SELECT q.id, q.rfq_id, q.farmer_id, u.first_name, u.last_name, q.product_id, q.rate_per_kg, q.quantity_kg,
+		q.delivery_date, COALESCE(q.notes, ''), q.status, q.order_id, q.created_at, q.updated_at
+	FROM rfq_quotes q
+	JOIN users u ON q.farmer_id = u.id
+	WHERE q.rfq_id = $1
+	ORDER BY q.rate_per_kg, q.created_at;`
+
+	rows, err := db.Query(q, rfqID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch quotes: %v", err)
+	}
+	defer rows.Close()
+
+	var quotes []types.RFQQuote
+	for rows.Next() {
+		var qt types.RFQQuote
+		if err := rows.Scan(&qt.ID, &qt.RFQID, &qt.FarmerID, &qt.FarmerFirstName, &qt.FarmerLastName, &qt.ProductID,
+			&qt.RatePerKg, &qt.QuantityKg, &qt.DeliveryDate, &qt.Notes, &qt.Status, &qt.OrderID, &qt.CreatedAt, &qt.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan quote: %v", err)
+		}
+		quotes = append(quotes, qt)
+	}
+	return quotes, nil
+}
+
+// HasAcceptedQuoteFromStore reports whether the RFQ was awarded to farmerID
+func HasAcceptedQuoteFromStore(db *sql.DB, rfqID, farmerID int) (bool, error) {
+	var accepted bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM rfq_quotes WHERE rfq_id = $1 AND farmer_id = $2 AND status = $3)`,
+		rfqID, farmerID, QuoteAccepted).Scan(&accepted)
+	if err != nil {
+		return false, fmt.Errorf("error querying quotes: %v", err)
+	}
+	return accepted, nil
+}
+
+// AcceptQuoteInStore awards the RFQ to one quote: the quote becomes an order through the order store,
+// the other quotes are rejected and the RFQ is marked awarded, all in one transaction.
+// It returns the accepted quote and the farmers whose quotes were rejected.
+func AcceptQuoteInStore(db *sql.DB, rfqID, quoteID, buyerID int) (types.RFQQuote, []int, error) {
+	var qt types.RFQQuote
+
+	tx, err := db.Begin()
+	if err != nil {
+		return qt, nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	r, err := scanRFQ(tx.QueryRow(`SELECT`+rfqColumns+` FROM rfqs r WHERE r.id = $1 FOR UPDATE`, rfqID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return qt, nil, fmt.Errorf("no rfq found with ID %d", rfqID)
+		}
+		return qt, nil, fmt.Errorf("error querying rfq: %v", err)
+	}
+	if r.BuyerID != buyerID {
+		return qt, nil, fmt.Errorf("rfq %d does not belong to you", rfqID)
+	}
+	if r.Status != StatusOpen {
+		return qt, nil, fmt.Errorf("rfq is already %s", r.Status)
+	}
+
+	err = tx.QueryRow(`
+		SELECT id, rfq_id, farmer_id, product_id, rate_per_kg, quantity_kg, delivery_date, COALESCE(notes, ''), status, created_at
+		FROM rfq_quotes WHERE id = $1 AND rfq_id = $2 FOR UPDATE`, quoteID, rfqID).
+		Scan(&qt.ID, &qt.RFQID, &qt.FarmerID, &qt.ProductID, &qt.RatePerKg, &qt.QuantityKg, &qt.DeliveryDate, &qt.Notes, &qt.Status, &qt.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return qt, nil, fmt.Errorf("no quote found with ID %d on rfq %d", quoteID, rfqID)
+		}
+		return qt, nil, fmt.Errorf("error querying quote: %v", err)
+	}
+	if qt.Status != QuoteSubmitted {
+		return qt, nil, fmt.Errorf("quote is already %s", qt.Status)
+	}
+
+	o := types.Order{
+		BuyerID:            r.BuyerID,
+		ProductID:          qt.ProductID,
+		QuantityInKg:       qt.QuantityKg,
+		TotalPrice:         pricing.Round(float64(qt.QuantityKg) * qt.RatePerKg),
+		ModeOfDelivery:     "RFQ",
+		DeliveryAddress:    r.DeliveryAddress,
+		DeliveryCity:       r.DeliveryCity,
+		DeliveryAddressZIP: r.DeliveryAddressZIP,
+		BuyersPhoneNumber:  r.BuyersPhoneNumber,
+	}
+	if qt.DeliveryDate != nil {
+		o.ExpectedDeliveryDate = *qt.DeliveryDate
+	}
+	if err := order.InsertOrderTx(tx, &o); err != nil {
+		return qt, nil, err
+	}
+	if err := order.DeductStockTx(tx, qt.ProductID, qt.QuantityKg); err != nil {
+		return qt, nil, fmt.Errorf("the farmer no longer has enough stock for this quote: %v", err)
+	}
+
+	qt.Status = QuoteAccepted
+	qt.OrderID = &o.ID
+	err = tx.QueryRow(`UPDATE rfq_quotes SET status = $1, order_id = $2, updated_at = NOW() WHERE id = $3 RETURNING updated_at`,
+		qt.Status, o.ID, qt.ID).Scan(&qt.UpdatedAt)
+	if err != nil {
+		return qt, nil, fmt.Errorf("error accepting quote: %v", err)
+	}
+
+	rows, err := tx.Query(`
+		UPDATE rfq_quotes SET status = $1, updated_at = NOW()
+		WHERE rfq_id = $2 AND id <> $3 AND status = $4
+		RETURNING farmer_id`, QuoteRejected, rfqID, qt.ID, QuoteSubmitted)
+	if err != nil {
+		return qt, nil, fmt.Errorf("error rejecting other quotes: %v", err)
+	}
+	var rejected []int
+	for rows.Next() {
+		var farmerID int
+		if err := rows.Scan(&farmerID); err != nil {
+			rows.Close()
+			return qt, nil, fmt.Errorf("failed to scan rejected quote: %v", err)
+		}
+		rejected = append(rejected, farmerID)
+	}
+	rows.Close()
+
+	if _, err := tx.Exec(`UPDATE rfqs SET status = $1, updated_at = NOW() WHERE id = $2`, StatusAwarded, rfqID); err != nil {
+		return qt, nil, fmt.Errorf("error awarding rfq: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return qt, nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+	order.PublishOrderEvent(db, o.ID, events.TypeOrderCreated)
+	return qt, rejected, nil
+}
@@ -0,0 +1,84 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Job is a piece of background work the server runs every Interval
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(db *sql.DB) error
+}
+
+// Start runs every job once right away and then on its interval until ctx is cancelled.
+// A failing run is logged and retried on the next tick, it never stops the server.
+func Start(ctx context.Context, db *sql.DB, jobs ...Job) {
+	for _, j := range jobs {
+		go run(ctx, db, j)
+	}
+}
+
+func run(ctx context.Context, db *sql.DB, j Job) {
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := j.Run(db); err != nil {
+			log.Printf("scheduler: job %s failed: %v", j.Name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DurationFromEnv reads a duration such as "30m" or "6h" from the environment, falling back to def
+func DurationFromEnv(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("scheduler: invalid %s=%q, using %v", key, v, def)
+		return def
+	}
+	return d
+}
+
+// IntFromEnv reads a non negative number from the environment, falling back to def
+func IntFromEnv(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("scheduler: invalid %s=%q, using %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+// FloatFromEnv reads a non negative decimal such as "2.5" from the environment, falling back to def
+func FloatFromEnv(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 {
+		log.Printf("scheduler: invalid %s=%q, using %g", key, v, def)
+		return def
+	}
+	return f
+}
@@ -0,0 +1,286 @@
+package shipment
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ritu84/agrohub/internal/notification"
+	order "github.com/ritu84/agrohub/internal/orders"
+	"github.com/ritu84/agrohub/types"
+	"github.com/labstack/echo/v4"
+)
+
+const maxProposedSlots = 5
+
+// parties resolves the order in the route and tells whether the logged in user is its buyer or its farmer. An order
+// waiting for, or turned down by, the buyer's organization isn't the farmer's yet, so it has no farmer.
+func parties(c echo.Context, db *sql.DB) (orderID, buyerID, farmerID, userID int, err error) {
+	orderID, err = strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, 0, 0, 0, echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing order id:%v", err))
+	}
+
+	buyerID, farmerID, err = order.GetOrderPartiesFromStore(db, orderID)
+	if err != nil {
+		return 0, 0, 0, 0, echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+	}
+	o, err := order.GetOrderFromStore(db, orderID)
+	if err != nil {
+		return 0, 0, 0, 0, echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+	}
+	if o.Status == order.StatusPendingApproval || o.Status == order.StatusRejected {
+		farmerID = 0
+	}
+
+	userID, ok := c.Get("user_id").(int)
+	if !ok {
+		return 0, 0, 0, 0, errors.New("user_id not found or invalid type")
+	}
+	return orderID, buyerID, farmerID, userID, nil
+}
+
+// ProposeSlots lets the farmer of an order offer the buyer delivery windows to pick from
+func ProposeSlots(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orderID, buyerID, farmerID, userID, err := parties(c, db)
+		if err != nil {
+			return err
+		}
+		if userID != farmerID {
+			return echo.NewHTTPError(http.StatusForbidden, "only the farmer of an order can propose delivery slots")
+		}
+
+		var req types.ProposeSlots
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if len(req.Slots) == 0 || len(req.Slots) > maxProposedSlots {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("propose between 1 and %d slots", maxProposedSlots))
+		}
+		for _, s := range req.Slots {
+			if !s.StartsAt.After(time.Now()) {
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, "slots must start in the future")
+			}
+			if !s.EndsAt.After(s.StartsAt) {
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, "a slot must end after it starts")
+			}
+		}
+
+		slots, err := ProposeSlotsInStore(db, orderID, req.Slots)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error proposing delivery slots: %v", err))
+		}
+
+		notification.Notify(db, buyerID, notification.KindShipment, "Pick a delivery slot",
+			fmt.Sprintf("The farmer proposed %d delivery slots for order #%d. Confirm the one that suits you.", len(slots), orderID))
+
+		return c.JSON(http.StatusCreated, slots)
+	}
+}
+
+// GetSlots lists the delivery slots of an order to its buyer and farmer
+func GetSlots(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orderID, buyerID, farmerID, userID, err := parties(c, db)
+		if err != nil {
+			return err
+		}
+		if userID != buyerID && userID != farmerID {
+			return echo.NewHTTPError(http.StatusForbidden, "only the buyer and farmer of an order can see its delivery slots")
+		}
+
+		slots, err := GetSlotsFromStore(db, orderID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching delivery slots: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, slots)
+	}
+}
+
+// ConfirmSlot lets the buyer of an order book one of the proposed slots, which schedules the shipment
+func ConfirmSlot(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orderID, buyerID, farmerID, userID, err := parties(c, db)
+		if err != nil {
+			return err
+		}
+		if userID != buyerID {
+			return echo.NewHTTPError(http.StatusForbidden, "only the buyer of an order can confirm a delivery slot")
+		}
+
+		slotID, err := strconv.Atoi(c.Param("slotId"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing slot id:%v", err))
+		}
+
+		s, err := ConfirmSlotInStore(db, orderID, slotID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error confirming delivery slot: %v", err))
+		}
+
+		notification.Notify(db, farmerID, notification.KindShipment, "Delivery slot confirmed",
+			fmt.Sprintf("Order #%d is to be delivered on %s between %s and %s.", orderID,
+				s.SlotStartsAt.Format("02 Jan 2006"), s.SlotStartsAt.Format("15:04"), s.SlotEndsAt.Format("15:04")))
+
+		return c.JSON(http.StatusCreated, s)
+	}
+}
+
+// GetShipment returns the shipment of an order and its tracking, the delivery OTP is only shown to the buyer
+func GetShipment(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orderID, buyerID, farmerID, userID, err := parties(c, db)
+		if err != nil {
+			return err
+		}
+		if userID != buyerID && userID != farmerID {
+			return echo.NewHTTPError(http.StatusForbidden, "only the buyer and farmer of an order can track its shipment")
+		}
+
+		s, err := GetShipmentFromStore(db, orderID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+		if userID != buyerID || s.Status == StatusDelivered {
+			s.DeliveryOTP = ""
+		}
+
+		return c.JSON(http.StatusOK, s)
+	}
+}
+
+// UpdateShipment lets the farmer set the carrier, vehicle and driver of a shipment
+func UpdateShipment(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orderID, _, farmerID, userID, err := parties(c, db)
+		if err != nil {
+			return err
+		}
+		if userID != farmerID {
+			return echo.NewHTTPError(http.StatusForbidden, "only the farmer of an order can update its shipment")
+		}
+
+		var req types.UpdateShipment
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if req.DriverPhone == "" {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "driver_phone is required")
+		}
+
+		if err := UpdateShipmentInStore(db, orderID, req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error updating shipment: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "shipment updated successfully!"})
+	}
+}
+
+// DispatchShipment records the shipment leaving the farm and reminds the buyer of their delivery OTP
+func DispatchShipment(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orderID, buyerID, farmerID, userID, err := parties(c, db)
+		if err != nil {
+			return err
+		}
+		if userID != farmerID {
+			return echo.NewHTTPError(http.StatusForbidden, "only the farmer of an order can dispatch it")
+		}
+
+		s, err := DispatchShipmentInStore(db, orderID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error dispatching shipment: %v", err))
+		}
+
+		notification.Notify(db, buyerID, notification.KindShipment, "Your order is on its way",
+			fmt.Sprintf("Order #%d has been dispatched, driver %s. Share OTP %s with the driver only once you have the goods.",
+				orderID, s.DriverPhone, s.DeliveryOTP))
+
+		s.DeliveryOTP = ""
+		return c.JSON(http.StatusOK, s)
+	}
+}
+
+// AddTrackingEvent lets the farmer post a tracking update on a shipment on its way
+func AddTrackingEvent(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orderID, _, farmerID, userID, err := parties(c, db)
+		if err != nil {
+			return err
+		}
+		if userID != farmerID {
+			return echo.NewHTTPError(http.StatusForbidden, "only the farmer of an order can update its tracking")
+		}
+
+		var e types.ShipmentEvent
+		if err := c.Bind(&e); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if e.Status == "" {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "status is required")
+		}
+
+		if err := AddTrackingEventInStore(db, orderID, &e); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error adding tracking event: %v", err))
+		}
+
+		return c.JSON(http.StatusCreated, e)
+	}
+}
+
+// ResetDeliveryOTP gives the buyer of an order a new delivery OTP
+func ResetDeliveryOTP(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orderID, buyerID, _, userID, err := parties(c, db)
+		if err != nil {
+			return err
+		}
+		if userID != buyerID {
+			return echo.NewHTTPError(http.StatusForbidden, "only the buyer of an order can get a new delivery OTP")
+		}
+
+		otp, err := ResetDeliveryOTPInStore(db, orderID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error generating delivery otp: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"delivery_otp": otp})
+	}
+}
+
+// DeliverShipment marks an order delivered with proof of delivery, the OTP from the buyer and a photo of the handover
+func DeliverShipment(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orderID, buyerID, farmerID, userID, err := parties(c, db)
+		if err != nil {
+			return err
+		}
+		if userID != farmerID {
+			return echo.NewHTTPError(http.StatusForbidden, "only the farmer of an order can mark it delivered")
+		}
+
+		var req types.ConfirmDelivery
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+		if req.OTP == "" || req.PhotoURL == "" {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "otp and photo_url are required")
+		}
+
+		s, err := DeliverShipmentInStore(db, orderID, req)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error delivering shipment: %v", err))
+		}
+
+		notification.Notify(db, buyerID, notification.KindShipment, "Order delivered",
+			fmt.Sprintf("Order #%d has been delivered. Its invoice is ready to download.", orderID))
+
+		s.DeliveryOTP = ""
+		return c.JSON(http.StatusOK, s)
+	}
+}
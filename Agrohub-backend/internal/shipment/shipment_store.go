@@ -0,0 +1,422 @@
+package shipment
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/ritu84/agrohub/internal/auth"
+	"github.com/ritu84/agrohub/internal/events"
+	order "github.com/ritu84/agrohub/internal/orders"
+	"github.com/ritu84/agrohub/internal/referral"
+	"github.com/ritu84/agrohub/types"
+)
+
+const (
+	SlotProposed  = "proposed"
+	SlotConfirmed = "confirmed"
+	SlotDeclined  = "declined"
+	SlotWithdrawn = "withdrawn"
+
+	StatusScheduled  = "scheduled"
+	StatusDispatched = "dispatched"
+	StatusDelivered  = "delivered"
+	StatusCancelled  = "cancelled" // the order was cancelled or refunded before it was dispatched
+)
+
+// MaxOTPAttempts wrong delivery OTPs lock the shipment until the buyer generates a new one
+const MaxOTPAttempts = 5
+
+// Orders in these states have been paid for, or will be paid on delivery, and haven't left the farm yet. Only they
+// can be scheduled and dispatched.
+var readyOrderStatuses = []string{"processing", "approved"}
+
+const shipmentColumns = `
+	s.id, s.order_id, s.slot_id, ds.starts_at, ds.ends_at, s.status, s.carrier, s.vehicle_number, s.driver_phone,
+	s.dispatched_at, s.delivered_at, COALESCE(s.proof_photo_url, ''), s.delivery_otp, s.created_at, s.updated_at`
+
+const shipmentFrom = `
+	FROM shipments s
+	JOIN delivery_slots ds ON ds.id = s.slot_id`
+
+func scanShipment(row interface{ Scan(...interface{}) error }, extra ...interface{}) (types.Shipment, error) {
+	var s types.Shipment
+	dest := []interface{}{&s.ID, &s.OrderID, &s.SlotID, &s.SlotStartsAt, &s.SlotEndsAt, &s.Status, &s.Carrier, &s.VehicleNumber, &s.DriverPhone,
+		&s.DispatchedAt, &s.DeliveredAt, &s.ProofPhotoURL, &s.DeliveryOTP, &s.CreatedAt, &s.UpdatedAt}
+	err := row.Scan(append(dest, extra...)...)
+	return s, err
+}
+
+// lockOrderStatusTx locks an order and makes sure it is in one of the allowed states, orders are always locked before
+// their shipment
+func lockOrderStatusTx(tx *sql.Tx, orderID int, allowed ...string) error {
+	var status string
+	err := tx.QueryRow(`SELECT status FROM orders WHERE id = $1 FOR UPDATE`, orderID).Scan(&status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("no order found with ID %d", orderID)
+		}
+		return fmt.Errorf("error querying order: %v", err)
+	}
+	for _, a := range allowed {
+		if status == a {
+			return nil
+		}
+	}
+	switch status {
+	case order.StatusPendingApproval:
+		return fmt.Errorf("order is waiting for approval by the buyer's organization")
+	case order.StatusRejected:
+		return fmt.Errorf("order was rejected by the buyer's organization")
+	}
+	return fmt.Errorf("order is %s, it has to be %s", status, strings.Join(allowed, " or "))
+}
+
+// lockOrderTx locks an order and makes sure it can still be scheduled
+func lockOrderTx(tx *sql.Tx, orderID int) error {
+	if err := lockOrderStatusTx(tx, orderID, readyOrderStatuses...); err != nil {
+		return err
+	}
+
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM shipments WHERE order_id = $1)`, orderID).Scan(&exists); err != nil {
+		return fmt.Errorf("error querying shipment: %v", err)
+	}
+	if exists {
+		return fmt.Errorf("a delivery slot has already been confirmed for order %d", orderID)
+	}
+	return nil
+}
+
+func insertEventTx(tx *sql.Tx, shipmentID int, status, location, note string) error {
+	_, err := tx.Exec(`INSERT INTO shipment_events (shipment_id, status, location, note) VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''))`,
+		shipmentID, status, location, note)
+	if err != nil {
+		return fmt.Errorf("error inserting shipment event: %v", err)
+	}
+	return nil
+}
+
+// lockShipmentTx locks the shipment of an order
+func lockShipmentTx(tx *sql.Tx, orderID int) (types.Shipment, error) {
+	s, err := scanShipment(tx.QueryRow(`SELECT`+shipmentColumns+shipmentFrom+` WHERE s.order_id = $1 FOR UPDATE OF s`, orderID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return s, fmt.Errorf("order %d has no confirmed delivery slot yet", orderID)
+		}
+		return s, fmt.Errorf("error querying shipment: %v", err)
+	}
+	return s, nil
+}
+
+// ProposeSlotsInStore offers the buyer new delivery windows for an order, replacing any they haven't answered yet
+func ProposeSlotsInStore(db *sql.DB, orderID int, slots []types.DeliverySlot) ([]types.DeliverySlot, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockOrderTx(tx, orderID); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`UPDATE delivery_slots SET status = $1 WHERE order_id = $2 AND status = $3`,
+		SlotWithdrawn, orderID, SlotProposed); err != nil {
+		return nil, fmt.Errorf("error withdrawing earlier slots: %v", err)
+	}
+
+	for i := range slots {
+		slots[i].OrderID = orderID
+		slots[i].Status = SlotProposed
+		err := tx.QueryRow(`
+			INSERT INTO delivery_slots (order_id, starts_at, ends_at, status)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id, created_at`, orderID, slots[i].StartsAt, slots[i].EndsAt, SlotProposed).
+			Scan(&slots[i].ID, &slots[i].CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error inserting delivery slot: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return slots, nil
+}
+
+// GetSlotsFromStore lists the delivery slots proposed for an order, soonest first
+func GetSlotsFromStore(db *sql.DB, orderID int) ([]types.DeliverySlot, error) {
+	rows, err := db.Query(`
+		SELECT id, order_id, starts_at, ends_at, status, created_at
+		FROM delivery_slots WHERE order_id = $1
+		ORDER BY starts_at, id`, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying delivery slots: %v", err)
+	}
+	defer rows.Close()
+
+	slots := []types.DeliverySlot{}
+	for rows.Next() {
+		var ds types.DeliverySlot
+		if err := rows.Scan(&ds.ID, &ds.OrderID, &ds.StartsAt, &ds.EndsAt, &ds.Status, &ds.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan delivery slot: %v", err)
+		}
+		slots = append(slots, ds)
+	}
+	return slots, rows.Err()
+}
+
+// ConfirmSlotInStore books one of the proposed slots and opens the order's shipment. The other slots are declined,
+// the order's expected delivery date becomes the slot's and the buyer gets the OTP that proves delivery.
+func ConfirmSlotInStore(db *sql.DB, orderID, slotID int) (types.Shipment, error) {
+	var s types.Shipment
+
+	tx, err := db.Begin()
+	if err != nil {
+		return s, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockOrderTx(tx, orderID); err != nil {
+		return s, err
+	}
+
+	var status string
+	var future bool
+	err = tx.QueryRow(`SELECT status, starts_at > NOW() FROM delivery_slots WHERE id = $1 AND order_id = $2`, slotID, orderID).
+		Scan(&status, &future)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return s, fmt.Errorf("no delivery slot %d on order %d", slotID, orderID)
+		}
+		return s, fmt.Errorf("error querying delivery slot: %v", err)
+	}
+	if status != SlotProposed {
+		return s, fmt.Errorf("delivery slot is %s", status)
+	}
+	if !future {
+		return s, fmt.Errorf("delivery slot has already started, ask the farmer for another one")
+	}
+
+	if _, err := tx.Exec(`UPDATE delivery_slots SET status = CASE WHEN id = $1 THEN $2 ELSE $3 END WHERE order_id = $4 AND status = $5`,
+		slotID, SlotConfirmed, SlotDeclined, orderID, SlotProposed); err != nil {
+		return s, fmt.Errorf("error confirming delivery slot: %v", err)
+	}
+	if _, err := tx.Exec(`
+		UPDATE orders SET expected_delivery_date = (SELECT starts_at::date FROM delivery_slots WHERE id = $1), updated_at = NOW()
+		WHERE id = $2`, slotID, orderID); err != nil {
+		return s, fmt.Errorf("error updating expected delivery date: %v", err)
+	}
+
+	var shipmentID int
+	err = tx.QueryRow(`
+		INSERT INTO shipments (order_id, slot_id, status, delivery_otp)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id`, orderID, slotID, StatusScheduled, authy.GenerateOTP()).Scan(&shipmentID)
+	if err != nil {
+		return s, fmt.Errorf("error inserting shipment: %v", err)
+	}
+	if err := insertEventTx(tx, shipmentID, StatusScheduled, "", ""); err != nil {
+		return s, err
+	}
+
+	if s, err = lockShipmentTx(tx, orderID); err != nil {
+		return s, err
+	}
+	if err := tx.Commit(); err != nil {
+		return s, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return s, nil
+}
+
+// GetShipmentFromStore returns the shipment of an order with its tracking events
+func GetShipmentFromStore(db *sql.DB, orderID int) (types.Shipment, error) {
+	s, err := scanShipment(db.QueryRow(`SELECT`+shipmentColumns+shipmentFrom+` WHERE s.order_id = $1`, orderID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return s, fmt.Errorf("order %d has no confirmed delivery slot yet", orderID)
+		}
+		return s, fmt.Errorf("error querying shipment: %v", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT id, shipment_id, status, COALESCE(location, ''), COALESCE(note, ''), created_at
+		FROM shipment_events WHERE shipment_id = $1 ORDER BY created_at, id`, s.ID)
+	if err != nil {
+		return s, fmt.Errorf("error querying shipment events: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e types.ShipmentEvent
+		if err := rows.Scan(&e.ID, &e.ShipmentID, &e.Status, &e.Location, &e.Note, &e.CreatedAt); err != nil {
+			return s, fmt.Errorf("failed to scan shipment event: %v", err)
+		}
+		s.Events = append(s.Events, e)
+	}
+	return s, rows.Err()
+}
+
+// UpdateShipmentInStore sets the carrier, vehicle and driver of a shipment that hasn't been delivered
+func UpdateShipmentInStore(db *sql.DB, orderID int, u types.UpdateShipment) error {
+	result, err := db.Exec(`
+		UPDATE shipments SET carrier = $1, vehicle_number = $2, driver_phone = $3, updated_at = NOW()
+		WHERE order_id = $4 AND status != $5`,
+		u.Carrier, u.VehicleNumber, u.DriverPhone, orderID, StatusDelivered)
+	if err != nil {
+		return fmt.Errorf("error updating shipment: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("order %d has no shipment on its way", orderID)
+	}
+	return nil
+}
+
+// DispatchShipmentInStore records the shipment leaving the farm, the order moves to shipped
+func DispatchShipmentInStore(db *sql.DB, orderID int) (types.Shipment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return types.Shipment{}, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockOrderStatusTx(tx, orderID, readyOrderStatuses...); err != nil {
+		return types.Shipment{}, err
+	}
+	s, err := lockShipmentTx(tx, orderID)
+	if err != nil {
+		return s, err
+	}
+	if s.Status != StatusScheduled {
+		return s, fmt.Errorf("shipment is already %s", s.Status)
+	}
+	if s.DriverPhone == "" {
+		return s, fmt.Errorf("set the driver's phone number before dispatching")
+	}
+
+	err = tx.QueryRow(`UPDATE shipments SET status = $1, dispatched_at = NOW(), updated_at = NOW() WHERE id = $2 RETURNING dispatched_at, updated_at`,
+		StatusDispatched, s.ID).Scan(&s.DispatchedAt, &s.UpdatedAt)
+	if err != nil {
+		return s, fmt.Errorf("error dispatching shipment: %v", err)
+	}
+	s.Status = StatusDispatched
+	if err := insertEventTx(tx, s.ID, StatusDispatched, "", ""); err != nil {
+		return s, err
+	}
+	if _, err := tx.Exec(`UPDATE orders SET status = 'shipped', updated_at = NOW() WHERE id = $1`, orderID); err != nil {
+		return s, fmt.Errorf("error updating order status: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return s, fmt.Errorf("error committing transaction: %v", err)
+	}
+	order.PublishOrderEvent(db, orderID, events.TypeOrderStatus)
+	return s, nil
+}
+
+// AddTrackingEventInStore adds a tracking update to a shipment on its way
+func AddTrackingEventInStore(db *sql.DB, orderID int, e *types.ShipmentEvent) error {
+	err := db.QueryRow(`
+		INSERT INTO shipment_events (shipment_id, status, location, note)
+		SELECT id, $2, NULLIF($3, ''), NULLIF($4, '') FROM shipments WHERE order_id = $1 AND status = $5
+		RETURNING id, shipment_id, created_at`, orderID, e.Status, e.Location, e.Note, StatusDispatched).
+		Scan(&e.ID, &e.ShipmentID, &e.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("order %d has no shipment on its way", orderID)
+		}
+		return fmt.Errorf("error inserting shipment event: %v", err)
+	}
+	return nil
+}
+
+// ResetDeliveryOTPInStore gives the buyer a new delivery OTP, e.g. after the driver got it wrong too many times
+func ResetDeliveryOTPInStore(db *sql.DB, orderID int) (string, error) {
+	otp := authy.GenerateOTP()
+	result, err := db.Exec(`
+		UPDATE shipments SET delivery_otp = $1, otp_attempts = 0, updated_at = NOW()
+		WHERE order_id = $2 AND status != $3`, otp, orderID, StatusDelivered)
+	if err != nil {
+		return "", fmt.Errorf("error updating delivery otp: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return "", fmt.Errorf("error getting rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return "", fmt.Errorf("order %d has no shipment on its way", orderID)
+	}
+	return otp, nil
+}
+
+// DeliverShipmentInStore completes a dispatched shipment against the OTP the buyer shared and a photo of the handover.
+// The order is marked delivered, which books the farmer's earnings. Wrong OTPs are counted even though the delivery fails.
+func DeliverShipmentInStore(db *sql.DB, orderID int, proof types.ConfirmDelivery) (types.Shipment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return types.Shipment{}, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockOrderStatusTx(tx, orderID, "shipped"); err != nil {
+		return types.Shipment{}, err
+	}
+	s, err := lockShipmentTx(tx, orderID)
+	if err != nil {
+		return s, err
+	}
+	if s.Status != StatusDispatched {
+		return s, fmt.Errorf("shipment is %s, only dispatched shipments can be delivered", s.Status)
+	}
+
+	var attempts int
+	if err := tx.QueryRow(`SELECT otp_attempts FROM shipments WHERE id = $1`, s.ID).Scan(&attempts); err != nil {
+		return s, fmt.Errorf("error querying shipment: %v", err)
+	}
+	if attempts >= MaxOTPAttempts {
+		return s, fmt.Errorf("too many wrong OTPs, the buyer has to generate a new one")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(proof.OTP), []byte(s.DeliveryOTP)) != 1 {
+		if _, err := tx.Exec(`UPDATE shipments SET otp_attempts = otp_attempts + 1 WHERE id = $1`, s.ID); err != nil {
+			return s, fmt.Errorf("error updating shipment: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return s, fmt.Errorf("error committing transaction: %v", err)
+		}
+		return s, fmt.Errorf("wrong delivery OTP, %d attempts left", MaxOTPAttempts-attempts-1)
+	}
+
+	err = tx.QueryRow(`
+		UPDATE shipments SET status = $1, proof_photo_url = $2, delivered_at = NOW(), updated_at = NOW()
+		WHERE id = $3
+		RETURNING delivered_at, updated_at`, StatusDelivered, proof.PhotoURL, s.ID).Scan(&s.DeliveredAt, &s.UpdatedAt)
+	if err != nil {
+		return s, fmt.Errorf("error delivering shipment: %v", err)
+	}
+	s.Status = StatusDelivered
+	s.ProofPhotoURL = proof.PhotoURL
+	if err := insertEventTx(tx, s.ID, StatusDelivered, "", ""); err != nil {
+		return s, err
+	}
+
+	if err := order.MarkDeliveredTx(tx, orderID); err != nil {
+		return s, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return s, fmt.Errorf("error committing transaction: %v", err)
+	}
+	order.PublishOrderEvent(db, orderID, events.TypeOrderStatus)
+	// a buyer's first delivered order rewards whoever referred them
+	referral.OrderDelivered(db, orderID)
+	return s, nil
+}
@@ -0,0 +1,251 @@
+package subscription
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ritu84/agrohub/internal/address"
+	"github.com/ritu84/agrohub/internal/pricing"
+	"github.com/ritu84/agrohub/internal/product"
+	"github.com/ritu84/agrohub/types"
+	"github.com/labstack/echo/v4"
+)
+
+var frequencies = map[string]bool{FrequencyWeekly: true, FrequencyFortnightly: true, FrequencyMonthly: true}
+
+// currentRate is what qty of the subscription's product costs per kg today, it checks the quantity fits the
+// product's order limits
+func currentRate(db *sql.DB, s types.Subscription) (float64, error) {
+	p, err := subscribedProduct(db, s)
+	if err != nil {
+		return 0, err
+	}
+	quote, err := pricing.Calculate(p, s.QuantityInKg)
+	if err != nil {
+		return 0, err
+	}
+	return quote.RatePerKg, nil
+}
+
+// tomorrow is the earliest a new or resumed subscription can deliver
+func tomorrow() time.Time {
+	return time.Now().AddDate(0, 0, 1)
+}
+
+// CreateSubscription subscribes the logged in buyer to a product, or to a category from one farmer, at today's rate
+func CreateSubscription(db *sql.DB, cfg Config) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req types.CreateSubscription
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		s := types.Subscription{
+			BuyerID:           userID,
+			FarmerID:          req.FarmerID,
+			Category:          strings.ToLower(strings.TrimSpace(req.Category)),
+			QuantityInKg:      req.QuantityInKg,
+			Frequency:         req.Frequency,
+			DeliveryDay:       req.DeliveryDay,
+			PriceTolerancePct: cfg.DefaultTolerancePct,
+			AddressID:         req.AddressID,
+			ModeOfDelivery:    req.ModeOfDelivery,
+			BuyersPhoneNumber: req.BuyersPhoneNumber,
+		}
+
+		if req.ProductID != 0 {
+			p, err := product.GetProductFromStore(db, req.ProductID)
+			if err != nil {
+				return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+			}
+			s.ProductID, s.FarmerID, s.Category = &p.ID, p.FarmerID, ""
+		} else if s.FarmerID == 0 || s.Category == "" {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "subscribe to a product_id, or to a category from a farmer_id")
+		}
+		if s.FarmerID == userID {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "you can't subscribe to your own produce")
+		}
+
+		if s.QuantityInKg <= 0 {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "quantity_in_kg must be greater than 0")
+		}
+		if !frequencies[s.Frequency] {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "frequency must be weekly, fortnightly or monthly")
+		}
+		if s.Frequency == FrequencyMonthly && (s.DeliveryDay < 1 || s.DeliveryDay > 28) {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "delivery_day must be a day of the month from 1 to 28")
+		}
+		if s.Frequency != FrequencyMonthly && (s.DeliveryDay < 0 || s.DeliveryDay > 6) {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "delivery_day must be a weekday from 0 (Sunday) to 6 (Saturday)")
+		}
+		if req.PriceTolerancePct != nil {
+			if *req.PriceTolerancePct < 0 || *req.PriceTolerancePct > 100 {
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, "price_tolerance_pct must be between 0 and 100")
+			}
+			s.PriceTolerancePct = *req.PriceTolerancePct
+		}
+
+		// the address is looked up again for every delivery, this only checks there is one
+		addressID := 0
+		if s.AddressID != nil {
+			addressID = *s.AddressID
+		}
+		if _, err := address.GetUserAddressFromStore(db, userID, addressID); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, err.Error())
+		}
+
+		rate, err := currentRate(db, s)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error pricing subscription: %v", err))
+		}
+		s.RatePerKg = rate
+
+		start := tomorrow()
+		if req.StartDate != nil && req.StartDate.After(start) {
+			start = *req.StartDate
+		}
+		s.NextDeliveryDate = FirstDelivery(start, s.Frequency, s.DeliveryDay)
+
+		if err := CreateSubscriptionInStore(db, &s); err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error creating subscription: %v", err))
+		}
+
+		return c.JSON(http.StatusCreated, s)
+	}
+}
+
+// GetSubscriptions lists the logged in user's subscriptions, as a buyer or as the farmer supplying them
+func GetSubscriptions(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		subscriptions, err := GetSubscriptionsFromStore(db, userID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching subscriptions: %v", err))
+		}
+		return c.JSON(http.StatusOK, subscriptions)
+	}
+}
+
+// GetSubscription returns a subscription with its recent deliveries, to its buyer and farmer
+func GetSubscription(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		subscriptionID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing subscription id:%v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		s, err := GetSubscriptionFromStore(db, subscriptionID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+		if userID != s.BuyerID && userID != s.FarmerID {
+			return echo.NewHTTPError(http.StatusForbidden, "you are not part of this subscription")
+		}
+
+		return c.JSON(http.StatusOK, s)
+	}
+}
+
+// PauseSubscription stops placing orders until the buyer resumes
+func PauseSubscription(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		subscriptionID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing subscription id:%v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		if err := PauseSubscriptionInStore(db, subscriptionID, userID); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error pausing subscription: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "subscription paused successfully!"})
+	}
+}
+
+// ResumeSubscription restarts a subscription from its next delivery day at today's rate
+func ResumeSubscription(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		subscriptionID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing subscription id:%v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		s, err := GetSubscriptionFromStore(db, subscriptionID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+		if userID != s.BuyerID {
+			return echo.NewHTTPError(http.StatusForbidden, "only the buyer can resume a subscription")
+		}
+
+		rate, err := currentRate(db, s)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error pricing subscription: %v", err))
+		}
+
+		// an active subscription keeps its next delivery, a paused one picks up from tomorrow
+		next := FirstDelivery(tomorrow(), s.Frequency, s.DeliveryDay)
+		if s.Status == StatusActive && s.NextDeliveryDate.After(next) {
+			next = s.NextDeliveryDate
+		}
+		if err := ResumeSubscriptionInStore(db, subscriptionID, userID, next, rate); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error resuming subscription: %v", err))
+		}
+
+		s, err = GetSubscriptionFromStore(db, subscriptionID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, err.Error())
+		}
+		return c.JSON(http.StatusOK, s)
+	}
+}
+
+// CancelSubscription ends a subscription, orders it already placed are not cancelled
+func CancelSubscription(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		subscriptionID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing subscription id:%v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		if err := CancelSubscriptionInStore(db, subscriptionID, userID); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error cancelling subscription: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "subscription cancelled successfully!"})
+	}
+}
@@ -0,0 +1,142 @@
+package subscription
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ritu84/agrohub/internal/address"
+	"github.com/ritu84/agrohub/internal/notification"
+	order "github.com/ritu84/agrohub/internal/orders"
+	"github.com/ritu84/agrohub/internal/pricing"
+	"github.com/ritu84/agrohub/internal/product"
+	"github.com/ritu84/agrohub/internal/scheduler"
+	"github.com/ritu84/agrohub/types"
+)
+
+// Config controls when subscription orders are placed
+type Config struct {
+	LeadDays            int     // days before the delivery date its order is placed
+	DefaultTolerancePct float64 // how far the rate may rise over the agreed one before a delivery is skipped
+	Interval            time.Duration
+}
+
+// ConfigFromEnv reads SUBSCRIPTION_LEAD_DAYS (default 2), SUBSCRIPTION_PRICE_TOLERANCE_PCT (default 10)
+// and SUBSCRIPTION_JOBS_INTERVAL (default 1h)
+func ConfigFromEnv() Config {
+	return Config{
+		LeadDays:            scheduler.IntFromEnv("SUBSCRIPTION_LEAD_DAYS", 2),
+		DefaultTolerancePct: float64(scheduler.IntFromEnv("SUBSCRIPTION_PRICE_TOLERANCE_PCT", 10)),
+		Interval:            scheduler.DurationFromEnv("SUBSCRIPTION_JOBS_INTERVAL", time.Hour),
+	}
+}
+
+func Jobs(cfg Config) []scheduler.Job {
+	return []scheduler.Job{
+		{Name: "place-subscription-orders", Interval: cfg.Interval, Run: func(db *sql.DB) error { return PlaceDueOrders(db, cfg) }},
+	}
+}
+
+// PlaceDueOrders places the order of every subscription delivering within LeadDays, or skips it and tells the buyer why
+func PlaceDueOrders(db *sql.DB, cfg Config) error {
+	ids, err := GetDueSubscriptionIDsFromStore(db, cfg.LeadDays)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		// a subscription more than one delivery behind, after downtime, catches up one run per pass
+		s, run, ok, err := ClaimRunInStore(db, id, cfg.LeadDays)
+		if err != nil {
+			// one bad subscription shouldn't hold up the rest
+			log.Printf("subscription %d: %v", id, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		orderID, reason := place(db, s, run.DeliveryDate)
+		if reason == "" {
+			run.Status, run.OrderID = RunOrdered, &orderID
+		} else {
+			run.Status, run.Reason = RunSkipped, reason
+		}
+		if err := FinishRunInStore(db, run); err != nil {
+			log.Printf("subscription %d: %v", id, err)
+		}
+
+		date := run.DeliveryDate.Format("Mon 2 Jan")
+		if reason == "" {
+			notification.Notify(db, s.BuyerID, notification.KindSubscription, "Subscription order placed",
+				fmt.Sprintf("Order #%d for %d kg is placed for delivery on %s. Pay for it in the app to confirm it.", orderID, s.QuantityInKg, date))
+		} else {
+			notification.Notify(db, s.BuyerID, notification.KindSubscription, "Subscription delivery skipped",
+				fmt.Sprintf("Your delivery of %d kg on %s was skipped: %s.", s.QuantityInKg, date, reason))
+		}
+	}
+	return nil
+}
+
+// subscribedProduct is the product a delivery is taken from
+func subscribedProduct(db *sql.DB, s types.Subscription) (types.Product, error) {
+	if s.ProductID != nil {
+		return product.GetProductFromStore(db, *s.ProductID)
+	}
+	id, err := CheapestProductFromStore(db, s.FarmerID, s.Category, s.QuantityInKg)
+	if err != nil {
+		return types.Product{}, err
+	}
+	return product.GetProductFromStore(db, id)
+}
+
+// place orders one delivery through the normal order store. It returns why the delivery was skipped, or the order ID.
+func place(db *sql.DB, s types.Subscription, deliveryDate time.Time) (int, string) {
+	if deliveryDate.Before(time.Now().Truncate(24 * time.Hour)) {
+		return 0, "the delivery date had already passed"
+	}
+
+	p, err := subscribedProduct(db, s)
+	if err != nil {
+		return 0, err.Error()
+	}
+	if !p.IsAvailable {
+		return 0, fmt.Sprintf("%s is not available", p.Name)
+	}
+	if p.Quantity < s.QuantityInKg {
+		return 0, fmt.Sprintf("%s only has %d kg in stock", p.Name, p.Quantity)
+	}
+
+	quote, err := pricing.Calculate(p, s.QuantityInKg)
+	if err != nil {
+		return 0, err.Error()
+	}
+	if limit := pricing.Round(s.RatePerKg * (1 + s.PriceTolerancePct/100)); quote.RatePerKg > limit {
+		return 0, fmt.Sprintf("%s went up from Rs %.2f/kg to Rs %.2f/kg, more than your %.0f%% tolerance. Resume the subscription to accept the new rate",
+			p.Name, s.RatePerKg, quote.RatePerKg, s.PriceTolerancePct)
+	}
+
+	addressID := 0
+	if s.AddressID != nil {
+		addressID = *s.AddressID
+	}
+	a, err := address.GetUserAddressFromStore(db, s.BuyerID, addressID)
+	if err != nil {
+		return 0, err.Error()
+	}
+
+	o := types.Order{
+		BuyerID:              s.BuyerID,
+		ProductID:            p.ID,
+		QuantityInKg:         s.QuantityInKg,
+		ModeOfDelivery:       s.ModeOfDelivery,
+		ExpectedDeliveryDate: deliveryDate,
+		BuyersPhoneNumber:    s.BuyersPhoneNumber,
+	}
+	order.SnapshotAddress(&o, a)
+	if err := order.CreateOrderInStore(db, &o); err != nil {
+		return 0, err.Error()
+	}
+	return o.ID, ""
+}
@@ -0,0 +1,262 @@
+package subscription
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ritu84/agrohub/types"
+)
+
+const (
+	StatusActive    = "active"
+	StatusPaused    = "paused"
+	StatusCancelled = "cancelled"
+
+	RunPending = "pending" // claimed by the scheduler, being placed
+	RunOrdered = "ordered"
+	RunSkipped = "skipped"
+
+	FrequencyWeekly      = "weekly"
+	FrequencyFortnightly = "fortnightly"
+	FrequencyMonthly     = "monthly"
+)
+
+const subscriptionColumns = `
+	s.id, s.buyer_id, s.farmer_id, s.product_id, COALESCE(p.name, ''), COALESCE(s.category, ''), s.quantity_in_kg,
+	s.frequency, s.delivery_day, s.rate_per_kg, s.price_tolerance_pct, s.address_id, COALESCE(s.mode_of_delivery, ''),
+	s.buyers_phone_number, s.status, s.next_delivery_date, s.created_at, s.updated_at`
+
+const subscriptionFrom = `
+	FROM subscriptions s
+	LEFT JOIN products p ON p.id = s.product_id`
+
+func scanSubscription(row interface{ Scan(...interface{}) error }) (types.Subscription, error) {
+	var s types.Subscription
+	err := row.Scan(&s.ID, &s.BuyerID, &s.FarmerID, &s.ProductID, &s.ProductName, &s.Category, &s.QuantityInKg,
+		&s.Frequency, &s.DeliveryDay, &s.RatePerKg, &s.PriceTolerancePct, &s.AddressID, &s.ModeOfDelivery,
+		&s.BuyersPhoneNumber, &s.Status, &s.NextDeliveryDate, &s.CreatedAt, &s.UpdatedAt)
+	return s, err
+}
+
+// FirstDelivery is the first date on or after from that falls on the subscription's delivery day
+func FirstDelivery(from time.Time, frequency string, day int) time.Time {
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	if frequency == FrequencyMonthly {
+		d := time.Date(from.Year(), from.Month(), day, 0, 0, 0, 0, time.UTC)
+		if d.Before(from) {
+			d = d.AddDate(0, 1, 0)
+		}
+		return d
+	}
+	return from.AddDate(0, 0, (day-int(from.Weekday())+7)%7)
+}
+
+// nextDelivery is the delivery after date
+func nextDelivery(date time.Time, frequency string) time.Time {
+	switch frequency {
+	case FrequencyMonthly:
+		return date.AddDate(0, 1, 0) // delivery days stop at 28 so this never spills into the next month
+	case FrequencyFortnightly:
+		return date.AddDate(0, 0, 14)
+	}
+	return date.AddDate(0, 0, 7)
+}
+
+// CheapestProductFromStore returns the farmer's cheapest live product of a category with at least qty kg in stock
+func CheapestProductFromStore(db *sql.DB, farmerID int, category string, qty int) (int, error) {
+	var productID int
+	err := db.QueryRow(`
+		SELECT id FROM products
+		WHERE farmer_id = $1 AND LOWER(type) = LOWER($2) AND is_verified_by_admin = true AND is_available = true
+			AND quantity_in_kg >= $3
+		ORDER BY rate_per_kg, id
+		LIMIT 1`, farmerID, category, qty).Scan(&productID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("the farmer has no %s with %d kg in stock", category, qty)
+		}
+		return 0, fmt.Errorf("error querying products: %v", err)
+	}
+	return productID, nil
+}
+
+// CreateSubscriptionInStore saves a new active subscription
+func CreateSubscriptionInStore(db *sql.DB, s *types.Subscription) error {
+	s.Status = StatusActive
+	err := db.QueryRow(`
+		INSERT INTO subscriptions (buyer_id, farmer_id, product_id, category, quantity_in_kg, frequency, delivery_day,
+			rate_per_kg, price_tolerance_pct, address_id, mode_of_delivery, buyers_phone_number, status, next_delivery_date)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, $9, $10, NULLIF($11, ''), $12, $13, $14)
+		RETURNING id, created_at, updated_at`,
+		s.BuyerID, s.FarmerID, s.ProductID, s.Category, s.QuantityInKg, s.Frequency, s.DeliveryDay,
+		s.RatePerKg, s.PriceTolerancePct, s.AddressID, s.ModeOfDelivery, s.BuyersPhoneNumber, s.Status, s.NextDeliveryDate).
+		Scan(&s.ID, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error inserting subscription: %v", err)
+	}
+	return nil
+}
+
+// GetSubscriptionFromStore returns a subscription with its last runs, the caller checks who may see it
+func GetSubscriptionFromStore(db *sql.DB, subscriptionID int) (types.Subscription, error) {
+	s, err := scanSubscription(db.QueryRow(`SELECT`+subscriptionColumns+subscriptionFrom+` WHERE s.id = $1`, subscriptionID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return s, fmt.Errorf("no subscription found with ID %d", subscriptionID)
+		}
+		return s, fmt.Errorf("error querying subscription: %v", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT id, subscription_id, delivery_date, status, order_id, COALESCE(reason, ''), created_at
+		FROM subscription_runs
+		WHERE subscription_id = $1
+		ORDER BY delivery_date DESC
+		LIMIT 20`, subscriptionID)
+	if err != nil {
+		return s, fmt.Errorf("error querying subscription runs: %v", err)
+	}
+	defer rows.Close()
+
+	s.Runs = []types.SubscriptionRun{}
+	for rows.Next() {
+		var r types.SubscriptionRun
+		if err := rows.Scan(&r.ID, &r.SubscriptionID, &r.DeliveryDate, &r.Status, &r.OrderID, &r.Reason, &r.CreatedAt); err != nil {
+			return s, fmt.Errorf("failed to scan subscription run: %v", err)
+		}
+		s.Runs = append(s.Runs, r)
+	}
+	return s, rows.Err()
+}
+
+// GetSubscriptionsFromStore lists the subscriptions a buyer has or a farmer supplies, cancelled ones last
+func GetSubscriptionsFromStore(db *sql.DB, userID int) ([]types.Subscription, error) {
+	rows, err := db.Query(`SELECT`+subscriptionColumns+subscriptionFrom+`
+		WHERE s.buyer_id = $1 OR s.farmer_id = $1
+		ORDER BY s.status = $2, s.next_delivery_date`, userID, StatusCancelled)
+	if err != nil {
+		return nil, fmt.Errorf("error querying subscriptions: %v", err)
+	}
+	defer rows.Close()
+
+	subscriptions := []types.Subscription{}
+	for rows.Next() {
+		s, err := scanSubscription(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan subscription: %v", err)
+		}
+		subscriptions = append(subscriptions, s)
+	}
+	return subscriptions, rows.Err()
+}
+
+// PauseSubscriptionInStore stops an active subscription from placing orders
+func PauseSubscriptionInStore(db *sql.DB, subscriptionID, buyerID int) error {
+	return setStatus(db, `UPDATE subscriptions SET status = $1, updated_at = NOW()
+		WHERE id = $2 AND buyer_id = $3 AND status = $4`, StatusPaused, subscriptionID, buyerID, StatusActive)
+}
+
+// ResumeSubscriptionInStore restarts a paused subscription from nextDelivery at ratePerKg. The buyer agrees to the
+// current rate by resuming, so resuming an active subscription is how they accept a price rise.
+func ResumeSubscriptionInStore(db *sql.DB, subscriptionID, buyerID int, nextDelivery time.Time, ratePerKg float64) error {
+	res, err := db.Exec(`UPDATE subscriptions SET status = $1, next_delivery_date = $2, rate_per_kg = $3, updated_at = NOW()
+		WHERE id = $4 AND buyer_id = $5 AND status <> $6`, StatusActive, nextDelivery, ratePerKg, subscriptionID, buyerID, StatusCancelled)
+	if err != nil {
+		return fmt.Errorf("error updating subscription: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("subscription not found, not yours, or cancelled")
+	}
+	return nil
+}
+
+// CancelSubscriptionInStore ends a subscription for good, orders already placed are kept
+func CancelSubscriptionInStore(db *sql.DB, subscriptionID, buyerID int) error {
+	return setStatus(db, `UPDATE subscriptions SET status = $1, updated_at = NOW()
+		WHERE id = $2 AND buyer_id = $3 AND status <> $1`, StatusCancelled, subscriptionID, buyerID)
+}
+
+func setStatus(db *sql.DB, q string, args ...interface{}) error {
+	res, err := db.Exec(q, args...)
+	if err != nil {
+		return fmt.Errorf("error updating subscription: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("subscription not found, not yours, or already %s", args[0])
+	}
+	return nil
+}
+
+// GetDueSubscriptionIDsFromStore returns the active subscriptions whose next delivery is within leadDays
+func GetDueSubscriptionIDsFromStore(db *sql.DB, leadDays int) ([]int, error) {
+	rows, err := db.Query(`
+		SELECT id FROM subscriptions
+		WHERE status = $1 AND next_delivery_date <= CURRENT_DATE + $2::INT
+		ORDER BY next_delivery_date`, StatusActive, leadDays)
+	if err != nil {
+		return nil, fmt.Errorf("error querying due subscriptions: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan subscription: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ClaimRunInStore moves a due subscription on to its following delivery and records a pending run for the one that
+// was due, so another instance or the next tick can't place it again. ok is false when there was nothing to claim.
+func ClaimRunInStore(db *sql.DB, subscriptionID, leadDays int) (s types.Subscription, run types.SubscriptionRun, ok bool, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return s, run, false, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	s, err = scanSubscription(tx.QueryRow(`SELECT`+subscriptionColumns+subscriptionFrom+`
+		WHERE s.id = $1 AND s.status = $2 AND s.next_delivery_date <= CURRENT_DATE + $3::INT
+		FOR UPDATE OF s SKIP LOCKED`, subscriptionID, StatusActive, leadDays))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return s, run, false, nil
+		}
+		return s, run, false, fmt.Errorf("error querying subscription: %v", err)
+	}
+
+	run.SubscriptionID, run.DeliveryDate, run.Status = s.ID, s.NextDeliveryDate, RunPending
+	err = tx.QueryRow(`
+		INSERT INTO subscription_runs (subscription_id, delivery_date, status)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (subscription_id, delivery_date) DO NOTHING
+		RETURNING id, created_at`, run.SubscriptionID, run.DeliveryDate, run.Status).Scan(&run.ID, &run.CreatedAt)
+	claimed := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return s, run, false, fmt.Errorf("error inserting subscription run: %v", err)
+	}
+
+	if _, err := tx.Exec(`UPDATE subscriptions SET next_delivery_date = $1, updated_at = NOW() WHERE id = $2`,
+		nextDelivery(s.NextDeliveryDate, s.Frequency), s.ID); err != nil {
+		return s, run, false, fmt.Errorf("error updating subscription: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return s, run, false, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return s, run, claimed, nil
+}
+
+// FinishRunInStore records how a claimed run went
+func FinishRunInStore(db *sql.DB, run types.SubscriptionRun) error {
+	_, err := db.Exec(`UPDATE subscription_runs SET status = $1, order_id = $2, reason = NULLIF($3, '') WHERE id = $4`,
+		run.Status, run.OrderID, run.Reason, run.ID)
+	if err != nil {
+		return fmt.Errorf("error updating subscription run: %v", err)
+	}
+	return nil
+}
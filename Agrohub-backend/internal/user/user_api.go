@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/ritu84/agrohub/internal/product"
 	"github.com/ritu84/agrohub/types"
 	"github.com/labstack/echo/v4"
 )
@@ -38,6 +40,11 @@ func GetUserProfile(db *sql.DB) echo.HandlerFunc {
 			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("Invalid user ID: %v", err))
 		}
 
+		// The full profile has KYC and contact details, others see the public farmer profile at /user/farmers/:id
+		if loggedIn, ok := c.Get("user_id").(int); !ok || (loggedIn != userID && c.Get("user_type") != "admin") {
+			return echo.NewHTTPError(http.StatusForbidden, "you can only see your own profile")
+		}
+
 		res, err := GetUserProfileFromStore(db, userID)
 		if err != nil {
 			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error getting user profile: %v", err))
@@ -69,3 +76,63 @@ func UpdateProfile(db *sql.DB) echo.HandlerFunc {
 		return c.JSON(http.StatusOK, map[string]string{"message": "user profile updated successfully!"})
 	}
 }
+
+const (
+	defaultFarmersPageSize = 20
+	maxFarmersPageSize     = 100
+)
+
+// GetFarmerProfile returns the public storefront of a farmer with their rating and live listings
+func GetFarmerProfile(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		farmerID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing farmer id :%v", err))
+		}
+
+		f, err := GetFarmerProfileFromStore(db, farmerID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+
+		f.Listings, err = product.GetLiveProductsOfFarmerFromStore(db, farmerID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching listings: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, f)
+	}
+}
+
+// ListFarmers is the farmer directory, filtered by ?state=, ?city= and ?category= and paged with ?limit= and ?offset=
+func ListFarmers(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		filter := types.FarmerFilter{
+			State:    strings.TrimSpace(c.QueryParam("state")),
+			City:     strings.TrimSpace(c.QueryParam("city")),
+			Category: strings.TrimSpace(c.QueryParam("category")),
+			Limit:    defaultFarmersPageSize,
+		}
+		if v := c.QueryParam("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > maxFarmersPageSize {
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("limit must be between 1 and %d", maxFarmersPageSize))
+			}
+			filter.Limit = n
+		}
+		if v := c.QueryParam("offset"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, "offset must be 0 or more")
+			}
+			filter.Offset = n
+		}
+
+		dir, err := GetFarmersFromStore(db, filter)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching farmers: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, dir)
+	}
+}
@@ -177,5 +177,70 @@ func CreateUserStore(db *sql.DB, user types.User) (int, error) {
     return newUserID, nil
 }
 
+// live listings are verified, available and in stock
+const liveProduct = `p.is_verified_by_admin = true AND p.is_available = true AND p.quantity_in_kg > 0`
+
+const farmerColumns = `
+	u.id, u.first_name, u.last_name, COALESCE(u.img, ''), f.city, f.state, f.farm_size,
+	COALESCE(f.is_verified_by_admin, false), u.created_at, COALESCE(r.average, 0), COALESCE(r.count, 0),
+	(SELECT COUNT(*) FROM products p WHERE p.farmer_id = u.id AND ` + liveProduct + `)`
+
+const farmerFrom = `
+	FROM users u
+	JOIN farmers f ON f.user_id = u.id
+	LEFT JOIN (
+		SELECT farmer_id, ROUND(AVG(rating), 1) AS average, COUNT(*) AS count
+		FROM reviews WHERE status = 'published' GROUP BY farmer_id
+	) r ON r.farmer_id = u.id
+	WHERE u.user_type = 'farmer'`
+
+func scanFarmer(row interface{ Scan(...interface{}) error }) (types.Farmer, error) {
+	var f types.Farmer
+	err := row.Scan(&f.ID, &f.FirstName, &f.LastName, &f.Image, &f.City, &f.State, &f.FarmSize,
+		&f.IsVerified, &f.MemberSince, &f.Rating.Average, &f.Rating.Count, &f.ListingCount)
+	return f, err
+}
 
+// GetFarmerProfileFromStore returns the public profile of a farmer, without their listings
+func GetFarmerProfileFromStore(db *sql.DB, farmerID int) (types.Farmer, error) {
+	f, err := scanFarmer(db.QueryRow(`SELECT`+farmerColumns+farmerFrom+` AND u.id = $1`, farmerID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return f, fmt.Errorf("no farmer found with ID %d", farmerID)
+		}
+		return f, fmt.Errorf("error finding farmer: %v", err)
+	}
+	return f, nil
+}
 
+// GetFarmersFromStore lists a page of the farmer directory, verified and best rated farmers first
+func GetFarmersFromStore(db *sql.DB, filter types.FarmerFilter) (types.FarmerDirectory, error) {
+	where := `
+	AND ($1::text = '' OR LOWER(f.state) = LOWER($1::text))
+	AND ($2::text = '' OR LOWER(f.city) = LOWER($2::text))
+	AND ($3::text = '' OR EXISTS (
+		SELECT 1 FROM products p WHERE p.farmer_id = u.id AND LOWER(p.type) = LOWER($3::text) AND ` + liveProduct + `))`
+	args := []interface{}{filter.State, filter.City, filter.Category}
+
+	dir := types.FarmerDirectory{Farmers: []types.Farmer{}}
+	if err := db.QueryRow(`SELECT COUNT(*)`+farmerFrom+where, args...).Scan(&dir.Total); err != nil {
+		return dir, fmt.Errorf("error counting farmers: %v", err)
+	}
+
+	rows, err := db.Query(`SELECT`+farmerColumns+farmerFrom+where+`
+	ORDER BY COALESCE(f.is_verified_by_admin, false) DESC, COALESCE(r.average, 0) DESC, COALESCE(r.count, 0) DESC, u.id
+	LIMIT $4 OFFSET $5`, append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return dir, fmt.Errorf("error querying farmers: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		f, err := scanFarmer(rows)
+		if err != nil {
+			return dir, fmt.Errorf("failed to scan farmer: %v", err)
+		}
+		dir.Farmers = append(dir.Farmers, f)
+	}
+	return dir, rows.Err()
+}
@@ -0,0 +1,275 @@
+package wishlist
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ritu84/agrohub/internal/notification"
+	"github.com/ritu84/agrohub/internal/scheduler"
+)
+
+// Conditions an alert waits for
+const (
+	ConditionBackInStock = "back_in_stock"
+	ConditionPriceBelow  = "price_below"
+	ConditionNewListing  = "new_listing"
+)
+
+var Conditions = map[string]bool{ConditionBackInStock: true, ConditionPriceBelow: true, ConditionNewListing: true}
+
+// liveProduct is a product buyers can order right now, alerts only ever match one
+const liveProduct = `p.is_verified_by_admin = true AND p.is_available = true AND p.quantity_in_kg > 0`
+
+// Config limits how often alerts notify a buyer
+type Config struct {
+	MinInterval time.Duration // between two notifications of the same alert
+	DailyLimit  int           // alert notifications a buyer gets in 24 hours, matches over it wait for a later sweep
+	Interval    time.Duration
+}
+
+// ConfigFromEnv reads ALERT_MIN_INTERVAL (default 6h), ALERT_DAILY_LIMIT (default 10)
+// and ALERT_JOBS_INTERVAL (default 30m)
+func ConfigFromEnv() Config {
+	return Config{
+		MinInterval: scheduler.DurationFromEnv("ALERT_MIN_INTERVAL", 6*time.Hour),
+		DailyLimit:  scheduler.IntFromEnv("ALERT_DAILY_LIMIT", 10),
+		Interval:    scheduler.DurationFromEnv("ALERT_JOBS_INTERVAL", 30*time.Minute),
+	}
+}
+
+// Jobs are the background jobs for alerts. Product changes are evaluated as they happen, the sweep catches stock
+// that came back through cancellations or harvests and matches held back by the rate limit.
+func Jobs(cfg Config) []scheduler.Job {
+	return []scheduler.Job{
+		{Name: "sweep-product-alerts", Interval: cfg.Interval, Run: func(db *sql.DB) error { return SweepAlerts(db, cfg) }},
+	}
+}
+
+// ProductChanged evaluates the alerts a product could match after it was listed, repriced, restocked or taken down.
+// Alerts must never fail the change that triggered them, so errors are only logged.
+func ProductChanged(db *sql.DB, productID int) {
+	if err := EvaluateProduct(db, productID, ConfigFromEnv()); err != nil {
+		log.Printf("wishlist: failed to evaluate alerts for product %d: %v", productID, err)
+	}
+}
+
+// listedProduct is what alerts are matched against
+type listedProduct struct {
+	ID        int
+	FarmerID  int
+	Name      string
+	Category  string
+	RatePerKg float64
+	Live      bool
+	CreatedAt time.Time
+	FarmPin   string
+}
+
+// candidate is an alert that could match a product, Notified is set when it already fired for the product
+type candidate struct {
+	ID          int
+	BuyerID     int
+	Condition   string
+	TargetPrice *float64
+	PinPrefix   string
+	CreatedAt   time.Time
+	Notified    bool
+}
+
+// EvaluateProduct notifies the buyers whose alerts the product matches now and didn't before, and re-arms
+// back_in_stock and price_below alerts it stopped matching so they fire again next time
+func EvaluateProduct(db *sql.DB, productID int, cfg Config) error {
+	var p listedProduct
+	err := db.QueryRow(`
+		SELECT p.id, p.farmer_id, p.name, LOWER(p.type), p.rate_per_kg, `+liveProduct+`, p.created_at, COALESCE(f.pin_code, '')
+		FROM products p
+		LEFT JOIN farmers f ON f.user_id = p.farmer_id
+		WHERE p.id = $1`, productID).
+		Scan(&p.ID, &p.FarmerID, &p.Name, &p.Category, &p.RatePerKg, &p.Live, &p.CreatedAt, &p.FarmPin)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// a deleted product took its alerts with it
+			return nil
+		}
+		return fmt.Errorf("error fetching product: %v", err)
+	}
+
+	candidates, err := getCandidatesFromStore(db, p)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range candidates {
+		matched := matches(a, p)
+		switch {
+		case matched && !a.Notified:
+			sent, err := claimInStore(db, a, p.ID, cfg)
+			if err != nil {
+				log.Printf("wishlist: alert %d: %v", a.ID, err)
+				continue
+			}
+			if sent {
+				title, body := message(a, p)
+				notification.Notify(db, a.BuyerID, notification.KindProductAlert, title, body)
+			}
+		case !matched && a.Notified && a.Condition != ConditionNewListing:
+			// a new listing is only new once, the others fire again when the product matches again
+			if _, err := db.Exec(`DELETE FROM product_alert_matches WHERE alert_id = $1 AND product_id = $2`, a.ID, p.ID); err != nil {
+				log.Printf("wishlist: alert %d: error re-arming: %v", a.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+func getCandidatesFromStore(db *sql.DB, p listedProduct) ([]candidate, error) {
+	rows, err := db.Query(`
+		SELECT a.id, a.buyer_id, a.condition, a.target_price, COALESCE(a.pin_prefix, ''), a.created_at,
+			EXISTS (SELECT 1 FROM product_alert_matches m WHERE m.alert_id = a.id AND m.product_id = $1)
+		FROM product_alerts a
+		WHERE a.buyer_id <> $2
+			AND (a.product_id = $1 OR (a.product_id IS NULL AND (a.category IS NULL OR a.category = $3)))`,
+		p.ID, p.FarmerID, p.Category)
+	if err != nil {
+		return nil, fmt.Errorf("error querying alerts: %v", err)
+	}
+	defer rows.Close()
+
+	var candidates []candidate
+	for rows.Next() {
+		var a candidate
+		if err := rows.Scan(&a.ID, &a.BuyerID, &a.Condition, &a.TargetPrice, &a.PinPrefix, &a.CreatedAt, &a.Notified); err != nil {
+			return nil, fmt.Errorf("error scanning alert: %v", err)
+		}
+		candidates = append(candidates, a)
+	}
+	return candidates, rows.Err()
+}
+
+// matches reports whether the product satisfies the alert's condition right now
+func matches(a candidate, p listedProduct) bool {
+	if !p.Live {
+		return false
+	}
+	switch a.Condition {
+	case ConditionBackInStock:
+		return true
+	case ConditionPriceBelow:
+		return a.TargetPrice != nil && p.RatePerKg <= *a.TargetPrice
+	case ConditionNewListing:
+		return p.CreatedAt.After(a.CreatedAt) && strings.HasPrefix(p.FarmPin, a.PinPrefix)
+	}
+	return false
+}
+
+func message(a candidate, p listedProduct) (string, string) {
+	switch a.Condition {
+	case ConditionPriceBelow:
+		return "Price drop", fmt.Sprintf("%s is now Rs %.2f/kg, at or below the Rs %.2f/kg you were waiting for.", p.Name, p.RatePerKg, *a.TargetPrice)
+	case ConditionNewListing:
+		return "New listing near you", fmt.Sprintf("%s was just listed in your district at Rs %.2f/kg.", p.Name, p.RatePerKg)
+	}
+	return "Back in stock", fmt.Sprintf("%s is available again at Rs %.2f/kg.", p.Name, p.RatePerKg)
+}
+
+// claimInStore records that the alert fired for the product. sent is false when the alert fired within MinInterval
+// or the buyer already got DailyLimit alerts today, the match is left for a later sweep.
+func claimInStore(db *sql.DB, a candidate, productID int, cfg Config) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// locking the alert stops two changes of the product notifying it twice
+	var recent bool
+	err = tx.QueryRow(`
+		SELECT COALESCE(last_notified_at > NOW() - $2 * INTERVAL '1 second', false)
+		FROM product_alerts WHERE id = $1
+		FOR UPDATE`, a.ID, cfg.MinInterval.Seconds()).Scan(&recent)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// deleted since it was read
+			return false, nil
+		}
+		return false, fmt.Errorf("error locking alert: %v", err)
+	}
+	if recent {
+		return false, nil
+	}
+
+	var today int
+	err = tx.QueryRow(`
+		SELECT COUNT(*) FROM notifications
+		WHERE user_id = $1 AND kind = $2 AND created_at > NOW() - INTERVAL '1 day'`,
+		a.BuyerID, notification.KindProductAlert).Scan(&today)
+	if err != nil {
+		return false, fmt.Errorf("error counting today's alerts: %v", err)
+	}
+	if today >= cfg.DailyLimit {
+		return false, nil
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO product_alert_matches (alert_id, product_id)
+		VALUES ($1, $2)
+		ON CONFLICT (alert_id, product_id) DO NOTHING`, a.ID, productID)
+	if err != nil {
+		return false, fmt.Errorf("error recording match: %v", err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	if _, err := tx.Exec(`UPDATE product_alerts SET last_notified_at = NOW() WHERE id = $1`, a.ID); err != nil {
+		return false, fmt.Errorf("error updating alert: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return true, nil
+}
+
+// SweepAlerts evaluates the products alerts point at, the products they already fired for, and for category
+// alerts every live product changed in the last day
+func SweepAlerts(db *sql.DB, cfg Config) error {
+	rows, err := db.Query(`
+		SELECT product_id FROM product_alerts WHERE product_id IS NOT NULL
+		UNION
+		SELECT m.product_id FROM product_alert_matches m
+		JOIN product_alerts a ON a.id = m.alert_id
+		WHERE a.condition <> $1
+		UNION
+		SELECT p.id FROM products p
+		WHERE `+liveProduct+` AND p.updated_at > NOW() - INTERVAL '1 day'
+			AND EXISTS (SELECT 1 FROM product_alerts a WHERE a.product_id IS NULL)`, ConditionNewListing)
+	if err != nil {
+		return fmt.Errorf("error finding products to evaluate: %v", err)
+	}
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning product id: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error finding products to evaluate: %v", err)
+	}
+
+	for _, id := range ids {
+		// one bad product shouldn't hold up the rest
+		if err := EvaluateProduct(db, id, cfg); err != nil {
+			log.Printf("wishlist: product %d: %v", id, err)
+		}
+	}
+	return nil
+}
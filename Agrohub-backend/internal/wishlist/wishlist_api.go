@@ -0,0 +1,191 @@
+package wishlist
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ritu84/agrohub/internal/address"
+	"github.com/ritu84/agrohub/types"
+	"github.com/labstack/echo/v4"
+)
+
+// GetWishlist lists the logged in buyer's saved products
+func GetWishlist(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		items, err := GetItemsFromStore(db, userID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching wishlist: %v", err))
+		}
+		return c.JSON(http.StatusOK, items)
+	}
+}
+
+// AddToWishlist saves a product to the logged in buyer's wishlist
+func AddToWishlist(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		productID, err := strconv.Atoi(c.Param("productId"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing product id:%v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+		if c.Get("user_type") != "buyer" {
+			return echo.NewHTTPError(http.StatusForbidden, "only buyers can save products")
+		}
+
+		if err := AddItemInStore(db, userID, productID); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error saving product: %v", err))
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "product saved successfully!"})
+	}
+}
+
+// RemoveFromWishlist removes a product from the logged in buyer's wishlist
+func RemoveFromWishlist(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		productID, err := strconv.Atoi(c.Param("productId"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing product id:%v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		if err := RemoveItemInStore(db, userID, productID); err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "product removed successfully!"})
+	}
+}
+
+// CreateAlert subscribes the logged in buyer to a product or a category. back_in_stock needs a product_id,
+// price_below a product_id or a category and a target_price, new_listing optionally a category.
+func CreateAlert(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req types.CreateProductAlert
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("unable to parse req body: %v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+		if c.Get("user_type") != "buyer" {
+			return echo.NewHTTPError(http.StatusForbidden, "only buyers can set alerts")
+		}
+
+		a := types.ProductAlert{
+			BuyerID:     userID,
+			ProductID:   req.ProductID,
+			Category:    strings.ToLower(strings.TrimSpace(req.Category)),
+			Condition:   req.Condition,
+			TargetPrice: req.TargetPrice,
+		}
+		if !Conditions[a.Condition] {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, "condition must be back_in_stock, price_below or new_listing")
+		}
+
+		switch a.Condition {
+		case ConditionBackInStock:
+			if a.ProductID == nil {
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, "a back_in_stock alert needs a product_id")
+			}
+			a.Category, a.TargetPrice = "", nil
+		case ConditionPriceBelow:
+			if a.ProductID == nil && a.Category == "" {
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, "a price_below alert needs a product_id or a category")
+			}
+			if a.TargetPrice == nil || *a.TargetPrice <= 0 {
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, "a price_below alert needs a target_price greater than 0")
+			}
+			if a.ProductID != nil {
+				a.Category = ""
+			}
+		case ConditionNewListing:
+			if a.ProductID != nil {
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, "a new_listing alert is on a category or on every product, not a product_id")
+			}
+			a.TargetPrice = nil
+
+			// listings are matched on the sorting district, the first three digits of the farm's pin code
+			pin := req.PinCode
+			if pin == "" {
+				d, err := address.GetUserAddressFromStore(db, userID, 0)
+				if err != nil {
+					return echo.NewHTTPError(echo.ErrBadRequest.Code, "send a pin_code or save a default address first")
+				}
+				pin = d.PinCode
+			}
+			if !pin.Valid() {
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, "pin_code must be a 6 digit pin code")
+			}
+			a.PinPrefix = string(pin[:3])
+		}
+
+		if a.ProductID != nil {
+			if err := CheckProductFromStore(db, userID, *a.ProductID); err != nil {
+				return echo.NewHTTPError(echo.ErrBadRequest.Code, err.Error())
+			}
+		}
+
+		if err := CreateAlertInStore(db, &a); err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error creating alert: %v", err))
+		}
+
+		return c.JSON(http.StatusCreated, a)
+	}
+}
+
+// GetAlerts lists the logged in buyer's alerts
+func GetAlerts(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		alerts, err := GetAlertsFromStore(db, userID)
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrInternalServerError.Code, fmt.Sprintf("error fetching alerts: %v", err))
+		}
+		return c.JSON(http.StatusOK, alerts)
+	}
+}
+
+// DeleteAlert stops one of the logged in buyer's alerts
+func DeleteAlert(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		alertID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(echo.ErrBadRequest.Code, fmt.Sprintf("error parsing alert id:%v", err))
+		}
+
+		userID, ok := c.Get("user_id").(int)
+		if !ok {
+			return errors.New("user_id not found or invalid type")
+		}
+
+		if err := DeleteAlertInStore(db, alertID, userID); err != nil {
+			return echo.NewHTTPError(echo.ErrNotFound.Code, err.Error())
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "alert deleted successfully!"})
+	}
+}
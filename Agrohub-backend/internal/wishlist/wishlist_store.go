@@ -0,0 +1,176 @@
+package wishlist
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ritu84/agrohub/types"
+)
+
+// CheckProductFromStore checks a product exists and isn't the buyer's own
+func CheckProductFromStore(db *sql.DB, buyerID, productID int) error {
+	var farmerID int
+	if err := db.QueryRow(`SELECT farmer_id FROM products WHERE id = $1`, productID).Scan(&farmerID); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("no product found with ID %d", productID)
+		}
+		return fmt.Errorf("error fetching product: %v", err)
+	}
+	if farmerID == buyerID {
+		return fmt.Errorf("product %d is your own", productID)
+	}
+	return nil
+}
+
+// AddItemInStore saves a product to the buyer's wishlist, saving it twice is not an error
+func AddItemInStore(db *sql.DB, buyerID, productID int) error {
+	if err := CheckProductFromStore(db, buyerID, productID); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO wishlist_items (buyer_id, product_id)
+		VALUES ($1, $2)
+		ON CONFLICT (buyer_id, product_id) DO NOTHING`, buyerID, productID)
+	if err != nil {
+		return fmt.Errorf("error saving product: %v", err)
+	}
+	return nil
+}
+
+func RemoveItemInStore(db *sql.DB, buyerID, productID int) error {
+	result, err := db.Exec(`DELETE FROM wishlist_items WHERE buyer_id = $1 AND product_id = $2`, buyerID, productID)
+	if err != nil {
+		return fmt.Errorf("error removing product: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("product %d is not in your wishlist", productID)
+	}
+	return nil
+}
+
+// GetItemsFromStore lists the buyer's saved products, latest first. Products that sold out or were taken down stay
+// in the list with is_available false.
+func GetItemsFromStore(db *sql.DB, buyerID int) ([]types.WishlistItem, error) {
+	rows, err := db.Query(`
+		SELECT p.id, p.farmer_id, p.name, p.type, p.img, p.quantity_in_kg,
+		p.rate_per_kg, COALESCE(p.jari_size, ''), p.expected_delivery,
+		p.created_at, p.updated_at, p.is_available AND p.quantity_in_kg > 0, p.is_verified_by_admin,
+		u.first_name, u.last_name, w.created_at
+		FROM wishlist_items w
+		JOIN products p ON p.id = w.product_id
+		JOIN users u ON u.id = p.farmer_id
+		WHERE w.buyer_id = $1
+		ORDER BY w.created_at DESC`, buyerID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying wishlist: %v", err)
+	}
+	defer rows.Close()
+
+	items := []types.WishlistItem{}
+	for rows.Next() {
+		var i types.WishlistItem
+		p := &i.Product
+		if err := rows.Scan(
+			&p.ID, &p.FarmerID, &p.Name, &p.Type, &p.Img, &p.Quantity,
+			&p.RatePerKg, &p.JariSize, &p.ExpectedDelivery,
+			&p.CreatedAt, &p.UpdatedAt, &p.IsAvailable, &p.IsVerifiedByAdmin,
+			&p.FarmerFirstName, &p.FarmerLastName, &i.AddedAt,
+		); err != nil {
+			return nil, fmt.Errorf("error scanning wishlist item: %v", err)
+		}
+		items = append(items, i)
+	}
+	return items, rows.Err()
+}
+
+const alertColumns = `
+	a.id, a.buyer_id, a.product_id, COALESCE(p.name, ''), COALESCE(a.category, ''), a.condition, a.target_price,
+	COALESCE(a.pin_prefix, ''), a.last_notified_at, a.created_at`
+
+const alertFrom = `
+	FROM product_alerts a
+	LEFT JOIN products p ON p.id = a.product_id`
+
+func scanAlert(row interface{ Scan(...interface{}) error }) (types.ProductAlert, error) {
+	var a types.ProductAlert
+	err := row.Scan(&a.ID, &a.BuyerID, &a.ProductID, &a.ProductName, &a.Category, &a.Condition, &a.TargetPrice,
+		&a.PinPrefix, &a.LastNotifiedAt, &a.CreatedAt)
+	return a, err
+}
+
+// CreateAlertInStore saves an alert. A back_in_stock alert on a product that is in stock right now only fires once
+// it has sold out and come back.
+func CreateAlertInStore(db *sql.DB, a *types.ProductAlert) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+		INSERT INTO product_alerts (buyer_id, product_id, category, condition, target_price, pin_prefix)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, NULLIF($6, ''))
+		RETURNING id, created_at`,
+		a.BuyerID, a.ProductID, a.Category, a.Condition, a.TargetPrice, a.PinPrefix).
+		Scan(&a.ID, &a.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error inserting alert: %v", err)
+	}
+
+	if a.Condition == ConditionBackInStock {
+		_, err = tx.Exec(`
+			INSERT INTO product_alert_matches (alert_id, product_id)
+			SELECT $1, p.id FROM products p WHERE p.id = $2 AND `+liveProduct, a.ID, a.ProductID)
+		if err != nil {
+			return fmt.Errorf("error recording current stock: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// GetAlertsFromStore lists the buyer's alerts, latest first
+func GetAlertsFromStore(db *sql.DB, buyerID int) ([]types.ProductAlert, error) {
+	rows, err := db.Query(`SELECT`+alertColumns+alertFrom+`
+		WHERE a.buyer_id = $1
+		ORDER BY a.created_at DESC`, buyerID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying alerts: %v", err)
+	}
+	defer rows.Close()
+
+	alerts := []types.ProductAlert{}
+	for rows.Next() {
+		a, err := scanAlert(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning alert: %v", err)
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+func DeleteAlertInStore(db *sql.DB, alertID, buyerID int) error {
+	result, err := db.Exec(`DELETE FROM product_alerts WHERE id = $1 AND buyer_id = $2`, alertID, buyerID)
+	if err != nil {
+		return fmt.Errorf("error deleting alert: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("no alert found with ID %d", alertID)
+	}
+	return nil
+}
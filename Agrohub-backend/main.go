@@ -2,6 +2,7 @@ package main
 
 import (
 	// "bytes"
+	"context"
 	"fmt"
 	// "io"
 	// "net/smtp"
@@ -12,11 +13,34 @@ import (
 	// "time"
 
 	"github.com/ritu84/agrohub/db"
+	"github.com/ritu84/agrohub/internal/address"
 	admins "github.com/ritu84/agrohub/internal/admin"
+	"github.com/ritu84/agrohub/internal/auction"
 	"github.com/ritu84/agrohub/internal/auth"
+	"github.com/ritu84/agrohub/internal/conversation"
+	"github.com/ritu84/agrohub/internal/dispute"
+	"github.com/ritu84/agrohub/internal/events"
+	"github.com/ritu84/agrohub/internal/fpo"
+	"github.com/ritu84/agrohub/internal/geo"
+	"github.com/ritu84/agrohub/internal/invoice"
+	"github.com/ritu84/agrohub/internal/ledger"
+	"github.com/ritu84/agrohub/internal/market"
+	"github.com/ritu84/agrohub/internal/notification"
+	"github.com/ritu84/agrohub/internal/offer"
 	"github.com/ritu84/agrohub/internal/orders"
+	"github.com/ritu84/agrohub/internal/organization"
+	"github.com/ritu84/agrohub/internal/payment"
+	"github.com/ritu84/agrohub/internal/preorder"
 	"github.com/ritu84/agrohub/internal/product"
+	"github.com/ritu84/agrohub/internal/promotion"
+	"github.com/ritu84/agrohub/internal/referral"
+	"github.com/ritu84/agrohub/internal/review"
+	"github.com/ritu84/agrohub/internal/rfq"
+	"github.com/ritu84/agrohub/internal/scheduler"
+	"github.com/ritu84/agrohub/internal/shipment"
+	"github.com/ritu84/agrohub/internal/subscription"
 	users "github.com/ritu84/agrohub/internal/user"
+	"github.com/ritu84/agrohub/internal/wishlist"
 	"github.com/labstack/echo-jwt/v4"
 
 	"github.com/labstack/echo/v4"
@@ -70,6 +94,31 @@ func main() {
 
 	defer conn.Close()
 
+	// Background jobs share the server's connection pool and stop when the server exits
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	scheduler.Start(ctx, conn, product.ListingJobs(product.ExpiryConfigFromEnv())...)
+	scheduler.Start(ctx, conn, offer.Jobs()...)
+	scheduler.Start(ctx, conn, auction.Jobs()...)
+	scheduler.Start(ctx, conn, ledger.Jobs(ledger.PayoutConfigFromEnv())...)
+	subscriptionConfig := subscription.ConfigFromEnv()
+	scheduler.Start(ctx, conn, subscription.Jobs(subscriptionConfig)...)
+	scheduler.Start(ctx, conn, wishlist.Jobs(wishlist.ConfigFromEnv())...)
+	scheduler.Start(ctx, conn, referral.Jobs(referral.ConfigFromEnv())...)
+
+	// Picked by PAYMENT_PROVIDER, the server doesn't start without one
+	paymentProvider, err := payment.ProviderFromEnv()
+	if err != nil {
+		log.Fatalf("error configuring payments: %v", err)
+	}
+	scheduler.Start(ctx, conn, payment.Jobs(paymentProvider)...)
+
+	// Pin codes outside the bundled dataset need PINCODE_DATASET or DELIVERY_FALLBACK_KM to be delivered to
+	if err := geo.CheckCoverage(); err != nil {
+		log.Fatalf("error configuring deliveries: %v", err)
+	}
+	scheduler.Start(ctx, conn, preorder.Jobs()...)
+
 	e := echo.New()
 	e.Use(middleware.Logger())
 	// e.Use(CustomLogger)
@@ -87,10 +136,11 @@ func main() {
 	api := e.Group("/api")
 	// Public routes
 	auth := api.Group("/auth")
-	auth.POST("/signup", authy.HandleSignUp())
+	auth.POST("/signup", authy.HandleSignUp(conn))
 	auth.POST("/complete-signup", authy.HandleCompleteSignup(conn))
 	auth.POST("/login", authy.HandleLogin())
 	auth.POST("/complete-login", authy.HandleCompleteLogin(conn))
+	api.POST("/payments/webhook", payment.Webhook(conn, paymentProvider)) // -> called by the payment provider, signed in X-Payment-Signature
 
 	// Admin routes
 	admin := api.Group("/admin")
@@ -104,6 +154,21 @@ func main() {
 	adminv1.GET("/users/:id", admins.GetUserProfile(conn))
 	adminv1.POST("/user/:id/approve", admins.ApproveUser(conn))
 	adminv1.POST("/approve-product", admins.ApproveProduct(conn))
+	adminv1.POST("/market-prices/import", market.ImportMarketPrices(conn), authy.IsAdmin) // -> multipart "file", mandi price csv
+	adminv1.POST("/orders/:id/refund", payment.RefundPayment(conn, paymentProvider), authy.IsAdmin)
+	adminv1.GET("/payouts/reconciliation", ledger.GetReconciliation(conn), authy.IsAdmin)
+	adminv1.PUT("/payouts/:id/paid", ledger.MarkPayoutPaid(conn), authy.IsAdmin) // -> {"reference": "<bank utr>"}
+	adminv1.GET("/reviews/reported", review.GetReportedReviews(conn), authy.IsAdmin)
+	adminv1.PUT("/reviews/:id/moderate", review.ModerateReview(conn), authy.IsAdmin, authy.ExtractUserID) // -> {"status": "hidden", "reason": "..."}
+	adminv1.GET("/conversations", conversation.GetAdminConversations(conn), authy.IsAdmin) // -> only conversations opened by a dispute
+	adminv1.GET("/conversations/:id/messages", conversation.GetAdminMessages(conn), authy.IsAdmin)
+	adminv1.GET("/disputes", dispute.GetDisputes(conn), authy.IsAdmin) // -> ?status=open|responded|resolved, unresolved by default
+	adminv1.GET("/disputes/:id", dispute.GetDispute(conn), authy.IsAdmin)
+	adminv1.PUT("/disputes/:id/resolve", dispute.ResolveDispute(conn, paymentProvider), authy.IsAdmin, authy.ExtractUserID) // -> {"resolution": "partial_refund", "amount": 400, "note": "..."}
+	adminv1.POST("/coupons", promotion.CreateCoupon(conn), authy.IsAdmin, authy.ExtractUserID) // -> platform funded, {"code": "DIWALI10", "discount_type": "percentage", "value": 10, ...}
+	adminv1.GET("/coupons", promotion.GetCoupons(conn), authy.IsAdmin, authy.ExtractUserID) // -> every coupon with its uses and discount given
+	adminv1.PUT("/coupons/:id/deactivate", promotion.DeactivateCoupon(conn), authy.IsAdmin, authy.ExtractUserID)
+	adminv1.GET("/referrals/report", referral.GetReport(conn), authy.IsAdmin) // -> ?from=2024-10-01&to=2024-10-31, referrals by referrer and the ones rejected as fraud
 
 	// protected routes
 	v1 := api.Group("/v1")
@@ -118,8 +183,16 @@ func main() {
 	user.PUT("/:id/profile", users.UpdateProfile(conn))
 	user.POST("/:id/newproduct", product.CreateProduct(conn), authy.IsFarmer)
 	user.POST("", users.CreateUser(conn))
-	// user.GET("/farmers",users.ListAllFarmers(conn))  //-> see all farmers with their contact details , product and eDOD
-	// user.GET("/farmers/:id",users.ListAllFarmers(conn))  -> see a farmer with their contact details and eDOD
+	user.GET("/farmers", users.ListFarmers(conn))         // -> farmer directory, ?state=&city=&category=mushroom&limit=20&offset=0
+	user.GET("/farmers/:id", users.GetFarmerProfile(conn)) // -> public storefront, no contact or KYC details
+
+	// Address book --> order creation takes an address_id, or falls back to the default address
+	addresses := v1.Group("/addresses")
+	addresses.GET("", address.GetAddresses(conn))
+	addresses.POST("", address.CreateAddress(conn))
+	addresses.PUT("/:id", address.UpdateAddress(conn))
+	addresses.PUT("/:id/default", address.SetDefaultAddress(conn))
+	addresses.DELETE("/:id", address.DeleteAddress(conn))
 
 	// Product routes
 	products := v1.Group("/product")
@@ -127,8 +200,15 @@ func main() {
 	products.GET("/farmer/:id", product.ListAllProductsOfFarmer(conn))
 	products.GET("/jari", product.ListJariProducts(conn))
 	products.GET("/mushroom", product.ListMushroomProducts(conn))
+	products.GET("/nearby", product.ListNearbyProducts(conn)) // -> ?pin_code=457661 or ?lat=&lng=, &radius_km=100, nearest first
 	products.GET("/:id", product.GetProduct(conn))
 	products.GET("/:id/mark-unavailable", product.UpdateProductAvailability(conn)) // --> Marks unavailable  --> Manage availabilty and is verified on client side
+	products.GET("/:id/quote", product.GetQuote(conn))                            // -> ?quantity_in_kg=60&pin_code=&coupon_code=, price with tiers, delivery and coupon applied
+	products.PUT("/:id/pricing", product.SetProductPricing(conn), authy.IsFarmer)
+	products.PUT("/:id/rate", product.UpdateProductRate(conn), authy.IsFarmer)
+	products.GET("/:id/price-history", product.GetPriceHistory(conn))
+	products.PUT("/:id/schedule", product.UpdateProductSchedule(conn), authy.IsFarmer) // -> expected_delivery / available_from, re-lists expired listings
+	products.GET("/:id/market-prices", market.GetPriceComparison(conn), authy.IsFarmer)
 
 	products.DELETE("/:id", product.DeleteProduct(conn), authy.IsFarmer)
 
@@ -139,6 +219,166 @@ func main() {
 	orders.GET("/:id", order.GetOrdersByID(conn))  // -> GET ORDER BY ID
 	orders.PUT("/:id/status", order.UpdateOrderStatus(conn))
 
+	// Payment routes --> an order moves to processing once its payment is captured or cash on delivery is chosen
+	orders.POST("/:id/payment", payment.CreatePayment(conn, paymentProvider))
+	orders.GET("/:id/payment", payment.GetPayment(conn))
+	orders.POST("/:id/payment/capture", payment.CapturePayment(conn, paymentProvider))
+	orders.GET("/:id/invoice", invoice.GetInvoice(conn)) // -> GST invoice PDF, numbered on first download after delivery
+
+	// Shipment routes --> farmer proposes slots, buyer confirms one, delivered only against the buyer's OTP
+	orders.POST("/:id/slots", shipment.ProposeSlots(conn), authy.IsFarmer)
+	orders.GET("/:id/slots", shipment.GetSlots(conn))
+	orders.POST("/:id/slots/:slotId/confirm", shipment.ConfirmSlot(conn))
+	orders.GET("/:id/shipment", shipment.GetShipment(conn))
+	orders.PUT("/:id/shipment", shipment.UpdateShipment(conn), authy.IsFarmer)
+	orders.POST("/:id/shipment/dispatch", shipment.DispatchShipment(conn), authy.IsFarmer)
+	orders.POST("/:id/shipment/events", shipment.AddTrackingEvent(conn), authy.IsFarmer)
+	orders.POST("/:id/shipment/otp", shipment.ResetDeliveryOTP(conn))
+	orders.POST("/:id/shipment/deliver", shipment.DeliverShipment(conn), authy.IsFarmer)
+
+	// Review routes --> buyers review delivered orders once, farmers reply publicly, anyone can report abuse
+	orders.POST("/:id/review", review.CreateReview(conn))
+	products.GET("/:id/reviews", review.GetProductReviews(conn)) // -> ?limit=20&offset=0
+	user.GET("/farmers/:id/reviews", review.GetFarmerReviews(conn))
+	reviews := v1.Group("/reviews")
+	reviews.PUT("/:id/reply", review.ReplyToReview(conn), authy.IsFarmer)
+	reviews.POST("/:id/report", review.ReportReview(conn))
+
+	// Dispute routes --> buyers dispute delivered orders within the dispute window, farmers respond, admins resolve
+	orders.POST("/:id/dispute", dispute.OpenDispute(conn, ledger.PayoutConfigFromEnv().DisputeWindowDays))
+	orders.GET("/:id/dispute", dispute.GetOrderDispute(conn))
+	disputes := v1.Group("/disputes")
+	disputes.GET("", dispute.GetMyDisputes(conn))
+	disputes.PUT("/:id/respond", dispute.RespondToDispute(conn), authy.IsFarmer)
+
+	// Subscription routes --> standing orders, placed SUBSCRIPTION_LEAD_DAYS before each delivery day
+	subscriptions := v1.Group("/subscriptions")
+	subscriptions.POST("", subscription.CreateSubscription(conn, subscriptionConfig))
+	subscriptions.GET("", subscription.GetSubscriptions(conn))
+	subscriptions.GET("/:id", subscription.GetSubscription(conn))
+	subscriptions.PUT("/:id/pause", subscription.PauseSubscription(conn))
+	subscriptions.PUT("/:id/resume", subscription.ResumeSubscription(conn)) // -> also accepts today's rate after a skipped price rise
+	subscriptions.PUT("/:id/cancel", subscription.CancelSubscription(conn))
+
+	// Organization routes --> members order for a business, orders above its approval_limit wait for an owner or approver
+	organizations := v1.Group("/organizations")
+	organizations.POST("", organization.CreateOrganization(conn))
+	organizations.GET("", organization.GetMyOrganizations(conn))
+	organizations.GET("/:id", organization.GetOrganization(conn))
+	organizations.PUT("/:id", organization.UpdateOrganization(conn)) // -> {"name": "...", "gstin": "...", "approval_limit": 25000}
+	organizations.GET("/:id/members", organization.GetMembers(conn))
+	organizations.POST("/:id/members", organization.AddMember(conn)) // -> {"email": "...", "role": "owner|purchaser|approver|viewer"}
+	organizations.PUT("/:id/members/:userId", organization.UpdateMemberRole(conn))
+	organizations.DELETE("/:id/members/:userId", organization.RemoveMember(conn))
+	organizations.GET("/:id/addresses", organization.GetAddresses(conn))
+	organizations.POST("/:id/addresses", organization.CreateAddress(conn))
+	organizations.DELETE("/:id/addresses/:addressId", organization.DeleteAddress(conn))
+	organizations.POST("/:id/orders", organization.CreateOrder(conn))
+	organizations.GET("/:id/orders", organization.GetOrders(conn)) // -> ?status=pending_approval
+	organizations.PUT("/:id/orders/:orderId/approve", organization.ApproveOrder(conn))
+	organizations.PUT("/:id/orders/:orderId/reject", organization.RejectOrder(conn)) // -> {"note": "..."}
+
+	// FPO routes --> farmer producer organizations list lots of their members' stock, proceeds are split by contribution
+	fpos := v1.Group("/fpos")
+	fpos.POST("", fpo.CreateFPO(conn), authy.IsFarmer)
+	fpos.GET("", fpo.GetMyFPOs(conn))
+	fpos.GET("/:id", fpo.GetFPO(conn))
+	fpos.PUT("/:id", fpo.UpdateFPO(conn))
+	fpos.GET("/:id/members", fpo.GetMembers(conn))
+	fpos.POST("/:id/members", fpo.AddMember(conn)) // -> {"farmer_id": 9, "role": "member"}
+	fpos.DELETE("/:id/members/:farmerId", fpo.RemoveMember(conn))
+	fpos.POST("/:id/lots", fpo.CreateLot(conn)) // -> product fields plus "contributions": [{"farmer_id": 9, "quantity_in_kg": 40}]
+	fpos.GET("/:id/lots", fpo.GetLots(conn))
+	fpos.POST("/:id/lots/:productId/contributions", fpo.AddContribution(conn))
+	fpos.GET("/:id/report", fpo.GetReport(conn)) // -> ?from=2024-10-01&to=2024-10-31, delivered sales split by member
+
+	// Coupon routes --> farmers fund coupons on their own produce, buyers apply them with "coupon_code" when ordering
+	coupons := v1.Group("/coupons")
+	coupons.POST("", promotion.CreateCoupon(conn), authy.IsFarmer)
+	coupons.GET("", promotion.GetCoupons(conn), authy.IsFarmer)
+	coupons.PUT("/:id/deactivate", promotion.DeactivateCoupon(conn), authy.IsFarmer)
+
+	// Referral routes --> your referral code and who signed up with it, new users send it as "referral_code" at signup
+	v1.GET("/referrals", referral.GetReferrals(conn))
+
+	// Wishlist routes --> saved products, and alerts when a product is back in stock, drops below a price or is listed nearby
+	wishlists := v1.Group("/wishlist")
+	wishlists.GET("", wishlist.GetWishlist(conn))
+	wishlists.PUT("/:productId", wishlist.AddToWishlist(conn))
+	wishlists.DELETE("/:productId", wishlist.RemoveFromWishlist(conn))
+	alerts := v1.Group("/alerts")
+	alerts.POST("", wishlist.CreateAlert(conn)) // -> {"condition": "back_in_stock|price_below|new_listing", "product_id": 5, "category": "mushroom", "target_price": 180}
+	alerts.GET("", wishlist.GetAlerts(conn))
+	alerts.DELETE("/:id", wishlist.DeleteAlert(conn))
+
+	// Pre-order routes --> reserve against a projected harvest, filled when the farmer records the actual harvest
+	products.POST("/:id/harvests", preorder.DeclareHarvest(conn), authy.IsFarmer)
+	products.GET("/:id/harvests", preorder.ListHarvests(conn))
+	harvests := v1.Group("/harvests")
+	harvests.POST("/:id/pre-orders", preorder.CreatePreOrder(conn))
+	harvests.GET("/:id/pre-orders", preorder.GetHarvestPreOrders(conn), authy.IsFarmer)
+	harvests.POST("/:id/record", preorder.RecordHarvest(conn, paymentProvider), authy.IsFarmer)
+	preOrders := v1.Group("/pre-orders")
+	preOrders.GET("", preorder.GetMyPreOrders(conn))
+	preOrders.PUT("/:id/cancel", preorder.CancelPreOrder(conn, paymentProvider))
+	preOrders.POST("/:id/deposit", payment.CreateDeposit(conn, paymentProvider)) // -> {"method": "upi"}, the pre-order is reserved once it is captured
+	preOrders.POST("/:id/deposit/capture", payment.CaptureDeposit(conn, paymentProvider))
+
+	// RFQ routes --> buyers post demand, verified farmers in the delivery state quote on it
+	rfqs := v1.Group("/rfqs")
+	rfqs.POST("", rfq.CreateRFQ(conn))
+	rfqs.GET("/mine", rfq.GetMyRFQs(conn))
+	rfqs.GET("/open", rfq.GetOpenRFQs(conn), authy.IsFarmer)
+	rfqs.GET("/:id", rfq.GetRFQ(conn))
+	rfqs.PUT("/:id/close", rfq.CloseRFQ(conn))
+	rfqs.POST("/:id/quotes", rfq.SubmitQuote(conn), authy.IsFarmer)
+	rfqs.GET("/:id/quotes", rfq.GetQuotes(conn))
+	rfqs.POST("/:id/quotes/:quoteId/accept", rfq.AcceptQuote(conn))
+
+	// Offer routes --> a buyer and the farmer negotiate price and quantity, an accepted offer becomes an order
+	products.POST("/:id/offers", offer.CreateOffer(conn))
+	offers := v1.Group("/offers")
+	offers.GET("", offer.GetMyOffers(conn))
+	offers.GET("/:id", offer.GetOffer(conn))
+	offers.POST("/:id/counter", offer.CounterOffer(conn))
+	offers.POST("/:id/accept", offer.AcceptOffer(conn))
+	offers.POST("/:id/reject", offer.RejectOffer(conn))
+
+	// Auction routes --> a lot is sold to the highest bid from a verified buyer, the winning bid becomes an order
+	products.POST("/:id/auctions", auction.CreateAuction(conn), authy.IsFarmer)
+	auctions := v1.Group("/auctions")
+	auctions.GET("", auction.GetOpenAuctions(conn))
+	auctions.GET("/:id", auction.GetAuction(conn))
+	auctions.POST("/:id/bids", auction.PlaceBid(conn))
+	auctions.PUT("/:id/cancel", auction.CancelAuction(conn), authy.IsFarmer)
+
+	// Earnings routes --> the farmer's share of delivered orders after platform commission, and their payouts
+	v1.GET("/earnings", ledger.GetEarnings(conn), authy.IsFarmer)
+
+	// Conversation routes --> buyer–farmer messaging about a product or an order
+	products.POST("/:id/conversation", conversation.StartProductConversation(conn))
+	orders.POST("/:id/conversation", conversation.StartOrderConversation(conn))
+	conversations := v1.Group("/conversations")
+	conversations.GET("", conversation.GetConversations(conn))
+	conversations.GET("/unread", conversation.GetUnreadCount(conn))
+	conversations.GET("/:id/messages", conversation.GetMessages(conn)) // -> ?after=<last message id> to poll, ?before= for history
+	conversations.POST("/:id/messages", conversation.SendMessage(conn))
+	conversations.PUT("/:id/read", conversation.MarkConversationRead(conn))
+	// WebSocket and EventSource clients can't always set headers, so streams also take the JWT as ?token=
+	streamAuth := echojwt.WithConfig(echojwt.Config{
+		SigningKey:  []byte(os.Getenv("JWT_SECRET")),
+		TokenLookup: "header:Authorization:Bearer ,query:token",
+	})
+	api.GET("/v1/conversations/ws", conversation.Stream(), streamAuth, authy.ExtractUserID)
+
+	// Live updates --> order status changes, new orders and moderation outcomes, as SSE or over a WebSocket
+	api.GET("/v1/events", events.Stream(), streamAuth, authy.ExtractUserID) // -> Last-Event-ID header or ?last_event_id= to resume
+
+	// Notification routes --> for the logged in user
+	notifications := v1.Group("/notifications")
+	notifications.GET("", notification.GetNotifications(conn))
+	notifications.PUT("/:id/read", notification.MarkNotificationRead(conn))
+
 	e.Logger.Fatal(e.Start(":8080"))
 }
 
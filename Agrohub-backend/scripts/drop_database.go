@@ -16,7 +16,7 @@ func main() {
 
 	defer conn.Close()
 
-	tables := []string{"users", "farmers", "buyers", "admins", "auth", "products", "orders"}
+	tables := []string{"users", "farmers", "buyers", "admins", "auth", "products", "orders", "product_price_tiers", "product_price_history", "market_prices", "notifications", "harvests", "pre_orders", "rfqs", "rfq_quotes", "offers", "offer_events", "auctions", "auction_bids", "payments", "payment_refunds", "ledger_transactions", "ledger_entries", "payout_batches", "payouts", "invoice_sequences", "invoices", "delivery_slots", "shipments", "shipment_events", "user_addresses", "reviews", "review_reports", "conversations", "messages", "disputes", "subscriptions", "subscription_runs", "organizations", "organization_members", "organization_addresses", "fpos", "fpo_members", "fpo_lot_contributions", "order_shares", "wishlist_items", "product_alerts", "product_alert_matches", "coupons", "order_discounts", "referrals"}
 	for i := 0; i < len(tables); i++ {
 		if err := db.DropTable(conn, tables[i]); err != nil {
 			fmt.Println("error dropping tables...")
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ritu84/agrohub/db"
+	"github.com/ritu84/agrohub/internal/market"
+)
+
+// usage: go run ./scripts/import_market_prices prices-2024-10.csv [more.csv ...]
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatal("usage: import_market_prices <file.csv> [file.csv ...]")
+	}
+
+	conn, err := db.Connect()
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	for _, path := range os.Args[1:] {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("error opening %s: %v", path, err)
+		}
+
+		res, err := market.ImportCSV(conn, f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("error importing %s: %v", path, err)
+		}
+
+		fmt.Printf("%s: imported %d rows, skipped %d\n", path, res.Imported, res.Skipped)
+		for _, e := range res.Errors {
+			fmt.Printf("  %s\n", e)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package types
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// PinCode is an Indian postal code. It is kept as a string so it is stored exactly as written, apps that still
+// send it as a number are accepted.
+type PinCode string
+
+func (p *PinCode) UnmarshalJSON(b []byte) error {
+	var s string
+	if len(b) > 0 && b[0] != '"' {
+		var n json.Number
+		if err := json.Unmarshal(b, &n); err != nil {
+			return err
+		}
+		s = n.String()
+	} else if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	*p = PinCode(strings.TrimSpace(s))
+	return nil
+}
+
+// Valid reports whether p is six digits, Indian pin codes never start with 0
+func (p PinCode) Valid() bool {
+	if len(p) != 6 || p[0] == '0' {
+		return false
+	}
+	for _, r := range p {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Address is one entry of a user's or an organization's address book
+type Address struct {
+	ID          int       `json:"id" db:"id"`
+	UserID      int       `json:"user_id" db:"user_id"` // for an organization's address, the member who saved it
+	Label       string    `json:"label" db:"label"`     // e.g. home, warehouse
+	ContactName string    `json:"contact_name,omitempty" db:"contact_name"`
+	Phone       string    `json:"phone,omitempty" db:"phone"`
+	Address     string    `json:"address" db:"address"`
+	Landmark    string    `json:"landmark,omitempty" db:"landmark"`
+	City        string    `json:"city" db:"city"`
+	State       string    `json:"state" db:"state"`
+	PinCode     PinCode   `json:"pin_code" db:"pin_code"`
+	IsDefault   bool      `json:"is_default" db:"is_default"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
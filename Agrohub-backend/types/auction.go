@@ -0,0 +1,41 @@
+package types
+
+import "time"
+
+// Auction sells a lot of a product to the highest bidder between StartsAt and EndsAt
+type Auction struct {
+	ID                 int       `json:"id" db:"id"`
+	ProductID          int       `json:"product_id" db:"product_id"`
+	ProductName        string    `json:"product_name,omitempty"`
+	FarmerID           int       `json:"farmer_id" db:"farmer_id"`
+	QuantityInKg       int       `json:"quantity_in_kg" db:"quantity_in_kg"`
+	StartingPricePerKg float64   `json:"starting_price_per_kg" db:"starting_price_per_kg"`
+	ReservePricePerKg  *float64  `json:"reserve_price_per_kg,omitempty" db:"reserve_price_per_kg"` // only shown to the farmer
+	ReserveMet         bool      `json:"reserve_met"`
+	MinIncrementPerKg  float64   `json:"min_increment_per_kg" db:"min_increment_per_kg"`
+	StartsAt           time.Time `json:"starts_at" db:"starts_at"`
+	EndsAt             time.Time `json:"ends_at" db:"ends_at"`
+	ExtensionMinutes   int       `json:"extension_minutes" db:"extension_minutes"` // a bid this close to the end extends it by as much
+	Status             string    `json:"status" db:"status"`
+	HighestBidPerKg    *float64  `json:"highest_bid_per_kg,omitempty"`
+	HighestBidID       *int      `json:"highest_bid_id,omitempty" db:"highest_bid_id"`
+	BidCount           int       `json:"bid_count"`
+	OrderID            *int      `json:"order_id,omitempty" db:"order_id"`
+	Bids               []Bid     `json:"bids,omitempty"`
+	CreatedAt          time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Bid is a buyer's bid per kg on the whole lot, the delivery details are used if it wins
+type Bid struct {
+	ID                 int       `json:"id" db:"id"`
+	AuctionID          int       `json:"auction_id" db:"auction_id"`
+	BuyerID            int       `json:"buyer_id" db:"buyer_id"`
+	AmountPerKg        float64   `json:"amount_per_kg" db:"amount_per_kg"`
+	BuyersPhoneNumber  int       `json:"buyers_phone_number,omitempty" db:"buyers_phone_number"`
+	ModeOfDelivery     string    `json:"mode_of_delivery,omitempty" db:"mode_of_delivery"`
+	DeliveryAddress    string    `json:"delivery_address,omitempty" db:"delivery_address"`
+	DeliveryCity       string    `json:"delivery_city,omitempty" db:"delivery_city"`
+	DeliveryAddressZIP PinCode   `json:"delivery_address_zip,omitempty" db:"delivery_address_zip"`
+	CreatedAt          time.Time `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,46 @@
+package types
+
+import "time"
+
+// Conversation is a buyer–farmer thread, about a product before ordering or about one order
+type Conversation struct {
+	ID              int        `json:"id" db:"id"`
+	ProductID       int        `json:"product_id" db:"product_id"`
+	ProductName     string     `json:"product_name,omitempty"`
+	OrderID         *int       `json:"order_id,omitempty" db:"order_id"` // nil for a product inquiry
+	BuyerID         int        `json:"buyer_id" db:"buyer_id"`
+	BuyerName       string     `json:"buyer_name,omitempty"`
+	FarmerID        int        `json:"farmer_id" db:"farmer_id"`
+	FarmerName      string     `json:"farmer_name,omitempty"`
+	LastMessage     *Message   `json:"last_message,omitempty"`
+	Unread          int        `json:"unread"` // messages from the other party the logged in user hasn't read
+	OpenedToAdminAt *time.Time `json:"opened_to_admins_at,omitempty" db:"opened_to_admins_at"`
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+type Message struct {
+	ID             int        `json:"id" db:"id"`
+	ConversationID int        `json:"conversation_id" db:"conversation_id"`
+	SenderID       int        `json:"sender_id" db:"sender_id"`
+	Body           string     `json:"body,omitempty" db:"body"`
+	ImageURL       string     `json:"image_url,omitempty" db:"image_url"`
+	ReadAt         *time.Time `json:"read_at,omitempty" db:"read_at"` // read receipt, set when the other party reads it
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+}
+
+// SendMessage is the request body for a new message, it needs a body, an image or both
+type SendMessage struct {
+	Body     string `json:"body"`
+	ImageURL string `json:"image_url"`
+}
+
+// ChatEvent is pushed over the conversations WebSocket. Type is "message" for a new message, or "read" when the
+// other party has read the conversation up to and including UpTo.
+type ChatEvent struct {
+	Type           string     `json:"type"`
+	ConversationID int        `json:"conversation_id"`
+	Message        *Message   `json:"message,omitempty"`
+	UpTo           int        `json:"up_to,omitempty"`
+	ReadAt         *time.Time `json:"read_at,omitempty"`
+}
@@ -0,0 +1,47 @@
+package types
+
+import "time"
+
+// Dispute is a buyer's complaint about a delivered order. The farmer responds and an admin resolves it, the order
+// isn't paid out to the farmer while it is open.
+type Dispute struct {
+	ID                int        `json:"id" db:"id"`
+	OrderID           int        `json:"order_id" db:"order_id"`
+	ProductID         int        `json:"product_id"`
+	ProductName       string     `json:"product_name,omitempty"`
+	BuyerID           int        `json:"buyer_id" db:"buyer_id"`
+	FarmerID          int        `json:"farmer_id" db:"farmer_id"`
+	Reason            string     `json:"reason" db:"reason"` // quality, short_weight, damaged, wrong_item or other
+	Description       string     `json:"description" db:"description"`
+	PhotoURLs         []string   `json:"photo_urls" db:"photo_urls"`
+	Response          string     `json:"response,omitempty" db:"response"` // the farmer's side
+	ResponsePhotoURLs []string   `json:"response_photo_urls,omitempty" db:"response_photo_urls"`
+	RespondedAt       *time.Time `json:"responded_at,omitempty" db:"responded_at"`
+	Status            string     `json:"status" db:"status"`
+	Resolution        string     `json:"resolution,omitempty" db:"resolution"`
+	RefundAmount      float64    `json:"refund_amount,omitempty" db:"refund_amount"`
+	ResolutionNote    string     `json:"resolution_note,omitempty" db:"resolution_note"`
+	ResolvedAt        *time.Time `json:"resolved_at,omitempty" db:"resolved_at"`
+	CreatedAt         time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// OpenDispute is the request body for a buyer raising a dispute
+type OpenDispute struct {
+	Reason      string   `json:"reason"`
+	Description string   `json:"description"`
+	PhotoURLs   []string `json:"photo_urls"`
+}
+
+// RespondDispute is the request body for the farmer's response to a dispute
+type RespondDispute struct {
+	Response  string   `json:"response"`
+	PhotoURLs []string `json:"photo_urls"`
+}
+
+// ResolveDispute is the request body for an admin's decision. Amount is only read for a partial refund.
+type ResolveDispute struct {
+	Resolution string  `json:"resolution"`
+	Amount     float64 `json:"amount"`
+	Note       string  `json:"note"`
+}
@@ -0,0 +1,39 @@
+package types
+
+import "time"
+
+// OrderEvent is the data of order.created and order.status events
+type OrderEvent struct {
+	OrderID      int       `json:"order_id"`
+	ProductID    int       `json:"product_id"`
+	ProductName  string    `json:"product_name"`
+	BuyerID      int       `json:"buyer_id"`
+	FarmerID     int       `json:"farmer_id"`
+	Status       string    `json:"status"`
+	QuantityInKg int       `json:"quantity_in_kg"`
+	TotalPrice   float64   `json:"total_price"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// ProductModerationEvent is the data of product.moderated events
+type ProductModerationEvent struct {
+	ProductID  int    `json:"product_id"`
+	Name       string `json:"name"`
+	IsVerified bool   `json:"is_verified"`
+}
+
+// ReviewModerationEvent is the data of review.moderated events
+type ReviewModerationEvent struct {
+	ReviewID  int    `json:"review_id"`
+	ProductID int    `json:"product_id"`
+	Status    string `json:"status"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// DisputeEvent is the data of dispute.updated events
+type DisputeEvent struct {
+	DisputeID  int    `json:"dispute_id"`
+	OrderID    int    `json:"order_id"`
+	Status     string `json:"status"`
+	Resolution string `json:"resolution,omitempty"`
+}
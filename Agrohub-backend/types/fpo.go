@@ -0,0 +1,79 @@
+package types
+
+import "time"
+
+// FPO is a farmer producer organization, a cooperative that sells its members' produce as one lot
+type FPO struct {
+	ID                 int       `json:"id" db:"id"`
+	Name               string    `json:"name" db:"name"`
+	RegistrationNumber string    `json:"registration_number,omitempty" db:"registration_number"`
+	District           string    `json:"district,omitempty" db:"district"`
+	State              string    `json:"state,omitempty" db:"state"`
+	Role               string    `json:"role,omitempty"` // the logged in farmer's role in it
+	MemberCount        int       `json:"member_count"`
+	CreatedAt          time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// FPOMember is a farmer belonging to an FPO
+type FPOMember struct {
+	FarmerID  int       `json:"farmer_id" db:"farmer_id"`
+	FirstName string    `json:"first_name" db:"first_name"`
+	LastName  string    `json:"last_name" db:"last_name"`
+	Role      string    `json:"role" db:"role"` // manager or member
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// AddFPOMember is the request body for adding a farmer to an FPO
+type AddFPOMember struct {
+	FarmerID int    `json:"farmer_id"`
+	Role     string `json:"role"`
+}
+
+// LotContribution is the stock one member put into an FPO lot
+type LotContribution struct {
+	FarmerID     int       `json:"farmer_id" db:"farmer_id"`
+	FirstName    string    `json:"first_name,omitempty"`
+	LastName     string    `json:"last_name,omitempty"`
+	QuantityInKg int       `json:"quantity_in_kg" db:"quantity_in_kg"`
+	RemainingKg  float64   `json:"remaining_kg" db:"remaining_kg"` // not delivered yet, the next orders are split by it
+	CreatedAt    time.Time `json:"created_at,omitempty" db:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at,omitempty" db:"updated_at"`
+}
+
+// Lot is a product an FPO sells for its members, with what each of them contributed
+type Lot struct {
+	Product
+	Contributions []LotContribution `json:"contributions"`
+	ContributedKg int               `json:"contributed_kg"`
+	SoldKg        float64           `json:"sold_kg"` // on delivered orders
+}
+
+// CreateLot is the request body for listing an FPO lot, quantity_in_kg is the total of the contributions
+type CreateLot struct {
+	Product
+	Contributions []LotContribution `json:"contributions"`
+}
+
+// FPOReportLine is what one member's share of the FPO's lots earned
+type FPOReportLine struct {
+	FarmerID   int     `json:"farmer_id"`
+	FirstName  string  `json:"first_name"`
+	LastName   string  `json:"last_name"`
+	SoldKg     float64 `json:"sold_kg"`
+	Gross      float64 `json:"gross"`
+	Commission float64 `json:"commission"`
+	Net        float64 `json:"net"` // gross less commission and any refunds taken back after delivery
+}
+
+// FPOReport splits the FPO's lot sales delivered between From and To by member
+type FPOReport struct {
+	FPOID      int             `json:"fpo_id"`
+	From       time.Time       `json:"from"`
+	To         time.Time       `json:"to"`
+	Orders     int             `json:"orders"`
+	Members    []FPOReportLine `json:"members"`
+	Gross      float64         `json:"gross"`
+	Commission float64         `json:"commission"`
+	Net        float64         `json:"net"`
+}
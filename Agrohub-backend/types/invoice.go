@@ -0,0 +1,42 @@
+package types
+
+import "time"
+
+// InvoiceParty is the seller or the buyer as printed on an invoice
+type InvoiceParty struct {
+	Name    string `json:"name"`
+	Phone   string `json:"phone"`
+	Address string `json:"address"`
+	City    string `json:"city"`
+	State   string `json:"state"`
+	PinCode string `json:"pin_code"`
+	GSTIN   string `json:"gstin,omitempty"`
+}
+
+// TaxLine is one tax on an invoice, CGST and SGST within a state or IGST across states
+type TaxLine struct {
+	Name        string  `json:"name"`
+	RatePercent float64 `json:"rate_percent"`
+	Amount      float64 `json:"amount"`
+}
+
+type Invoice struct {
+	ID            int          `json:"id"`
+	OrderID       int          `json:"order_id"`
+	Number        string       `json:"invoice_number"`
+	FinancialYear string       `json:"financial_year"`
+	IssuedAt      time.Time    `json:"issued_at"`
+	Seller        InvoiceParty `json:"seller"`
+	Buyer         InvoiceParty `json:"buyer"`
+	ShipTo        InvoiceParty `json:"ship_to"`
+	Description   string       `json:"description"`
+	HSNCode       string       `json:"hsn_code"`
+	QuantityInKg  int          `json:"quantity_in_kg"`
+	RatePerKg     float64      `json:"rate_per_kg"`              // before tax
+	DeliveryValue float64      `json:"delivery_value"`           // delivery charges before tax, part of the taxable value
+	DiscountValue float64      `json:"discount_value,omitempty"` // coupon discounts before tax, taken off the taxable value
+	TaxableValue  float64      `json:"taxable_value"`
+	TaxRate       float64      `json:"tax_rate"`
+	TaxLines      []TaxLine    `json:"tax_lines"`
+	Total         float64      `json:"total"`
+}
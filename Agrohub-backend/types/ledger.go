@@ -0,0 +1,70 @@
+package types
+
+import "time"
+
+// EarningsLine is one delivered order on a farmer's earnings statement
+type EarningsLine struct {
+	OrderID        int       `json:"order_id"`
+	ProductName    string    `json:"product_name"`
+	DeliveredAt    time.Time `json:"delivered_at"`
+	Gross          float64   `json:"gross"`
+	Commission     float64   `json:"commission"`
+	Net            float64   `json:"net"` // negative on cash on delivery orders, where the farmer owes the commission
+	CashOnDelivery bool      `json:"cash_on_delivery"`
+	PayoutID       *int      `json:"payout_id,omitempty"`
+	PayoutStatus   string    `json:"payout_status,omitempty"`
+}
+
+type EarningsStatement struct {
+	FarmerID      int            `json:"farmer_id"`
+	From          time.Time      `json:"from"`
+	To            time.Time      `json:"to"`
+	Orders        []EarningsLine `json:"orders"`
+	Gross         float64        `json:"gross"`
+	Commission    float64        `json:"commission"`
+	Net           float64        `json:"net"`
+	PaidOut       float64        `json:"paid_out"`
+	InTransit     float64        `json:"in_transit"`
+	UnpaidBalance float64        `json:"unpaid_balance"` // owed to the farmer and not yet in a payout
+}
+
+type Payout struct {
+	ID        int        `json:"id"`
+	BatchID   int        `json:"batch_id"`
+	FarmerID  int        `json:"farmer_id"`
+	Amount    float64    `json:"amount"`
+	Status    string     `json:"status"`
+	Reference string     `json:"reference,omitempty"` // bank transfer reference, set when marked paid
+	CreatedAt time.Time  `json:"created_at"`
+	PaidAt    *time.Time `json:"paid_at,omitempty"`
+}
+
+type MarkPayoutPaid struct {
+	Reference string `json:"reference"`
+}
+
+type AccountBalance struct {
+	Account string  `json:"account"`
+	Debit   float64 `json:"debit"`
+	Credit  float64 `json:"credit"`
+}
+
+type PayoutBatchSummary struct {
+	ID           int       `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	PayoutCount  int       `json:"payout_count"`
+	Total        float64   `json:"total"`
+	PaidTotal    float64   `json:"paid_total"`
+	PendingTotal float64   `json:"pending_total"`
+}
+
+// Reconciliation is the admin's check that the ledger agrees with the payouts and orders it describes
+type Reconciliation struct {
+	Balances                 []AccountBalance     `json:"balances"`
+	Batches                  []PayoutBatchSummary `json:"batches"`
+	UnbalancedTransactions   []int                `json:"unbalanced_transactions"`
+	PayoutsInTransit         float64              `json:"payouts_in_transit"` // per the ledger
+	PendingPayouts           float64              `json:"pending_payouts"`    // per the payouts table
+	DeliveredOrdersNotBooked []int                `json:"delivered_orders_not_booked"`
+	PayoutMismatches         []int                `json:"payout_mismatches"` // payouts whose amount differs from their ledger entry
+}
@@ -0,0 +1,29 @@
+package types
+
+import "time"
+
+// MarketPrice is one mandi price row, prices are in Rs per quintal as published
+type MarketPrice struct {
+	ID              int       `json:"id" db:"id"`
+	Commodity       string    `json:"commodity" db:"commodity"`
+	Variety         string    `json:"variety,omitempty" db:"variety"`
+	State           string    `json:"state" db:"state"`
+	District        string    `json:"district,omitempty" db:"district"`
+	Market          string    `json:"market" db:"market"`
+	ArrivalDate     time.Time `json:"arrival_date" db:"arrival_date"`
+	MinPrice        float64   `json:"min_price" db:"min_price"`
+	MaxPrice        float64   `json:"max_price" db:"max_price"`
+	ModalPrice      float64   `json:"modal_price" db:"modal_price"`
+	ModalPricePerKg float64   `json:"modal_price_per_kg"`
+}
+
+// PriceComparison puts a farmer's rate next to recent mandi prices for the same commodity and state
+type PriceComparison struct {
+	ProductID              int           `json:"product_id"`
+	ProductName            string        `json:"product_name"`
+	RatePerKg              float64       `json:"rate_per_kg"`
+	State                  string        `json:"state"`
+	Days                   int           `json:"days"`
+	AverageModalPricePerKg float64       `json:"average_modal_price_per_kg,omitempty"`
+	MarketPrices           []MarketPrice `json:"market_prices"`
+}
@@ -0,0 +1,46 @@
+package types
+
+import "time"
+
+// Offer is a buyer and farmer negotiating the price and quantity of a product
+type Offer struct {
+	ID                 int          `json:"id" db:"id"`
+	ProductID          int          `json:"product_id" db:"product_id"`
+	ProductName        string       `json:"product_name,omitempty"`
+	BuyerID            int          `json:"buyer_id" db:"buyer_id"`
+	FarmerID           int          `json:"farmer_id" db:"farmer_id"`
+	RatePerKg          float64      `json:"rate_per_kg" db:"rate_per_kg"`
+	QuantityInKg       int          `json:"quantity_in_kg" db:"quantity_in_kg"`
+	Awaiting           string       `json:"awaiting" db:"awaiting"` // whose turn it is, buyer or farmer
+	Status             string       `json:"status" db:"status"`
+	ExpiresAt          time.Time    `json:"expires_at" db:"expires_at"`
+	OrderID            *int         `json:"order_id,omitempty" db:"order_id"`
+	ModeOfDelivery     string       `json:"mode_of_delivery" db:"mode_of_delivery"`
+	DeliveryAddress    string       `json:"delivery_address" db:"delivery_address"`
+	DeliveryCity       string       `json:"delivery_city" db:"delivery_city"`
+	DeliveryAddressZIP PinCode      `json:"delivery_address_zip" db:"delivery_address_zip"`
+	BuyersPhoneNumber  int          `json:"buyers_phone_number" db:"buyers_phone_number"`
+	Message            string       `json:"message,omitempty"`
+	Events             []OfferEvent `json:"events,omitempty"`
+	CreatedAt          time.Time    `json:"created_at" db:"created_at"`
+	UpdatedAt          time.Time    `json:"updated_at" db:"updated_at"`
+}
+
+// OfferEvent is one step of the negotiation thread
+type OfferEvent struct {
+	ID           int       `json:"id" db:"id"`
+	OfferID      int       `json:"offer_id" db:"offer_id"`
+	ActorID      *int      `json:"actor_id,omitempty" db:"actor_id"` // empty when the system expired the offer
+	Action       string    `json:"action" db:"action"`
+	RatePerKg    *float64  `json:"rate_per_kg,omitempty" db:"rate_per_kg"`
+	QuantityInKg *int      `json:"quantity_in_kg,omitempty" db:"quantity_in_kg"`
+	Message      string    `json:"message,omitempty" db:"message"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+// CounterOffer is the request body for countering an offer
+type CounterOffer struct {
+	RatePerKg    float64 `json:"rate_per_kg"`
+	QuantityInKg int     `json:"quantity_in_kg"`
+	Message      string  `json:"message"`
+}
@@ -7,10 +7,19 @@ type Order struct {
 	BuyerID              int       `json:"buyer_id" db:"buyer_id"`
 	ProductID            int       `json:"product_id" db:"product_id"`
 	QuantityInKg         int       `json:"quantity_in_kg" db:"quantity_in_kg"`
-	TotalPrice           float64   `json:"total_price" db:"total_price"`
+	TotalPrice           float64   `json:"total_price" db:"total_price"` // delivery fee included, discounts taken off
+	DeliveryFee          float64   `json:"delivery_fee" db:"delivery_fee"`
+	CouponCode           string    `json:"coupon_code,omitempty"` // applied when the order is placed
+	Discount             float64   `json:"discount" db:"discount"`
+	Discounts            []OrderDiscount `json:"discounts,omitempty"`
+	DistanceKm           *float64  `json:"distance_km,omitempty" db:"distance_km"`
 	DeliveryAddress      string    `json:"delivery_address" db:"delivery_address"`
 	DeliveryCity         string    `json:"delivery_city" db:"delivery_city"`
-	DeliveryAddressZIP   int       `json:"delivery_address_zip" db:"delivery_address_pin_code"`
+	DeliveryAddressZIP   PinCode   `json:"delivery_address_zip" db:"delivery_address_zip"`
+	DeliveryLandmark     string    `json:"delivery_landmark,omitempty" db:"delivery_landmark"`
+	DeliveryState        string    `json:"delivery_state,omitempty" db:"delivery_state"`
+	AddressID            *int      `json:"address_id,omitempty" db:"address_id"` // saved address the delivery details were copied from
+	OrganizationID       *int      `json:"organization_id,omitempty" db:"organization_id"` // placed by buyer_id on behalf of the organization
 	Status               string    `json:"status" db:"status"`
 	ModeOfDelivery       string    `json:"mode_of_delivery" db:"mode_of_delivery"`
 	ExpectedDeliveryDate time.Time `json:"expected_delivery_date" time_format:"2006-01-02" db:"expected_delivery_date"`
@@ -24,6 +33,8 @@ type OrderDetails struct {
 	OrderID              int        `json:"order_id"`
 	QuantityInKg         int        `json:"quantity_in_kg"`
 	TotalPrice           float64    `json:"total_price"`
+	DeliveryFee          float64    `json:"delivery_fee"`
+	Discount             float64    `json:"discount,omitempty"` // coupon discounts, already taken off total_price
 	Status               string     `json:"status"`
 	ModeOfDelivery       string     `json:"mode_of_delivery"`
 	ExpectedDeliveryDate *time.Time `json:"expected_delivery_date,omitempty"`
@@ -40,7 +51,7 @@ type BuyersDetails struct {
 	BuyerPhoneNumber string `json:"buyer_phone_number"`
 	DeliveryAddress  string `json:"delivery_address"`
 	DeliveryCity     string `json:"delivery_city"`
-	DeliveryZIP      int    `json:"delivery_zip"`
+	DeliveryZIP      PinCode `json:"delivery_zip"`
 }
 
 // SellerDetails struct contains information related to the seller (farmer).
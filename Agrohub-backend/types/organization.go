@@ -0,0 +1,51 @@
+package types
+
+import "time"
+
+// Organization is a business buying through several staff accounts, such as a hotel or a processor
+type Organization struct {
+	ID            int       `json:"id" db:"id"`
+	Name          string    `json:"name" db:"name"`
+	GSTIN         string    `json:"gstin,omitempty" db:"gstin"`
+	ApprovalLimit *float64  `json:"approval_limit,omitempty" db:"approval_limit"` // orders above it wait for an approver, empty means none do
+	Role          string    `json:"role,omitempty"`                               // the logged in user's role in it
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// OrganizationMember is a user acting for an organization
+type OrganizationMember struct {
+	UserID    int       `json:"user_id" db:"user_id"`
+	FirstName string    `json:"first_name" db:"first_name"`
+	LastName  string    `json:"last_name" db:"last_name"`
+	Email     string    `json:"email" db:"email"`
+	Role      string    `json:"role" db:"role"` // owner, purchaser, approver or viewer
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// AddOrganizationMember is the request body for adding a registered user to an organization
+type AddOrganizationMember struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// OrganizationOrder is an order placed for an organization as its members see it
+type OrganizationOrder struct {
+	OrderID      int        `json:"order_id"`
+	ProductID    int        `json:"product_id"`
+	ProductName  string     `json:"product_name"`
+	QuantityInKg int        `json:"quantity_in_kg"`
+	TotalPrice   float64    `json:"total_price"`
+	Status       string     `json:"status"`
+	PlacedBy     int        `json:"placed_by"`
+	PlacedByName string     `json:"placed_by_name"`
+	ReviewedBy   *int       `json:"reviewed_by,omitempty"` // the approver who approved or rejected it
+	ReviewNote   string     `json:"review_note,omitempty"`
+	ReviewedAt   *time.Time `json:"reviewed_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// ReviewOrganizationOrder is the request body for an approver's decision, a note is required to reject
+type ReviewOrganizationOrder struct {
+	Note string `json:"note"`
+}
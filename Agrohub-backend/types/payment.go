@@ -0,0 +1,37 @@
+package types
+
+import "time"
+
+// Payment is money collected, or to be collected on delivery, for an order or as the deposit of a pre-order
+type Payment struct {
+	ID                int        `json:"id" db:"id"`
+	OrderID           int        `json:"order_id,omitempty" db:"order_id"`         // not set on a deposit until its pre-order is filled
+	PreOrderID        *int       `json:"pre_order_id,omitempty" db:"pre_order_id"` // set on a pre-order's deposit
+	Provider          string     `json:"provider" db:"provider"`
+	Method            string     `json:"method" db:"method"` // upi, card, netbanking or cod
+	IntentID          string     `json:"intent_id,omitempty" db:"intent_id"`
+	ClientSecret      string     `json:"client_secret,omitempty"` // only returned when the payment is created
+	ProviderPaymentID string     `json:"provider_payment_id,omitempty" db:"provider_payment_id"`
+	Amount            float64    `json:"amount" db:"amount"`
+	RefundedAmount    float64    `json:"refunded_amount" db:"refunded_amount"`
+	Currency          string     `json:"currency" db:"currency"`
+	Status            string     `json:"status" db:"status"`
+	CapturedAt        *time.Time `json:"captured_at,omitempty" db:"captured_at"`
+	CreatedAt         time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+type CreatePayment struct {
+	Method string `json:"method"`
+}
+
+// CapturePayment is what the gateway's checkout hands the app once the buyer has paid
+type CapturePayment struct {
+	PaymentID string `json:"payment_id"`
+	Signature string `json:"signature"`
+}
+
+type RefundRequest struct {
+	Amount float64 `json:"amount"` // leave empty to refund whatever is left
+	Reason string  `json:"reason"`
+}
@@ -0,0 +1,44 @@
+package types
+
+import "time"
+
+// Harvest is a farmer's projected harvest of a product that buyers can pre-order against
+type Harvest struct {
+	ID             int        `json:"id" db:"id"`
+	ProductID      int        `json:"product_id" db:"product_id"`
+	FarmerID       int        `json:"farmer_id"`
+	ProjectedQtyKg int        `json:"projected_qty_kg" db:"projected_qty_kg"`
+	ProjectedDate  time.Time  `json:"projected_date" db:"projected_date"`
+	DepositPercent float64    `json:"deposit_percent" db:"deposit_percent"`
+	ReservedQtyKg  int        `json:"reserved_qty_kg"`
+	ActualQtyKg    *int       `json:"actual_qty_kg,omitempty" db:"actual_qty_kg"`
+	Status         string     `json:"status" db:"status"`
+	RecordedAt     *time.Time `json:"recorded_at,omitempty" db:"recorded_at"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+}
+
+// PreOrder reserves part of a harvest at the rate quoted when it was placed
+type PreOrder struct {
+	ID                 int       `json:"id" db:"id"`
+	HarvestID          int       `json:"harvest_id" db:"harvest_id"`
+	ProductID          int       `json:"product_id" db:"product_id"`
+	BuyerID            int       `json:"buyer_id" db:"buyer_id"`
+	QuantityInKg       int       `json:"quantity_in_kg" db:"quantity_in_kg"`
+	AllocatedKg        int       `json:"allocated_kg" db:"allocated_kg"`
+	RatePerKg          float64   `json:"rate_per_kg" db:"rate_per_kg"`
+	TotalPrice         float64   `json:"total_price" db:"total_price"`
+	DepositAmount      float64   `json:"deposit_amount" db:"deposit_amount"`
+	Status             string    `json:"status" db:"status"`
+	OrderID            *int      `json:"order_id,omitempty" db:"order_id"`
+	ModeOfDelivery     string    `json:"mode_of_delivery" db:"mode_of_delivery"`
+	DeliveryAddress    string    `json:"delivery_address" db:"delivery_address"`
+	DeliveryCity       string    `json:"delivery_city" db:"delivery_city"`
+	DeliveryAddressZIP PinCode   `json:"delivery_address_zip" db:"delivery_address_zip"`
+	BuyersPhoneNumber  int       `json:"buyers_phone_number" db:"buyers_phone_number"`
+	CreatedAt          time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type RecordHarvest struct {
+	ActualQtyKg int `json:"actual_qty_kg"`
+}
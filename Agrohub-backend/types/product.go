@@ -13,12 +13,14 @@ type Product struct {
 	ID                 int        `json:"id" db:"id"`
 	Img                string     `json:"img" db:"img"`
 	FarmerID           int        `json:"farmer_id" db:"farmer_id"`
+	FPOID              *int       `json:"fpo_id,omitempty" db:"fpo_id"` // a lot an FPO sells for its members, farmer_id is the member who listed it
 	Name               string     `json:"name" db:"name"`
 	Type               string     `json:"type" db:"type"`
 	Quantity           int        `json:"quantity_in_kg" db:"quantity_in_kg"`
 	RatePerKg          float64    `json:"rate_per_kg" db:"rate_per_kg"`
 	JariSize           string     `json:"jari_size,omitempty" db:"jari_size"`
 	ExpectedDelivery   *time.Time `json:"expected_delivery,omitempty" db:"expected_delivery"`
+	AvailableFrom      *time.Time `json:"available_from,omitempty" db:"available_from"` // pre-harvest listings go live on this date
 	CreatedAt          time.Time  `json:"created_at" db:"created_at"`
 	UpdatedAt          time.Time  `json:"updated_at" db:"updated_at"`
 	FarmersPhoneNumber string     `json:"farmer_phone_number" db:"farmers_phone_number"`
@@ -26,6 +28,62 @@ type Product struct {
 	FarmerLastName     string     `json:"farmers_last_name,omitempty"`
 	IsAvailable        bool       `json:"is_available" db:"is_available"`
 	IsVerifiedByAdmin  bool       `json:"is_verified_by_admin" db:"is_verified_by_admin"`
+	MinOrderQty        int        `json:"min_order_qty_kg,omitempty" db:"min_order_qty_kg"`
+	MaxOrderQty        int        `json:"max_order_qty_kg,omitempty" db:"max_order_qty_kg"` // 0 means no upper limit
+	OrderStep          int        `json:"order_step_kg,omitempty" db:"order_step_kg"`
+	PriceTiers         []PriceTier `json:"price_tiers,omitempty"`
+	FarmerCity         string     `json:"farmer_city,omitempty"`
+	FarmerState        string     `json:"farmer_state,omitempty"`
+	DistanceKm         *float64   `json:"distance_km,omitempty"` // from the buyer's location, only in nearby search
+	Rating             *RatingSummary `json:"rating,omitempty"`
+}
+
+// PriceTier is a volume based rate, MaxQtyKg of 0 means the tier is open ended (e.g. 50+ kg)
+type PriceTier struct {
+	ID        int     `json:"id,omitempty" db:"id"`
+	ProductID int     `json:"product_id,omitempty" db:"product_id"`
+	MinQtyKg  int     `json:"min_qty_kg" db:"min_qty_kg"`
+	MaxQtyKg  int     `json:"max_qty_kg,omitempty" db:"max_qty_kg"`
+	RatePerKg float64 `json:"rate_per_kg" db:"rate_per_kg"`
+}
+
+// ProductPricing is the request body farmers send to set tiers and order limits on a product
+type ProductPricing struct {
+	MinOrderQty int         `json:"min_order_qty_kg"`
+	MaxOrderQty int         `json:"max_order_qty_kg"`
+	OrderStep   int         `json:"order_step_kg"`
+	PriceTiers  []PriceTier `json:"price_tiers"`
+}
+
+// ProductSchedule is the request body farmers send to move a listing's dates. A missing expected_delivery keeps the
+// current one, a missing available_from lists it right away.
+type ProductSchedule struct {
+	ExpectedDelivery *time.Time `json:"expected_delivery"`
+	AvailableFrom    *time.Time `json:"available_from"`
+}
+
+// PriceChange is one entry of a product's rate_per_kg history
+type PriceChange struct {
+	ID           int       `json:"id" db:"id"`
+	ProductID    int       `json:"product_id" db:"product_id"`
+	OldRatePerKg *float64  `json:"old_rate_per_kg,omitempty" db:"old_rate_per_kg"`
+	NewRatePerKg float64   `json:"new_rate_per_kg" db:"new_rate_per_kg"`
+	ChangedBy    *int      `json:"changed_by,omitempty" db:"changed_by"`
+	ChangedAt    time.Time `json:"changed_at" db:"changed_at"`
+}
+
+// Quote is the price a buyer would pay for a quantity of a product
+type Quote struct {
+	ProductID    int        `json:"product_id"`
+	QuantityInKg int        `json:"quantity_in_kg"`
+	RatePerKg    float64    `json:"rate_per_kg"`
+	Subtotal     float64    `json:"subtotal"`
+	DeliveryFee  float64    `json:"delivery_fee"`
+	DistanceKm   *float64   `json:"distance_km,omitempty"` // farm to delivery pin code, as the crow flies
+	Discount     float64    `json:"discount,omitempty"` // coupon discounts, already taken off total_price
+	Discounts    []OrderDiscount `json:"discounts,omitempty"`
+	TotalPrice   float64    `json:"total_price"`
+	AppliedTier  *PriceTier `json:"applied_tier,omitempty"`
 }
 
 
@@ -33,6 +91,9 @@ type OrderSummary struct {
 	OrderID              int        `json:"order_id"`
 	QuantityInKg         int        `json:"quantity_in_kg"`
 	TotalPrice           float64    `json:"total_price"`
+	DeliveryFee          float64    `json:"delivery_fee"`
+	Discount             float64    `json:"discount,omitempty"`
+	Discounts            []OrderDiscount `json:"discounts,omitempty"`
 	Status               string     `json:"status"`
 	ModeOfDelivery       string     `json:"mode_of_delivery"`
 	ExpectedDeliveryDate *time.Time `json:"expected_delivery_date,omitempty"`
@@ -45,7 +106,9 @@ type OrderSummary struct {
 	UserPhoneNumber      string     `json:"user_phone_number"`
 	DeliveryAddress      string     `json:"delivery_address"`
 	DeliveryCity         string     `json:"delivery_city"`
-	DeliveryAddressZIP   int        `json:"delivery_address_pin_code"`
+	DeliveryAddressZIP   PinCode    `json:"delivery_address_pin_code"`
+	DeliveryLandmark     string     `json:"delivery_landmark,omitempty"`
+	DeliveryState        string     `json:"delivery_state,omitempty"`
 	BuyersPhoneNumber    int        `json:"buyers_phone_number" db:"buyers_phone_number"`
 	FarmersPhoneNumber   int        `json:"farmer_phone_number" db:"farmers_phone_number"`
 	ProductImg           string     `json:"product_img" db:"img"`
@@ -0,0 +1,36 @@
+package types
+
+import "time"
+
+// Coupon is a discount code. A platform funded coupon is paid for by the platform, the farmer is paid as if it wasn't
+// used. A farmer funded coupon comes off the farmer's price and is always limited to their produce.
+type Coupon struct {
+	ID            int        `json:"id" db:"id"`
+	Code          string     `json:"code" db:"code"`
+	Description   string     `json:"description,omitempty" db:"description"`
+	DiscountType  string     `json:"discount_type" db:"discount_type"`         // percentage or flat
+	Value         float64    `json:"value" db:"value"`                         // percent off, or rupees off
+	MaxDiscount   *float64   `json:"max_discount,omitempty" db:"max_discount"` // caps a percentage discount
+	FundedBy      string     `json:"funded_by" db:"funded_by"`                 // platform or farmer
+	FarmerID      *int       `json:"farmer_id,omitempty" db:"farmer_id"`       // only valid on this farmer's produce
+	BuyerID       *int       `json:"buyer_id,omitempty" db:"buyer_id"`         // only this buyer can use it
+	Category      string     `json:"category,omitempty" db:"category"`         // only valid on this product type
+	MinOrderValue float64    `json:"min_order_value" db:"min_order_value"`     // before delivery
+	StartsAt      time.Time  `json:"starts_at" db:"starts_at"`
+	EndsAt        *time.Time `json:"ends_at,omitempty" db:"ends_at"`
+	UsageLimit    *int       `json:"usage_limit,omitempty" db:"usage_limit"` // uses across all buyers
+	PerUserLimit  int        `json:"per_user_limit" db:"per_user_limit"`
+	IsActive      bool       `json:"is_active" db:"is_active"`
+	Used          int        `json:"used"`     // orders it was used on, cancelled and rejected orders give their use back
+	Redeemed      float64    `json:"redeemed"` // discount given on those orders
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// OrderDiscount is a discount line of an order
+type OrderDiscount struct {
+	CouponID int     `json:"coupon_id" db:"coupon_id"`
+	Code     string  `json:"code" db:"code"`
+	FundedBy string  `json:"funded_by" db:"funded_by"`
+	Amount   float64 `json:"amount" db:"amount"`
+}
@@ -0,0 +1,58 @@
+package types
+
+import "time"
+
+// Referral is a user who signed up with another user's referral code
+type Referral struct {
+	ID           int        `json:"id" db:"id"`
+	ReferrerID   int        `json:"referrer_id" db:"referrer_id"`
+	ReferredID   int        `json:"referred_id" db:"referred_id"`
+	FirstName    string     `json:"first_name"` // of the referred user
+	LastName     string     `json:"last_name"`
+	UserType     string     `json:"user_type"` // of the referred user, farmer or buyer
+	Code         string     `json:"code" db:"code"`
+	Status       string     `json:"status" db:"status"` // pending, rewarded or rejected
+	RejectReason string     `json:"reject_reason,omitempty" db:"reject_reason"`
+	RewardAmount *float64   `json:"reward_amount,omitempty" db:"reward_amount"`
+	RewardCoupon string     `json:"reward_coupon,omitempty"` // code of the coupon the referrer was rewarded with
+	RewardedAt   *time.Time `json:"rewarded_at,omitempty" db:"rewarded_at"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+}
+
+// ReferralSummary is a user's referral code and the users who signed up with it
+type ReferralSummary struct {
+	Code      string     `json:"code"`
+	Referrals []Referral `json:"referrals"`
+	Pending   int        `json:"pending"`
+	Rewarded  int        `json:"rewarded"`
+	Rejected  int        `json:"rejected"`
+	Earned    float64    `json:"earned"` // value of the reward coupons
+}
+
+// ReferralReportLine is how one referrer did in the report's period
+type ReferralReportLine struct {
+	ReferrerID int     `json:"referrer_id"`
+	FirstName  string  `json:"first_name"`
+	LastName   string  `json:"last_name"`
+	UserType   string  `json:"user_type"`
+	Referred   int     `json:"referred"`
+	Rewarded   int     `json:"rewarded"`
+	Rejected   int     `json:"rejected"`
+	Rewards    float64 `json:"rewards"`
+}
+
+// ReferralReport covers the referrals made between From and To
+type ReferralReport struct {
+	From      time.Time            `json:"from"`
+	To        time.Time            `json:"to"`
+	Referred  int                  `json:"referred"`
+	Farmers   int                  `json:"farmers"`
+	Buyers    int                  `json:"buyers"`
+	Pending   int                  `json:"pending"`
+	Rewarded  int                  `json:"rewarded"`
+	Rejected  int                  `json:"rejected"`
+	Rewards   float64              `json:"rewards"`  // value of the reward coupons issued
+	Redeemed  float64              `json:"redeemed"` // discount given on orders with those coupons
+	Referrers []ReferralReportLine `json:"referrers"`
+	Flagged   []Referral           `json:"flagged"` // referrals rejected by the fraud checks
+}
@@ -0,0 +1,52 @@
+package types
+
+import "time"
+
+// Review is a buyer's rating of a delivered order, it counts towards the product's and the farmer's rating
+type Review struct {
+	ID           int        `json:"id" db:"id"`
+	OrderID      int        `json:"order_id" db:"order_id"`
+	ProductID    int        `json:"product_id" db:"product_id"`
+	ProductName  string     `json:"product_name,omitempty"`
+	FarmerID     int        `json:"farmer_id" db:"farmer_id"`
+	BuyerID      int        `json:"buyer_id" db:"buyer_id"`
+	BuyerName    string     `json:"buyer_name,omitempty"`
+	Rating       int        `json:"rating" db:"rating"`
+	Body         string     `json:"body,omitempty" db:"body"`
+	PhotoURLs    []string   `json:"photo_urls,omitempty" db:"photo_urls"`
+	Reply        string     `json:"reply,omitempty" db:"reply"` // the farmer's public reply
+	RepliedAt    *time.Time `json:"replied_at,omitempty" db:"replied_at"`
+	Status       string     `json:"status" db:"status"`
+	HiddenReason string     `json:"hidden_reason,omitempty" db:"hidden_reason"`
+	ReportCount  int        `json:"report_count,omitempty"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// RatingSummary is the average of the published reviews of a product or a farmer
+type RatingSummary struct {
+	Average float64 `json:"average"`
+	Count   int     `json:"count"`
+}
+
+// ReviewList is a page of reviews with the rating they add up to
+type ReviewList struct {
+	Rating  RatingSummary `json:"rating"`
+	Reviews []Review      `json:"reviews"`
+}
+
+// ReviewReply is the request body for a farmer's reply
+type ReviewReply struct {
+	Reply string `json:"reply"`
+}
+
+// ReportReview is the request body for reporting an abusive review
+type ReportReview struct {
+	Reason string `json:"reason"`
+}
+
+// ModerateReview is the request body for an admin hiding or restoring a review
+type ModerateReview struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
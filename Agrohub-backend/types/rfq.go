@@ -0,0 +1,43 @@
+package types
+
+import "time"
+
+// RFQ is a buyer's request for quotation, e.g. 200 kg oyster mushroom weekly delivered to Indore
+type RFQ struct {
+	ID                 int       `json:"id" db:"id"`
+	BuyerID            int       `json:"buyer_id" db:"buyer_id"`
+	Category           string    `json:"category" db:"category"`
+	Title              string    `json:"title" db:"title"`
+	QuantityKg         int       `json:"quantity_kg" db:"quantity_kg"`
+	Frequency          string    `json:"frequency" db:"frequency"`
+	TargetPricePerKg   *float64  `json:"target_price_per_kg,omitempty" db:"target_price_per_kg"`
+	Notes              string    `json:"notes,omitempty" db:"notes"`
+	BuyersPhoneNumber  int       `json:"buyers_phone_number,omitempty" db:"buyers_phone_number"` // only for the buyer and the farmer they awarded it to
+	DeliveryAddress    string    `json:"delivery_address,omitempty" db:"delivery_address"`
+	DeliveryCity       string    `json:"delivery_city" db:"delivery_city"`
+	DeliveryState      string    `json:"delivery_state" db:"delivery_state"`
+	DeliveryAddressZIP PinCode   `json:"delivery_address_zip" db:"delivery_address_zip"`
+	Deadline           time.Time `json:"deadline" db:"deadline"`
+	Status             string    `json:"status" db:"status"`
+	QuoteCount         int       `json:"quote_count"`
+	CreatedAt          time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// RFQQuote is a farmer's offer to fill an RFQ from one of their listings
+type RFQQuote struct {
+	ID              int        `json:"id" db:"id"`
+	RFQID           int        `json:"rfq_id" db:"rfq_id"`
+	FarmerID        int        `json:"farmer_id" db:"farmer_id"`
+	FarmerFirstName string     `json:"farmer_first_name,omitempty"`
+	FarmerLastName  string     `json:"farmer_last_name,omitempty"`
+	ProductID       int        `json:"product_id" db:"product_id"`
+	RatePerKg       float64    `json:"rate_per_kg" db:"rate_per_kg"`
+	QuantityKg      int        `json:"quantity_kg" db:"quantity_kg"`
+	DeliveryDate    *time.Time `json:"delivery_date,omitempty" db:"delivery_date"`
+	Notes           string     `json:"notes,omitempty" db:"notes"`
+	Status          string     `json:"status" db:"status"`
+	OrderID         *int       `json:"order_id,omitempty" db:"order_id"`
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at" db:"updated_at"`
+}
@@ -0,0 +1,61 @@
+package types
+
+import "time"
+
+// DeliverySlot is a delivery window the farmer proposes for an order, the buyer confirms one of them
+type DeliverySlot struct {
+	ID        int       `json:"id" db:"id"`
+	OrderID   int       `json:"order_id" db:"order_id"`
+	StartsAt  time.Time `json:"starts_at" db:"starts_at"`
+	EndsAt    time.Time `json:"ends_at" db:"ends_at"`
+	Status    string    `json:"status" db:"status"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// ProposeSlots is the request body for proposing delivery slots
+type ProposeSlots struct {
+	Slots []DeliverySlot `json:"slots"`
+}
+
+// Shipment carries an order from the farmer to the buyer in the confirmed slot
+type Shipment struct {
+	ID            int             `json:"id" db:"id"`
+	OrderID       int             `json:"order_id" db:"order_id"`
+	SlotID        int             `json:"slot_id" db:"slot_id"`
+	SlotStartsAt  time.Time       `json:"slot_starts_at"`
+	SlotEndsAt    time.Time       `json:"slot_ends_at"`
+	Status        string          `json:"status" db:"status"`
+	Carrier       string          `json:"carrier" db:"carrier"`
+	VehicleNumber string          `json:"vehicle_number" db:"vehicle_number"`
+	DriverPhone   string          `json:"driver_phone" db:"driver_phone"`
+	DispatchedAt  *time.Time      `json:"dispatched_at,omitempty" db:"dispatched_at"`
+	DeliveredAt   *time.Time      `json:"delivered_at,omitempty" db:"delivered_at"`
+	ProofPhotoURL string          `json:"proof_photo_url,omitempty" db:"proof_photo_url"`
+	DeliveryOTP   string          `json:"delivery_otp,omitempty" db:"delivery_otp"` // only shown to the buyer
+	Events        []ShipmentEvent `json:"events,omitempty"`
+	CreatedAt     time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+// ShipmentEvent is one tracking update of a shipment
+type ShipmentEvent struct {
+	ID         int       `json:"id" db:"id"`
+	ShipmentID int       `json:"shipment_id" db:"shipment_id"`
+	Status     string    `json:"status" db:"status"`
+	Location   string    `json:"location,omitempty" db:"location"`
+	Note       string    `json:"note,omitempty" db:"note"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// UpdateShipment is the request body for setting who carries a shipment
+type UpdateShipment struct {
+	Carrier       string `json:"carrier"`
+	VehicleNumber string `json:"vehicle_number"`
+	DriverPhone   string `json:"driver_phone"`
+}
+
+// ConfirmDelivery is the proof of delivery, the OTP the buyer shares with the driver and a photo of the handover
+type ConfirmDelivery struct {
+	OTP      string `json:"otp"`
+	PhotoURL string `json:"photo_url"`
+}
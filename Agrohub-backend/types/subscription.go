@@ -0,0 +1,53 @@
+package types
+
+import "time"
+
+// Subscription is a buyer's standing order. Either ProductID is set, or FarmerID and Category and every delivery is
+// taken from that farmer's cheapest live product of the category.
+type Subscription struct {
+	ID                int               `json:"id" db:"id"`
+	BuyerID           int               `json:"buyer_id" db:"buyer_id"`
+	FarmerID          int               `json:"farmer_id" db:"farmer_id"`
+	ProductID         *int              `json:"product_id,omitempty" db:"product_id"`
+	ProductName       string            `json:"product_name,omitempty"`
+	Category          string            `json:"category,omitempty" db:"category"` // product type, mushroom or jari
+	QuantityInKg      int               `json:"quantity_in_kg" db:"quantity_in_kg"`
+	Frequency         string            `json:"frequency" db:"frequency"`       // weekly, fortnightly or monthly
+	DeliveryDay       int               `json:"delivery_day" db:"delivery_day"` // 0 (Sunday) to 6, or 1 to 28 for monthly
+	RatePerKg         float64           `json:"rate_per_kg" db:"rate_per_kg"`   // the rate agreed to, later rates are compared to it
+	PriceTolerancePct float64           `json:"price_tolerance_pct" db:"price_tolerance_pct"`
+	AddressID         *int              `json:"address_id,omitempty" db:"address_id"` // empty means the buyer's default address
+	ModeOfDelivery    string            `json:"mode_of_delivery" db:"mode_of_delivery"`
+	BuyersPhoneNumber int               `json:"buyers_phone_number" db:"buyers_phone_number"`
+	Status            string            `json:"status" db:"status"`
+	NextDeliveryDate  time.Time         `json:"next_delivery_date" db:"next_delivery_date"`
+	Runs              []SubscriptionRun `json:"runs,omitempty"`
+	CreatedAt         time.Time         `json:"created_at" db:"created_at"`
+	UpdatedAt         time.Time         `json:"updated_at" db:"updated_at"`
+}
+
+// SubscriptionRun is one delivery of a subscription, either placed as an order or skipped with a reason
+type SubscriptionRun struct {
+	ID             int       `json:"id" db:"id"`
+	SubscriptionID int       `json:"subscription_id" db:"subscription_id"`
+	DeliveryDate   time.Time `json:"delivery_date" db:"delivery_date"`
+	Status         string    `json:"status" db:"status"`
+	OrderID        *int      `json:"order_id,omitempty" db:"order_id"`
+	Reason         string    `json:"reason,omitempty" db:"reason"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+// CreateSubscription is the request body for subscribing. StartDate is the earliest delivery, tomorrow if empty.
+type CreateSubscription struct {
+	ProductID         int        `json:"product_id"`
+	FarmerID          int        `json:"farmer_id"`
+	Category          string     `json:"category"`
+	QuantityInKg      int        `json:"quantity_in_kg"`
+	Frequency         string     `json:"frequency"`
+	DeliveryDay       int        `json:"delivery_day"`
+	StartDate         *time.Time `json:"start_date"`
+	PriceTolerancePct *float64   `json:"price_tolerance_pct"`
+	AddressID         *int       `json:"address_id"`
+	ModeOfDelivery    string     `json:"mode_of_delivery"`
+	BuyersPhoneNumber int        `json:"buyers_phone_number"`
+}
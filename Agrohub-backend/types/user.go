@@ -43,11 +43,36 @@ type User struct {
 	UserType     string    `json:"user_type" db:"user_type"`
 	AadharFrontImg string `json:"aadhar_front_img,omitempty" db:"aadhar_front_img"`
 	AadharBackImg string `json:"aadhar_back_img,omitempty" db:"aadhar_back_img"`
+	ReferralCode string `json:"referral_code,omitempty"` // code of the user who referred them, only read at signup
 }
 
+// Farmer is the public profile of a farmer, it leaves out contact and KYC details
 type Farmer struct {
-	ID        string `json:"id" db:"id"`
-	FirstName string `json:"first_name" db:"first_name"`
-	LastName  string `json:"last_name" db:"last_name"`
-	Image     string `json:"img" db:"img"`
+	ID           string        `json:"id" db:"id"`
+	FirstName    string        `json:"first_name" db:"first_name"`
+	LastName     string        `json:"last_name" db:"last_name"`
+	Image        string        `json:"img" db:"img"`
+	City         string        `json:"city" db:"city"`
+	State        string        `json:"state" db:"state"`
+	FarmSize     float64       `json:"farm_size" db:"farm_size"` // acres
+	IsVerified   bool          `json:"is_verified_by_admin" db:"is_verified_by_admin"`
+	MemberSince  time.Time     `json:"member_since" db:"created_at"`
+	Rating       RatingSummary `json:"rating"`
+	ListingCount int           `json:"listing_count"`
+	Listings     []Product     `json:"listings,omitempty"` // only on the profile, not in the directory
+}
+
+// FarmerFilter narrows down the farmer directory
+type FarmerFilter struct {
+	State    string
+	City     string
+	Category string // farmers with a live listing of this product type
+	Limit    int
+	Offset   int
+}
+
+// FarmerDirectory is a page of the farmer directory
+type FarmerDirectory struct {
+	Farmers []Farmer `json:"farmers"`
+	Total   int      `json:"total"`
 }
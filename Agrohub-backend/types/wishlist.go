@@ -0,0 +1,35 @@
+package types
+
+import "time"
+
+// WishlistItem is a product a buyer saved for later
+type WishlistItem struct {
+	Product Product   `json:"product"`
+	AddedAt time.Time `json:"added_at" db:"created_at"`
+}
+
+// ProductAlert is a buyer's standing request to be told when a product, or any product of a category, matches
+// Condition. Either ProductID or Category may be empty, not both, except for new_listing alerts which can match any
+// product listed in the buyer's district.
+type ProductAlert struct {
+	ID             int        `json:"id" db:"id"`
+	BuyerID        int        `json:"buyer_id" db:"buyer_id"`
+	ProductID      *int       `json:"product_id,omitempty" db:"product_id"`
+	ProductName    string     `json:"product_name,omitempty"`
+	Category       string     `json:"category,omitempty" db:"category"`         // product type, mushroom or jari
+	Condition      string     `json:"condition" db:"condition"`                 // back_in_stock, price_below or new_listing
+	TargetPrice    *float64   `json:"target_price,omitempty" db:"target_price"` // rate per kg a price_below alert waits for
+	PinPrefix      string     `json:"pin_prefix,omitempty" db:"pin_prefix"`     // sorting district (first 3 pin digits) of a new_listing alert
+	LastNotifiedAt *time.Time `json:"last_notified_at,omitempty" db:"last_notified_at"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+}
+
+// CreateProductAlert is the request body for an alert. PinCode is only read by new_listing alerts, it defaults to
+// the pin code of the buyer's default address.
+type CreateProductAlert struct {
+	ProductID   *int     `json:"product_id"`
+	Category    string   `json:"category"`
+	Condition   string   `json:"condition"`
+	TargetPrice *float64 `json:"target_price"`
+	PinCode     PinCode  `json:"pin_code"`
+}